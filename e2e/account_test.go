@@ -0,0 +1,53 @@
+package e2e
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDeleteAccount(t *testing.T) {
+	h := NewHarness(t)
+	adminToken := registerAndLogin(t, h, "e2eadmin")
+	h.SetRole("e2eadmin", "admin")
+	token := registerAndLogin(t, h, "e2edelete")
+
+	// 需要再次确认密码
+	expectCode(t, "delete without password", h.DoJSON(http.MethodDelete, "/user/", token, map[string]string{}),
+		http.StatusUnprocessableEntity)
+	expectCode(t, "delete with wrong password", h.DoJSON(http.MethodDelete, "/user/", token,
+		map[string]string{"password": "654321"}), http.StatusForbidden)
+
+	// 注销后token失效, 不能再登录
+	rsp := h.DoJSON(http.MethodDelete, "/user/", token, map[string]string{"password": "123456"})
+	expectCode(t, "delete", rsp, http.StatusOK)
+	if purgeTime, _ := rsp.Data()["purge_time"].(float64); int64(purgeTime) <= time.Now().Unix() {
+		t.Fatalf("delete: unexpected body %v", rsp.Body)
+	}
+	expectCode(t, "profile after delete", h.DoJSON(http.MethodGet, "/user/", token, nil), http.StatusUnauthorized)
+	rsp = login(t, h, "e2edelete")
+	expectCode(t, "login after delete", rsp, http.StatusForbidden)
+	if rsp.Body["error"] != "account_pending_deletion" {
+		t.Fatalf("login after delete: unexpected body %v", rsp.Body)
+	}
+
+	// 管理员可以看到注销状态, 不能禁用, 可以恢复
+	rsp = h.DoJSON(http.MethodGet, "/admin/users/e2edelete", adminToken, nil)
+	expectCode(t, "get deleted user", rsp, http.StatusOK)
+	if rsp.Data()["status"] != "pending_deletion" || rsp.Data()["delete_time"] == float64(0) {
+		t.Fatalf("get deleted user: unexpected body %v", rsp.Body)
+	}
+	rsp = h.DoJSON(http.MethodPut, "/admin/users/e2edelete/status", adminToken,
+		map[string]string{"status": "disabled", "reason": "滥用"})
+	expectCode(t, "disable deleted user", rsp, http.StatusConflict)
+	if rsp.Body["error"] != "account_pending_deletion" {
+		t.Fatalf("disable deleted user: unexpected body %v", rsp.Body)
+	}
+	rsp = h.DoJSON(http.MethodPut, "/admin/users/e2edelete/status", adminToken,
+		map[string]string{"status": "active", "reason": "用户撤回注销"})
+	expectCode(t, "restore", rsp, http.StatusOK)
+	if rsp.Data()["status"] != "active" || rsp.Data()["delete_time"] != float64(0) {
+		t.Fatalf("restore: unexpected body %v", rsp.Body)
+	}
+	expectCode(t, "login after restore", login(t, h, "e2edelete"), http.StatusOK)
+}
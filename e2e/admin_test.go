@@ -0,0 +1,177 @@
+package e2e
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	rpcAudit "user_rpc/pkg/audit"
+	rpcUserService "user_rpc/service/user"
+)
+
+// 按事件类型前缀筛选审计事件
+func auditEvents(h *Harness, prefix string) []rpcAudit.Event {
+	var events []rpcAudit.Event
+	for _, event := range h.Audit.Events() {
+		if strings.HasPrefix(event.Action, prefix) {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// 登录, 返回响应
+func login(t *testing.T, h *Harness, username string) Response {
+	t.Helper()
+	captchaID, captchaAns := captcha(t, h)
+	return h.DoJSON(http.MethodPost, "/login", "", map[string]string{
+		"username":    username,
+		"password":    "123456",
+		"captcha_id":  captchaID,
+		"captcha_ans": captchaAns,
+	})
+}
+
+func TestAdminRequiresPermission(t *testing.T) {
+	h := NewHarness(t)
+	token := registerAndLogin(t, h, "e2eplain")
+
+	rsp := h.DoJSON(http.MethodGet, "/admin/users?q=e2e", token, nil)
+	expectCode(t, "search as user", rsp, http.StatusForbidden)
+	if rsp.Body["error"] != "insufficient_permission" {
+		t.Fatalf("search as user: unexpected body %v", rsp.Body)
+	}
+	events := auditEvents(h, "/userapi.v1.UserService/")
+	if len(events) != 1 || events[0].Outcome != rpcAudit.OutcomeDenied || events[0].Actor != "e2eplain" {
+		t.Fatalf("unexpected audit events: %v", events)
+	}
+
+	expectCode(t, "search without token", h.DoJSON(http.MethodGet, "/admin/users", "", nil), http.StatusUnauthorized)
+}
+
+func TestAdminManageUser(t *testing.T) {
+	h := NewHarness(t)
+	adminToken := registerAndLogin(t, h, "e2eadmin")
+	h.SetRole("e2eadmin", "admin")
+	userToken := registerAndLogin(t, h, "e2etarget")
+	expectCode(t, "edit nickname", h.DoJSON(http.MethodPut, "/user/", userToken,
+		map[string]string{"nickname": "badnick"}), http.StatusOK)
+
+	// 搜索和查看
+	rsp := h.DoJSON(http.MethodGet, "/admin/users?q=e2etar", adminToken, nil)
+	expectCode(t, "search", rsp, http.StatusOK)
+	users, _ := rsp.Data()["users"].([]interface{})
+	if len(users) != 1 || users[0].(map[string]interface{})["username"] != "e2etarget" {
+		t.Fatalf("search: unexpected body %v", rsp.Body)
+	}
+	rsp = h.DoJSON(http.MethodGet, "/admin/users/e2etarget", adminToken, nil)
+	expectCode(t, "get user", rsp, http.StatusOK)
+	if rsp.Data()["status"] != "active" || rsp.Data()["role"] != "user" {
+		t.Fatalf("get user: unexpected body %v", rsp.Body)
+	}
+	expectCode(t, "get missing user", h.DoJSON(http.MethodGet, "/admin/users/e2emissing", adminToken, nil),
+		http.StatusNotFound)
+
+	// 重置昵称
+	rsp = h.DoJSON(http.MethodPost, "/admin/users/e2etarget/nickname/reset", adminToken,
+		map[string]string{"reason": "违规昵称"})
+	expectCode(t, "reset nickname", rsp, http.StatusOK)
+	if rsp.Data()["nickname"] != "" {
+		t.Fatalf("reset nickname: unexpected body %v", rsp.Body)
+	}
+	expectCode(t, "reset nickname without reason", h.DoJSON(http.MethodPost,
+		"/admin/users/e2etarget/nickname/reset", adminToken, map[string]string{}), http.StatusUnprocessableEntity)
+
+	// 不能修改自己的状态, 由 user_rpc 校验
+	h.RPCCalls()
+	expectCode(t, "disable self", h.DoJSON(http.MethodPut, "/admin/users/e2eadmin/status", adminToken,
+		map[string]string{"status": "disabled", "reason": "测试"}), http.StatusBadRequest)
+	if calls := h.RPCCalls(); len(calls) == 0 || calls[len(calls)-1].Method != "/userapi.v1.UserService/SetUserStatus" {
+		t.Fatalf("disable self: unexpected rpc calls %+v", calls)
+	}
+
+	// 禁用后token失效, 不能再登录
+	rsp = h.DoJSON(http.MethodPut, "/admin/users/e2etarget/status", adminToken,
+		map[string]string{"status": "disabled", "reason": "滥用"})
+	expectCode(t, "disable", rsp, http.StatusOK)
+	if rsp.Data()["status"] != "disabled" {
+		t.Fatalf("disable: unexpected body %v", rsp.Body)
+	}
+	expectCode(t, "profile after disable", h.DoJSON(http.MethodGet, "/user/", userToken, nil),
+		http.StatusUnauthorized)
+	rsp = login(t, h, "e2etarget")
+	expectCode(t, "login after disable", rsp, http.StatusForbidden)
+	if rsp.Body["error"] != "account_disabled" {
+		t.Fatalf("login after disable: unexpected body %v", rsp.Body)
+	}
+
+	// 启用后可以登录, 强制登出后token失效
+	expectCode(t, "enable", h.DoJSON(http.MethodPut, "/admin/users/e2etarget/status", adminToken,
+		map[string]string{"status": "active", "reason": "申诉通过"}), http.StatusOK)
+	rsp = login(t, h, "e2etarget")
+	expectCode(t, "login after enable", rsp, http.StatusOK)
+	userToken, _ = rsp.Data()["token"].(string)
+	expectCode(t, "profile after enable", h.DoJSON(http.MethodGet, "/user/", userToken, nil), http.StatusOK)
+	expectCode(t, "force logout", h.DoJSON(http.MethodPost, "/admin/users/e2etarget/logout", adminToken,
+		map[string]string{"reason": "账号异常"}), http.StatusOK)
+	expectCode(t, "profile after force logout", h.DoJSON(http.MethodGet, "/user/", userToken, nil),
+		http.StatusUnauthorized)
+
+	// 每个管理操作都有审计记录
+	var actions []string
+	for _, event := range auditEvents(h, "admin.") {
+		if event.Actor != "e2eadmin" {
+			t.Fatalf("unexpected audit actor: %v", event)
+		}
+		actions = append(actions, event.Action)
+	}
+	expected := []string{
+		rpcUserService.ActionSearchUsers, rpcUserService.ActionGetUser, rpcUserService.ActionGetUser,
+		rpcUserService.ActionResetNickname, rpcUserService.ActionSetUserStatus, rpcUserService.ActionSetUserStatus,
+		rpcUserService.ActionSetUserStatus, rpcUserService.ActionForceLogout,
+	}
+	if len(actions) != len(expected) {
+		t.Fatalf("unexpected audit actions: %v", actions)
+	}
+	for i := range expected {
+		if actions[i] != expected[i] {
+			t.Fatalf("unexpected audit actions: %v", actions)
+		}
+	}
+}
+
+func TestAdminListAuditEvents(t *testing.T) {
+	h := NewHarness(t)
+	adminToken := registerAndLogin(t, h, "e2eauditor")
+	h.SetRole("e2eauditor", "admin")
+	userToken := registerAndLogin(t, h, "e2eaudited")
+	expectCode(t, "edit nickname", h.DoJSONWithHeader(http.MethodPut, "/user/", userToken,
+		map[string]string{"nickname": "newnick"}, http.Header{"User-Agent": []string{"e2e-agent"}}), http.StatusOK)
+
+	// 普通用户不能查询审计日志
+	expectCode(t, "list as user", h.DoJSON(http.MethodGet, "/admin/audit-events", userToken, nil),
+		http.StatusForbidden)
+
+	// 按用户和事件类型筛选, 记录客户端信息和修改前后的值
+	rsp := h.DoJSON(http.MethodGet, "/admin/audit-events?username=e2eaudited&action="+
+		rpcUserService.ActionEditProfile, adminToken, nil)
+	expectCode(t, "list edit events", rsp, http.StatusOK)
+	events, _ := rsp.Data()["events"].([]interface{})
+	if len(events) != 1 {
+		t.Fatalf("list edit events: unexpected body %v", rsp.Body)
+	}
+	event := events[0].(map[string]interface{})
+	after, _ := event["after"].(map[string]interface{})
+	if event["actor"] != "e2eaudited" || event["user_agent"] != "e2e-agent" || event["ip"] == "" ||
+		after["nickname"] != "newnick" {
+		t.Fatalf("list edit events: unexpected event %v", event)
+	}
+
+	// 分页
+	rsp = h.DoJSON(http.MethodGet, "/admin/audit-events?username=e2eaudited&page_size=1", adminToken, nil)
+	expectCode(t, "list first page", rsp, http.StatusOK)
+	if events, _ = rsp.Data()["events"].([]interface{}); len(events) != 1 || rsp.Data()["next_page_token"] == "" {
+		t.Fatalf("list first page: unexpected body %v", rsp.Body)
+	}
+	expectCode(t, "list invalid range", h.DoJSON(http.MethodGet, "/admin/audit-events?start_time=200&end_time=100",
+		adminToken, nil), http.StatusBadRequest)
+}
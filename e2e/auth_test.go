@@ -0,0 +1,67 @@
+package e2e
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 使用原始 Authorization 请求头获取用户信息
+func getProfileWithAuthorization(h *Harness, authorization string) Response {
+	req := httptest.NewRequest(http.MethodGet, "/user/", nil)
+	if len(authorization) > 0 {
+		req.Header.Set("Authorization", authorization)
+	}
+	return h.Do(req)
+}
+
+func expectChallenge(t *testing.T, step string, rsp Response, code int, errCode, headerErr string) {
+	t.Helper()
+	expectCode(t, step, rsp, code)
+	challenge := rsp.Header.Get("WWW-Authenticate")
+	if !strings.HasPrefix(challenge, `Bearer realm="user_web"`) {
+		t.Fatalf("%s: unexpected WWW-Authenticate %q", step, challenge)
+	}
+	if hasErr := strings.Contains(challenge, "error="); hasErr != (len(headerErr) > 0) ||
+		hasErr && !strings.Contains(challenge, `error="`+headerErr+`"`) {
+		t.Fatalf("%s: expected error %q in WWW-Authenticate %q", step, headerErr, challenge)
+	}
+	if rsp.Body["error"] != errCode {
+		t.Fatalf("%s: expected error %q, got body %v", step, errCode, rsp.Body)
+	}
+}
+
+func TestBearerAuthentication(t *testing.T) {
+	h := NewHarness(t)
+	token := registerAndLogin(t, h, "e2ebearer")
+
+	// Bearer 不区分大小写, 兼容直接传token
+	for _, authorization := range []string{"Bearer " + token, "bearer  " + token, token} {
+		expectCode(t, authorization, getProfileWithAuthorization(h, authorization), http.StatusOK)
+	}
+
+	expectChallenge(t, "missing token", getProfileWithAuthorization(h, ""),
+		http.StatusUnauthorized, "missing_token", "")
+	expectChallenge(t, "unsupported scheme", getProfileWithAuthorization(h, "Basic dXNlcjpwd2Q="),
+		http.StatusUnauthorized, "missing_token", "")
+	expectChallenge(t, "malformed", getProfileWithAuthorization(h, "Bearer "+token+" extra"),
+		http.StatusBadRequest, "invalid_request", "invalid_request")
+	expectChallenge(t, "never issued", getProfileWithAuthorization(h, "Bearer neverissued"),
+		http.StatusUnauthorized, "invalid_token", "invalid_token")
+
+	// token过期后返回 expired, 登出的token返回 invalid_token
+	loggedOut := registerAndLogin(t, h, "e2ebearer2")
+	expectCode(t, "logout", h.DoJSON(http.MethodPost, "/logout", loggedOut, nil), http.StatusOK)
+	h.Redis.FastForward(time.Hour + time.Second)
+	expectChallenge(t, "expired", getProfileWithAuthorization(h, "Bearer "+token),
+		http.StatusUnauthorized, "expired", "invalid_token")
+	expectChallenge(t, "logged out", getProfileWithAuthorization(h, "Bearer "+loggedOut),
+		http.StatusUnauthorized, "invalid_token", "invalid_token")
+
+	// 签发记录也过期后视为无效
+	h.Redis.FastForward(time.Hour)
+	expectChallenge(t, "forgotten", getProfileWithAuthorization(h, "Bearer "+token),
+		http.StatusUnauthorized, "invalid_token", "invalid_token")
+}
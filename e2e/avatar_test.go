@@ -0,0 +1,302 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	webClient "user_web/client"
+	"user_web/pkg/avatargc"
+	webStorage "user_web/pkg/storage"
+	"user_web/pkg/upload"
+	webUtil "user_web/pkg/util"
+)
+
+// 生成指定颜色的png图片
+func solidPNG(t *testing.T, c color.Color) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for x := 0; x < 16; x++ {
+		for y := 0; y < 16; y++ {
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// 上传头像, 返回存储路径
+func uploadAvatar(t *testing.T, h *Harness, token string, content []byte) string {
+	t.Helper()
+	rsp := h.DoUpload("/user/avatar", token, "avatar", "avatar.png", content)
+	expectCode(t, "upload avatar", rsp, http.StatusOK)
+	picPath, _ := rsp.Data()["pic_path"].(string)
+	return picPath
+}
+
+func TestAvatarGC(t *testing.T) {
+	h := NewHarness(t)
+	alice := registerAndLogin(t, h, "gcalice")
+	bob := registerAndLogin(t, h, "gcbob")
+
+	// alice 更换头像后旧头像不再被引用; bob 与 alice 的旧头像相同, 共用同一个文件
+	red := solidPNG(t, color.RGBA{R: 255, A: 255})
+	old := uploadAvatar(t, h, alice, red)
+	current := uploadAvatar(t, h, alice, solidPNG(t, color.RGBA{G: 255, A: 255}))
+	orphan := uploadAvatar(t, h, bob, solidPNG(t, color.RGBA{B: 255, A: 255}))
+	if uploadAvatar(t, h, bob, red) != old {
+		t.Fatalf("same content should share one avatar")
+	}
+
+	// 遍历头像路径需要管理员的身份凭证
+	refs := avatargc.RPCReferences(webClient.RpcClient)
+	anonymous := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("request_id", "gc"))
+	if _, err := avatargc.Run(anonymous, webStorage.Store, refs, avatargc.Options{Prefix: "avatars"}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("gc without identity: expected Unauthenticated, got %v", err)
+	}
+	if _, err := avatargc.Run(gcContext(t, alice), webStorage.Store, refs, avatargc.Options{Prefix: "avatars"}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("gc as user: expected PermissionDenied, got %v", err)
+	}
+	admin := registerAndLogin(t, h, "gcadmin")
+	h.SetRole("gcadmin", "admin")
+	ctx := gcContext(t, admin)
+	report, err := avatargc.Run(ctx, webStorage.Store, refs, avatargc.Options{
+		Prefix: "avatars", GracePeriod: time.Hour, DryRun: true,
+	})
+	if err != nil {
+		t.Fatalf("gc within grace period: %v", err)
+	}
+	if report.Scanned != 9 || report.Recent != 3 || len(report.Orphans) != 0 {
+		t.Fatalf("unexpected report within grace period: %+v", report)
+	}
+
+	report, err = avatargc.Run(ctx, webStorage.Store, refs, avatargc.Options{Prefix: "avatars"})
+	if err != nil {
+		t.Fatalf("gc: %v", err)
+	}
+	if report.Referenced != 6 || report.Deleted != 3 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	for picPath, kept := range map[string]bool{old: true, current: true, orphan: false} {
+		ok, _ := webStorage.Store.Exists(ctx, webUtil.AvatarVariantKey(picPath, 512))
+		if ok != kept {
+			t.Fatalf("%s: expected kept=%v", picPath, kept)
+		}
+	}
+}
+
+// 校验token, 返回携带身份凭证的rpc context
+func gcContext(t *testing.T, token string) context.Context {
+	t.Helper()
+	ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("request_id", "gc"))
+	ctx, err := avatargc.Authenticate(ctx, webClient.RpcClient, token)
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	return ctx
+}
+
+// 统计上传会话保存在存储中的分片数量
+func chunkCount(t *testing.T, location string) int {
+	t.Helper()
+	var n int
+	dir := "uploads/" + strings.TrimPrefix(location, "/user/avatar/uploads/")
+	err := webStorage.Store.List(context.Background(), dir, func(webStorage.ObjectInfo) error {
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("list chunks: %v", err)
+	}
+	return n
+}
+
+// 发送断点续传请求
+func uploadRequest(method, path, token string, body []byte, header map[string]string) *http.Request {
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	req.Header.Set("Authorization", token)
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	return req
+}
+
+func TestAvatarUploadSizeLimit(t *testing.T) {
+	h := NewHarness(t)
+	token := registerAndLogin(t, h, "limituser")
+
+	// 超过上限的请求在解析multipart之前被拒绝
+	rsp := h.DoUpload("/user/avatar", token, "avatar", "avatar.png", make([]byte, 2<<20))
+	expectCode(t, "upload too large avatar", rsp, http.StatusRequestEntityTooLarge)
+
+	// 未声明Content-Length时读取超过上限也会被拒绝
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("avatar", "avatar.png")
+	_, _ = fw.Write(make([]byte, 2<<20))
+	_ = mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/user/avatar", ioutil.NopCloser(&body))
+	req.ContentLength = -1
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Authorization", token)
+	if w := h.Serve(req); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("upload chunked too large avatar: expected 413, got %d", w.Code)
+	}
+}
+
+func TestResumableAvatarUpload(t *testing.T) {
+	h := NewHarness(t)
+	token := registerAndLogin(t, h, "tususer")
+	other := registerAndLogin(t, h, "tusother")
+	content := solidPNG(t, color.RGBA{R: 128, G: 64, A: 255})
+	patchHeader := func(offset int) map[string]string {
+		return map[string]string{"Content-Type": "application/offset+octet-stream", "Upload-Offset": strconv.Itoa(offset)}
+	}
+
+	// 创建会话
+	w := h.Serve(uploadRequest(http.MethodPost, "/user/avatar/uploads", token, nil,
+		map[string]string{"Upload-Length": strconv.Itoa(2 << 20)}))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("create too large upload: expected 413, got %d", w.Code)
+	}
+	w = h.Serve(uploadRequest(http.MethodPost, "/user/avatar/uploads", token, nil,
+		map[string]string{"Upload-Length": strconv.Itoa(len(content))}))
+	location := w.Header().Get("Location")
+	if w.Code != http.StatusCreated || !strings.HasPrefix(location, "/user/avatar/uploads/") {
+		t.Fatalf("create upload: %d %s", w.Code, location)
+	}
+
+	// 上传前半部分
+	half := len(content) / 2
+	w = h.Serve(uploadRequest(http.MethodPatch, location, token, content[:half], patchHeader(0)))
+	if w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != strconv.Itoa(half) {
+		t.Fatalf("patch first half: %d %s", w.Code, w.Header().Get("Upload-Offset"))
+	}
+
+	// 分片保存在存储中, redis只保存会话信息
+	if n := chunkCount(t, location); n != 1 {
+		t.Fatalf("expected 1 stored chunk, got %d", n)
+	}
+
+	// 重复发送的请求偏移量不一致; 其他用户看不到该会话
+	w = h.Serve(uploadRequest(http.MethodPatch, location, token, content[:half], patchHeader(0)))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("patch with stale offset: expected 409, got %d", w.Code)
+	}
+	if n := chunkCount(t, location); n != 1 {
+		t.Fatalf("rejected chunk should be removed, got %d chunks", n)
+	}
+	if w = h.Serve(uploadRequest(http.MethodHead, location, other, nil, nil)); w.Code != http.StatusNotFound {
+		t.Fatalf("head other user's upload: expected 404, got %d", w.Code)
+	}
+
+	// 断线后查询进度再继续上传
+	w = h.Serve(uploadRequest(http.MethodHead, location, token, nil, nil))
+	if w.Code != http.StatusOK || w.Header().Get("Upload-Offset") != strconv.Itoa(half) ||
+		w.Header().Get("Upload-Length") != strconv.Itoa(len(content)) {
+		t.Fatalf("head upload: %d %v", w.Code, w.Header())
+	}
+	rsp := h.Do(uploadRequest(http.MethodPatch, location, token, content[half:], patchHeader(half)))
+	expectCode(t, "patch second half", rsp, http.StatusOK)
+	picPath, _ := rsp.Data()["pic_path"].(string)
+	if ok, _ := webStorage.Store.Exists(context.Background(), webUtil.AvatarVariantKey(picPath, 512)); !ok {
+		t.Fatalf("resumable upload avatar not stored: %v", rsp.Data())
+	}
+
+	// 上传完成后会话被删除
+	rsp = h.DoJSON(http.MethodGet, "/user/", token, nil)
+	if rsp.Data()["pic_path"] != picPath {
+		t.Fatalf("profile not updated: %v", rsp.Data())
+	}
+	if w = h.Serve(uploadRequest(http.MethodHead, location, token, nil, nil)); w.Code != http.StatusNotFound {
+		t.Fatalf("head finished upload: expected 404, got %d", w.Code)
+	}
+	if n := chunkCount(t, location); n != 0 {
+		t.Fatalf("finished upload left %d chunks", n)
+	}
+}
+
+// 读取到一半断开的请求体
+type brokenReader struct{}
+
+func (brokenReader) Read([]byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
+
+func TestResumableUploadLimits(t *testing.T) {
+	h := NewHarness(t)
+	token := registerAndLogin(t, h, "tuslimit")
+	create := func() string {
+		t.Helper()
+		w := h.Serve(uploadRequest(http.MethodPost, "/user/avatar/uploads", token, nil,
+			map[string]string{"Upload-Length": "100"}))
+		if w.Code != http.StatusCreated {
+			t.Fatalf("create upload: %d", w.Code)
+		}
+		return w.Header().Get("Location")
+	}
+
+	// 超过 UPLOAD_SESSION_MAX 时最早的会话及其分片失效
+	first := create()
+	if w := h.Serve(uploadRequest(http.MethodPatch, first, token, make([]byte, 10),
+		map[string]string{"Content-Type": "application/offset+octet-stream", "Upload-Offset": "0"})); w.Code != http.StatusNoContent {
+		t.Fatalf("patch first upload: %d", w.Code)
+	}
+	second := create()
+	if w := h.Serve(uploadRequest(http.MethodHead, first, token, nil, nil)); w.Code != http.StatusNotFound {
+		t.Fatalf("head evicted upload: expected 404, got %d", w.Code)
+	}
+	if n := chunkCount(t, first); n != 0 {
+		t.Fatalf("evicted upload left %d chunks", n)
+	}
+	if w := h.Serve(uploadRequest(http.MethodHead, second, token, nil, nil)); w.Code != http.StatusOK {
+		t.Fatalf("head latest upload: expected 200, got %d", w.Code)
+	}
+
+	// 客户端断开等读取错误返回400, 只有超过剩余长度返回413
+	req := httptest.NewRequest(http.MethodPatch, second, ioutil.NopCloser(brokenReader{}))
+	req.Header.Set("Authorization", token)
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", "0")
+	if w := h.Serve(req); w.Code != http.StatusBadRequest {
+		t.Fatalf("patch broken body: expected 400, got %d", w.Code)
+	}
+	w := h.Serve(uploadRequest(http.MethodPatch, second, token, make([]byte, 101),
+		map[string]string{"Content-Type": "application/offset+octet-stream", "Upload-Offset": "0"}))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("patch too large chunk: expected 413, got %d", w.Code)
+	}
+
+	// 会话过期后留下的分片被回收
+	if w = h.Serve(uploadRequest(http.MethodPatch, second, token, make([]byte, 10),
+		map[string]string{"Content-Type": "application/offset+octet-stream", "Upload-Offset": "0"})); w.Code != http.StatusNoContent {
+		t.Fatalf("patch second upload: %d", w.Code)
+	}
+	ctx := context.Background()
+	if orphans, err := upload.Sweep(ctx, webStorage.Store, false); err != nil || len(orphans) != 0 {
+		t.Fatalf("sweep active upload: %v %v", orphans, err)
+	}
+	h.Redis.FastForward(2 * time.Hour)
+	if orphans, err := upload.Sweep(ctx, webStorage.Store, false); err != nil || len(orphans) != 1 {
+		t.Fatalf("sweep expired upload: %v %v", orphans, err)
+	}
+	if n := chunkCount(t, second); n != 0 {
+		t.Fatalf("expired upload left %d chunks", n)
+	}
+}
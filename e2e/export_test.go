@@ -0,0 +1,110 @@
+package e2e
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// 等待导出完成, 返回下载地址
+func waitExport(t *testing.T, h *Harness, token string) string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		rsp := h.DoJSON(http.MethodGet, "/user/export", token, nil)
+		if rsp.Code == http.StatusOK {
+			if rsp.Data()["status"] != "ready" {
+				t.Fatalf("export: unexpected body %v", rsp.Body)
+			}
+			downloadURL, _ := rsp.Data()["download_url"].(string)
+			return downloadURL
+		}
+		expectCode(t, "export progress", rsp, http.StatusAccepted)
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("export not ready")
+	return ""
+}
+
+func TestExportUserData(t *testing.T) {
+	h := NewHarness(t)
+	token := registerAndLogin(t, h, "e2eexport")
+	picPath := uploadAvatar(t, h, token, pngImage(t))
+
+	expectCode(t, "export before create", h.DoJSON(http.MethodGet, "/user/export", token, nil), http.StatusNotFound)
+	expectCode(t, "export without token", h.DoJSON(http.MethodPost, "/user/export", "", nil), http.StatusUnauthorized)
+
+	// 后台生成压缩包
+	rsp := h.DoJSON(http.MethodPost, "/user/export", token, nil)
+	expectCode(t, "create export", rsp, http.StatusAccepted)
+	if rsp.Data()["status"] != "pending" || rsp.Header.Get("Location") != "/user/export" {
+		t.Fatalf("create export: unexpected response %v %v", rsp.Header, rsp.Body)
+	}
+	downloadURL := waitExport(t, h, token)
+
+	// 下载地址不需要登录凭证
+	w := h.Serve(httptest.NewRequest(http.MethodGet, downloadURL, nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" ||
+		w.Header().Get("Cache-Control") != "private, no-store" {
+		t.Fatalf("download: %d %v", w.Code, w.Header())
+	}
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("open archive: %v", err)
+	}
+	var data struct {
+		Profile struct {
+			Username string `json:"username"`
+			PicPath  string `json:"pic_path"`
+		} `json:"profile"`
+		ActiveSessions int `json:"active_sessions"`
+	}
+	var avatars int
+	for _, f := range zr.File {
+		if f.Name != "data.json" {
+			avatars++
+			continue
+		}
+		r, _ := f.Open()
+		content, _ := ioutil.ReadAll(r)
+		r.Close()
+		if err = json.Unmarshal(content, &data); err != nil {
+			t.Fatalf("decode data.json: %v", err)
+		}
+	}
+	if data.Profile.Username != "e2eexport" || data.Profile.PicPath != picPath || data.ActiveSessions != 1 || avatars == 0 {
+		t.Fatalf("unexpected archive: %+v, %d avatars", data, avatars)
+	}
+
+	// 篡改签名的地址不可用
+	w = h.Serve(httptest.NewRequest(http.MethodGet, downloadURL+"x", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("download with invalid signature: %d", w.Code)
+	}
+
+	// 重新导出后旧文件被删除, 超过次数限制后返回429
+	expectCode(t, "export again", h.DoJSON(http.MethodPost, "/user/export", token, nil), http.StatusAccepted)
+	newURL := waitExport(t, h, token)
+	if newURL == downloadURL {
+		t.Fatalf("export again should create a new archive")
+	}
+	if w = h.Serve(httptest.NewRequest(http.MethodGet, downloadURL, nil)); w.Code != http.StatusNotFound {
+		t.Fatalf("download previous export: %d", w.Code)
+	}
+	if w = h.Serve(httptest.NewRequest(http.MethodGet, newURL, nil)); w.Code != http.StatusOK {
+		t.Fatalf("download new export: %d", w.Code)
+	}
+	expectCode(t, "export rate limited", h.DoJSON(http.MethodPost, "/user/export", token, nil), http.StatusTooManyRequests)
+
+	// 注销账号时删除导出文件
+	expectCode(t, "delete account", h.DoJSON(http.MethodDelete, "/user/", token, map[string]string{"password": "123456"}),
+		http.StatusOK)
+	if w = h.Serve(httptest.NewRequest(http.MethodGet, newURL, nil)); w.Code != http.StatusNotFound {
+		t.Fatalf("download after delete account: %d", w.Code)
+	}
+}
@@ -0,0 +1,22 @@
+module e2e
+
+go 1.16
+
+require (
+	github.com/alicebob/miniredis/v2 v2.30.0
+	github.com/gin-gonic/gin v1.7.7
+	github.com/go-redis/redis/v8 v8.11.5
+	github.com/spf13/viper v1.11.0
+	go.uber.org/zap v1.21.0
+	google.golang.org/grpc v1.45.0
+	gopkg.in/natefinch/lumberjack.v2 v2.2.1 // indirect
+	user_rpc v0.0.0
+	user_web v0.0.0
+	userapi v0.0.0
+)
+
+replace (
+	user_rpc => ../user_rpc
+	user_web => ../user_web
+	userapi => ../userapi
+)
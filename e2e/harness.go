@@ -0,0 +1,350 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	redisLib "github.com/go-redis/redis/v8"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+	rpcUserDao "user_rpc/dao/user"
+	rpcHandler "user_rpc/handler"
+	rpcAudit "user_rpc/pkg/audit"
+	rpcConfig "user_rpc/pkg/config"
+	rpcIdentity "user_rpc/pkg/identity"
+	rpcLimiter "user_rpc/pkg/limiter"
+	rpcLogger "user_rpc/pkg/logger"
+	rpcMfa "user_rpc/pkg/mfa"
+	rpcNotify "user_rpc/pkg/notify"
+	rpcRbac "user_rpc/pkg/rbac"
+	rpcRedis "user_rpc/pkg/redis"
+	rpcUserService "user_rpc/service/user"
+	webClient "user_web/client"
+	webConfig "user_web/pkg/config"
+	webExport "user_web/pkg/export"
+	webLogger "user_web/pkg/logger"
+	webRedis "user_web/pkg/redis"
+	webStorage "user_web/pkg/storage"
+	webRoute "user_web/route"
+	"userapi/v1"
+)
+
+// bufconn缓冲区大小
+const bufSize = 1024 * 1024
+
+// Harness 端到端测试环境
+// user_rpc 运行在 bufconn 上, mysql 使用内存实现, redis 使用 miniredis;
+// user_web 的路由直接使用 bufconn 的 rpc 客户端, 通过 httptest 驱动
+type Harness struct {
+	t         *testing.T
+	Redis     *miniredis.Miniredis
+	Router    *gin.Engine
+	UploadDir string
+	RPCServer *grpc.Server
+	Users     *rpcUserDao.MemoryUserRepository
+	Audit     *rpcAudit.MemoryRecorder
+	Notifier  *rpcNotify.MemoryNotifier
+
+	userCache *rpcUserDao.RedisUserCache
+	mu        sync.Mutex
+	rpcCalls  []RPCCall
+	failures  map[string]codes.Code
+}
+
+// RPCCall user_rpc 收到的一元rpc请求
+type RPCCall struct {
+	Method string
+	Req    interface{}
+}
+
+// NewHarness 启动端到端测试环境, 测试结束时自动关闭
+func NewHarness(t *testing.T) *Harness {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	h := &Harness{
+		t:         t,
+		Redis:     miniredis.RunT(t),
+		UploadDir: t.TempDir(),
+	}
+	h.setupConfig()
+	rpcLogger.Logger = zap.NewNop()
+	webLogger.Logger = zap.NewNop()
+
+	// 启动user_rpc
+	rpcRedisClient := &rpcRedis.RedisClient{
+		Client: redisLib.NewClient(&redisLib.Options{Addr: h.Redis.Addr()}),
+		Ctx:    context.Background(),
+	}
+	h.Users = rpcUserDao.NewMemoryUserRepository()
+	h.Audit = &rpcAudit.MemoryRecorder{}
+	h.Notifier = &rpcNotify.MemoryNotifier{}
+	h.userCache = rpcUserDao.NewRedisUserCache(rpcRedisClient)
+	userService := rpcUserService.NewUserService(
+		h.Users,
+		rpcUserDao.NewRedisTokenStore(rpcRedisClient),
+		h.userCache,
+		rpcIdentity.NewSigner([]byte("e2e"), time.Minute),
+		h.Audit,
+	)
+	userService.SetAuditQuerier(h.Audit)
+	userService.SetLoginHistory(rpcUserDao.NewRedisLoginHistory(rpcRedisClient), h.Notifier)
+	mfaAuthenticator, err := rpcMfa.NewAuthenticator("e2e", []byte("e2e"))
+	if err != nil {
+		t.Fatalf("new mfa authenticator: %v", err)
+	}
+	userService.SetMFA(mfaAuthenticator, rpcUserDao.NewRedisMFAChallengeStore(rpcRedisClient), time.Minute)
+	userHandler := rpcHandler.NewUserHandler(userService)
+	lis := bufconn.Listen(bufSize)
+	limiter := rpcLimiter.NewLimiter(rpcLimiter.Options{
+		InitialLimit: 100, MinLimit: 10, MaxLimit: 100, LatencyThreshold: time.Second,
+	})
+	rpcServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(h.recordCall, rpcLimiter.UnaryServerInterceptor(limiter, rpcHandler.MethodPriorities),
+			rpcRbac.UnaryServerInterceptor(rpcHandler.MethodPermissions, userService.CallerRole, h.Audit)),
+		grpc.ChainStreamInterceptor(rpcLimiter.StreamServerInterceptor(limiter, rpcHandler.MethodPriorities),
+			rpcRbac.StreamServerInterceptor(rpcHandler.MethodPermissions, userService.CallerRole, h.Audit)),
+	)
+	userapi.RegisterUserServiceServer(rpcServer, userHandler)
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(rpcHandler.HealthServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(rpcServer, healthServer)
+	h.RPCServer = rpcServer
+	go func() {
+		_ = rpcServer.Serve(lis)
+	}()
+
+	// user_web连接bufconn
+	conn, err := grpc.Dial("bufnet", append(webClient.DialOptions(),
+		grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
+			return lis.Dial()
+		}),
+	)...)
+	if err != nil {
+		t.Fatalf("dial bufconn: %v", err)
+	}
+	webClient.RpcClient = userapi.NewUserServiceClient(conn)
+
+	// 验证码通过sync.Once持有redis.Redis指针, 原地替换以便多个测试复用
+	if webRedis.Redis == nil {
+		webRedis.Redis = &webRedis.RedisClient{}
+	}
+	*webRedis.Redis = webRedis.RedisClient{
+		Client: redisLib.NewClient(&redisLib.Options{Addr: h.Redis.Addr()}),
+		Ctx:    context.Background(),
+	}
+
+	// 头像存储使用本地目录
+	webStorage.Store = webStorage.NewLocalStorage(h.UploadDir, "")
+	webExport.SetupExport()
+
+	h.ResetRouter()
+
+	t.Cleanup(func() {
+		_ = conn.Close()
+		rpcServer.Stop()
+		_ = webRedis.Redis.Client.Close()
+		_ = rpcRedisClient.Client.Close()
+	})
+	return h
+}
+
+// 记录 user_rpc 收到的请求
+func (h *Harness) recordCall(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	h.mu.Lock()
+	h.rpcCalls = append(h.rpcCalls, RPCCall{Method: info.FullMethod, Req: req})
+	code, fail := h.failures[info.FullMethod]
+	h.mu.Unlock()
+	if fail {
+		return nil, status.Error(code, "injected failure")
+	}
+	return handler(ctx, req)
+}
+
+// FailMethod user_rpc 的指定方法之后都返回code错误
+func (h *Harness) FailMethod(method string, code codes.Code) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.failures == nil {
+		h.failures = make(map[string]codes.Code)
+	}
+	h.failures[method] = code
+}
+
+// RPCCalls 返回并清空 user_rpc 收到的请求
+func (h *Harness) RPCCalls() []RPCCall {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	calls := h.rpcCalls
+	h.rpcCalls = nil
+	return calls
+}
+
+// SetRole 修改用户角色并清除用户缓存, 角色只能通过数据库修改
+func (h *Harness) SetRole(username, role string) {
+	h.t.Helper()
+	if _, err := h.Users.UpdateColumnsByUsername(username, 0, map[string]interface{}{"role": role}); err != nil {
+		h.t.Fatalf("set role: %v", err)
+	}
+	if err := h.userCache.Del(username); err != nil {
+		h.t.Fatalf("set role: %v", err)
+	}
+}
+
+// ResetRouter 重新注册user_web路由, 修改中间件在注册时读取的配置后使用
+func (h *Harness) ResetRouter() {
+	h.Router = gin.New()
+	webRoute.RegisterHandler(h.Router)
+}
+
+// 初始化两个服务的配置
+func (h *Harness) setupConfig() {
+	rpcConfig.Config = viper.New()
+	rpcConfig.Config.Set("CACHE_TOKEN_PREFIX", "token")
+	rpcConfig.Config.Set("CACHE_TOKEN_EXPIRE", 3600)
+	rpcConfig.Config.Set("CACHE_TOKEN_EXPIRED_RETAIN", 3600)
+	rpcConfig.Config.Set("CACHE_USER_PREFIX", "user")
+	rpcConfig.Config.Set("CACHE_USER_EXPIRE", 3600)
+	rpcConfig.Config.Set("LOGIN_HISTORY_PREFIX", "login")
+	rpcConfig.Config.Set("LOGIN_HISTORY_SIZE", 3)
+	rpcConfig.Config.Set("LOGIN_HISTORY_EXPIRE", 3600)
+	rpcConfig.Config.Set("MFA_CHALLENGE_PREFIX", "mfa")
+
+	webConfig.Config = viper.New()
+	webConfig.Config.Set("APP_UPLOAD_DIR", h.UploadDir)
+	webConfig.Config.Set("APP_TIMEZONE", "UTC")
+	webConfig.Config.Set("CAPTCHA_PREFIX", "captcha")
+	webConfig.Config.Set("CAPTCHA_KEY_EXPIRE", 300)
+	webConfig.Config.Set("CAPTCHA_HEIGHT", 80)
+	webConfig.Config.Set("CAPTCHA_WIDTH", 240)
+	webConfig.Config.Set("CAPTCHA_LENGTH", 6)
+	webConfig.Config.Set("CAPTCHA_MAXSKEW", 0.7)
+	webConfig.Config.Set("CAPTCHA_DOTCOUNT", 80)
+	webConfig.Config.Set("AVATAR_MAX_SIZE", 1<<20)
+	webConfig.Config.Set("UPLOAD_SESSION_PREFIX", "upload")
+	webConfig.Config.Set("UPLOAD_SESSION_EXPIRE", 3600)
+	webConfig.Config.Set("UPLOAD_SESSION_MAX", 1)
+	webConfig.Config.Set("EXPORT_PREFIX", "export")
+	webConfig.Config.Set("EXPORT_JOB_TIMEOUT", 60)
+	webConfig.Config.Set("EXPORT_LINK_EXPIRE", 3600)
+	webConfig.Config.Set("EXPORT_URL_SECRET", "e2e")
+	webConfig.Config.Set("RATE_LIMIT_PREFIX", "ratelimit")
+	webConfig.Config.Set("RATE_LIMIT_LOGIN", "ip:5/1m")
+	webConfig.Config.Set("RATE_LIMIT_USER", "token:100/1m")
+	webConfig.Config.Set("RATE_LIMIT_ADMIN", "token:100/1m")
+	webConfig.Config.Set("RATE_LIMIT_EXPORT", "user:2/24h")
+	webConfig.Config.Set("RPC_FALLBACK_PREFIX", "fallback")
+	webConfig.Config.Set("RPC_FALLBACK_EXPIRE", 60)
+	webConfig.Config.Set("RPC_HEALTH_CHECK", true)
+	webConfig.Config.Set("WEB_HSTS_MAX_AGE", 31536000)
+}
+
+// Response http响应
+type Response struct {
+	Code   int
+	Header http.Header
+	Body   map[string]interface{}
+}
+
+// Data 获取响应中的data字段
+func (r Response) Data() map[string]interface{} {
+	data, _ := r.Body["data"].(map[string]interface{})
+	return data
+}
+
+// Serve 发送http请求, 返回原始响应
+func (h *Harness) Serve(req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.Router.ServeHTTP(w, req)
+	return w
+}
+
+// Do 发送http请求, 响应体按json解析
+func (h *Harness) Do(req *http.Request) Response {
+	h.t.Helper()
+	w := h.Serve(req)
+
+	rsp := Response{Code: w.Code, Header: w.Header()}
+	if w.Body.Len() > 0 {
+		if err := json.Unmarshal(w.Body.Bytes(), &rsp.Body); err != nil {
+			h.t.Fatalf("%s %s: decode body %q: %v", req.Method, req.URL, w.Body.String(), err)
+		}
+	}
+	return rsp
+}
+
+// DoJSON 发送json请求
+func (h *Harness) DoJSON(method, path, token string, data interface{}) Response {
+	h.t.Helper()
+	return h.DoJSONWithHeader(method, path, token, data, nil)
+}
+
+// DoJSONWithHeader 发送带额外请求头的json请求
+func (h *Harness) DoJSONWithHeader(method, path, token string, data interface{}, header http.Header) Response {
+	h.t.Helper()
+	var body io.Reader
+	if data != nil {
+		b, err := json.Marshal(data)
+		if err != nil {
+			h.t.Fatalf("encode request: %v", err)
+		}
+		body = bytes.NewReader(b)
+	}
+	req := httptest.NewRequest(method, path, body)
+	req.Header.Set("Content-Type", "application/json")
+	if len(token) > 0 {
+		req.Header.Set("Authorization", token)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	return h.Do(req)
+}
+
+// DoUpload 发送文件上传请求
+func (h *Harness) DoUpload(path, token, field, filename string, content []byte) Response {
+	h.t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile(field, filename)
+	if err != nil {
+		h.t.Fatalf("create form file: %v", err)
+	}
+	_, _ = fw.Write(content)
+	_ = mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, path, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	if len(token) > 0 {
+		req.Header.Set("Authorization", token)
+	}
+	return h.Do(req)
+}
+
+// CaptchaAnswer 从redis中读取验证码答案
+func (h *Harness) CaptchaAnswer(id string) string {
+	h.t.Helper()
+	ans, err := h.Redis.Get(fmt.Sprintf("captcha:%s", id))
+	if err != nil {
+		h.t.Fatalf("get captcha answer: %v", err)
+	}
+	return ans
+}
@@ -0,0 +1,39 @@
+package e2e
+
+import (
+	"net/http"
+	"testing"
+	"userapi/v1"
+)
+
+func TestAuthenticateOnce(t *testing.T) {
+	h := NewHarness(t)
+	token := registerAndLogin(t, h, "e2eidentity")
+
+	h.RPCCalls()
+	expectCode(t, "edit profile", h.DoJSON(http.MethodPut, "/user/", token, map[string]string{"nickname": "e2enick"}),
+		http.StatusOK)
+
+	// 中间件认证一次, 后续rpc携带身份凭证而不是token
+	calls := h.RPCCalls()
+	if len(calls) != 2 || calls[0].Method != "/userapi.v1.UserService/Authenticate" ||
+		calls[1].Method != "/userapi.v1.UserService/EditUserProfile" {
+		t.Fatalf("unexpected rpc calls: %v", calls)
+	}
+	if req := calls[1].Req.(*userapi.EditUserRequest); len(req.Token) > 0 {
+		t.Fatalf("edit profile should not carry the token: %v", req)
+	}
+
+	rsp := h.DoJSON(http.MethodGet, "/user/", token, nil)
+	expectCode(t, "profile", rsp, http.StatusOK)
+	if rsp.Data()["nickname"] != "e2enick" {
+		t.Fatalf("profile: unexpected body %v", rsp.Body)
+	}
+
+	// 无效token在中间件中被拒绝, 不会调用后续rpc
+	h.RPCCalls()
+	expectCode(t, "invalid token", h.DoJSON(http.MethodGet, "/user/", "invalid", nil), http.StatusUnauthorized)
+	if calls = h.RPCCalls(); len(calls) != 1 {
+		t.Fatalf("invalid token: unexpected rpc calls: %v", calls)
+	}
+}
@@ -0,0 +1,62 @@
+package e2e
+
+import (
+	"net/http"
+	"testing"
+	rpcNotify "user_rpc/pkg/notify"
+)
+
+// 以指定的User-Agent登录
+func loginWithUserAgent(t *testing.T, h *Harness, username string, userAgent string) {
+	t.Helper()
+	captchaID, captchaAns := captcha(t, h)
+	rsp := h.DoJSONWithHeader(http.MethodPost, "/login", "", map[string]string{
+		"username":    username,
+		"password":    "123456",
+		"captcha_id":  captchaID,
+		"captcha_ans": captchaAns,
+	}, http.Header{"User-Agent": []string{userAgent}})
+	expectCode(t, "login "+username, rsp, http.StatusOK)
+}
+
+func TestLoginHistory(t *testing.T) {
+	h := NewHarness(t)
+	token := registerAndLogin(t, h, "e2elogins")
+
+	// 同一设备再次登录不提醒, 新设备登录提醒
+	loginWithUserAgent(t, h, "e2elogins", "")
+	if notifications := h.Notifier.Notifications(); len(notifications) != 0 {
+		t.Fatalf("unexpected notifications: %+v", notifications)
+	}
+	loginWithUserAgent(t, h, "e2elogins", "e2e-phone")
+	loginWithUserAgent(t, h, "e2elogins", "e2e-phone")
+	notifications := h.Notifier.Notifications()
+	if len(notifications) != 1 || notifications[0].Kind != rpcNotify.KindNewDevice ||
+		notifications[0].Username != "e2elogins" || notifications[0].Detail["user_agent"] != "e2e-phone" {
+		t.Fatalf("unexpected notifications: %+v", notifications)
+	}
+
+	// 按时间倒序返回, 只保留最近的 LOGIN_HISTORY_SIZE 条
+	rsp := h.DoJSON(http.MethodGet, "/user/logins", token, nil)
+	expectCode(t, "list logins", rsp, http.StatusOK)
+	logins, _ := rsp.Data()["logins"].([]interface{})
+	if len(logins) != 3 {
+		t.Fatalf("list logins: unexpected body %v", rsp.Body)
+	}
+	first := logins[0].(map[string]interface{})
+	second := logins[1].(map[string]interface{})
+	if first["user_agent"] != "e2e-phone" || first["new_device"] != false || second["new_device"] != true ||
+		first["ip"] == "" {
+		t.Fatalf("list logins: unexpected body %v", rsp.Body)
+	}
+
+	rsp = h.DoJSON(http.MethodGet, "/user/logins?limit=1", token, nil)
+	expectCode(t, "list logins with limit", rsp, http.StatusOK)
+	if logins, _ = rsp.Data()["logins"].([]interface{}); len(logins) != 1 {
+		t.Fatalf("list logins with limit: unexpected body %v", rsp.Body)
+	}
+	expectCode(t, "list logins with invalid limit", h.DoJSON(http.MethodGet, "/user/logins?limit=1000", token, nil),
+		http.StatusUnprocessableEntity)
+	expectCode(t, "list logins without token", h.DoJSON(http.MethodGet, "/user/logins", "", nil),
+		http.StatusUnauthorized)
+}
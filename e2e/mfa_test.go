@@ -0,0 +1,85 @@
+package e2e
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+	rpcMfa "user_rpc/pkg/mfa"
+)
+
+func TestMFA(t *testing.T) {
+	h := NewHarness(t)
+	token := registerAndLogin(t, h, "e2emfa")
+
+	// 设置两步验证, 返回密钥和配置地址
+	rsp := h.DoJSON(http.MethodPost, "/user/mfa", token, nil)
+	expectCode(t, "enroll mfa", rsp, http.StatusOK)
+	secret, _ := rsp.Data()["secret"].(string)
+	uri, _ := rsp.Data()["provisioning_uri"].(string)
+	if len(secret) == 0 || !strings.HasPrefix(uri, "otpauth://totp/e2e:e2emfa?") {
+		t.Fatalf("enroll mfa: unexpected body %v", rsp.Body)
+	}
+	if rsp.Header.Get("Cache-Control") != "no-store" {
+		t.Fatalf("enroll mfa: unexpected headers %v", rsp.Header)
+	}
+
+	expectCode(t, "confirm with invalid code", h.DoJSON(http.MethodPost, "/user/mfa/confirm", token,
+		map[string]string{"code": "abc"}), http.StatusUnprocessableEntity)
+	rsp = h.DoJSON(http.MethodPost, "/user/mfa/confirm", token, map[string]string{"code": "000000"})
+	expectCode(t, "confirm with wrong code", rsp, http.StatusForbidden)
+	if rsp.Body["error"] != "mfa_code_invalid" {
+		t.Fatalf("confirm with wrong code: unexpected body %v", rsp.Body)
+	}
+	code, _ := rpcMfa.Code(secret, time.Now())
+	rsp = h.DoJSON(http.MethodPost, "/user/mfa/confirm", token, map[string]string{"code": code})
+	expectCode(t, "confirm mfa", rsp, http.StatusOK)
+	recoveryCodes, _ := rsp.Data()["recovery_codes"].([]interface{})
+	if len(recoveryCodes) != rpcMfa.RecoveryCodeCount {
+		t.Fatalf("confirm mfa: unexpected body %v", rsp.Body)
+	}
+	rsp = h.DoJSON(http.MethodPost, "/user/mfa", token, nil)
+	expectCode(t, "enroll after enabled", rsp, http.StatusConflict)
+	if rsp.Body["error"] != "mfa_already_enabled" {
+		t.Fatalf("enroll after enabled: unexpected body %v", rsp.Body)
+	}
+	rsp = h.DoJSON(http.MethodGet, "/user/", token, nil)
+	expectCode(t, "get profile", rsp, http.StatusOK)
+	if rsp.Data()["mfa_enabled"] != true {
+		t.Fatalf("get profile: unexpected body %v", rsp.Body)
+	}
+
+	// 密码正确时只返回挑战, 不签发token
+	rsp = login(t, h, "e2emfa")
+	expectCode(t, "login with mfa", rsp, http.StatusOK)
+	challenge, _ := rsp.Data()["mfa_challenge"].(string)
+	if rsp.Data()["mfa_required"] != true || len(challenge) == 0 || rsp.Data()["token"] != nil {
+		t.Fatalf("login with mfa: unexpected body %v", rsp.Body)
+	}
+
+	// 使用恢复码完成登录, 挑战只能使用一次
+	verify := map[string]string{"mfa_challenge": challenge, "code": recoveryCodes[0].(string)}
+	rsp = h.DoJSON(http.MethodPost, "/login/mfa", "", verify)
+	expectCode(t, "verify mfa", rsp, http.StatusOK)
+	mfaToken, _ := rsp.Data()["token"].(string)
+	if len(mfaToken) == 0 || rsp.Data()["username"] != "e2emfa" {
+		t.Fatalf("verify mfa: unexpected body %v", rsp.Body)
+	}
+	expectCode(t, "profile with mfa token", h.DoJSON(http.MethodGet, "/user/", mfaToken, nil), http.StatusOK)
+	rsp = h.DoJSON(http.MethodPost, "/login/mfa", "", verify)
+	expectCode(t, "reuse mfa challenge", rsp, http.StatusUnauthorized)
+	if rsp.Body["error"] != "mfa_challenge_invalid" {
+		t.Fatalf("reuse mfa challenge: unexpected body %v", rsp.Body)
+	}
+
+	// 关闭两步验证需要密码, 关闭后恢复普通登录
+	expectCode(t, "disable with wrong password", h.DoJSON(http.MethodDelete, "/user/mfa", token,
+		map[string]string{"password": "654321", "code": recoveryCodes[1].(string)}), http.StatusForbidden)
+	expectCode(t, "disable mfa", h.DoJSON(http.MethodDelete, "/user/mfa", token,
+		map[string]string{"password": "123456", "code": recoveryCodes[1].(string)}), http.StatusOK)
+	rsp = login(t, h, "e2emfa")
+	expectCode(t, "login after disable", rsp, http.StatusOK)
+	if token, _ := rsp.Data()["token"].(string); len(token) == 0 {
+		t.Fatalf("login after disable: unexpected body %v", rsp.Body)
+	}
+}
@@ -0,0 +1,55 @@
+package e2e
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLoginRateLimit(t *testing.T) {
+	h := NewHarness(t)
+	login := func() Response {
+		return h.DoJSON(http.MethodPost, "/login", "", map[string]string{
+			"username": "nobody", "password": "123456", "captcha_id": "x", "captcha_ans": "000000",
+		})
+	}
+
+	// 窗口内前5次请求正常处理, 剩余次数递减
+	for i := 1; i <= 5; i++ {
+		rsp := login()
+		expectCode(t, "login "+strconv.Itoa(i), rsp, http.StatusUnprocessableEntity)
+		if rsp.Header.Get("RateLimit-Limit") != "5" || rsp.Header.Get("RateLimit-Remaining") != strconv.Itoa(5-i) {
+			t.Fatalf("login %d: unexpected headers %v", i, rsp.Header)
+		}
+	}
+
+	rsp := login()
+	expectCode(t, "login over limit", rsp, http.StatusTooManyRequests)
+	retryAfter, err := strconv.Atoi(rsp.Header.Get("Retry-After"))
+	if err != nil || retryAfter <= 0 || retryAfter > 60 || rsp.Header.Get("RateLimit-Policy") != "5;w=60" {
+		t.Fatalf("login over limit: unexpected headers %v", rsp.Header)
+	}
+
+	// 未配置限流的接口不受影响; 计数器过期后恢复
+	expectCode(t, "captcha", h.DoJSON(http.MethodGet, "/captcha", "", nil), http.StatusOK)
+	h.Redis.FastForward(2 * time.Minute)
+	expectCode(t, "login after window", login(), http.StatusUnprocessableEntity)
+}
+
+func TestUserRateLimitByToken(t *testing.T) {
+	h := NewHarness(t)
+	alice := registerAndLogin(t, h, "limitalice")
+	bob := registerAndLogin(t, h, "limitbob")
+
+	// 同一IP下不同用户的计数互不影响
+	for i := 0; i < 3; i++ {
+		h.DoJSON(http.MethodGet, "/user/", alice, nil)
+	}
+	if rsp := h.DoJSON(http.MethodGet, "/user/", alice, nil); rsp.Header.Get("RateLimit-Remaining") != "96" {
+		t.Fatalf("alice: unexpected remaining %s", rsp.Header.Get("RateLimit-Remaining"))
+	}
+	if rsp := h.DoJSON(http.MethodGet, "/user/", bob, nil); rsp.Header.Get("RateLimit-Remaining") != "99" {
+		t.Fatalf("bob: unexpected remaining %s", rsp.Header.Get("RateLimit-Remaining"))
+	}
+}
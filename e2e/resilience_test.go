@@ -0,0 +1,43 @@
+package e2e
+
+import (
+	"google.golang.org/grpc/codes"
+	"net/http"
+	"testing"
+)
+
+func TestProfileFallbackWhenRPCUnavailable(t *testing.T) {
+	h := NewHarness(t)
+	token := registerAndLogin(t, h, "e2efallback")
+	other := registerAndLogin(t, h, "e2efallback2")
+
+	rsp := h.DoJSON(http.MethodGet, "/user/", token, nil)
+	expectCode(t, "profile", rsp, http.StatusOK)
+	username := rsp.Data()["username"]
+
+	// 编辑用户信息和上传头像后覆盖缓存, 降级时不会返回旧数据
+	expectCode(t, "edit profile", h.DoJSON(http.MethodPut, "/user/", token, map[string]string{"nickname": "e2enick2"}),
+		http.StatusOK)
+	picPath := uploadAvatar(t, h, token, pngImage(t))
+
+	// 获取用户信息不可用时返回已缓存的用户信息
+	h.FailMethod("/userapi.v1.UserService/GetUserProfile", codes.Unavailable)
+	rsp = h.DoJSON(http.MethodGet, "/user/", token, nil)
+	expectCode(t, "profile fallback", rsp, http.StatusOK)
+	if rsp.Data()["username"] != username || rsp.Data()["nickname"] != "e2enick2" || rsp.Data()["pic_path"] != picPath {
+		t.Fatalf("profile fallback: unexpected body %v", rsp.Body)
+	}
+
+	// 没有缓存的请求返回503
+	rsp = h.DoJSON(http.MethodGet, "/user/", other, nil)
+	expectCode(t, "uncached profile", rsp, http.StatusServiceUnavailable)
+	if rsp.Header.Get("Retry-After") == "" {
+		t.Fatalf("uncached profile: missing Retry-After header %v", rsp.Header)
+	}
+
+	// 认证不降级, 服务不可用时已认证过的token也不能使用
+	h.RPCServer.Stop()
+	expectCode(t, "profile without auth", h.DoJSON(http.MethodGet, "/user/", token, nil), http.StatusServiceUnavailable)
+	expectCode(t, "edit profile", h.DoJSON(http.MethodPut, "/user/", token, map[string]string{"nickname": "e2enick"}),
+		http.StatusServiceUnavailable)
+}
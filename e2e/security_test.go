@@ -0,0 +1,83 @@
+package e2e
+
+import (
+	"crypto/tls"
+	"image/color"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	webRoute "user_web/route"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	h := NewHarness(t)
+
+	w := h.Serve(httptest.NewRequest(http.MethodGet, "/captcha", nil))
+	expected := map[string]string{
+		"X-Content-Type-Options":  "nosniff",
+		"X-Frame-Options":         "DENY",
+		"Referrer-Policy":         "no-referrer",
+		"Content-Security-Policy": "default-src 'none'; frame-ancestors 'none'",
+	}
+	for key, value := range expected {
+		if got := w.Header().Get(key); got != value {
+			t.Fatalf("%s = %q, want %q", key, got, value)
+		}
+	}
+	if hsts := w.Header().Get("Strict-Transport-Security"); hsts != "" {
+		t.Fatalf("HSTS must not be sent over http: %s", hsts)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/captcha", nil)
+	req.TLS = &tls.ConnectionState{}
+	if hsts := h.Serve(req).Header().Get("Strict-Transport-Security"); hsts != "max-age=31536000; includeSubDomains" {
+		t.Fatalf("unexpected HSTS over https: %q", hsts)
+	}
+
+	// 头像禁止作为页面执行脚本
+	token := registerAndLogin(t, h, "e2eheaders")
+	rsp := h.DoUpload("/user/avatar", token, "avatar", "avatar.png", solidPNG(t, color.White))
+	expectCode(t, "upload avatar", rsp, http.StatusOK)
+	avatar, _ := rsp.Data()["avatar"].(string)
+	w = h.Serve(httptest.NewRequest(http.MethodGet, avatar, nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Header().Get("Content-Security-Policy"), "sandbox") ||
+		w.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Fatalf("avatar: unexpected headers %d %v", w.Code, w.Header())
+	}
+}
+
+func TestHTTPSRedirect(t *testing.T) {
+	webroot := t.TempDir()
+	challengeDir := filepath.Join(webroot, ".well-known", "acme-challenge")
+	if err := os.MkdirAll(challengeDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(challengeDir, "token1"), []byte("token1.thumbprint"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	redirect := func(handler http.Handler, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+
+	w := redirect(webRoute.RedirectHandler("443", webroot), "http://example.com:8080/user/?a=1")
+	if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != "https://example.com/user/?a=1" {
+		t.Fatalf("redirect: %d %s", w.Code, w.Header().Get("Location"))
+	}
+	w = redirect(webRoute.RedirectHandler("3443", ""), "http://example.com/login")
+	if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != "https://example.com:3443/login" {
+		t.Fatalf("redirect with port: %d %s", w.Code, w.Header().Get("Location"))
+	}
+
+	// ACME http-01 验证文件不跳转
+	w = redirect(webRoute.RedirectHandler("443", webroot), "http://example.com/.well-known/acme-challenge/token1")
+	if w.Code != http.StatusOK || w.Body.String() != "token1.thumbprint" {
+		t.Fatalf("acme challenge: %d %s", w.Code, w.Body.String())
+	}
+}
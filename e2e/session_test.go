@@ -0,0 +1,106 @@
+package e2e
+
+import (
+	"net/http"
+	"testing"
+	webConfig "user_web/pkg/config"
+)
+
+// 从响应中获取指定名称的cookie
+func responseCookie(rsp Response, name string) *http.Cookie {
+	for _, cookie := range (&http.Response{Header: rsp.Header}).Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestCookieSessionWithCSRF(t *testing.T) {
+	h := NewHarness(t)
+	bearer := registerAndLogin(t, h, "e2ecookie")
+	webConfig.Config.Set("WEB_SESSION_COOKIE", true)
+	webConfig.Config.Set("WEB_SESSION_COOKIE_SECURE", true)
+
+	captchaID, captchaAns := captcha(t, h)
+	rsp := h.DoJSON(http.MethodPost, "/login", "", map[string]string{
+		"username":    "e2ecookie",
+		"password":    "123456",
+		"captcha_id":  captchaID,
+		"captcha_ans": captchaAns,
+	})
+	expectCode(t, "cookie login", rsp, http.StatusOK)
+	sessionCookie, csrfCookie := responseCookie(rsp, "session"), responseCookie(rsp, "csrf_token")
+	if sessionCookie == nil || !sessionCookie.HttpOnly || !sessionCookie.Secure || sessionCookie.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("cookie login: unexpected session cookie %v", rsp.Header["Set-Cookie"])
+	}
+	if csrfCookie == nil || csrfCookie.HttpOnly || rsp.Data()["csrf_token"] != csrfCookie.Value {
+		t.Fatalf("cookie login: unexpected csrf cookie %v, body %v", rsp.Header["Set-Cookie"], rsp.Body)
+	}
+	if _, ok := rsp.Data()["token"]; ok {
+		t.Fatalf("cookie login: token must not be returned in body")
+	}
+
+	cookies := http.Header{"Cookie": {sessionCookie.Name + "=" + sessionCookie.Value + "; " + csrfCookie.Name + "=" + csrfCookie.Value}}
+	withCSRF := http.Header{"Cookie": cookies["Cookie"], "X-Csrf-Token": {csrfCookie.Value}}
+	edit := map[string]string{"nickname": "cookienick"}
+
+	// 读取不需要CSRF token, 修改需要
+	expectCode(t, "cookie profile", h.DoJSONWithHeader(http.MethodGet, "/user/", "", nil, cookies), http.StatusOK)
+	expectCode(t, "edit without csrf", h.DoJSONWithHeader(http.MethodPut, "/user/", "", edit, cookies), http.StatusForbidden)
+	wrongCSRF := http.Header{"Cookie": cookies["Cookie"], "X-Csrf-Token": {"forged"}}
+	expectCode(t, "edit with wrong csrf", h.DoJSONWithHeader(http.MethodPut, "/user/", "", edit, wrongCSRF), http.StatusForbidden)
+	expectCode(t, "edit with csrf", h.DoJSONWithHeader(http.MethodPut, "/user/", "", edit, withCSRF), http.StatusOK)
+
+	// Bearer 请求头不受CSRF校验影响
+	expectCode(t, "bearer edit", h.DoJSON(http.MethodPut, "/user/", "Bearer "+bearer, map[string]string{"nickname": "bearernick"}),
+		http.StatusOK)
+
+	// 登出需要CSRF token, 成功后清除cookie
+	expectCode(t, "logout without csrf", h.DoJSONWithHeader(http.MethodPost, "/logout", "", nil, cookies), http.StatusForbidden)
+	rsp = h.DoJSONWithHeader(http.MethodPost, "/logout", "", nil, withCSRF)
+	expectCode(t, "logout with csrf", rsp, http.StatusOK)
+	if cleared := responseCookie(rsp, "session"); cleared == nil || cleared.MaxAge >= 0 {
+		t.Fatalf("logout: session cookie not cleared %v", rsp.Header["Set-Cookie"])
+	}
+	if rsp = h.DoJSONWithHeader(http.MethodGet, "/user/", "", nil, cookies); rsp.Code == http.StatusOK {
+		t.Fatalf("profile after logout should fail, got %v", rsp.Body)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	h := NewHarness(t)
+	webConfig.Config.Set("WEB_CORS_ALLOWED_ORIGINS", "https://app.example.com")
+	webConfig.Config.Set("WEB_CORS_ALLOW_CREDENTIALS", true)
+	webConfig.Config.Set("WEB_CORS_MAX_AGE", 600)
+	h.ResetRouter()
+
+	preflight := func(origin string) Response {
+		return h.DoJSONWithHeader(http.MethodOptions, "/user/", "", nil, http.Header{
+			"Origin":                         {origin},
+			"Access-Control-Request-Method":  {http.MethodPut},
+			"Access-Control-Request-Headers": {"content-type, x-csrf-token"},
+		})
+	}
+
+	rsp := preflight("https://app.example.com")
+	expectCode(t, "preflight", rsp, http.StatusNoContent)
+	if rsp.Header.Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		rsp.Header.Get("Access-Control-Allow-Credentials") != "true" ||
+		rsp.Header.Get("Access-Control-Max-Age") != "600" ||
+		rsp.Header.Get("Access-Control-Allow-Methods") == "" || rsp.Header.Get("Access-Control-Allow-Headers") == "" {
+		t.Fatalf("preflight: unexpected headers %v", rsp.Header)
+	}
+	expectCode(t, "preflight from other origin", preflight("https://evil.example.com"), http.StatusForbidden)
+
+	rsp = h.DoJSONWithHeader(http.MethodGet, "/captcha", "", nil, http.Header{"Origin": {"https://app.example.com"}})
+	expectCode(t, "cors captcha", rsp, http.StatusOK)
+	if rsp.Header.Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		rsp.Header.Get("Access-Control-Expose-Headers") == "" || rsp.Header.Get("Vary") != "Origin" {
+		t.Fatalf("cors captcha: unexpected headers %v", rsp.Header)
+	}
+	rsp = h.DoJSONWithHeader(http.MethodGet, "/captcha", "", nil, http.Header{"Origin": {"https://evil.example.com"}})
+	if rsp.Header.Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("cors captcha from other origin: unexpected headers %v", rsp.Header)
+	}
+}
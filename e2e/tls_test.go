@@ -0,0 +1,211 @@
+package e2e
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+	rpcHandler "user_rpc/handler"
+	rpcConfig "user_rpc/pkg/config"
+	rpcTLS "user_rpc/pkg/tlsconfig"
+	webClient "user_web/client"
+	webConfig "user_web/pkg/config"
+	webTLS "user_web/pkg/tlsconfig"
+	"userapi/v1"
+)
+
+// 测试用CA
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// 签发证书并写入 dir/name.crt 和 dir/name.key
+func (ca *testCA) issue(t *testing.T, dir, name, identity string, usage x509.ExtKeyUsage) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: identity},
+		DNSNames:     []string{identity},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	writeFile(t, filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	NewHarness(t)
+	dir := t.TempDir()
+	serverCA, clientCA := newTestCA(t, "rpc-server-ca"), newTestCA(t, "rpc-client-ca")
+	serverCA.issue(t, dir, "server", "user-rpc", x509.ExtKeyUsageServerAuth)
+	clientCA.issue(t, dir, "client", "user-web", x509.ExtKeyUsageClientAuth)
+	writeFile(t, filepath.Join(dir, "server-ca.crt"), serverCA.pem)
+	writeFile(t, filepath.Join(dir, "client-ca.crt"), clientCA.pem)
+
+	// 开启mTLS的user_rpc, 只允许user-web注册和校验token; 通过校验的请求返回 Unimplemented
+	serverTLS, err := rpcTLS.NewReloader(func() rpcTLS.Files {
+		return rpcTLS.Files{
+			CertFile: filepath.Join(dir, "server.crt"),
+			KeyFile:  filepath.Join(dir, "server.key"),
+			CAFile:   filepath.Join(dir, "client-ca.crt"),
+		}
+	})
+	if err != nil {
+		t.Fatalf("server tls: %v", err)
+	}
+	rpcConfig.Config.Set("RPC_TLS_ALLOWED_CLIENTS", "user-web")
+	lis := bufconn.Listen(bufSize)
+	rpcServer := grpc.NewServer(
+		grpc.Creds(credentials.NewTLS(serverTLS.ServerConfig())),
+		grpc.ChainUnaryInterceptor(rpcTLS.UnaryServerInterceptor(rpcHandler.PrivilegedMethods, rpcTLS.AllowedClients)),
+	)
+	userapi.RegisterUserServiceServer(rpcServer, &userapi.UnimplementedUserServiceServer{})
+	go func() {
+		_ = rpcServer.Serve(lis)
+	}()
+	t.Cleanup(rpcServer.Stop)
+
+	// user_web通过 client.DialOptions 使用 RpcTLS 连接
+	clientFiles := webTLS.Files{
+		CertFile: filepath.Join(dir, "client.crt"),
+		KeyFile:  filepath.Join(dir, "client.key"),
+		CAFile:   filepath.Join(dir, "server-ca.crt"),
+	}
+	webTLS.RpcTLS, err = webTLS.NewReloader(func() webTLS.Files { return clientFiles })
+	if err != nil {
+		t.Fatalf("client tls: %v", err)
+	}
+	t.Cleanup(func() {
+		webTLS.RpcTLS = nil
+	})
+	webConfig.Config.Set("RPC_TLS_SERVER_NAME", "user-rpc")
+	dial := func() userapi.UserServiceClient {
+		conn, err := grpc.Dial("bufnet", append(webClient.DialOptions(),
+			grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+				return lis.Dial()
+			}),
+		)...)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		t.Cleanup(func() {
+			_ = conn.Close()
+		})
+		return userapi.NewUserServiceClient(conn)
+	}
+	expect := func(step string, err error, code codes.Code) {
+		t.Helper()
+		if status.Code(err) != code {
+			t.Fatalf("%s: expected %v, got %v", step, code, err)
+		}
+	}
+	ctx := context.Background()
+
+	// 授权的客户端可以调用敏感方法, 请求到达业务逻辑
+	_, err = dial().CreateUserProfile(ctx, &userapi.CreateUserRequest{})
+	expect("authorized client", err, codes.Unimplemented)
+
+	// 轮换为未授权的身份后, 新连接只能调用普通方法
+	clientCA.issue(t, dir, "client", "user-other", x509.ExtKeyUsageClientAuth)
+	if err = webTLS.RpcTLS.Reload(); err != nil {
+		t.Fatalf("reload client: %v", err)
+	}
+	other := dial()
+	_, err = other.CreateUserProfile(ctx, &userapi.CreateUserRequest{})
+	expect("unauthorized client", err, codes.PermissionDenied)
+	_, err = other.Login(ctx, &userapi.LoginRequest{})
+	expect("unauthorized client login", err, codes.Unimplemented)
+
+	// 没有客户端证书时握手失败
+	clientFiles = webTLS.Files{CAFile: filepath.Join(dir, "server-ca.crt")}
+	if err = webTLS.RpcTLS.Reload(); err != nil {
+		t.Fatalf("reload client: %v", err)
+	}
+	_, err = dial().Login(ctx, &userapi.LoginRequest{})
+	expect("client without certificate", err, codes.Unavailable)
+
+	// 服务端更换CA后, 客户端重新加载CA才能连接
+	clientCA.issue(t, dir, "client", "user-web", x509.ExtKeyUsageClientAuth)
+	clientFiles = webTLS.Files{
+		CertFile: filepath.Join(dir, "client.crt"),
+		KeyFile:  filepath.Join(dir, "client.key"),
+		CAFile:   filepath.Join(dir, "server-ca.crt"),
+	}
+	newServerCA := newTestCA(t, "rpc-server-ca-2")
+	newServerCA.issue(t, dir, "server", "user-rpc", x509.ExtKeyUsageServerAuth)
+	if err = serverTLS.Reload(); err != nil {
+		t.Fatalf("reload server: %v", err)
+	}
+	if err = webTLS.RpcTLS.Reload(); err != nil {
+		t.Fatalf("reload client: %v", err)
+	}
+	_, err = dial().CreateUserProfile(ctx, &userapi.CreateUserRequest{})
+	expect("untrusted server", err, codes.Unavailable)
+
+	writeFile(t, filepath.Join(dir, "server-ca.crt"), newServerCA.pem)
+	if err = webTLS.RpcTLS.Reload(); err != nil {
+		t.Fatalf("reload client: %v", err)
+	}
+	_, err = dial().CreateUserProfile(ctx, &userapi.CreateUserRequest{})
+	expect("rotated server", err, codes.Unimplemented)
+}
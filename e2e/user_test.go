@@ -0,0 +1,244 @@
+package e2e
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 生成一张png图片
+func pngImage(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	img.Set(1, 1, color.RGBA{R: 255, A: 255})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// 获取验证码并从redis中读取答案
+func captcha(t *testing.T, h *Harness) (string, string) {
+	t.Helper()
+	rsp := h.DoJSON(http.MethodGet, "/captcha", "", nil)
+	if rsp.Code != http.StatusOK {
+		t.Fatalf("captcha: %d %v", rsp.Code, rsp.Body)
+	}
+	id, _ := rsp.Data()["captcha_id"].(string)
+	if len(id) == 0 {
+		t.Fatalf("captcha: empty captcha_id, %v", rsp.Body)
+	}
+	return id, h.CaptchaAnswer(id)
+}
+
+// 注册并登录, 返回token
+func registerAndLogin(t *testing.T, h *Harness, username string) string {
+	t.Helper()
+	captchaID, captchaAns := captcha(t, h)
+	rsp := h.DoJSON(http.MethodPost, "/register", "", map[string]string{
+		"username":    username,
+		"password":    "123456",
+		"captcha_id":  captchaID,
+		"captcha_ans": captchaAns,
+	})
+	expectCode(t, "register "+username, rsp, http.StatusOK)
+
+	captchaID, captchaAns = captcha(t, h)
+	rsp = h.DoJSON(http.MethodPost, "/login", "", map[string]string{
+		"username":    username,
+		"password":    "123456",
+		"captcha_id":  captchaID,
+		"captcha_ans": captchaAns,
+	})
+	expectCode(t, "login "+username, rsp, http.StatusOK)
+	token, _ := rsp.Data()["token"].(string)
+	return token
+}
+
+func expectCode(t *testing.T, step string, rsp Response, code int) {
+	t.Helper()
+	if rsp.Code != code {
+		t.Fatalf("%s: expected status %d, got %d, body: %v", step, code, rsp.Code, rsp.Body)
+	}
+}
+
+func TestUserLifecycle(t *testing.T) {
+	h := NewHarness(t)
+
+	// 注册
+	captchaID, captchaAns := captcha(t, h)
+	rsp := h.DoJSON(http.MethodPost, "/register", "", map[string]string{
+		"username":    "e2euser",
+		"password":    "123456",
+		"nickname":    "e2enick",
+		"captcha_id":  captchaID,
+		"captcha_ans": captchaAns,
+	})
+	expectCode(t, "register", rsp, http.StatusOK)
+	if rsp.Data()["username"] != "e2euser" || rsp.Data()["nickname"] != "e2enick" {
+		t.Fatalf("register: unexpected data %v", rsp.Data())
+	}
+
+	// 验证码只能使用一次
+	rsp = h.DoJSON(http.MethodPost, "/login", "", map[string]string{
+		"username":    "e2euser",
+		"password":    "123456",
+		"captcha_id":  captchaID,
+		"captcha_ans": captchaAns,
+	})
+	expectCode(t, "login with used captcha", rsp, http.StatusUnprocessableEntity)
+
+	// 登录
+	captchaID, captchaAns = captcha(t, h)
+	rsp = h.DoJSON(http.MethodPost, "/login", "", map[string]string{
+		"username":    "e2euser",
+		"password":    "123456",
+		"captcha_id":  captchaID,
+		"captcha_ans": captchaAns,
+	})
+	expectCode(t, "login", rsp, http.StatusOK)
+	token, _ := rsp.Data()["token"].(string)
+	if len(token) == 0 {
+		t.Fatalf("login: empty token, %v", rsp.Body)
+	}
+
+	// 获取用户信息
+	rsp = h.DoJSON(http.MethodGet, "/user/", token, nil)
+	expectCode(t, "get profile", rsp, http.StatusOK)
+	if rsp.Data()["nickname"] != "e2enick" {
+		t.Fatalf("get profile: unexpected data %v", rsp.Data())
+	}
+
+	etag := rsp.Header.Get("ETag")
+	if len(etag) == 0 {
+		t.Fatalf("get profile: missing etag")
+	}
+
+	// 编辑用户信息, 携带当前版本号
+	rsp = h.DoJSONWithHeader(http.MethodPut, "/user/", token, map[string]string{"nickname": "e2enick2"},
+		http.Header{"If-Match": {etag}})
+	expectCode(t, "edit profile", rsp, http.StatusOK)
+	if rsp.Header.Get("ETag") == etag {
+		t.Fatalf("edit profile: etag not changed")
+	}
+	if rsp.Data()["nickname"] != "e2enick2" || rsp.Data()["id"] == float64(0) {
+		t.Fatalf("edit profile: unexpected data %v", rsp.Data())
+	}
+	// 使用过期的版本号编辑
+	stale := h.DoJSONWithHeader(http.MethodPut, "/user/", token, map[string]string{"nickname": "e2enick3"},
+		http.Header{"If-Match": {etag}})
+	expectCode(t, "edit profile with stale etag", stale, http.StatusPreconditionFailed)
+	current := rsp.Header.Get("ETag")
+	expectCode(t, "edit profile with weak etag", h.DoJSONWithHeader(http.MethodPut, "/user/", token,
+		map[string]string{"nickname": "e2enick3"}, http.Header{"If-Match": {"W/" + current}}), http.StatusPreconditionFailed)
+	expectCode(t, "edit profile with malformed if-match", h.DoJSONWithHeader(http.MethodPut, "/user/", token,
+		map[string]string{"nickname": "e2enick3"}, http.Header{"If-Match": {"3"}}), http.StatusBadRequest)
+	rsp = h.DoJSONWithHeader(http.MethodPut, "/user/", token, map[string]string{"nickname": "e2enick2"},
+		http.Header{"If-Match": {etag + ", W/" + etag + ", " + current}})
+	expectCode(t, "edit profile with etag list", rsp, http.StatusOK)
+	expectCode(t, "edit profile with stale etag list", h.DoJSONWithHeader(http.MethodPut, "/user/", token,
+		map[string]string{"nickname": "e2enick3"}, http.Header{"If-Match": {etag + ", " + current}}),
+		http.StatusPreconditionFailed)
+
+	rsp = h.DoJSON(http.MethodGet, "/user/", token, nil)
+	expectCode(t, "get profile after edit", rsp, http.StatusOK)
+	if rsp.Data()["nickname"] != "e2enick2" {
+		t.Fatalf("get profile after edit: unexpected data %v", rsp.Data())
+	}
+
+	// 按update_mask更新, mask中值为空的字段被清空, 只校验mask中的字段
+	expectCode(t, "edit without fields", h.DoJSON(http.MethodPut, "/user/", token, map[string]string{}),
+		http.StatusUnprocessableEntity)
+	expectCode(t, "edit with unknown mask", h.DoJSON(http.MethodPut, "/user/", token,
+		map[string]string{"update_mask": "pic_path"}), http.StatusUnprocessableEntity)
+	expectCode(t, "edit with short nickname", h.DoJSON(http.MethodPut, "/user/", token,
+		map[string]string{"nickname": "ab"}), http.StatusUnprocessableEntity)
+	rsp = h.DoJSON(http.MethodPut, "/user/", token, map[string]string{"update_mask": "nickname"})
+	expectCode(t, "clear nickname", rsp, http.StatusOK)
+	if rsp.Data()["nickname"] != "" || rsp.Data()["create_time"] == nil || rsp.Data()["update_time"] == nil ||
+		rsp.Data()["role"] == nil {
+		t.Fatalf("clear nickname: unexpected data %v", rsp.Data())
+	}
+	expectCode(t, "restore nickname", h.DoJSON(http.MethodPut, "/user/", token,
+		map[string]string{"nickname": "e2enick2", "update_mask": "nickname"}), http.StatusOK)
+
+	// 上传头像
+	rsp = h.DoUpload("/user/avatar", token, "avatar", "avatar.png", pngImage(t))
+	expectCode(t, "upload avatar", rsp, http.StatusOK)
+	rsp = h.DoJSON(http.MethodGet, "/user/", token, nil)
+	expectCode(t, "get profile after upload", rsp, http.StatusOK)
+	picPath, _ := rsp.Data()["pic_path"].(string)
+	if len(picPath) == 0 {
+		t.Fatalf("get profile after upload: empty pic_path, %v", rsp.Data())
+	}
+	avatars, _ := rsp.Data()["avatars"].(map[string]interface{})
+	for _, size := range []string{"64", "128", "512"} {
+		ext := filepath.Ext(picPath)
+		variant := strings.TrimSuffix(picPath, ext) + "_" + size + ext
+		if _, err := os.Stat(filepath.Join(h.UploadDir, variant)); err != nil {
+			t.Fatalf("uploaded avatar %s not stored: %v", size, err)
+		}
+		if avatars[size] != "/"+variant {
+			t.Fatalf("unexpected avatar url for size %s: %v", size, avatars[size])
+		}
+	}
+	checkAvatarServed(t, h, rsp.Data()["avatar"].(string))
+
+	// 伪装成图片的其他文件不能上传
+	rsp = h.DoUpload("/user/avatar", token, "avatar", "avatar.png", []byte("MZ\x90\x00 not an image"))
+	expectCode(t, "upload renamed executable", rsp, http.StatusUnprocessableEntity)
+
+	// 登出后token失效
+	rsp = h.DoJSON(http.MethodPost, "/logout", token, nil)
+	expectCode(t, "logout", rsp, http.StatusOK)
+	rsp = h.DoJSON(http.MethodGet, "/user/", token, nil)
+	expectCode(t, "get profile after logout", rsp, http.StatusUnauthorized)
+}
+
+// 通过头像地址获取文件, 检查缓存头、协商缓存、Range请求和路径穿越
+func checkAvatarServed(t *testing.T, h *Harness, avatar string) {
+	t.Helper()
+	if !strings.HasPrefix(avatar, "/avatars/") {
+		t.Fatalf("unexpected avatar url: %s", avatar)
+	}
+
+	w := h.Serve(httptest.NewRequest(http.MethodGet, avatar, nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("get avatar: %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	cfg, err := png.DecodeConfig(bytes.NewReader(w.Body.Bytes()))
+	if err != nil || cfg.Width != 512 || cfg.Height != 512 {
+		t.Fatalf("get avatar: unexpected image %dx%d %v", cfg.Width, cfg.Height, err)
+	}
+	etag := w.Header().Get("ETag")
+	if len(etag) == 0 || len(w.Header().Get("Last-Modified")) == 0 ||
+		!strings.Contains(w.Header().Get("Cache-Control"), "max-age") {
+		t.Fatalf("get avatar: missing cache headers %v", w.Header())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, avatar, nil)
+	req.Header.Set("If-None-Match", etag)
+	if w = h.Serve(req); w.Code != http.StatusNotModified {
+		t.Fatalf("get avatar with etag: expected 304, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, avatar, nil)
+	req.Header.Set("Range", "bytes=0-3")
+	if w = h.Serve(req); w.Code != http.StatusPartialContent || w.Body.Len() != 4 {
+		t.Fatalf("get avatar range: %d %d", w.Code, w.Body.Len())
+	}
+
+	for _, path := range []string{"/avatars/../.env", "/avatars/%2e%2e/%2e%2e/etc/passwd", "/avatars/ab/missing.png"} {
+		if w = h.Serve(httptest.NewRequest(http.MethodGet, path, nil)); w.Code != http.StatusNotFound {
+			t.Fatalf("get %s: expected 404, got %d", path, w.Code)
+		}
+	}
+}
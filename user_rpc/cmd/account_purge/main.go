@@ -0,0 +1,81 @@
+// account_purge 清理保留期已过的待注销账号
+//
+// 在 user_rpc 目录下运行, 读取同一份 .env 配置, 建议每天定时执行:
+//
+//	go run ./cmd/account_purge
+//	go run ./cmd/account_purge -hard-delete
+//
+// 默认匿名化账号: 清空密码、昵称和头像, 保留用户名以免被重新注册冒用;
+// 被清理的头像文件之后由 user_web 的 avatar_gc 回收; 个人数据导出文件在申请注销时已由 user_web 删除,
+// 删除失败的文件由 user_web 的 export_gc 在下载地址过期后删除
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"google.golang.org/grpc/metadata"
+	"os"
+	"time"
+	userDao "user_rpc/dao/user"
+	"user_rpc/pkg/audit"
+	"user_rpc/pkg/config"
+	"user_rpc/pkg/database"
+	"user_rpc/pkg/identity"
+	"user_rpc/pkg/logger"
+	"user_rpc/pkg/redis"
+	userService "user_rpc/service/user"
+)
+
+func main() {
+	hardDelete := flag.Bool("hard-delete", false, "删除账号记录, 用户名可以被重新注册")
+	flag.Parse()
+
+	// 连接在 run 返回前关闭, 失败时再退出
+	if err := run(*hardDelete); err != nil {
+		fmt.Fprintln(os.Stderr, "account purge failed:", err)
+		os.Exit(1)
+	}
+}
+
+// 初始化依赖并清理账号, 返回前关闭audit、database、redis
+func run(hardDelete bool) error {
+	// 初始化config、logger、database、audit、redis、identity
+	config.SetupConfig()
+	logger.SetupLogger()
+	database.SetupDatabase()
+	audit.SetupAudit()
+	redis.SetupRedis()
+	identity.SetupIdentity()
+	defer func() {
+		audit.Close()
+		database.Close()
+		redis.Close()
+	}()
+
+	service := userService.NewUserService(
+		userDao.NewDBUserRepository(database.DB),
+		userDao.NewRedisTokenStore(redis.Redis),
+		userDao.NewRedisUserCache(redis.Redis),
+		identity.RpcSigner,
+		audit.RpcRecorder,
+	)
+	service.SetDeletionRetention(time.Duration(config.GetInt("ACCOUNT_DELETION_RETENTION")) * time.Second)
+	// 清理账号时同时删除登录记录, 不发送通知
+	service.SetLoginHistory(userDao.NewRedisLoginHistory(redis.Redis), nil)
+
+	requestID := fmt.Sprintf("account-purge-%d", time.Now().UnixNano())
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("request_id", requestID))
+	report, err := service.PurgeAccounts(ctx, time.Now(), hardDelete)
+	if err != nil {
+		logger.Error("accountPurge", "requestID:", requestID, "run error", err.Error())
+		return err
+	}
+
+	for _, picPath := range report.PicPaths {
+		fmt.Println("unreferenced avatar", picPath)
+	}
+	fmt.Printf("purged: %d, skipped: %d, unreferenced avatars: %d\n", report.Purged, report.Skipped, len(report.PicPaths))
+	logger.Info("accountPurge", "requestID:", requestID, "hardDelete:", hardDelete, "report:", report)
+	return nil
+}
@@ -0,0 +1,85 @@
+package user
+
+import (
+	"errors"
+	"time"
+	userModel "user_rpc/model/user"
+)
+
+// ErrVersionConflict 乐观锁更新时版本号不一致
+var ErrVersionConflict = errors.New("user version conflict")
+
+// UserRepository 用户数据存储接口
+type UserRepository interface {
+	// GetByUsername 根据用户名获取用户信息, 用户不存在时返回空用户
+	GetByUsername(username string) (userModel.User, error)
+	// CreateOne 创建用户信息
+	CreateOne(username, password, nickname string) (userModel.User, error)
+	// UpdateColumnsByUsername 在一次带版本号的更新中更新多个字段, 返回更新后的用户信息
+	// version为0时使用当前版本号, 版本号不一致时返回 ErrVersionConflict
+	UpdateColumnsByUsername(username string, version int, columns map[string]interface{}) (userModel.User, error)
+	// ListPicPaths 遍历所有用户正在使用的非空头像路径, fn返回错误时停止遍历
+	ListPicPaths(fn func(picPath string) error) error
+	// Search 按用户名前缀或昵称包含关键字搜索用户, query为空时列出所有用户
+	// 从cursor开始按分表和ID顺序查询, 返回最多limit个用户和下一页的位置, 没有更多数据时next为nil
+	Search(query string, cursor SearchCursor, limit int) (users []userModel.User, next *SearchCursor, err error)
+	// ListPendingDeletion 遍历申请注销时间早于before的待注销用户, fn返回错误时停止遍历
+	ListPendingDeletion(before time.Time, fn func(user userModel.User) error) error
+	// DeleteByUsername 按版本号删除用户, 版本号不一致时返回 ErrVersionConflict
+	DeleteByUsername(username string, version int) error
+}
+
+// SearchCursor 跨分表搜索的位置, 从第 Table 个分表中ID大于 LastID 的记录继续
+type SearchCursor struct {
+	Table  int
+	LastID int64
+}
+
+// TokenStore token存储接口
+type TokenStore interface {
+	// Set 写入token, value为用户名
+	Set(token string, value string) error
+	// Get 获取token对应的用户名, token不存在时返回空字符串
+	Get(token string) (string, error)
+	// Expired 判断不存在的token是否曾经签发并已过期, 登出删除的token不算过期
+	Expired(token string) (bool, error)
+	// Del 删除token
+	Del(token string) error
+	// DelByUsername 删除用户的所有token
+	DelByUsername(username string) error
+	// CountByUsername 统计用户当前有效的token数量
+	CountByUsername(username string) (int, error)
+}
+
+// LoginHistory 登录记录存储接口, 每个用户保留保留期内最近的若干条记录和已知设备
+type LoginHistory interface {
+	// Add 写入登录记录, 以记录的时间为当前时间, 返回设备和IP组合是否首次出现
+	// 用户在保留期内没有任何已知设备时(如首次登录)不算新设备
+	Add(username string, record userModel.LoginRecord) (newDevice bool, err error)
+	// List 按时间倒序返回保留期内最近的limit条登录记录
+	List(username string, limit int) ([]userModel.LoginRecord, error)
+	// DelByUsername 删除用户的登录记录和已知设备
+	DelByUsername(username string) error
+}
+
+// MFAChallengeStore 两步验证挑战存储接口, 挑战在密码校验通过后签发, 验证码校验通过后删除
+type MFAChallengeStore interface {
+	// Set 写入挑战, value为用户名, ttl后过期
+	Set(challenge string, username string, ttl time.Duration) error
+	// Get 获取挑战对应的用户名, 挑战不存在或已过期时返回空字符串
+	Get(challenge string) (string, error)
+	// Fail 记录一次验证失败, 返回累计失败次数; 挑战不存在时返回0
+	Fail(challenge string) (int, error)
+	// Del 删除挑战
+	Del(challenge string) error
+}
+
+// UserCache 用户信息缓存接口
+type UserCache interface {
+	// Get 获取用户信息缓存, 缓存不存在时返回空用户
+	Get(username string) (userModel.User, error)
+	// Set 写入用户信息缓存, 不保存密码、盐和两步验证密钥
+	Set(username string, user userModel.User) error
+	// Del 删除用户信息缓存
+	Del(username string) error
+}
@@ -3,38 +3,122 @@ package user
 import (
 	"encoding/json"
 	"fmt"
+	redisLib "github.com/go-redis/redis/v8"
 	"time"
 	userModel "user_rpc/model/user"
 	"user_rpc/pkg/config"
 	"user_rpc/pkg/redis"
 )
 
-// SetTokenCache 写入token缓存
-func SetTokenCache(token string, value string) error {
+// RedisTokenStore token存储的redis实现
+type RedisTokenStore struct {
+	Client *redis.RedisClient
+}
+
+// NewRedisTokenStore 创建redis token存储
+func NewRedisTokenStore(client *redis.RedisClient) *RedisTokenStore {
+	return &RedisTokenStore{Client: client}
+}
+
+// 签发记录的key, 比token多保留 CACHE_TOKEN_EXPIRED_RETAIN 秒, 用于区分过期和从未签发的token
+func tokenIssuedKey(token string) string {
+	return fmt.Sprintf("%s_issued:%s", config.GetString("CACHE_TOKEN_PREFIX"), token)
+}
+
+// 用户的token集合, 用于强制登出
+func userTokensKey(username string) string {
+	return fmt.Sprintf("%s_user:%s", config.GetString("CACHE_TOKEN_PREFIX"), username)
+}
+
+// Set 写入token缓存和签发记录, 并加入用户的token集合
+func (s *RedisTokenStore) Set(token string, value string) error {
 	key := fmt.Sprintf("%s:%s", config.GetString("CACHE_TOKEN_PREFIX"), token)
 	expire := time.Duration(config.GetInt("CACHE_TOKEN_EXPIRE")) * time.Second
-	return redis.Redis.Set(key, value, expire)
+	if retain := time.Duration(config.GetInt("CACHE_TOKEN_EXPIRED_RETAIN")) * time.Second; retain > 0 {
+		if err := s.Client.Set(tokenIssuedKey(token), 1, expire+retain); err != nil {
+			return err
+		}
+	}
+	// 集合的过期时间随最新的token延长, 已过期的token留在集合中不影响强制登出
+	setKey := userTokensKey(value)
+	_, err := s.Client.Client.TxPipelined(s.Client.Ctx, func(pipe redisLib.Pipeliner) error {
+		pipe.SAdd(s.Client.Ctx, setKey, token)
+		pipe.Expire(s.Client.Ctx, setKey, expire)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return s.Client.Set(key, value, expire)
 }
 
-// GetTokenCache 获取token缓存
-func GetTokenCache(token string) (string, error) {
+// Get 获取token缓存
+func (s *RedisTokenStore) Get(token string) (string, error) {
 	key := fmt.Sprintf("%s:%s", config.GetString("CACHE_TOKEN_PREFIX"), token)
-	val, err := redis.Redis.Get(key)
+	val, err := s.Client.Get(key)
 	return val, err
 }
 
-// DelTokenCache 删除token缓存
-func DelTokenCache(token string) (err error) {
+// Expired 签发记录还在但token缓存已不存在时, token已过期
+func (s *RedisTokenStore) Expired(token string) (bool, error) {
+	val, err := s.Client.Get(tokenIssuedKey(token))
+	return len(val) > 0, err
+}
+
+// DelByUsername 删除用户token集合中的所有token和签发记录
+func (s *RedisTokenStore) DelByUsername(username string) error {
+	setKey := userTokensKey(username)
+	tokens, err := s.Client.Client.SMembers(s.Client.Ctx, setKey).Result()
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, 2*len(tokens)+1)
+	for _, token := range tokens {
+		keys = append(keys, fmt.Sprintf("%s:%s", config.GetString("CACHE_TOKEN_PREFIX"), token), tokenIssuedKey(token))
+	}
+	keys = append(keys, setKey)
+	return s.Client.Client.Del(s.Client.Ctx, keys...).Err()
+}
+
+// CountByUsername 统计用户当前有效的token数量, 集合中已过期的token不计入
+func (s *RedisTokenStore) CountByUsername(username string) (int, error) {
+	tokens, err := s.Client.Client.SMembers(s.Client.Ctx, userTokensKey(username)).Result()
+	if err != nil || len(tokens) == 0 {
+		return 0, err
+	}
+	keys := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		keys = append(keys, fmt.Sprintf("%s:%s", config.GetString("CACHE_TOKEN_PREFIX"), token))
+	}
+	count, err := s.Client.Client.Exists(s.Client.Ctx, keys...).Result()
+	return int(count), err
+}
+
+// Del 删除token缓存和签发记录
+func (s *RedisTokenStore) Del(token string) (err error) {
 	key := fmt.Sprintf("%s:%s", config.GetString("CACHE_TOKEN_PREFIX"), token)
-	err = redis.Redis.Del(key)
+	if err = s.Client.Del(tokenIssuedKey(token)); err != nil {
+		return
+	}
+	err = s.Client.Del(key)
 	return
 }
 
-// GetUserCache 获取用户信息缓存
-func GetUserCache(username string) (userModel.User, error) {
+// RedisUserCache 用户信息缓存的redis实现
+type RedisUserCache struct {
+	Client *redis.RedisClient
+}
+
+// NewRedisUserCache 创建redis用户信息缓存
+func NewRedisUserCache(client *redis.RedisClient) *RedisUserCache {
+	return &RedisUserCache{Client: client}
+}
+
+// Get 获取用户信息缓存
+func (c *RedisUserCache) Get(username string) (userModel.User, error) {
 	var user userModel.User
 	key := fmt.Sprintf("%s:%s", config.GetString("CACHE_USER_PREFIX"), username)
-	val, err := redis.Redis.Get(key)
+	val, err := c.Client.Get(key)
 	if err != nil || len(val) == 0 {
 		return user, err
 	}
@@ -42,20 +126,187 @@ func GetUserCache(username string) (userModel.User, error) {
 	return user, err
 }
 
-// SetUserCache 写入用户信息缓存
-func SetUserCache(username string, user userModel.User) error {
+// Set 写入用户信息缓存
+func (c *RedisUserCache) Set(username string, user userModel.User) error {
 	data, err := json.Marshal(user)
 	if err != nil {
 		return err
 	}
 	key := fmt.Sprintf("%s:%s", config.GetString("CACHE_USER_PREFIX"), username)
 	expire := time.Duration(config.GetInt("CACHE_USER_EXPIRE")) * time.Second
-	return redis.Redis.Set(key, string(data), expire)
+	return c.Client.Set(key, string(data), expire)
 }
 
-// DelUserCache 删除用户信息缓存
-func DelUserCache(username string) (err error) {
+// Del 删除用户信息缓存
+func (c *RedisUserCache) Del(username string) (err error) {
 	key := fmt.Sprintf("%s:%s", config.GetString("CACHE_USER_PREFIX"), username)
-	err = redis.Redis.Del(key)
+	err = c.Client.Del(key)
 	return
 }
+
+// RedisLoginHistory 登录记录存储的redis实现
+// 每个用户一个list保存登录记录, 一个hash保存已知的设备和IP组合及最后登录时间,
+// 保留 LOGIN_HISTORY_SIZE 条记录, 超过 LOGIN_HISTORY_EXPIRE 秒的记录和设备不再使用
+type RedisLoginHistory struct {
+	Client *redis.RedisClient
+}
+
+// NewRedisLoginHistory 创建redis登录记录存储
+func NewRedisLoginHistory(client *redis.RedisClient) *RedisLoginHistory {
+	return &RedisLoginHistory{Client: client}
+}
+
+// 用户的登录记录list
+func loginRecordsKey(username string) string {
+	return fmt.Sprintf("%s:%s", config.GetString("LOGIN_HISTORY_PREFIX"), username)
+}
+
+// 用户的已知设备hash
+func loginDevicesKey(username string) string {
+	return fmt.Sprintf("%s_devices:%s", config.GetString("LOGIN_HISTORY_PREFIX"), username)
+}
+
+// 设备和IP组合
+func loginDeviceField(record userModel.LoginRecord) string {
+	return record.Device + "|" + record.IP
+}
+
+// 清理过期的设备, 判断是否为新设备, 写入登录记录并截断到保留数量
+// KEYS[1] 已知设备hash KEYS[2] 登录记录list
+// ARGV[1] 设备和IP组合 ARGV[2] 当前时间 ARGV[3] 保留秒数 ARGV[4] 保留数量
+// ARGV[5] ARGV[6] 分别为已知设备和新设备的登录记录, 返回1表示新设备
+var addLoginScript = redisLib.NewScript(`
+local now = tonumber(ARGV[2])
+local retain = tonumber(ARGV[3])
+local size = tonumber(ARGV[4])
+local devices = redis.call('HGETALL', KEYS[1])
+local known = 0
+for i = 1, #devices, 2 do
+	if tonumber(devices[i + 1]) <= now - retain then
+		redis.call('HDEL', KEYS[1], devices[i])
+	else
+		known = known + 1
+	end
+end
+local new = 0
+if known > 0 and redis.call('HEXISTS', KEYS[1], ARGV[1]) == 0 then
+	new = 1
+end
+redis.call('HSET', KEYS[1], ARGV[1], now)
+-- 已知设备超过保留数量时删除最久未登录的
+local count = redis.call('HLEN', KEYS[1])
+while count > size do
+	local fields = redis.call('HGETALL', KEYS[1])
+	local oldest, oldestTime = nil, nil
+	for i = 1, #fields, 2 do
+		local t = tonumber(fields[i + 1])
+		if oldestTime == nil or t < oldestTime then
+			oldest, oldestTime = fields[i], t
+		end
+	end
+	redis.call('HDEL', KEYS[1], oldest)
+	count = count - 1
+end
+redis.call('LPUSH', KEYS[2], ARGV[5 + new])
+redis.call('LTRIM', KEYS[2], 0, size - 1)
+redis.call('EXPIRE', KEYS[1], retain)
+redis.call('EXPIRE', KEYS[2], retain)
+return new
+`)
+
+// Add 写入登录记录, 返回是否为新设备
+func (h *RedisLoginHistory) Add(username string, record userModel.LoginRecord) (bool, error) {
+	record.NewDevice = false
+	known, err := json.Marshal(record)
+	if err != nil {
+		return false, err
+	}
+	record.NewDevice = true
+	unknown, err := json.Marshal(record)
+	if err != nil {
+		return false, err
+	}
+	newDevice, err := addLoginScript.Run(h.Client.Ctx, h.Client.Client,
+		[]string{loginDevicesKey(username), loginRecordsKey(username)},
+		loginDeviceField(record), record.Time.Unix(), config.GetInt("LOGIN_HISTORY_EXPIRE"),
+		config.GetInt("LOGIN_HISTORY_SIZE"), string(known), string(unknown)).Int()
+	return newDevice == 1, err
+}
+
+// List 按时间倒序返回保留期内最近的登录记录
+func (h *RedisLoginHistory) List(username string, limit int) ([]userModel.LoginRecord, error) {
+	values, err := h.Client.Client.LRange(h.Client.Ctx, loginRecordsKey(username), 0, int64(limit-1)).Result()
+	if err != nil {
+		return nil, err
+	}
+	since := time.Now().Add(-time.Duration(config.GetInt("LOGIN_HISTORY_EXPIRE")) * time.Second)
+	records := make([]userModel.LoginRecord, 0, len(values))
+	for _, value := range values {
+		var record userModel.LoginRecord
+		if err = json.Unmarshal([]byte(value), &record); err != nil {
+			return nil, err
+		}
+		if !record.Time.After(since) {
+			break
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// DelByUsername 删除用户的登录记录和已知设备
+func (h *RedisLoginHistory) DelByUsername(username string) error {
+	return h.Client.Client.Del(h.Client.Ctx, loginDevicesKey(username), loginRecordsKey(username)).Err()
+}
+
+// RedisMFAChallengeStore 两步验证挑战存储的redis实现, 每个挑战一个hash保存用户名和失败次数
+type RedisMFAChallengeStore struct {
+	Client *redis.RedisClient
+}
+
+// NewRedisMFAChallengeStore 创建redis两步验证挑战存储
+func NewRedisMFAChallengeStore(client *redis.RedisClient) *RedisMFAChallengeStore {
+	return &RedisMFAChallengeStore{Client: client}
+}
+
+func mfaChallengeKey(challenge string) string {
+	return fmt.Sprintf("%s:%s", config.GetString("MFA_CHALLENGE_PREFIX"), challenge)
+}
+
+// 挑战存在时失败次数加1, 避免为已过期的挑战创建不会过期的key
+var failMFAChallengeScript = redisLib.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+return redis.call('HINCRBY', KEYS[1], 'failures', 1)
+`)
+
+// Set 写入挑战
+func (s *RedisMFAChallengeStore) Set(challenge string, username string, ttl time.Duration) error {
+	key := mfaChallengeKey(challenge)
+	_, err := s.Client.Client.TxPipelined(s.Client.Ctx, func(pipe redisLib.Pipeliner) error {
+		pipe.HSet(s.Client.Ctx, key, "username", username, "failures", 0)
+		pipe.Expire(s.Client.Ctx, key, ttl)
+		return nil
+	})
+	return err
+}
+
+// Get 获取挑战对应的用户名
+func (s *RedisMFAChallengeStore) Get(challenge string) (string, error) {
+	username, err := s.Client.Client.HGet(s.Client.Ctx, mfaChallengeKey(challenge), "username").Result()
+	if err == redisLib.Nil {
+		return "", nil
+	}
+	return username, err
+}
+
+// Fail 记录一次验证失败
+func (s *RedisMFAChallengeStore) Fail(challenge string) (int, error) {
+	return failMFAChallengeScript.Run(s.Client.Ctx, s.Client.Client, []string{mfaChallengeKey(challenge)}).Int()
+}
+
+// Del 删除挑战
+func (s *RedisMFAChallengeStore) Del(challenge string) error {
+	return s.Client.Del(mfaChallengeKey(challenge))
+}
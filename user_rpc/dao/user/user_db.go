@@ -1,19 +1,27 @@
 package user
 
 import (
-	"errors"
-	"fmt"
 	"gorm.io/gorm"
+	"strings"
 	"time"
 	userModel "user_rpc/model/user"
-	"user_rpc/pkg/database"
 	"user_rpc/pkg/logger"
 	"user_rpc/pkg/util"
 )
 
+// DBUserRepository 用户数据存储的mysql实现
+type DBUserRepository struct {
+	DB *gorm.DB
+}
+
+// NewDBUserRepository 创建mysql用户数据存储
+func NewDBUserRepository(db *gorm.DB) *DBUserRepository {
+	return &DBUserRepository{DB: db}
+}
+
 // GetByUsername 根据用户名获取用户信息
-func GetByUsername(username string) (user userModel.User, err error) {
-	err = database.DB.
+func (r *DBUserRepository) GetByUsername(username string) (user userModel.User, err error) {
+	err = r.DB.
 		Table(util.GetTableByUsername(username)).
 		Where("username = ?", username).
 		First(&user).Error
@@ -24,7 +32,7 @@ func GetByUsername(username string) (user userModel.User, err error) {
 }
 
 // CreateOne 创建用户信息
-func CreateOne(username, password, nickname string) (user userModel.User, err error) {
+func (r *DBUserRepository) CreateOne(username, password, nickname string) (user userModel.User, err error) {
 	currentTime := time.Now()
 	user.Username = username
 	user.Salt = util.GenerateRandomStr()
@@ -32,7 +40,10 @@ func CreateOne(username, password, nickname string) (user userModel.User, err er
 	user.Nickname = nickname
 	user.CreateTime = currentTime
 	user.UpdateTime = currentTime
-	res := database.DB.
+	user.Version = 1 // 与表结构中version、role、status的默认值一致
+	user.Role = userModel.RoleUser
+	user.Status = userModel.StatusActive
+	res := r.DB.
 		Table(util.GetTableByUsername(username)).
 		Model(&userModel.User{}).
 		Select("username", "password", "salt", "nickname").
@@ -40,54 +51,153 @@ func CreateOne(username, password, nickname string) (user userModel.User, err er
 	return user, res.Error
 }
 
-// UpdateColumnByUsername 更新用户信息的某个字段
-func UpdateColumnByUsername(username string, column string, value string) error {
-	// 开启事务，使用乐观锁更新数据
-	return database.DB.Transaction(func(tx *gorm.DB) error {
-		var user userModel.User
+// UpdateColumnsByUsername 更新用户信息的多个字段, 返回更新后的用户信息
+func (r *DBUserRepository) UpdateColumnsByUsername(username string, version int, columns map[string]interface{}) (user userModel.User, err error) {
+	table := util.GetTableByUsername(username)
 
-		// 先查询用户的version
-		err := tx.
-			Table(util.GetTableByUsername(username)).
-			Select("version").
-			Where("username = ?", username).
-			First(&user).Error
-		if err != nil {
-			return err
+	// 开启事务，使用乐观锁更新数据
+	err = r.DB.Transaction(func(tx *gorm.DB) error {
+		// 未指定version时先查询用户当前的version
+		if version == 0 {
+			err := tx.
+				Table(table).
+				Select("version").
+				Where("username = ?", username).
+				First(&user).Error
+			if err != nil {
+				return err
+			}
+			version = user.Version
 		}
 
 		// 在根据version去更新
-		currentTime := time.Now()
-		newVersion := user.Version + 1
+		updates := map[string]interface{}{
+			"update_time": time.Now(),
+			"version":     version + 1,
+		}
+		selects := []string{"update_time", "version"}
+		for column, value := range columns {
+			updates[column] = value
+			selects = append(selects, column)
+		}
 		res := tx.
-			Table(util.GetTableByUsername(username)).
-			Where("username = ? AND version = ?", username, user.Version).
-			Select(column, "update_time", "version").
-			Updates(map[string]interface{}{
-				column:        value,
-				"update_time": currentTime,
-				"version":     newVersion,
-			})
+			Table(table).
+			Where("username = ? AND version = ?", username, version).
+			Select(selects).
+			Updates(updates)
 		if res.Error != nil {
 			return res.Error
 		}
 		if res.RowsAffected <= 0 {
-			logger.Debug("userDao", "UpdateColumnByUsername not affected",
-				username, "column", column, "value", value)
-			return errors.New(fmt.Sprintf("UpdateColumnByUsername not affected, "+
-				"username: %s, column: %s, value: %s", username, column, value))
+			logger.Debug("userDao", "UpdateColumnsByUsername not affected",
+				username, "version", version, "columns", columns)
+			return ErrVersionConflict
 		}
 
-		return nil
+		// 查询更新后的用户信息
+		return tx.
+			Table(table).
+			Where("username = ?", username).
+			First(&user).Error
 	})
+	return
 }
 
-// UpdateNickNameByUsername 更新用户的Nickname
-func UpdateNickNameByUsername(username string, nickname string) error {
-	return UpdateColumnByUsername(username, "nickname", nickname)
+// ListPicPaths 逐个分表遍历正在使用的头像路径
+func (r *DBUserRepository) ListPicPaths(fn func(picPath string) error) error {
+	for _, table := range util.GetUserTables() {
+		rows, err := r.DB.
+			Table(table).
+			Distinct("pic_path").
+			Where("pic_path <> ''").
+			Rows()
+		if err != nil {
+			return err
+		}
+
+		for rows.Next() {
+			var picPath string
+			if err = rows.Scan(&picPath); err != nil {
+				break
+			}
+			if err = fn(picPath); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = rows.Err()
+		}
+		rows.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 转义LIKE中的通配符
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// Search 逐个分表搜索用户, 用户名前缀可以使用唯一索引
+func (r *DBUserRepository) Search(query string, cursor SearchCursor, limit int) (users []userModel.User, next *SearchCursor, err error) {
+	tables := util.GetUserTables()
+	pattern := likeEscaper.Replace(query)
+	for i := cursor.Table; i < len(tables) && len(users) < limit; i++ {
+		lastID := int64(0)
+		if i == cursor.Table {
+			lastID = cursor.LastID
+		}
+
+		var found []userModel.User
+		db := r.DB.Table(tables[i]).Where("id > ?", lastID)
+		if len(query) > 0 {
+			db = db.Where("username LIKE ? OR nickname LIKE ?", pattern+"%", "%"+pattern+"%")
+		}
+		if err = db.Order("id").Limit(limit - len(users)).Find(&found).Error; err != nil {
+			return nil, nil, err
+		}
+		users = append(users, found...)
+
+		// 取满一页时从当前分表的最后一条继续
+		if len(users) == limit {
+			next = &SearchCursor{Table: i, LastID: found[len(found)-1].ID}
+		}
+	}
+	return users, next, nil
 }
 
-// UpdatePicPathByUsername 更新用户的PicPath
-func UpdatePicPathByUsername(username string, picPath string) error {
-	return UpdateColumnByUsername(username, "pic_path", picPath)
+// ListPendingDeletion 逐个分表遍历保留期已过的待注销用户
+func (r *DBUserRepository) ListPendingDeletion(before time.Time, fn func(user userModel.User) error) error {
+	for _, table := range util.GetUserTables() {
+		var users []userModel.User
+		err := r.DB.
+			Table(table).
+			Where("status = ? AND deleted_at < ?", userModel.StatusPendingDeletion, before).
+			Order("id").
+			Find(&users).Error
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			if err = fn(user); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DeleteByUsername 使用乐观锁删除用户
+func (r *DBUserRepository) DeleteByUsername(username string, version int) error {
+	res := r.DB.
+		Table(util.GetTableByUsername(username)).
+		Where("username = ? AND version = ?", username, version).
+		Delete(&userModel.User{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected <= 0 {
+		return ErrVersionConflict
+	}
+	return nil
 }
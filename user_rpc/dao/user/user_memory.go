@@ -0,0 +1,423 @@
+package user
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	userModel "user_rpc/model/user"
+	"user_rpc/pkg/util"
+)
+
+// MemoryUserRepository 用户数据存储的内存实现, 用于测试
+type MemoryUserRepository struct {
+	mu     sync.RWMutex
+	lastID int64
+	users  map[string]userModel.User
+}
+
+// NewMemoryUserRepository 创建内存用户数据存储
+func NewMemoryUserRepository() *MemoryUserRepository {
+	return &MemoryUserRepository{users: make(map[string]userModel.User)}
+}
+
+// GetByUsername 根据用户名获取用户信息
+func (r *MemoryUserRepository) GetByUsername(username string) (userModel.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.users[username], nil
+}
+
+// CreateOne 创建用户信息
+func (r *MemoryUserRepository) CreateOne(username, password, nickname string) (user userModel.User, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.users[username]; ok {
+		return user, fmt.Errorf("duplicate username: %s", username)
+	}
+
+	currentTime := time.Now()
+	r.lastID++
+	user.ID = r.lastID
+	user.Username = username
+	user.Salt = util.GenerateRandomStr()
+	user.Password = util.GeneratePwdHash(password, user.Salt)
+	user.Nickname = nickname
+	user.CreateTime = currentTime
+	user.UpdateTime = currentTime
+	user.Version = 1
+	user.Role = userModel.RoleUser
+	user.Status = userModel.StatusActive
+	r.users[username] = user
+	return user, nil
+}
+
+// UpdateColumnsByUsername 更新用户信息的多个字段, 返回更新后的用户信息
+func (r *MemoryUserRepository) UpdateColumnsByUsername(username string, version int, columns map[string]interface{}) (userModel.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[username]
+	if !ok {
+		return user, fmt.Errorf("user not found, username: %s", username)
+	}
+	if version != 0 && version != user.Version {
+		return user, ErrVersionConflict
+	}
+	for column, value := range columns {
+		switch column {
+		case "nickname":
+			user.Nickname, _ = value.(string)
+		case "pic_path":
+			user.PicPath, _ = value.(string)
+		case "role":
+			user.Role, _ = value.(string)
+		case "status":
+			user.Status, _ = value.(int)
+		case "password":
+			user.Password, _ = value.(string)
+		case "salt":
+			user.Salt, _ = value.(string)
+		case "mfa_secret":
+			user.MFASecret, _ = value.(string)
+		case "mfa_enabled":
+			user.MFAEnabled, _ = value.(bool)
+		case "mfa_recovery_codes":
+			user.MFARecoveryCodes, _ = value.(string)
+		case "mfa_last_step":
+			user.MFALastStep, _ = value.(int64)
+		case "deleted_at":
+			if deletedAt, ok := value.(time.Time); ok {
+				user.DeletedAt = &deletedAt
+			} else {
+				user.DeletedAt = nil
+			}
+		default:
+			return r.users[username], fmt.Errorf("unknown column: %s", column)
+		}
+	}
+	user.UpdateTime = time.Now()
+	user.Version++
+	r.users[username] = user
+	return user, nil
+}
+
+// ListPicPaths 遍历正在使用的头像路径
+func (r *MemoryUserRepository) ListPicPaths(fn func(picPath string) error) error {
+	r.mu.RLock()
+	picPaths := make([]string, 0, len(r.users))
+	for _, user := range r.users {
+		if len(user.PicPath) > 0 {
+			picPaths = append(picPaths, user.PicPath)
+		}
+	}
+	r.mu.RUnlock()
+
+	for _, picPath := range picPaths {
+		if err := fn(picPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Search 按ID顺序搜索用户, 内存实现只有一个分表
+func (r *MemoryUserRepository) Search(query string, cursor SearchCursor, limit int) ([]userModel.User, *SearchCursor, error) {
+	r.mu.RLock()
+	var users []userModel.User
+	for _, user := range r.users {
+		if user.ID > cursor.LastID && (strings.HasPrefix(user.Username, query) || strings.Contains(user.Nickname, query)) {
+			users = append(users, user)
+		}
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	if len(users) <= limit {
+		return users, nil, nil
+	}
+	users = users[:limit]
+	return users, &SearchCursor{LastID: users[limit-1].ID}, nil
+}
+
+// ListPendingDeletion 按ID顺序遍历保留期已过的待注销用户
+func (r *MemoryUserRepository) ListPendingDeletion(before time.Time, fn func(user userModel.User) error) error {
+	r.mu.RLock()
+	var users []userModel.User
+	for _, user := range r.users {
+		if user.Status == userModel.StatusPendingDeletion && user.DeletedAt != nil && user.DeletedAt.Before(before) {
+			users = append(users, user)
+		}
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	for _, user := range users {
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteByUsername 按版本号删除用户
+func (r *MemoryUserRepository) DeleteByUsername(username string, version int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[username]
+	if !ok || user.Version != version {
+		return ErrVersionConflict
+	}
+	delete(r.users, username)
+	return nil
+}
+
+// MemoryTokenStore token存储的内存实现, 用于测试
+type MemoryTokenStore struct {
+	mu      sync.RWMutex
+	tokens  map[string]string
+	expired map[string]bool
+}
+
+// NewMemoryTokenStore 创建内存token存储
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{tokens: make(map[string]string), expired: make(map[string]bool)}
+}
+
+// Set 写入token
+func (s *MemoryTokenStore) Set(token string, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[token] = value
+	return nil
+}
+
+// Get 获取token
+func (s *MemoryTokenStore) Get(token string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tokens[token], nil
+}
+
+// Expired 判断token是否已过期
+func (s *MemoryTokenStore) Expired(token string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.expired[token], nil
+}
+
+// Expire 使token过期, 用于测试
+func (s *MemoryTokenStore) Expire(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.tokens[token]; ok {
+		delete(s.tokens, token)
+		s.expired[token] = true
+	}
+}
+
+// DelByUsername 删除用户的所有token
+func (s *MemoryTokenStore) DelByUsername(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for token, value := range s.tokens {
+		if value == username {
+			delete(s.tokens, token)
+		}
+	}
+	return nil
+}
+
+// CountByUsername 统计用户的token数量
+func (s *MemoryTokenStore) CountByUsername(username string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	count := 0
+	for _, value := range s.tokens {
+		if value == username {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Del 删除token
+func (s *MemoryTokenStore) Del(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tokens, token)
+	delete(s.expired, token)
+	return nil
+}
+
+// MemoryUserCache 用户信息缓存的内存实现, 用于测试
+type MemoryUserCache struct {
+	mu    sync.RWMutex
+	users map[string]userModel.User
+}
+
+// NewMemoryUserCache 创建内存用户信息缓存
+func NewMemoryUserCache() *MemoryUserCache {
+	return &MemoryUserCache{users: make(map[string]userModel.User)}
+}
+
+// Get 获取用户信息缓存
+func (c *MemoryUserCache) Get(username string) (userModel.User, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.users[username], nil
+}
+
+// Set 写入用户信息缓存
+func (c *MemoryUserCache) Set(username string, user userModel.User) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// 与redis实现一致, 不保存凭证
+	user.Password, user.Salt, user.MFASecret, user.MFARecoveryCodes = "", "", "", ""
+	c.users[username] = user
+	return nil
+}
+
+// Del 删除用户信息缓存
+func (c *MemoryUserCache) Del(username string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.users, username)
+	return nil
+}
+
+// MemoryLoginHistory 登录记录存储的内存实现, 用于测试
+type MemoryLoginHistory struct {
+	mu        sync.Mutex
+	size      int
+	retention time.Duration
+	records   map[string][]userModel.LoginRecord
+	devices   map[string]map[string]time.Time
+}
+
+// NewMemoryLoginHistory 创建内存登录记录存储, 每个用户保留size条记录, 超过retention的记录和设备不再使用
+func NewMemoryLoginHistory(size int, retention time.Duration) *MemoryLoginHistory {
+	return &MemoryLoginHistory{
+		size:      size,
+		retention: retention,
+		records:   make(map[string][]userModel.LoginRecord),
+		devices:   make(map[string]map[string]time.Time),
+	}
+}
+
+// Add 写入登录记录, 返回是否为新设备
+func (h *MemoryLoginHistory) Add(username string, record userModel.LoginRecord) (bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	devices := h.devices[username]
+	if devices == nil {
+		devices = make(map[string]time.Time)
+		h.devices[username] = devices
+	}
+	for device, lastLogin := range devices {
+		if !lastLogin.After(record.Time.Add(-h.retention)) {
+			delete(devices, device)
+		}
+	}
+	field := loginDeviceField(record)
+	_, known := devices[field]
+	record.NewDevice = len(devices) > 0 && !known
+	devices[field] = record.Time
+
+	records := append([]userModel.LoginRecord{record}, h.records[username]...)
+	if len(records) > h.size {
+		records = records[:h.size]
+	}
+	h.records[username] = records
+	return record.NewDevice, nil
+}
+
+// List 按时间倒序返回保留期内最近的登录记录
+func (h *MemoryLoginHistory) List(username string, limit int) ([]userModel.LoginRecord, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	since := time.Now().Add(-h.retention)
+	var records []userModel.LoginRecord
+	for _, record := range h.records[username] {
+		if len(records) >= limit || !record.Time.After(since) {
+			break
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// DelByUsername 删除用户的登录记录和已知设备
+func (h *MemoryLoginHistory) DelByUsername(username string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.records, username)
+	delete(h.devices, username)
+	return nil
+}
+
+// MemoryMFAChallengeStore 两步验证挑战存储的内存实现, 用于测试
+type MemoryMFAChallengeStore struct {
+	mu         sync.Mutex
+	challenges map[string]*memoryMFAChallenge
+}
+
+type memoryMFAChallenge struct {
+	username string
+	failures int
+	expire   time.Time
+}
+
+// NewMemoryMFAChallengeStore 创建内存两步验证挑战存储
+func NewMemoryMFAChallengeStore() *MemoryMFAChallengeStore {
+	return &MemoryMFAChallengeStore{challenges: make(map[string]*memoryMFAChallenge)}
+}
+
+// 获取未过期的挑战
+func (s *MemoryMFAChallengeStore) get(challenge string) *memoryMFAChallenge {
+	c, ok := s.challenges[challenge]
+	if !ok || !time.Now().Before(c.expire) {
+		return nil
+	}
+	return c
+}
+
+// Set 写入挑战
+func (s *MemoryMFAChallengeStore) Set(challenge string, username string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.challenges[challenge] = &memoryMFAChallenge{username: username, expire: time.Now().Add(ttl)}
+	return nil
+}
+
+// Get 获取挑战对应的用户名
+func (s *MemoryMFAChallengeStore) Get(challenge string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c := s.get(challenge); c != nil {
+		return c.username, nil
+	}
+	return "", nil
+}
+
+// Fail 记录一次验证失败
+func (s *MemoryMFAChallengeStore) Fail(challenge string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.get(challenge)
+	if c == nil {
+		return 0, nil
+	}
+	c.failures++
+	return c.failures, nil
+}
+
+// Del 删除挑战
+func (s *MemoryMFAChallengeStore) Del(challenge string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.challenges, challenge)
+	return nil
+}
@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"user_rpc/pkg/rbac"
+)
+
+// PrivilegedMethods 开启TLS时只允许 RPC_TLS_ALLOWED_CLIENTS 中的客户端调用的方法
+// 注册、token校验、签发身份凭证、管理接口和头像回收只应由 user_web 发起
+var PrivilegedMethods = map[string]bool{
+	"/userapi.v1.UserService/CreateUserProfile": true,
+	"/userapi.v1.UserService/Authenticate":      true,
+	"/userapi.v1.UserService/SearchUsers":       true,
+	"/userapi.v1.UserService/AdminGetUser":      true,
+	"/userapi.v1.UserService/SetUserStatus":     true,
+	"/userapi.v1.UserService/ForceLogout":       true,
+	"/userapi.v1.UserService/ResetNickname":     true,
+	"/userapi.v1.UserService/ListAuditEvents":   true,
+	"/userapi.v1.UserService/ListPicPaths":      true,
+}
+
+// MethodPermissions 管理接口要求调用方角色拥有的权限, 包括流式接口
+var MethodPermissions = map[string]rbac.Permission{
+	"/userapi.v1.UserService/SearchUsers":     rbac.PermUserRead,
+	"/userapi.v1.UserService/AdminGetUser":    rbac.PermUserRead,
+	"/userapi.v1.UserService/SetUserStatus":   rbac.PermUserStatus,
+	"/userapi.v1.UserService/ForceLogout":     rbac.PermUserLogout,
+	"/userapi.v1.UserService/ResetNickname":   rbac.PermUserModerate,
+	"/userapi.v1.UserService/ListAuditEvents": rbac.PermAuditRead,
+	"/userapi.v1.UserService/ListPicPaths":    rbac.PermAvatarGC,
+}
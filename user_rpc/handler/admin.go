@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"context"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"user_rpc/pkg/logger"
+	"user_rpc/pkg/util"
+	"userapi/v1"
+)
+
+// SearchUsers 搜索用户接口
+func (u *UserHandler) SearchUsers(ctx context.Context, req *userapi.SearchUsersRequest) (*userapi.SearchUsersResponse, error) {
+	requestID := util.GetRequestIDFromContext(ctx)
+
+	// 调用搜索用户逻辑
+	users, nextPageToken, err := u.service.SearchUsers(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp := &userapi.SearchUsersResponse{NextPageToken: nextPageToken}
+	for _, user := range users {
+		userRsp := userModelToUserRsp(user)
+		rsp.Users = append(rsp.Users, &userRsp)
+	}
+	logger.Debug("searchUsers", "requestID:", requestID, "search users success, count:", len(rsp.Users))
+
+	return rsp, nil
+}
+
+// AdminGetUser 查看任意用户信息接口
+func (u *UserHandler) AdminGetUser(ctx context.Context, req *userapi.AdminUserRequest) (*userapi.UserResponse, error) {
+	requestID := util.GetRequestIDFromContext(ctx)
+
+	// 调用查看用户逻辑
+	user, err := u.service.AdminGetUser(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp := userModelToUserRsp(user)
+	logger.Debug("adminGetUser", "requestID:", requestID, "data:", &rsp)
+
+	return &rsp, nil
+}
+
+// SetUserStatus 禁用或启用账号接口
+func (u *UserHandler) SetUserStatus(ctx context.Context, req *userapi.SetUserStatusRequest) (*userapi.UserResponse, error) {
+	requestID := util.GetRequestIDFromContext(ctx)
+
+	// 调用修改账号状态逻辑
+	user, err := u.service.SetUserStatus(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp := userModelToUserRsp(user)
+	logger.Debug("setUserStatus", "requestID:", requestID, "data:", &rsp)
+
+	return &rsp, nil
+}
+
+// ForceLogout 强制登出接口
+func (u *UserHandler) ForceLogout(ctx context.Context, req *userapi.AdminUserRequest) (*emptypb.Empty, error) {
+	requestID := util.GetRequestIDFromContext(ctx)
+
+	// 调用强制登出逻辑
+	if err := u.service.ForceLogout(ctx, req); err != nil {
+		return nil, err
+	}
+
+	logger.Debug("forceLogout", "requestID:", requestID, "force logout success, username:", req.Username)
+	return &emptypb.Empty{}, nil
+}
+
+// ResetNickname 重置违规昵称接口
+func (u *UserHandler) ResetNickname(ctx context.Context, req *userapi.AdminUserRequest) (*userapi.UserResponse, error) {
+	requestID := util.GetRequestIDFromContext(ctx)
+
+	// 调用重置昵称逻辑
+	user, err := u.service.ResetNickname(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp := userModelToUserRsp(user)
+	logger.Debug("resetNickname", "requestID:", requestID, "data:", &rsp)
+
+	return &rsp, nil
+}
+
+// ListAuditEvents 查询审计日志接口
+func (u *UserHandler) ListAuditEvents(ctx context.Context, req *userapi.ListAuditEventsRequest) (
+	*userapi.ListAuditEventsResponse, error) {
+	requestID := util.GetRequestIDFromContext(ctx)
+
+	// 调用查询审计日志逻辑
+	events, nextPageToken, err := u.service.ListAuditEvents(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp := &userapi.ListAuditEventsResponse{NextPageToken: nextPageToken}
+	for _, event := range events {
+		rsp.Events = append(rsp.Events, auditEventToRsp(event))
+	}
+	logger.Debug("listAuditEvents", "requestID:", requestID, "list audit events success, count:", len(rsp.Events))
+
+	return rsp, nil
+}
@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"context"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"user_rpc/pkg/logger"
+	"user_rpc/pkg/util"
+	"userapi/v1"
+)
+
+// VerifyMFA 两步验证登录接口
+func (u *UserHandler) VerifyMFA(ctx context.Context, req *userapi.VerifyMFARequest) (*userapi.LoginResponse, error) {
+	requestID := util.GetRequestIDFromContext(ctx)
+
+	// 调用两步验证登录逻辑
+	user, token, err := u.service.VerifyMFA(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp := userModelToLoginRsp(user, token)
+	logger.Debug("verifyMFA", "requestID:", requestID, "verify mfa success, username:", user.Username)
+	return &rsp, nil
+}
+
+// EnrollMFA 开始设置两步验证接口
+func (u *UserHandler) EnrollMFA(ctx context.Context, req *userapi.EnrollMFARequest) (*userapi.EnrollMFAResponse, error) {
+	requestID := util.GetRequestIDFromContext(ctx)
+
+	// 调用开始设置两步验证逻辑
+	secret, uri, err := u.service.EnrollMFA(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Debug("enrollMFA", "requestID:", requestID, "enroll mfa success")
+	return &userapi.EnrollMFAResponse{Secret: secret, ProvisioningUri: uri}, nil
+}
+
+// ConfirmMFA 确认开启两步验证接口
+func (u *UserHandler) ConfirmMFA(ctx context.Context, req *userapi.ConfirmMFARequest) (*userapi.ConfirmMFAResponse, error) {
+	requestID := util.GetRequestIDFromContext(ctx)
+
+	// 调用确认开启两步验证逻辑
+	codes, err := u.service.ConfirmMFA(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Debug("confirmMFA", "requestID:", requestID, "confirm mfa success")
+	return &userapi.ConfirmMFAResponse{RecoveryCodes: codes}, nil
+}
+
+// DisableMFA 关闭两步验证接口
+func (u *UserHandler) DisableMFA(ctx context.Context, req *userapi.DisableMFARequest) (*emptypb.Empty, error) {
+	requestID := util.GetRequestIDFromContext(ctx)
+
+	// 调用关闭两步验证逻辑
+	if err := u.service.DisableMFA(ctx, req); err != nil {
+		return nil, err
+	}
+
+	logger.Debug("disableMFA", "requestID:", requestID, "disable mfa success")
+	return &emptypb.Empty{}, nil
+}
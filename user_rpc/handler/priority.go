@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"user_rpc/pkg/limiter"
+)
+
+// MethodPriorities 各rpc方法的优先级, 过载时低优先级的方法先被拒绝
+// 认证是所有已登录接口的前提, 优先级最高; 注册、头像回收、数据导出和管理接口可以稍后重试, 优先级最低
+var MethodPriorities = map[string]limiter.Priority{
+	"/userapi.v1.UserService/Authenticate":      limiter.PriorityCritical,
+	"/userapi.v1.UserService/GetUserProfile":    limiter.PriorityHigh,
+	"/userapi.v1.UserService/Login":             limiter.PriorityHigh,
+	"/userapi.v1.UserService/VerifyMFA":         limiter.PriorityHigh,
+	"/userapi.v1.UserService/Logout":            limiter.PriorityNormal,
+	"/userapi.v1.UserService/EditUserProfile":   limiter.PriorityNormal,
+	"/userapi.v1.UserService/DeleteAccount":     limiter.PriorityNormal,
+	"/userapi.v1.UserService/ListLogins":        limiter.PriorityNormal,
+	"/userapi.v1.UserService/EnrollMFA":         limiter.PriorityNormal,
+	"/userapi.v1.UserService/ConfirmMFA":        limiter.PriorityNormal,
+	"/userapi.v1.UserService/DisableMFA":        limiter.PriorityNormal,
+	"/userapi.v1.UserService/CreateUserProfile": limiter.PriorityLow,
+	"/userapi.v1.UserService/ListPicPaths":      limiter.PriorityLow,
+	"/userapi.v1.UserService/ExportUserData":    limiter.PriorityLow,
+	"/userapi.v1.UserService/SearchUsers":       limiter.PriorityLow,
+	"/userapi.v1.UserService/AdminGetUser":      limiter.PriorityLow,
+	"/userapi.v1.UserService/SetUserStatus":     limiter.PriorityLow,
+	"/userapi.v1.UserService/ForceLogout":       limiter.PriorityLow,
+	"/userapi.v1.UserService/ResetNickname":     limiter.PriorityLow,
+	"/userapi.v1.UserService/ListAuditEvents":   limiter.PriorityLow,
+}
@@ -4,19 +4,29 @@ import (
 	"context"
 	"google.golang.org/protobuf/types/known/emptypb"
 	userModel "user_rpc/model/user"
+	"user_rpc/pkg/audit"
 	"user_rpc/pkg/logger"
 	"user_rpc/pkg/util"
-	"user_rpc/proto"
 	userService "user_rpc/service/user"
+	"userapi/v1"
 )
 
+// HealthServiceName 健康检查中用户服务的名称
+const HealthServiceName = "userapi.v1.UserService"
+
 // UserHandler 用户服务
 type UserHandler struct {
+	service *userService.UserService
+}
+
+// NewUserHandler 创建用户服务
+func NewUserHandler(service *userService.UserService) *UserHandler {
+	return &UserHandler{service: service}
 }
 
 // 用户模型转换为登录接口的返回格式
-func userModelToLoginRsp(user userModel.User, token string) proto.LoginResponse {
-	return proto.LoginResponse{
+func userModelToLoginRsp(user userModel.User, token string) userapi.LoginResponse {
+	return userapi.LoginResponse{
 		Id:         user.ID,
 		Username:   user.Username,
 		Nickname:   user.Nickname,
@@ -28,27 +38,42 @@ func userModelToLoginRsp(user userModel.User, token string) proto.LoginResponse
 }
 
 // 用户模型转换为接口的用户信息返回格式
-func userModelToUserRsp(user userModel.User) proto.UserResponse {
-	return proto.UserResponse{
+func userModelToUserRsp(user userModel.User) userapi.UserResponse {
+	var deleteTime int64
+	if user.DeletedAt != nil {
+		deleteTime = user.DeletedAt.Unix()
+	}
+	return userapi.UserResponse{
 		Id:         user.ID,
 		Username:   user.Username,
 		Nickname:   user.Nickname,
 		PicPath:    user.PicPath,
 		CreateTime: user.CreateTime.Unix(),
 		UpdateTime: user.UpdateTime.Unix(),
+		Version:    int64(user.Version),
+		Role:       user.Role,
+		Status:     userapi.UserStatus(user.Status),
+		DeleteTime: deleteTime,
+		MfaEnabled: user.MFAEnabled,
 	}
 }
 
 // Login 登录接口
-func (u *UserHandler) Login(ctx context.Context, req *proto.LoginRequest) (*proto.LoginResponse, error) {
+func (u *UserHandler) Login(ctx context.Context, req *userapi.LoginRequest) (*userapi.LoginResponse, error) {
 	requestID := util.GetRequestIDFromContext(ctx)
 
 	// 调用login逻辑
-	user, token, err := userService.Login(ctx, req)
+	user, token, challenge, err := u.service.Login(ctx, req)
 	if err != nil {
 		return nil, err
 	}
 
+	// 开启两步验证时只返回挑战, 通过 VerifyMFA 获取token
+	if len(challenge) > 0 {
+		logger.Debug("login", "requestID:", requestID, "mfa required, username:", user.Username)
+		return &userapi.LoginResponse{Username: user.Username, MfaRequired: true, MfaChallenge: challenge}, nil
+	}
+
 	rsp := userModelToLoginRsp(user, token)
 	logger.Debug("login", "requestID:", requestID, "login success, rsp:", &rsp)
 
@@ -56,12 +81,12 @@ func (u *UserHandler) Login(ctx context.Context, req *proto.LoginRequest) (*prot
 }
 
 // Logout 登出接口
-func (u *UserHandler) Logout(ctx context.Context, req *proto.AuthRequest) (*emptypb.Empty, error) {
+func (u *UserHandler) Logout(ctx context.Context, req *userapi.AuthRequest) (*emptypb.Empty, error) {
 	requestID := util.GetRequestIDFromContext(ctx)
 
 	// 调用登出逻辑
 	token := req.Token
-	if err := userService.Logout(ctx, req); err != nil {
+	if err := u.service.Logout(ctx, req); err != nil {
 		return nil, err
 	}
 
@@ -70,11 +95,11 @@ func (u *UserHandler) Logout(ctx context.Context, req *proto.AuthRequest) (*empt
 }
 
 // GetUserProfile 获取用户信息接口
-func (u *UserHandler) GetUserProfile(ctx context.Context, req *proto.AuthRequest) (*proto.UserResponse, error) {
+func (u *UserHandler) GetUserProfile(ctx context.Context, req *userapi.AuthRequest) (*userapi.UserResponse, error) {
 	requestID := util.GetRequestIDFromContext(ctx)
 
 	// 调用获取用户信息逻辑
-	user, err := userService.GetUserProfile(ctx, req)
+	user, err := u.service.GetUserProfile(ctx, req)
 	if err != nil {
 		return nil, err
 	}
@@ -86,31 +111,27 @@ func (u *UserHandler) GetUserProfile(ctx context.Context, req *proto.AuthRequest
 }
 
 // EditUserProfile 编辑用户信息接口
-func (u *UserHandler) EditUserProfile(ctx context.Context, req *proto.EditUserRequest) (*proto.UserResponse, error) {
+func (u *UserHandler) EditUserProfile(ctx context.Context, req *userapi.EditUserRequest) (*userapi.UserResponse, error) {
 	requestID := util.GetRequestIDFromContext(ctx)
 
 	// 调用编辑用户逻辑
-	username, err := userService.EditUserProfile(ctx, req)
+	user, err := u.service.EditUserProfile(ctx, req)
 	if err != nil {
 		return nil, err
 	}
 
-	rsp := userModelToUserRsp(userModel.User{
-		Username: username,
-		Nickname: req.Nickname,
-		PicPath:  req.PicPath,
-	})
+	rsp := userModelToUserRsp(user)
 	logger.Debug("editUserProfile", "requestID:", requestID, "data:", &rsp)
 
 	return &rsp, nil
 }
 
 // CreateUserProfile 创建用户信息接口
-func (u *UserHandler) CreateUserProfile(ctx context.Context, req *proto.CreateUserRequest) (*proto.UserResponse, error) {
+func (u *UserHandler) CreateUserProfile(ctx context.Context, req *userapi.CreateUserRequest) (*userapi.UserResponse, error) {
 	requestID := util.GetRequestIDFromContext(ctx)
 
 	// 调用创建用户逻辑
-	user, err := userService.CreateUserProfile(ctx, req)
+	user, err := u.service.CreateUserProfile(ctx, req)
 	if err != nil {
 		return nil, err
 	}
@@ -121,16 +142,131 @@ func (u *UserHandler) CreateUserProfile(ctx context.Context, req *proto.CreateUs
 	return &rsp, nil
 }
 
-// Auth 认证接口
-func (u *UserHandler) Auth(ctx context.Context, req *proto.AuthRequest) (*emptypb.Empty, error) {
+// Authenticate 认证接口, 返回用户身份和身份凭证
+func (u *UserHandler) Authenticate(ctx context.Context, req *userapi.AuthRequest) (*userapi.AuthResponse, error) {
 	requestID := util.GetRequestIDFromContext(ctx)
 
 	// 调用认证逻辑
-	token := req.Token
-	if err := userService.Auth(ctx, req); err != nil {
+	user, assertion, expire, err := u.service.Authenticate(ctx, req)
+	if err != nil {
 		return nil, err
 	}
 
-	logger.Debug("auth", "requestID:", requestID, "auth success, token:", token)
-	return &emptypb.Empty{}, nil
+	logger.Debug("authenticate", "requestID:", requestID, "authenticate success, username:", user.Username)
+	return &userapi.AuthResponse{
+		Id:                 user.ID,
+		Username:           user.Username,
+		Identity:           assertion,
+		IdentityExpireTime: expire.Unix(),
+	}, nil
+}
+
+// ListPicPaths 遍历头像路径接口
+func (u *UserHandler) ListPicPaths(req *userapi.ListPicPathsRequest, stream userapi.UserService_ListPicPathsServer) error {
+	ctx := stream.Context()
+	requestID := util.GetRequestIDFromContext(ctx)
+
+	// 调用遍历逻辑, 逐条发送
+	count := 0
+	err := u.service.ListPicPaths(ctx, func(picPath string) error {
+		count++
+		return stream.Send(&userapi.PicPathResponse{PicPath: picPath})
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.Debug("listPicPaths", "requestID:", requestID, "list pic paths success, count:", count)
+	return nil
+}
+
+// DeleteAccount 注销账号接口
+func (u *UserHandler) DeleteAccount(ctx context.Context, req *userapi.DeleteAccountRequest) (*userapi.DeleteAccountResponse, error) {
+	requestID := util.GetRequestIDFromContext(ctx)
+
+	// 调用注销账号逻辑
+	purgeTime, err := u.service.DeleteAccount(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Debug("deleteAccount", "requestID:", requestID, "delete account success, purge time:", purgeTime)
+	return &userapi.DeleteAccountResponse{PurgeTime: purgeTime.Unix()}, nil
+}
+
+// ExportUserData 导出个人数据接口
+func (u *UserHandler) ExportUserData(ctx context.Context, req *userapi.ExportUserDataRequest) (*userapi.ExportUserDataResponse, error) {
+	requestID := util.GetRequestIDFromContext(ctx)
+
+	// 调用导出个人数据逻辑
+	export, err := u.service.ExportUserData(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := userModelToUserRsp(export.User)
+	rsp := &userapi.ExportUserDataResponse{
+		Profile:        &profile,
+		ActiveSessions: int32(export.ActiveSessions),
+		ExportTime:     export.ExportTime.Unix(),
+	}
+	for _, event := range export.AuditEvents {
+		rsp.AuditEvents = append(rsp.AuditEvents, auditEventToRsp(event))
+	}
+	for _, record := range export.Logins {
+		rsp.Logins = append(rsp.Logins, loginRecordToRsp(record))
+	}
+	logger.Debug("exportUserData", "requestID:", requestID, "export user data success, audit events:",
+		len(rsp.AuditEvents), "logins:", len(rsp.Logins))
+
+	return rsp, nil
+}
+
+// ListLogins 查看登录记录接口
+func (u *UserHandler) ListLogins(ctx context.Context, req *userapi.ListLoginsRequest) (*userapi.ListLoginsResponse, error) {
+	requestID := util.GetRequestIDFromContext(ctx)
+
+	// 调用查看登录记录逻辑
+	records, err := u.service.ListLogins(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp := &userapi.ListLoginsResponse{}
+	for _, record := range records {
+		rsp.Logins = append(rsp.Logins, loginRecordToRsp(record))
+	}
+	logger.Debug("listLogins", "requestID:", requestID, "list logins success, count:", len(rsp.Logins))
+	return rsp, nil
+}
+
+// 登录记录转换为rpc响应
+func loginRecordToRsp(record userModel.LoginRecord) *userapi.LoginRecord {
+	return &userapi.LoginRecord{
+		Time:      record.Time.Unix(),
+		Ip:        record.IP,
+		UserAgent: record.UserAgent,
+		Device:    record.Device,
+		NewDevice: record.NewDevice,
+	}
+}
+
+// 审计事件转换为rpc响应
+func auditEventToRsp(event audit.Event) *userapi.AuditEvent {
+	return &userapi.AuditEvent{
+		Id:        event.ID,
+		Time:      event.Time.Unix(),
+		Action:    event.Action,
+		Actor:     event.Actor,
+		Target:    event.Target,
+		Ip:        event.IP,
+		UserAgent: event.UserAgent,
+		RequestId: event.RequestID,
+		Reason:    event.Reason,
+		Detail:    event.Detail,
+		Before:    event.Before,
+		After:     event.After,
+		Outcome:   event.Outcome,
+		Error:     event.Error,
+	}
 }
@@ -2,27 +2,50 @@ package main
 
 import (
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"time"
+	userDao "user_rpc/dao/user"
 	"user_rpc/handler"
+	"user_rpc/pkg/audit"
 	"user_rpc/pkg/config"
 	"user_rpc/pkg/database"
+	"user_rpc/pkg/identity"
+	"user_rpc/pkg/limiter"
 	"user_rpc/pkg/logger"
+	"user_rpc/pkg/mfa"
+	"user_rpc/pkg/notify"
+	"user_rpc/pkg/rbac"
 	"user_rpc/pkg/redis"
-	"user_rpc/proto"
+	"user_rpc/pkg/tlsconfig"
+	userService "user_rpc/service/user"
+	"userapi/v1"
 )
 
 func init() {
-	// 初始化config logger database redis
+	// 初始化config logger database audit redis notify limiter tls identity
 	config.SetupConfig()
 	logger.SetupLogger()
 	database.SetupDatabase()
+	audit.SetupAudit()
 	redis.SetupRedis()
+	notify.SetupNotify()
+	limiter.SetupLimiter()
+	tlsconfig.SetupTLS()
+	identity.SetupIdentity()
+	mfa.SetupMFA()
 }
 
 func destroy() {
-	// 关闭 database redis
+	// 写入剩余的审计事件, 等待发送中的通知, 关闭 database redis
+	audit.Close()
+	notify.Close()
 	database.Close()
 	redis.Close()
 }
@@ -36,9 +59,65 @@ func main() {
 	}
 	logger.Debug("main", "listen success", config.GetString("RPC_SERVER_ADDR"))
 
+	// 调试服务, 通过 /debug/vars 查看并发限制器状态
+	if addr := config.GetString("RPC_DEBUG_ADDR"); len(addr) > 0 {
+		go func() {
+			if err := http.ListenAndServe(addr, nil); err != nil {
+				logger.Error("main", "debug serve err", err)
+			}
+		}()
+	}
+
 	// 注册并启动服务
-	rpcServer := grpc.NewServer()
-	proto.RegisterUserServiceServer(rpcServer, &handler.UserHandler{})
+	unaryInterceptors := []grpc.UnaryServerInterceptor{
+		limiter.UnaryServerInterceptor(limiter.RpcLimiter, handler.MethodPriorities),
+	}
+	streamInterceptors := []grpc.StreamServerInterceptor{
+		limiter.StreamServerInterceptor(limiter.RpcLimiter, handler.MethodPriorities),
+	}
+	serverOptions := []grpc.ServerOption{
+		// 允许客户端按 RPC_KEEPALIVE_MIN_TIME 秒的间隔发送keepalive探测
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             time.Duration(config.GetInt("RPC_KEEPALIVE_MIN_TIME")) * time.Second,
+			PermitWithoutStream: true,
+		}),
+	}
+	// 开启TLS时校验客户端身份, 未授权的请求不占用并发
+	if tlsconfig.RpcTLS != nil {
+		serverOptions = append(serverOptions, grpc.Creds(credentials.NewTLS(tlsconfig.RpcTLS.ServerConfig())))
+		unaryInterceptors = append([]grpc.UnaryServerInterceptor{
+			tlsconfig.UnaryServerInterceptor(handler.PrivilegedMethods, tlsconfig.AllowedClients),
+		}, unaryInterceptors...)
+		streamInterceptors = append([]grpc.StreamServerInterceptor{
+			tlsconfig.StreamServerInterceptor(handler.PrivilegedMethods, tlsconfig.AllowedClients),
+		}, streamInterceptors...)
+	}
+	// 管理接口校验调用方角色的权限
+	service := userService.NewUserService(
+		userDao.NewDBUserRepository(database.DB),
+		userDao.NewRedisTokenStore(redis.Redis),
+		userDao.NewRedisUserCache(redis.Redis),
+		identity.RpcSigner,
+		audit.RpcRecorder,
+	)
+	service.SetDeletionRetention(time.Duration(config.GetInt("ACCOUNT_DELETION_RETENTION")) * time.Second)
+	service.SetAuditQuerier(audit.RpcQuerier)
+	service.SetLoginHistory(userDao.NewRedisLoginHistory(redis.Redis), notify.RpcNotifier)
+	service.SetMFA(mfa.RpcAuthenticator, userDao.NewRedisMFAChallengeStore(redis.Redis),
+		time.Duration(config.GetInt("MFA_CHALLENGE_EXPIRE"))*time.Second)
+	unaryInterceptors = append(unaryInterceptors,
+		rbac.UnaryServerInterceptor(handler.MethodPermissions, service.CallerRole, audit.RpcRecorder))
+	streamInterceptors = append(streamInterceptors,
+		rbac.StreamServerInterceptor(handler.MethodPermissions, service.CallerRole, audit.RpcRecorder))
+	serverOptions = append(serverOptions, grpc.ChainUnaryInterceptor(unaryInterceptors...),
+		grpc.ChainStreamInterceptor(streamInterceptors...))
+	rpcServer := grpc.NewServer(serverOptions...)
+	userHandler := handler.NewUserHandler(service)
+	userapi.RegisterUserServiceServer(rpcServer, userHandler)
+	// 健康检查, user_web 据此剔除不可用的实例
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(handler.HealthServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(rpcServer, healthServer)
 	go func() {
 		if err := rpcServer.Serve(lis); err != nil {
 			logger.Error("main", "rpc serve err", err)
@@ -52,6 +131,7 @@ func main() {
 
 	// 关闭rpc、数据库、缓存连接
 	logger.Info("main", "shutdown server")
+	healthServer.Shutdown()
 	rpcServer.GracefulStop()
 	destroy()
 	logger.Info("main", "server exit")
@@ -30,7 +30,7 @@ func main() {
 	insertData()
 }
 
-// 创建表结构
+// 创建表结构, 与 users.sql 保持一致
 func createTable() {
 	tableTemplate := `
 CREATE TABLE users_0 (
@@ -43,8 +43,16 @@ CREATE TABLE users_0 (
    create_time datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
    update_time datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
    version int unsigned NOT NULL DEFAULT '1',
+   role varchar(16) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT 'user',
+   status tinyint unsigned NOT NULL DEFAULT '0',
+   deleted_at datetime DEFAULT NULL,
+   mfa_secret varchar(128) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT '',
+   mfa_enabled tinyint(1) NOT NULL DEFAULT '0',
+   mfa_recovery_codes varchar(1024) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT '',
+   mfa_last_step bigint NOT NULL DEFAULT '0',
    PRIMARY KEY (id),
-   UNIQUE KEY uniq_username (username)
+   UNIQUE KEY uniq_username (username),
+   KEY idx_status_deleted_at (status, deleted_at)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
 `
 	database.DB.Exec(tableTemplate)
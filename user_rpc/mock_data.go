@@ -63,16 +63,18 @@ func mockUserToken(num int) {
 		panic(err)
 	}
 
+	tokenStore := userDao.NewRedisTokenStore(redis.Redis)
+	userCache := userDao.NewRedisUserCache(redis.Redis)
 	var builder strings.Builder
 	for _, user := range users {
 		// 生成并写入token
 		token = util.GenerateToken(user.Username)
-		if err = userDao.SetTokenCache(token, user.Username); err != nil {
+		if err = tokenStore.Set(token, user.Username); err != nil {
 			panic(err)
 		}
 
 		// 写入user cache
-		if err = userDao.SetUserCache(user.Username, user); err != nil {
+		if err = userCache.Set(user.Username, user); err != nil {
 			panic(err)
 		}
 
@@ -95,16 +97,18 @@ func mockEditUser(num int) {
 		panic(err)
 	}
 
+	tokenStore := userDao.NewRedisTokenStore(redis.Redis)
+	userCache := userDao.NewRedisUserCache(redis.Redis)
 	var builder strings.Builder
 	for _, user := range users {
 		// 生成并写入token
 		token = util.GenerateToken(user.Username)
-		if err = userDao.SetTokenCache(token, user.Username); err != nil {
+		if err = tokenStore.Set(token, user.Username); err != nil {
 			panic(err)
 		}
 
 		// 写入user cache
-		if err = userDao.SetUserCache(user.Username, user); err != nil {
+		if err = userCache.Set(user.Username, user); err != nil {
 			panic(err)
 		}
 
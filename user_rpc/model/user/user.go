@@ -4,15 +4,59 @@ import (
 	"time"
 )
 
-// User 用户模型
+// 用户角色
+const (
+	// RoleUser 普通用户
+	RoleUser = "user"
+	// RoleModerator 内容审核员, 可以查看用户和重置违规昵称
+	RoleModerator = "moderator"
+	// RoleAdmin 管理员, 拥有所有管理权限
+	RoleAdmin = "admin"
+)
+
+// 账号状态, 与 userapi.UserStatus 一致
+const (
+	// StatusActive 正常
+	StatusActive = 0
+	// StatusDisabled 被管理员禁用
+	StatusDisabled = 1
+	// StatusPendingDeletion 用户申请注销, 保留期内可以由管理员恢复
+	StatusPendingDeletion = 2
+	// StatusDeleted 已注销, 个人数据已清理, 用户名保留不能再注册
+	StatusDeleted = 3
+)
+
+// User 用户模型, 密码、盐和两步验证密钥不写入用户信息缓存, 校验凭证时从数据库读取
 type User struct {
-	ID         int64     `gorm:"column:id"`
-	Username   string    `gorm:"column:username"`
-	Password   string    `gorm:"column:password"`
-	Nickname   string    `gorm:"column:nickname"`
-	PicPath    string    `gorm:"column:pic_path"`
-	CreateTime time.Time `gorm:"column:create_time"`
-	UpdateTime time.Time `gorm:"column:update_time"`
-	Salt       string    `gorm:"column:salt"`
-	Version    int       `gorm:"column:version"`
+	ID         int64      `gorm:"column:id"`
+	Username   string     `gorm:"column:username"`
+	Password   string     `gorm:"column:password" json:"-"`
+	Nickname   string     `gorm:"column:nickname"`
+	PicPath    string     `gorm:"column:pic_path"`
+	CreateTime time.Time  `gorm:"column:create_time"`
+	UpdateTime time.Time  `gorm:"column:update_time"`
+	Salt       string     `gorm:"column:salt" json:"-"`
+	Version    int        `gorm:"column:version"`
+	Role       string     `gorm:"column:role"`
+	Status     int        `gorm:"column:status"`
+	DeletedAt  *time.Time `gorm:"column:deleted_at"`
+	// MFASecret 加密后的TOTP密钥, 开始设置但未确认时 MFAEnabled 为false
+	MFASecret  string `gorm:"column:mfa_secret" json:"-"`
+	MFAEnabled bool   `gorm:"column:mfa_enabled"`
+	// MFARecoveryCodes 未使用的恢复码哈希(json数组)
+	MFARecoveryCodes string `gorm:"column:mfa_recovery_codes" json:"-"`
+	// MFALastStep 最近一次验证通过的TOTP时间步, 防止验证码重复使用
+	MFALastStep int64 `gorm:"column:mfa_last_step"`
+}
+
+// LoginRecord 登录记录
+type LoginRecord struct {
+	// Time 登录时间, 精确到分钟
+	Time      time.Time `json:"time"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	// Device 根据User-Agent计算的设备指纹
+	Device string `json:"device"`
+	// NewDevice 是否为首次出现的设备和IP组合
+	NewDevice bool `json:"new_device"`
 }
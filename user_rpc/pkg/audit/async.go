@@ -0,0 +1,103 @@
+package audit
+
+import (
+	"io"
+	"sync"
+	"user_rpc/pkg/logger"
+)
+
+// 每次批量写入的最大事件数
+const asyncBatchSize = 100
+
+// Sink 审计事件的持久化存储, 需要支持并发写入
+type Sink interface {
+	Write(events []Event) error
+}
+
+// AsyncRecorder 在后台批量写入sink, 不阻塞请求
+// 缓冲区满时同步写入, 写入失败时记录到日志, 事件不会丢弃
+type AsyncRecorder struct {
+	sink   Sink
+	events chan Event
+	done   chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewAsyncRecorder 创建异步记录器, bufferSize 为等待写入的事件数上限
+func NewAsyncRecorder(sink Sink, bufferSize int) *AsyncRecorder {
+	r := &AsyncRecorder{
+		sink:   sink,
+		events: make(chan Event, bufferSize),
+		done:   make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+// Record 记录审计事件
+func (r *AsyncRecorder) Record(event Event) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		LogRecorder{}.Record(event)
+		return
+	}
+	select {
+	case r.events <- event:
+	default:
+		logger.Warn("audit", "buffer full, write synchronously", event.Action)
+		r.write([]Event{event})
+	}
+}
+
+// 取出缓冲区中已有的事件批量写入
+func (r *AsyncRecorder) run() {
+	defer close(r.done)
+	for event := range r.events {
+		batch := append(make([]Event, 0, asyncBatchSize), event)
+	drain:
+		for len(batch) < asyncBatchSize {
+			select {
+			case event, ok := <-r.events:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, event)
+			default:
+				break drain
+			}
+		}
+		r.write(batch)
+	}
+}
+
+func (r *AsyncRecorder) write(events []Event) {
+	if err := r.sink.Write(events); err != nil {
+		// 写入失败的事件记录到日志, 避免丢失
+		logger.Error("audit", "write events error", err, "count:", len(events))
+		for _, event := range events {
+			LogRecorder{}.Record(event)
+		}
+	}
+}
+
+// Close 等待缓冲区中的事件写入后关闭sink, 之后记录的事件写入日志
+func (r *AsyncRecorder) Close() {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return
+	}
+	r.closed = true
+	close(r.events)
+	r.mu.Unlock()
+
+	<-r.done
+	if closer, ok := r.sink.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			logger.Error("audit", "close sink error", err)
+		}
+	}
+}
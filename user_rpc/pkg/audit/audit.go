@@ -0,0 +1,190 @@
+// Package audit 审计日志, 记录登录、资料修改和管理操作等安全相关的事件
+//
+// 审计日志只追加不修改, 根据 AUDIT_SINK 写入日志文件、mysql 的 audit_events 表或jsonl文件,
+// mysql和jsonl异步批量写入, 并支持按用户、事件类型和时间范围查询
+package audit
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+	"user_rpc/pkg/config"
+	"user_rpc/pkg/database"
+	"user_rpc/pkg/logger"
+)
+
+// 事件结果
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	OutcomeDenied  = "denied"
+)
+
+// Event 审计事件
+type Event struct {
+	// ID 审计日志中的序号, 按写入顺序递增, 由存储分配
+	ID     int64     `json:"-"`
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	Actor  string    `json:"actor"`
+	Target string    `json:"target,omitempty"`
+	// IP UserAgent 发起请求的客户端, 由 user_web 传递
+	IP        string            `json:"ip,omitempty"`
+	UserAgent string            `json:"user_agent,omitempty"`
+	RequestID string            `json:"request_id"`
+	Reason    string            `json:"reason,omitempty"`
+	Detail    map[string]string `json:"detail,omitempty"`
+	// Before After 修改前后的字段值
+	Before  map[string]string `json:"before,omitempty"`
+	After   map[string]string `json:"after,omitempty"`
+	Outcome string            `json:"outcome"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// Recorder 审计事件记录器
+type Recorder interface {
+	Record(event Event)
+}
+
+// Filter 审计事件的查询条件, 零值表示不限
+type Filter struct {
+	// Username 匹配操作人或被操作人
+	Username string
+	Action   string
+	// Start End 时间范围, 包含Start不包含End
+	Start time.Time
+	End   time.Time
+	// AfterID 只返回序号大于AfterID的事件, 用于分页
+	AfterID int64
+	// Limit 最多返回的数量
+	Limit int
+}
+
+// Full 已查询到 n 个事件时是否达到数量上限, Limit 小于等于0时不限
+func (f Filter) Full(n int) bool {
+	return f.Limit > 0 && n >= f.Limit
+}
+
+// Match 判断事件是否满足查询条件, 不判断序号
+func (f Filter) Match(event Event) bool {
+	if len(f.Username) > 0 && event.Actor != f.Username && event.Target != f.Username {
+		return false
+	}
+	if len(f.Action) > 0 && event.Action != f.Action {
+		return false
+	}
+	if !f.Start.IsZero() && event.Time.Before(f.Start) {
+		return false
+	}
+	if !f.End.IsZero() && !event.Time.Before(f.End) {
+		return false
+	}
+	return true
+}
+
+// Querier 审计事件查询接口, 只写入日志的记录器不支持查询
+type Querier interface {
+	// Query 按序号顺序查询满足条件的事件
+	Query(filter Filter) ([]Event, error)
+}
+
+var (
+	// RpcRecorder 根据配置创建的审计事件记录器
+	RpcRecorder Recorder
+	// RpcQuerier 审计事件查询, 写入日志时为nil
+	RpcQuerier Querier
+)
+
+// 异步写入的记录器, 关闭时写入缓冲区中的事件
+var asyncRecorder *AsyncRecorder
+
+// 异步写入的默认缓冲区大小
+const defaultBufferSize = 1024
+
+// SetupAudit 根据配置 AUDIT_SINK 初始化审计日志
+// log 写入日志文件, mysql 写入 audit_events 表, jsonl 写入 AUDIT_JSONL_FILE; mysql需要在 database.SetupDatabase 之后调用
+func SetupAudit() {
+	var sink Sink
+	switch driver := config.GetString("AUDIT_SINK"); driver {
+	case "", "log":
+		RpcRecorder = LogRecorder{}
+		return
+	case "mysql":
+		sink = NewMySQLSink(database.DB)
+	case "jsonl":
+		jsonlSink, err := NewJSONLSink(config.GetString("AUDIT_JSONL_FILE"))
+		if err != nil {
+			logger.Error("audit", "open jsonl file error", err)
+			panic(err)
+		}
+		sink = jsonlSink
+	default:
+		logger.Error("audit", "unknown audit sink", driver)
+		panic("unknown audit sink: " + driver)
+	}
+
+	bufferSize := config.GetInt("AUDIT_BUFFER_SIZE")
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+	asyncRecorder = NewAsyncRecorder(sink, bufferSize)
+	RpcRecorder = asyncRecorder
+	RpcQuerier, _ = sink.(Querier)
+}
+
+// Close 写入缓冲区中的事件并关闭存储
+func Close() {
+	if asyncRecorder != nil {
+		asyncRecorder.Close()
+	}
+}
+
+// LogRecorder 以json格式写入日志
+type LogRecorder struct{}
+
+// Record 记录审计事件
+func (LogRecorder) Record(event Event) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		logger.Error("audit", "marshal event error", err, event.Action)
+		return
+	}
+	logger.Info("audit", string(data))
+}
+
+// MemoryRecorder 在内存中保存审计事件, 用于测试
+type MemoryRecorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+// Record 记录审计事件
+func (r *MemoryRecorder) Record(event Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	event.ID = int64(len(r.events) + 1)
+	r.events = append(r.events, event)
+}
+
+// Events 返回已记录的审计事件
+func (r *MemoryRecorder) Events() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Event(nil), r.events...)
+}
+
+// Query 查询审计事件
+func (r *MemoryRecorder) Query(filter Filter) ([]Event, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var events []Event
+	for _, event := range r.events {
+		if filter.Full(len(events)) {
+			break
+		}
+		if event.ID > filter.AfterID && filter.Match(event) {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
@@ -0,0 +1,156 @@
+package audit
+
+import (
+	"errors"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+	"user_rpc/pkg/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.Logger = zap.NewNop()
+	os.Exit(m.Run())
+}
+
+// 在内存中保存写入的事件, 可以模拟写入失败
+type memorySink struct {
+	mu     sync.Mutex
+	events []Event
+	err    error
+}
+
+func (s *memorySink) Write(events []Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.events = append(s.events, events...)
+	return nil
+}
+
+func TestFilterMatch(t *testing.T) {
+	now := time.Now()
+	event := Event{Time: now, Action: "user.login", Actor: "alice", Target: "bob"}
+	matched := []Filter{
+		{},
+		{Username: "alice"},
+		{Username: "bob", Action: "user.login"},
+		{Start: now, End: now.Add(time.Second)},
+	}
+	for _, filter := range matched {
+		if !filter.Match(event) {
+			t.Fatalf("filter should match: %+v", filter)
+		}
+	}
+	unmatched := []Filter{
+		{Username: "carol"},
+		{Action: "user.logout"},
+		{Start: now.Add(time.Second)},
+		{End: now},
+	}
+	for _, filter := range unmatched {
+		if filter.Match(event) {
+			t.Fatalf("filter should not match: %+v", filter)
+		}
+	}
+}
+
+func TestMemoryRecorderQuery(t *testing.T) {
+	r := &MemoryRecorder{}
+	for _, action := range []string{"user.login", "user.logout", "user.login"} {
+		r.Record(Event{Action: action, Actor: "alice"})
+	}
+
+	// Limit 为零值时不限数量
+	if events, err := r.Query(Filter{Action: "user.login"}); err != nil || len(events) != 2 {
+		t.Fatalf("query without limit: %+v %v", events, err)
+	}
+	if events, err := r.Query(Filter{Limit: 1}); err != nil || len(events) != 1 {
+		t.Fatalf("query with limit: %+v %v", events, err)
+	}
+}
+
+func TestAsyncRecorder(t *testing.T) {
+	sink := &memorySink{}
+	r := NewAsyncRecorder(sink, 1)
+	for i := 0; i < 50; i++ {
+		r.Record(Event{Action: "user.login"})
+	}
+
+	// 关闭时写入缓冲区中的事件, 之后的事件写入日志
+	r.Close()
+	r.Record(Event{Action: "user.logout"})
+	r.Close()
+	if len(sink.events) != 50 {
+		t.Fatalf("expected 50 events, got %d", len(sink.events))
+	}
+
+	// 写入失败时不阻塞
+	failing := &memorySink{err: errors.New("db down")}
+	r = NewAsyncRecorder(failing, 1)
+	r.Record(Event{Action: "user.login"})
+	r.Close()
+}
+
+func TestJSONLSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit", "audit.jsonl")
+	sink, err := NewJSONLSink(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	now := time.Now()
+	err = sink.Write([]Event{
+		{Time: now, Action: "user.login", Actor: "alice", IP: "10.0.0.1"},
+		{Time: now, Action: "user.login", Actor: "bob"},
+		{Time: now, Action: "user.edit_profile", Actor: "alice", Before: map[string]string{"nickname": "a"},
+			After: map[string]string{"nickname": "b"}},
+	})
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	events, err := sink.Query(Filter{Username: "alice", Limit: 10})
+	if err != nil || len(events) != 2 || events[0].ID != 1 || events[0].IP != "10.0.0.1" ||
+		events[1].ID != 3 || events[1].After["nickname"] != "b" {
+		t.Fatalf("query: %+v %v", events, err)
+	}
+	if events, err = sink.Query(Filter{AfterID: 1, Limit: 1}); err != nil || len(events) != 1 || events[0].ID != 2 {
+		t.Fatalf("query after id: %+v %v", events, err)
+	}
+	if events, err = sink.Query(Filter{}); err != nil || len(events) != 3 {
+		t.Fatalf("query without limit: %+v %v", events, err)
+	}
+	if err = sink.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	// 重新打开后继续编号, 不完整的行被补齐
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatalf("open file: %v", err)
+	}
+	_, _ = f.WriteString(`{"action":"user.lo`)
+	f.Close()
+	sink, err = NewJSONLSink(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer sink.Close()
+	if err = sink.Write([]Event{{Time: now, Action: "user.logout", Actor: "alice"}}); err != nil {
+		t.Fatalf("write after reopen: %v", err)
+	}
+	events, err = sink.Query(Filter{Action: "user.logout", Limit: 10})
+	if err != nil || len(events) != 1 || events[0].ID != 5 {
+		t.Fatalf("query after reopen: %+v %v", events, err)
+	}
+	data, _ := ioutil.ReadFile(path)
+	if data[len(data)-1] != '\n' {
+		t.Fatalf("file should end with newline")
+	}
+}
@@ -0,0 +1,118 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"user_rpc/pkg/logger"
+)
+
+// JSONLSink 追加写入jsonl文件, 每行一个事件, 以行号作为序号
+// 查询时顺序扫描文件, 适用于单实例和数据量较小的场景; 文件不能被截断或轮转
+type JSONLSink struct {
+	mu    sync.Mutex
+	path  string
+	file  *os.File
+	lines int64
+}
+
+// NewJSONLSink 打开或创建jsonl文件
+func NewJSONLSink(path string) (*JSONLSink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	// 统计已有的行数
+	s := &JSONLSink{path: path, file: file}
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// 上次写入中断留下的不完整行补上换行
+			if len(line) > 0 {
+				s.lines++
+				if _, err = file.Write([]byte("\n")); err != nil {
+					file.Close()
+					return nil, err
+				}
+			}
+			break
+		}
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		s.lines++
+	}
+	return s, nil
+}
+
+// Write 追加写入审计事件并同步到磁盘
+func (s *JSONLSink) Write(events []Event) error {
+	var buf bytes.Buffer
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	s.lines += int64(len(events))
+	return s.file.Sync()
+}
+
+// Query 顺序扫描文件查询审计事件
+func (s *JSONLSink) Query(filter Filter) ([]Event, error) {
+	// 查询期间不写入, 避免读到不完整的行
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	file, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var events []Event
+	reader := bufio.NewReader(file)
+	for id := int64(1); id <= s.lines && !filter.Full(len(events)); id++ {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return nil, err
+		}
+		if id <= filter.AfterID {
+			continue
+		}
+		var event Event
+		if err = json.Unmarshal(line, &event); err != nil {
+			logger.Warn("audit", "skip invalid line", id, err)
+			continue
+		}
+		event.ID = id
+		if filter.Match(event) {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// Close 关闭文件
+func (s *JSONLSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
@@ -0,0 +1,138 @@
+package audit
+
+import (
+	"encoding/json"
+	"gorm.io/gorm"
+	"time"
+)
+
+// 审计事件表中各字段的最大长度, 超出部分截断
+const (
+	maxShortColumn = 64
+	maxLongColumn  = 255
+)
+
+// 审计事件表的记录, 结构见 users.sql 中的 audit_events
+type eventRecord struct {
+	ID         int64     `gorm:"column:id"`
+	CreateTime time.Time `gorm:"column:create_time"`
+	Action     string    `gorm:"column:action"`
+	Actor      string    `gorm:"column:actor"`
+	Target     string    `gorm:"column:target"`
+	IP         string    `gorm:"column:ip"`
+	UserAgent  string    `gorm:"column:user_agent"`
+	RequestID  string    `gorm:"column:request_id"`
+	Reason     string    `gorm:"column:reason"`
+	Detail     string    `gorm:"column:detail"`
+	Before     string    `gorm:"column:before_values"`
+	After      string    `gorm:"column:after_values"`
+	Outcome    string    `gorm:"column:outcome"`
+	Error      string    `gorm:"column:error"`
+}
+
+// TableName 审计事件表名
+func (eventRecord) TableName() string {
+	return "audit_events"
+}
+
+// MySQLSink 写入mysql的 audit_events 表, 以自增ID作为序号
+type MySQLSink struct {
+	DB *gorm.DB
+}
+
+// NewMySQLSink 创建mysql审计事件存储
+func NewMySQLSink(db *gorm.DB) *MySQLSink {
+	return &MySQLSink{DB: db}
+}
+
+// Write 批量写入审计事件
+func (s *MySQLSink) Write(events []Event) error {
+	records := make([]eventRecord, 0, len(events))
+	for _, event := range events {
+		records = append(records, eventRecord{
+			CreateTime: event.Time,
+			Action:     truncate(event.Action, maxShortColumn),
+			Actor:      truncate(event.Actor, maxShortColumn),
+			Target:     truncate(event.Target, maxShortColumn),
+			IP:         truncate(event.IP, maxShortColumn),
+			UserAgent:  truncate(event.UserAgent, maxLongColumn),
+			RequestID:  truncate(event.RequestID, maxShortColumn),
+			Reason:     truncate(event.Reason, maxLongColumn),
+			Detail:     encodeValues(event.Detail),
+			Before:     encodeValues(event.Before),
+			After:      encodeValues(event.After),
+			Outcome:    event.Outcome,
+			Error:      truncate(event.Error, maxLongColumn),
+		})
+	}
+	return s.DB.Create(&records).Error
+}
+
+// Query 按ID顺序查询审计事件, 按用户查询使用 actor 和 target 上的索引
+func (s *MySQLSink) Query(filter Filter) ([]Event, error) {
+	db := s.DB.Model(&eventRecord{}).Where("id > ?", filter.AfterID)
+	if len(filter.Username) > 0 {
+		db = db.Where("(actor = ? OR target = ?)", filter.Username, filter.Username)
+	}
+	if len(filter.Action) > 0 {
+		db = db.Where("action = ?", filter.Action)
+	}
+	if !filter.Start.IsZero() {
+		db = db.Where("create_time >= ?", filter.Start)
+	}
+	if !filter.End.IsZero() {
+		db = db.Where("create_time < ?", filter.End)
+	}
+
+	var records []eventRecord
+	if err := db.Order("id").Limit(filter.Limit).Find(&records).Error; err != nil {
+		return nil, err
+	}
+	events := make([]Event, 0, len(records))
+	for _, record := range records {
+		events = append(events, Event{
+			ID:        record.ID,
+			Time:      record.CreateTime,
+			Action:    record.Action,
+			Actor:     record.Actor,
+			Target:    record.Target,
+			IP:        record.IP,
+			UserAgent: record.UserAgent,
+			RequestID: record.RequestID,
+			Reason:    record.Reason,
+			Detail:    decodeValues(record.Detail),
+			Before:    decodeValues(record.Before),
+			After:     decodeValues(record.After),
+			Outcome:   record.Outcome,
+			Error:     record.Error,
+		})
+	}
+	return events, nil
+}
+
+// 字段值以json保存, 为空时保存空字符串
+func encodeValues(values map[string]string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(values)
+	return string(data)
+}
+
+func decodeValues(data string) map[string]string {
+	if len(data) == 0 {
+		return nil
+	}
+	var values map[string]string
+	_ = json.Unmarshal([]byte(data), &values)
+	return values
+}
+
+// 按字符截断
+func truncate(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max])
+}
@@ -0,0 +1,115 @@
+package identity
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"google.golang.org/grpc/metadata"
+	"strconv"
+	"strings"
+	"time"
+	"user_rpc/pkg/config"
+	"user_rpc/pkg/logger"
+)
+
+// MetadataKey 身份凭证在metadata中的key
+const MetadataKey = "x-identity"
+
+var (
+	// ErrInvalid 身份凭证格式或签名错误
+	ErrInvalid = errors.New("identity: invalid assertion")
+	// ErrExpired 身份凭证已过期
+	ErrExpired = errors.New("identity: assertion expired")
+)
+
+// RpcSigner 签发和校验身份凭证
+var RpcSigner *Signer
+
+// Signer 使用HMAC-SHA256签发和校验身份凭证
+// 凭证格式为 base64url(用户名).过期时间.base64url(签名), 只有持有密钥的 user_rpc 可以签发
+type Signer struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+// NewSigner 创建身份凭证签发器, ttl 为凭证有效期
+func NewSigner(secret []byte, ttl time.Duration) *Signer {
+	return &Signer{secret: secret, ttl: ttl}
+}
+
+// ErrSecretRequired 未配置 RPC_IDENTITY_SECRET 且不是单实例部署
+var ErrSecretRequired = errors.New("identity: RPC_IDENTITY_SECRET is required unless RPC_SINGLE_INSTANCE=true")
+
+// SetupIdentity 根据配置初始化 RpcSigner
+// 多个实例需要配置相同的 RPC_IDENTITY_SECRET; 未配置时启动失败,
+// 只有显式配置 RPC_SINGLE_INSTANCE=true (单实例或开发环境) 时使用随机密钥
+func SetupIdentity() {
+	secret := []byte(config.GetString("RPC_IDENTITY_SECRET"))
+	if len(secret) == 0 {
+		if !config.GetBool("RPC_SINGLE_INSTANCE") {
+			logger.Error("identity", "setup identity error", ErrSecretRequired)
+			panic(ErrSecretRequired)
+		}
+		logger.Warn("identity", "RPC_IDENTITY_SECRET is empty, use a random secret for single instance")
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			logger.Error("identity", "generate secret error", err)
+			panic(err)
+		}
+	}
+	ttl := time.Duration(config.GetInt("RPC_IDENTITY_TTL")) * time.Second
+	if ttl <= 0 {
+		ttl = time.Minute
+	}
+	RpcSigner = NewSigner(secret, ttl)
+}
+
+func (s *Signer) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Sign 签发用户的身份凭证, 返回凭证和过期时间
+func (s *Signer) Sign(username string, now time.Time) (string, time.Time) {
+	expire := now.Add(s.ttl)
+	payload := base64.RawURLEncoding.EncodeToString([]byte(username)) + "." + strconv.FormatInt(expire.Unix(), 10)
+	return payload + "." + s.sign(payload), expire
+}
+
+// Verify 校验身份凭证, 返回用户名
+func (s *Signer) Verify(assertion string, now time.Time) (string, error) {
+	parts := strings.Split(assertion, ".")
+	if len(parts) != 3 {
+		return "", ErrInvalid
+	}
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(s.sign(payload))) {
+		return "", ErrInvalid
+	}
+	expire, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", ErrInvalid
+	}
+	if now.Unix() >= expire {
+		return "", ErrExpired
+	}
+	username, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || len(username) == 0 {
+		return "", ErrInvalid
+	}
+	return string(username), nil
+}
+
+// FromContext 读取并校验metadata中的身份凭证, 没有凭证时返回空用户名
+func (s *Signer) FromContext(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(MetadataKey)
+	if len(values) == 0 {
+		return "", nil
+	}
+	return s.Verify(values[0], time.Now())
+}
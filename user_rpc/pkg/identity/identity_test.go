@@ -0,0 +1,78 @@
+package identity
+
+import (
+	"context"
+	viperLib "github.com/spf13/viper"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/metadata"
+	"testing"
+	"time"
+	"user_rpc/pkg/config"
+	"user_rpc/pkg/logger"
+)
+
+func TestSignVerify(t *testing.T) {
+	s := NewSigner([]byte("secret"), time.Minute)
+	now := time.Unix(1700000000, 0)
+
+	assertion, expire := s.Sign("alice", now)
+	if expire != now.Add(time.Minute) {
+		t.Fatalf("unexpected expire: %v", expire)
+	}
+	if username, err := s.Verify(assertion, now); err != nil || username != "alice" {
+		t.Fatalf("verify: %q %v", username, err)
+	}
+
+	// 过期、篡改、其他密钥签发的凭证
+	if _, err := s.Verify(assertion, expire); err != ErrExpired {
+		t.Fatalf("expected ErrExpired, got %v", err)
+	}
+	forged, _ := NewSigner([]byte("other"), time.Minute).Sign("alice", now)
+	for _, a := range []string{"", "a.b", assertion + "x", forged, "Ym9i" + assertion[len("YWxpY2U"):]} {
+		if _, err := s.Verify(a, now); err != ErrInvalid {
+			t.Errorf("Verify(%q): expected ErrInvalid, got %v", a, err)
+		}
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	s := NewSigner([]byte("secret"), time.Minute)
+
+	if username, err := s.FromContext(context.Background()); err != nil || len(username) > 0 {
+		t.Fatalf("no identity: %q %v", username, err)
+	}
+	assertion, _ := s.Sign("alice", time.Now())
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(MetadataKey, assertion))
+	if username, err := s.FromContext(ctx); err != nil || username != "alice" {
+		t.Fatalf("with identity: %q %v", username, err)
+	}
+}
+
+func TestSetupIdentity(t *testing.T) {
+	logger.Logger = zap.NewNop()
+	config.Config = viperLib.New()
+
+	// 未配置密钥时启动失败
+	func() {
+		defer func() {
+			if r := recover(); r != ErrSecretRequired {
+				t.Fatalf("expected ErrSecretRequired panic, got %v", r)
+			}
+		}()
+		SetupIdentity()
+	}()
+
+	// 单实例使用随机密钥
+	config.Config.Set("RPC_SINGLE_INSTANCE", true)
+	SetupIdentity()
+	if RpcSigner == nil || len(RpcSigner.secret) != 32 || RpcSigner.ttl != time.Minute {
+		t.Fatalf("unexpected signer: %+v", RpcSigner)
+	}
+
+	config.Config.Set("RPC_SINGLE_INSTANCE", false)
+	config.Config.Set("RPC_IDENTITY_SECRET", "secret")
+	SetupIdentity()
+	if string(RpcSigner.secret) != "secret" {
+		t.Fatalf("unexpected signer: %+v", RpcSigner)
+	}
+}
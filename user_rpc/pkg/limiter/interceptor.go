@@ -0,0 +1,90 @@
+package limiter
+
+import (
+	"context"
+	"expvar"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"time"
+	"user_rpc/pkg/config"
+	"user_rpc/pkg/logger"
+)
+
+// RpcLimiter 全局rpc并发限制器
+var RpcLimiter *Limiter
+
+// SetupLimiter 根据配置初始化并发限制器, 并通过expvar导出状态
+func SetupLimiter() {
+	RpcLimiter = NewLimiter(Options{
+		InitialLimit:     config.GetInt("LIMITER_INITIAL_LIMIT"),
+		MinLimit:         config.GetInt("LIMITER_MIN_LIMIT"),
+		MaxLimit:         config.GetInt("LIMITER_MAX_LIMIT"),
+		LatencyThreshold: time.Duration(config.GetInt("LIMITER_LATENCY_THRESHOLD")) * time.Millisecond,
+		BackoffRatio:     config.GetFloat64("LIMITER_BACKOFF_RATIO"),
+	})
+	expvar.Publish("rpc_limiter", expvar.Func(func() interface{} {
+		return RpcLimiter.Stats()
+	}))
+	logger.Debug("limiter", "setup success", RpcLimiter.Stats())
+}
+
+// 获取方法的优先级, 未配置的方法为普通优先级
+func priorityOf(priorities map[string]Priority, method string) Priority {
+	if p, ok := priorities[method]; ok {
+		return p
+	}
+	return PriorityNormal
+}
+
+// 健康检查不受并发限制, 否则过载时客户端会把实例标记为不可用
+func isExempt(method string) bool {
+	return strings.HasPrefix(method, "/grpc.health.v1.Health/")
+}
+
+// 超时或服务不可用说明已经过载
+func isDropped(err error) bool {
+	switch status.Code(err) {
+	case codes.DeadlineExceeded, codes.Unavailable:
+		return true
+	}
+	return false
+}
+
+// UnaryServerInterceptor 一元rpc并发限制拦截器, 超过限制时返回 ResourceExhausted
+func UnaryServerInterceptor(l *Limiter, priorities map[string]Priority) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if isExempt(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		priority := priorityOf(priorities, info.FullMethod)
+		token, ok := l.Acquire(priority)
+		if !ok {
+			logger.Warn("limiter", "reject", info.FullMethod, "priority:", priority.String())
+			return nil, status.Error(codes.ResourceExhausted, "server overloaded")
+		}
+
+		start := time.Now()
+		rsp, err := handler(ctx, req)
+		token.Done(time.Since(start), isDropped(err))
+		return rsp, err
+	}
+}
+
+// StreamServerInterceptor 流式rpc并发限制拦截器, 流式请求只占用并发, 不参与上限调整
+func StreamServerInterceptor(l *Limiter, priorities map[string]Priority) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if isExempt(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		priority := priorityOf(priorities, info.FullMethod)
+		token, ok := l.Acquire(priority)
+		if !ok {
+			logger.Warn("limiter", "reject", info.FullMethod, "priority:", priority.String())
+			return status.Error(codes.ResourceExhausted, "server overloaded")
+		}
+		defer token.Ignore()
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,170 @@
+// Package limiter rpc自适应并发限制
+//
+// 使用AIMD算法调整并发上限: 请求耗时超过阈值或超时时按比例降低上限, 否则在并发接近上限时加一;
+// 不同优先级的请求只能使用上限的一部分, 并发升高时低优先级的请求先被拒绝
+package limiter
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Priority 请求优先级
+type Priority int
+
+const (
+	// PriorityLow 低优先级, 最先被拒绝
+	PriorityLow Priority = iota
+	// PriorityNormal 普通优先级
+	PriorityNormal
+	// PriorityHigh 高优先级
+	PriorityHigh
+	// PriorityCritical 最高优先级, 可以使用全部并发
+	PriorityCritical
+)
+
+// 各优先级可以使用的并发比例
+var priorityShares = map[Priority]float64{
+	PriorityLow:      0.5,
+	PriorityNormal:   0.7,
+	PriorityHigh:     0.9,
+	PriorityCritical: 1,
+}
+
+// String 优先级名称
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	case PriorityCritical:
+		return "critical"
+	}
+	return "unknown"
+}
+
+// Options 并发限制配置
+type Options struct {
+	// InitialLimit 初始并发上限
+	InitialLimit int
+	// MinLimit 并发上限的最小值
+	MinLimit int
+	// MaxLimit 并发上限的最大值, 应小于数据库连接池大小
+	MaxLimit int
+	// LatencyThreshold 请求耗时阈值, 超过时降低并发上限
+	LatencyThreshold time.Duration
+	// BackoffRatio 降低并发上限的比例
+	BackoffRatio float64
+}
+
+// Limiter 自适应并发限制器
+type Limiter struct {
+	mu       sync.Mutex
+	opts     Options
+	limit    float64
+	inflight int
+	accepted map[Priority]int64
+	rejected map[Priority]int64
+}
+
+// NewLimiter 创建并发限制器
+func NewLimiter(opts Options) *Limiter {
+	if opts.MinLimit <= 0 {
+		opts.MinLimit = 1
+	}
+	if opts.MaxLimit < opts.MinLimit {
+		opts.MaxLimit = opts.MinLimit
+	}
+	if opts.InitialLimit < opts.MinLimit || opts.InitialLimit > opts.MaxLimit {
+		opts.InitialLimit = opts.MaxLimit
+	}
+	if opts.BackoffRatio <= 0 || opts.BackoffRatio >= 1 {
+		opts.BackoffRatio = 0.9
+	}
+	return &Limiter{
+		opts:     opts,
+		limit:    float64(opts.InitialLimit),
+		accepted: make(map[Priority]int64),
+		rejected: make(map[Priority]int64),
+	}
+}
+
+// Token 已获得的执行许可, 请求结束后必须调用 Done 或 Ignore
+type Token struct {
+	limiter  *Limiter
+	inflight int
+}
+
+// Acquire 申请执行许可, 当前并发超过该优先级可用的上限时拒绝
+func (l *Limiter) Acquire(priority Priority) (*Token, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	share, ok := priorityShares[priority]
+	if !ok {
+		share = priorityShares[PriorityNormal]
+	}
+	if float64(l.inflight) >= math.Max(1, math.Floor(l.limit*share)) {
+		l.rejected[priority]++
+		return nil, false
+	}
+	l.inflight++
+	l.accepted[priority]++
+	return &Token{limiter: l, inflight: l.inflight}, true
+}
+
+// Done 请求结束, 根据耗时和是否超时调整并发上限
+func (t *Token) Done(latency time.Duration, dropped bool) {
+	l := t.limiter
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inflight--
+	if dropped || latency > l.opts.LatencyThreshold {
+		l.limit = math.Max(float64(l.opts.MinLimit), l.limit*l.opts.BackoffRatio)
+		return
+	}
+	// 并发没有用到一半时说明上限不是瓶颈, 不需要增加
+	if float64(t.inflight)*2 >= l.limit {
+		l.limit = math.Min(float64(l.opts.MaxLimit), l.limit+1)
+	}
+}
+
+// Ignore 请求结束, 不参与并发上限调整, 用于耗时与负载无关的流式请求
+func (t *Token) Ignore() {
+	t.limiter.mu.Lock()
+	t.limiter.inflight--
+	t.limiter.mu.Unlock()
+}
+
+// Stats 限制器状态
+type Stats struct {
+	Limit    int              `json:"limit"`
+	Inflight int              `json:"inflight"`
+	Accepted map[string]int64 `json:"accepted"`
+	Rejected map[string]int64 `json:"rejected"`
+}
+
+// Stats 获取限制器状态
+func (l *Limiter) Stats() Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	stats := Stats{
+		Limit:    int(l.limit),
+		Inflight: l.inflight,
+		Accepted: make(map[string]int64, len(l.accepted)),
+		Rejected: make(map[string]int64, len(l.rejected)),
+	}
+	for p, n := range l.accepted {
+		stats.Accepted[p.String()] = n
+	}
+	for p, n := range l.rejected {
+		stats.Rejected[p.String()] = n
+	}
+	return stats
+}
@@ -0,0 +1,125 @@
+package limiter
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"os"
+	"testing"
+	"time"
+	"user_rpc/pkg/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.Logger = zap.NewNop()
+	os.Exit(m.Run())
+}
+
+func newTestLimiter() *Limiter {
+	return NewLimiter(Options{
+		InitialLimit:     10,
+		MinLimit:         2,
+		MaxLimit:         20,
+		LatencyThreshold: 100 * time.Millisecond,
+		BackoffRatio:     0.5,
+	})
+}
+
+// 一直申请直到被拒绝, 返回获得的许可
+func fill(l *Limiter, priority Priority) []*Token {
+	var tokens []*Token
+	for {
+		token, ok := l.Acquire(priority)
+		if !ok {
+			return tokens
+		}
+		tokens = append(tokens, token)
+	}
+}
+
+func TestPriorityShedding(t *testing.T) {
+	l := newTestLimiter()
+
+	// 低优先级最多使用一半的并发, 之后只有更高优先级的请求可以执行
+	if n := len(fill(l, PriorityLow)); n != 5 {
+		t.Fatalf("low priority: expected 5 tokens, got %d", n)
+	}
+	if n := len(fill(l, PriorityNormal)); n != 2 {
+		t.Fatalf("normal priority: expected 2 tokens, got %d", n)
+	}
+	if n := len(fill(l, PriorityCritical)); n != 3 {
+		t.Fatalf("critical priority: expected 3 tokens, got %d", n)
+	}
+
+	stats := l.Stats()
+	if stats.Inflight != 10 || stats.Accepted["low"] != 5 || stats.Rejected["low"] != 1 || stats.Rejected["critical"] != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestAIMD(t *testing.T) {
+	l := newTestLimiter()
+
+	// 慢请求按比例降低上限, 不低于最小值
+	tokens := fill(l, PriorityCritical)
+	tokens[0].Done(time.Second, false)
+	if l.Stats().Limit != 5 {
+		t.Fatalf("expected limit 5 after slow request, got %d", l.Stats().Limit)
+	}
+	tokens[1].Done(0, true)
+	tokens[2].Done(0, true)
+	if l.Stats().Limit != 2 {
+		t.Fatalf("expected min limit 2, got %d", l.Stats().Limit)
+	}
+	for _, token := range tokens[3:] {
+		token.Ignore()
+	}
+
+	// 并发接近上限的快请求逐步增加上限
+	for i := 0; i < 30; i++ {
+		for _, token := range fill(l, PriorityCritical) {
+			token.Done(time.Millisecond, false)
+		}
+	}
+	if stats := l.Stats(); stats.Limit != 20 || stats.Inflight != 0 {
+		t.Fatalf("expected max limit 20 and no inflight, got %+v", stats)
+	}
+
+	// 并发很低时不增加上限
+	l = newTestLimiter()
+	token, _ := l.Acquire(PriorityNormal)
+	token.Done(time.Millisecond, false)
+	if l.Stats().Limit != 10 {
+		t.Fatalf("limit should not grow when underused, got %d", l.Stats().Limit)
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	l := NewLimiter(Options{InitialLimit: 2, MinLimit: 2, MaxLimit: 2, LatencyThreshold: time.Second})
+	interceptor := UnaryServerInterceptor(l, map[string]Priority{"/test/Critical": PriorityCritical})
+	tokens := fill(l, PriorityNormal)
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+	_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test/Normal"}, handler)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted, got %v", err)
+	}
+	rsp, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test/Critical"}, handler)
+	if err != nil || rsp != "ok" {
+		t.Fatalf("critical request should pass: %v %v", rsp, err)
+	}
+	if l.Stats().Inflight != len(tokens) {
+		t.Fatalf("interceptor should release its token")
+	}
+
+	// 健康检查不受限制
+	fill(l, PriorityCritical)
+	rsp, err = interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/grpc.health.v1.Health/Check"}, handler)
+	if err != nil || rsp != "ok" {
+		t.Fatalf("health check should bypass the limiter: %v %v", rsp, err)
+	}
+}
@@ -0,0 +1,176 @@
+// Package mfa 基于TOTP的两步验证
+//
+// TOTP密钥使用 MFA_SECRET_KEY 派生的密钥以AES-GCM加密后保存在用户表中, 恢复码只保存哈希;
+// 未配置 MFA_SECRET_KEY 时不能开启两步验证, 修改密钥后已开启两步验证的用户无法通过验证
+package mfa
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"user_rpc/pkg/config"
+	"user_rpc/pkg/logger"
+)
+
+// RecoveryCodeCount 开启两步验证时生成的恢复码数量
+const RecoveryCodeCount = 10
+
+// ErrInvalidSecret 加密的密钥格式错误或无法解密
+var ErrInvalidSecret = errors.New("mfa: invalid sealed secret")
+
+// RpcAuthenticator 根据配置创建的两步验证器, 未配置 MFA_SECRET_KEY 时为nil
+var RpcAuthenticator *Authenticator
+
+// Authenticator 生成和加解密TOTP密钥
+type Authenticator struct {
+	issuer string
+	aead   cipher.AEAD
+}
+
+// NewAuthenticator 创建两步验证器, issuer 为验证器应用中显示的服务名, key 用于加密TOTP密钥
+func NewAuthenticator(issuer string, key []byte) (*Authenticator, error) {
+	sum := sha256.Sum256(key)
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Authenticator{issuer: issuer, aead: aead}, nil
+}
+
+// SetupMFA 根据配置初始化 RpcAuthenticator, 多个实例需要配置相同的 MFA_SECRET_KEY
+func SetupMFA() {
+	key := config.GetString("MFA_SECRET_KEY")
+	if len(key) == 0 {
+		logger.Warn("mfa", "MFA_SECRET_KEY is empty, two-factor authentication disabled")
+		return
+	}
+	issuer := config.GetString("MFA_ISSUER")
+	if len(issuer) == 0 {
+		issuer = "user_system"
+	}
+	authenticator, err := NewAuthenticator(issuer, []byte(key))
+	if err != nil {
+		logger.Error("mfa", "create authenticator error", err)
+		panic(err)
+	}
+	RpcAuthenticator = authenticator
+}
+
+// NewSecret 生成新的TOTP密钥, 返回base32编码的密钥和加密后用于保存的密钥
+func (a *Authenticator) NewSecret() (secret string, sealed string, err error) {
+	key := make([]byte, 20)
+	if _, err = rand.Read(key); err != nil {
+		return "", "", err
+	}
+	secret = secretEncoding.EncodeToString(key)
+
+	nonce := make([]byte, a.aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", "", err
+	}
+	data := a.aead.Seal(nonce, nonce, []byte(secret), nil)
+	return secret, base64.RawStdEncoding.EncodeToString(data), nil
+}
+
+// Open 解密保存的TOTP密钥
+func (a *Authenticator) Open(sealed string) (string, error) {
+	data, err := base64.RawStdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < a.aead.NonceSize() {
+		return "", ErrInvalidSecret
+	}
+	nonce, ciphertext := data[:a.aead.NonceSize()], data[a.aead.NonceSize():]
+	secret, err := a.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", ErrInvalidSecret
+	}
+	return string(secret), nil
+}
+
+// ProvisioningURI 生成用户的 otpauth:// 配置地址
+func (a *Authenticator) ProvisioningURI(username string, secret string) string {
+	return ProvisioningURI(a.issuer, username, secret)
+}
+
+// IsTOTPCode 判断输入是否为TOTP验证码, 否则按恢复码处理
+func IsTOTPCode(code string) bool {
+	if len(code) != Digits {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// 规范化恢复码, 忽略大小写、空格和连字符
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToUpper(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewChallenge 生成两步验证挑战, 32字节随机数的base64url编码, 不包含用户名等可预测的内容
+func NewChallenge() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// NewRecoveryCodes 生成一次性恢复码, 返回恢复码和用于保存的哈希列表(json数组)
+// 恢复码为10位base32字符, 以 XXXXX-XXXXX 的格式展示
+func NewRecoveryCodes() (codes []string, hashes string, err error) {
+	hashList := make([]string, 0, RecoveryCodeCount)
+	for i := 0; i < RecoveryCodeCount; i++ {
+		buf := make([]byte, 7)
+		if _, err = rand.Read(buf); err != nil {
+			return nil, "", err
+		}
+		code := secretEncoding.EncodeToString(buf)[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashList = append(hashList, hashRecoveryCode(code))
+	}
+	data, err := json.Marshal(hashList)
+	if err != nil {
+		return nil, "", err
+	}
+	return codes, string(data), nil
+}
+
+// UseRecoveryCode 校验恢复码, 通过时返回去掉该恢复码后的哈希列表
+func UseRecoveryCode(hashes string, code string) (remaining string, ok bool) {
+	var hashList []string
+	if len(hashes) == 0 || json.Unmarshal([]byte(hashes), &hashList) != nil {
+		return hashes, false
+	}
+	hash := hashRecoveryCode(code)
+	for i, h := range hashList {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			hashList = append(hashList[:i], hashList[i+1:]...)
+			data, err := json.Marshal(hashList)
+			if err != nil {
+				return hashes, false
+			}
+			return string(data), true
+		}
+	}
+	return hashes, false
+}
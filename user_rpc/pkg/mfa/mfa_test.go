@@ -0,0 +1,111 @@
+package mfa
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHOTPVectors(t *testing.T) {
+	// RFC 6238 附录B中SHA1的测试向量
+	key := []byte("12345678901234567890")
+	vectors := map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	}
+	for unix, expected := range vectors {
+		if code := hotp(key, uint64(Step(time.Unix(unix, 0))), 8); code != expected {
+			t.Fatalf("time %d: expected %s, got %s", unix, expected, code)
+		}
+	}
+}
+
+func TestValidateCode(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111111, 0)
+	code, err := Code(secret, now)
+	if err != nil || len(code) != Digits {
+		t.Fatalf("code: %q %v", code, err)
+	}
+
+	// 允许前后一个时间步的偏差, 同一个时间步不能重复使用
+	step, ok := ValidateCode(secret, code, now.Add(Period), 0)
+	if !ok || step != Step(now) {
+		t.Fatalf("validate with skew: %d %v", step, ok)
+	}
+	if _, ok = ValidateCode(secret, code, now, step); ok {
+		t.Fatalf("code should not be reused")
+	}
+	if _, ok = ValidateCode(secret, code, now.Add(2*Period), 0); ok {
+		t.Fatalf("expired code should be rejected")
+	}
+	if _, ok = ValidateCode(strings.ToLower(secret), code, now, 0); !ok {
+		t.Fatalf("lowercase secret should be accepted")
+	}
+}
+
+func TestAuthenticator(t *testing.T) {
+	a, err := NewAuthenticator("user_system", []byte("key"))
+	if err != nil {
+		t.Fatalf("new authenticator: %v", err)
+	}
+	secret, sealed, err := a.NewSecret()
+	if err != nil || strings.Contains(sealed, secret) {
+		t.Fatalf("new secret: %q %q %v", secret, sealed, err)
+	}
+	if opened, err := a.Open(sealed); err != nil || opened != secret {
+		t.Fatalf("open: %q %v", opened, err)
+	}
+
+	// 其他密钥不能解密
+	other, _ := NewAuthenticator("user_system", []byte("other"))
+	if _, err = other.Open(sealed); err != ErrInvalidSecret {
+		t.Fatalf("open with other key: %v", err)
+	}
+
+	uri := a.ProvisioningURI("alice", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/user_system:alice?") || !strings.Contains(uri, "secret="+secret) {
+		t.Fatalf("unexpected uri: %s", uri)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := NewRecoveryCodes()
+	if err != nil || len(codes) != RecoveryCodeCount || strings.Contains(hashes, codes[0][:5]) {
+		t.Fatalf("new recovery codes: %v %s %v", codes, hashes, err)
+	}
+	if IsTOTPCode(codes[0]) || !IsTOTPCode("012345") {
+		t.Fatalf("unexpected code type")
+	}
+
+	// 恢复码只能使用一次, 忽略大小写和连字符
+	remaining, ok := UseRecoveryCode(hashes, strings.ToLower(strings.ReplaceAll(codes[0], "-", "")))
+	if !ok {
+		t.Fatalf("recovery code should be accepted")
+	}
+	if _, ok = UseRecoveryCode(remaining, codes[0]); ok {
+		t.Fatalf("recovery code should not be reused")
+	}
+	if _, ok = UseRecoveryCode(remaining, codes[1]); !ok {
+		t.Fatalf("other recovery codes should still work")
+	}
+	if _, ok = UseRecoveryCode("", codes[1]); ok {
+		t.Fatalf("empty hashes should reject")
+	}
+}
+
+func TestNewChallenge(t *testing.T) {
+	a, err := NewChallenge()
+	if err != nil {
+		t.Fatalf("new challenge: %v", err)
+	}
+	b, _ := NewChallenge()
+	if len(a) != 43 || a == b || strings.ContainsAny(a, "+/=") {
+		t.Fatalf("unexpected challenges: %q %q", a, b)
+	}
+}
@@ -0,0 +1,172 @@
+// Package notify 用户通知, 目前用于新设备登录提醒
+//
+// 根据 NOTIFY_SINK 写入日志文件, 或以json的形式POST到 NOTIFY_WEBHOOK_URL, 由外部服务发送邮件或短信;
+// 通知不影响业务流程, 发送失败只记录日志
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+	"user_rpc/pkg/config"
+	"user_rpc/pkg/logger"
+)
+
+// 通知类型
+const (
+	// KindNewDevice 新设备或新IP登录
+	KindNewDevice = "new_device"
+)
+
+// Notification 发送给用户的通知
+type Notification struct {
+	Kind      string            `json:"kind"`
+	Username  string            `json:"username"`
+	Time      time.Time         `json:"time"`
+	RequestID string            `json:"request_id"`
+	Detail    map[string]string `json:"detail,omitempty"`
+}
+
+// Notifier 通知发送接口, 实现不能阻塞调用方
+type Notifier interface {
+	Notify(notification Notification)
+}
+
+// RpcNotifier 根据配置创建的通知发送器
+var RpcNotifier Notifier
+
+// 通过webhook发送的通知器, 关闭时等待发送中的通知
+var webhookNotifier *WebhookNotifier
+
+// webhook的默认超时时间和最大并发数
+const (
+	defaultWebhookTimeout     = 5 * time.Second
+	defaultWebhookConcurrency = 32
+)
+
+// SetupNotify 根据配置 NOTIFY_SINK 初始化通知发送器, log 写入日志文件, webhook 发送到 NOTIFY_WEBHOOK_URL
+func SetupNotify() {
+	switch sink := config.GetString("NOTIFY_SINK"); sink {
+	case "", "log":
+		RpcNotifier = LogNotifier{}
+	case "webhook":
+		url := config.GetString("NOTIFY_WEBHOOK_URL")
+		if len(url) == 0 {
+			logger.Error("notify", "NOTIFY_WEBHOOK_URL is empty")
+			panic("NOTIFY_WEBHOOK_URL is empty")
+		}
+		timeout := time.Duration(config.GetInt("NOTIFY_WEBHOOK_TIMEOUT")) * time.Millisecond
+		if timeout <= 0 {
+			timeout = defaultWebhookTimeout
+		}
+		webhookNotifier = NewWebhookNotifier(url, timeout, defaultWebhookConcurrency)
+		RpcNotifier = webhookNotifier
+	default:
+		logger.Error("notify", "unknown notify sink", sink)
+		panic("unknown notify sink: " + sink)
+	}
+}
+
+// Close 等待发送中的通知
+func Close() {
+	if webhookNotifier != nil {
+		webhookNotifier.Close()
+	}
+}
+
+// LogNotifier 以json格式写入日志
+type LogNotifier struct{}
+
+// Notify 发送通知
+func (LogNotifier) Notify(notification Notification) {
+	data, err := json.Marshal(notification)
+	if err != nil {
+		logger.Error("notify", "marshal notification error", err, notification.Kind)
+		return
+	}
+	logger.Info("notify", string(data))
+}
+
+// WebhookNotifier 异步POST json到webhook, 同时发送的通知超过上限时丢弃并记录日志
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+
+	wg      sync.WaitGroup
+	sending chan struct{}
+}
+
+// NewWebhookNotifier 创建webhook通知器, concurrency为同时发送的通知上限
+func NewWebhookNotifier(url string, timeout time.Duration, concurrency int) *WebhookNotifier {
+	return &WebhookNotifier{
+		URL:     url,
+		Client:  &http.Client{Timeout: timeout},
+		sending: make(chan struct{}, concurrency),
+	}
+}
+
+// Notify 发送通知
+func (n *WebhookNotifier) Notify(notification Notification) {
+	select {
+	case n.sending <- struct{}{}:
+	default:
+		logger.Warn("notify", "too many pending notifications, drop", notification.Kind, "requestID:",
+			notification.RequestID)
+		return
+	}
+	n.wg.Add(1)
+	go func() {
+		defer func() {
+			<-n.sending
+			n.wg.Done()
+		}()
+		if err := n.send(notification); err != nil {
+			logger.Error("notify", "send webhook error", err, notification.Kind, "requestID:", notification.RequestID)
+		}
+	}()
+}
+
+// POST通知, 非2xx的响应视为失败
+func (n *WebhookNotifier) send(notification Notification) error {
+	data, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	rsp, err := n.Client.Post(n.URL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		return fmt.Errorf("webhook status %d", rsp.StatusCode)
+	}
+	return nil
+}
+
+// Close 等待发送中的通知
+func (n *WebhookNotifier) Close() {
+	n.wg.Wait()
+}
+
+// MemoryNotifier 在内存中保存通知, 用于测试
+type MemoryNotifier struct {
+	mu            sync.Mutex
+	notifications []Notification
+}
+
+// Notify 发送通知
+func (n *MemoryNotifier) Notify(notification Notification) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.notifications = append(n.notifications, notification)
+}
+
+// Notifications 返回已发送的通知
+func (n *MemoryNotifier) Notifications() []Notification {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]Notification(nil), n.notifications...)
+}
@@ -0,0 +1,52 @@
+package notify
+
+import (
+	"encoding/json"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+	"user_rpc/pkg/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.Logger = zap.NewNop()
+	os.Exit(m.Run())
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var mu sync.Mutex
+	var received []Notification
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		var notification Notification
+		if err := json.NewDecoder(r.Body).Decode(&notification); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		received = append(received, notification)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	// 超过并发上限的通知被丢弃, 不阻塞调用方
+	n := NewWebhookNotifier(server.URL, time.Second, 1)
+	n.Notify(Notification{Kind: KindNewDevice, Username: "alice", Detail: map[string]string{"ip": "10.0.0.1"}})
+	n.Notify(Notification{Kind: KindNewDevice, Username: "bob"})
+	close(release)
+	n.Close()
+
+	if len(received) != 1 || received[0].Username != "alice" || received[0].Detail["ip"] != "10.0.0.1" {
+		t.Fatalf("unexpected notifications: %+v", received)
+	}
+
+	// 发送失败只记录日志
+	server.Close()
+	n.Notify(Notification{Kind: KindNewDevice, Username: "carol"})
+	n.Close()
+}
@@ -0,0 +1,106 @@
+// Package rbac 基于角色的权限校验
+package rbac
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"time"
+	userModel "user_rpc/model/user"
+	"user_rpc/pkg/audit"
+	"user_rpc/pkg/logger"
+	"user_rpc/pkg/util"
+	"userapi/v1"
+)
+
+// Permission 管理权限
+type Permission string
+
+const (
+	// PermUserRead 搜索和查看任意用户
+	PermUserRead Permission = "user:read"
+	// PermUserModerate 重置违规昵称
+	PermUserModerate Permission = "user:moderate"
+	// PermUserStatus 禁用和启用账号
+	PermUserStatus Permission = "user:status"
+	// PermUserLogout 强制登出用户
+	PermUserLogout Permission = "user:logout"
+	// PermAuditRead 查询审计日志
+	PermAuditRead Permission = "audit:read"
+	// PermAvatarGC 遍历所有用户的头像路径, 用于回收头像
+	PermAvatarGC Permission = "avatar:gc"
+)
+
+// 各角色拥有的权限, 未列出的角色(包括普通用户)没有任何管理权限
+var rolePermissions = map[string][]Permission{
+	userModel.RoleModerator: {PermUserRead, PermUserModerate},
+	userModel.RoleAdmin:     {PermUserRead, PermUserModerate, PermUserStatus, PermUserLogout, PermAuditRead, PermAvatarGC},
+}
+
+// Can 判断角色是否拥有权限
+func Can(role string, perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// RoleResolver 获取调用方的用户名和角色, 未认证时返回 Unauthenticated 错误
+type RoleResolver func(ctx context.Context) (username string, role string, err error)
+
+// UnaryServerInterceptor 权限校验拦截器, permissions 中的方法要求调用方的角色拥有对应权限
+// 权限不足时记录审计事件, 返回带 INSUFFICIENT_PERMISSION 原因的 PermissionDenied
+func UnaryServerInterceptor(permissions map[string]Permission, resolve RoleResolver,
+	recorder audit.Recorder) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := authorize(ctx, info.FullMethod, permissions, resolve, recorder); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor 流式接口的权限校验拦截器, 规则与 UnaryServerInterceptor 相同
+func StreamServerInterceptor(permissions map[string]Permission, resolve RoleResolver,
+	recorder audit.Recorder) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := authorize(ss.Context(), info.FullMethod, permissions, resolve, recorder); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// 校验调用方的角色是否拥有方法要求的权限, 不需要权限的方法不解析角色
+func authorize(ctx context.Context, method string, permissions map[string]Permission, resolve RoleResolver,
+	recorder audit.Recorder) error {
+	perm, ok := permissions[method]
+	if !ok {
+		return nil
+	}
+	username, role, err := resolve(ctx)
+	if err != nil {
+		return err
+	}
+	if Can(role, perm) {
+		return nil
+	}
+	requestID := util.GetRequestIDFromContext(ctx)
+	logger.Warn("rbac", "requestID:", requestID, "permission denied, username:", username,
+		"role:", role, "method:", method)
+	ip, userAgent := util.GetClientFromContext(ctx)
+	recorder.Record(audit.Event{
+		Time:      time.Now(),
+		Action:    method,
+		Actor:     username,
+		IP:        ip,
+		UserAgent: userAgent,
+		RequestID: requestID,
+		Detail:    map[string]string{"role": role, "permission": string(perm)},
+		Outcome:   audit.OutcomeDenied,
+	})
+	return util.ErrorWithReason(codes.PermissionDenied, userapi.ReasonInsufficientPermission,
+		"permission "+string(perm)+" required")
+}
@@ -0,0 +1,101 @@
+package rbac
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"os"
+	"testing"
+	userModel "user_rpc/model/user"
+	"user_rpc/pkg/audit"
+	"user_rpc/pkg/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.Logger = zap.NewNop()
+	os.Exit(m.Run())
+}
+
+func TestCan(t *testing.T) {
+	if !Can(userModel.RoleAdmin, PermUserStatus) || !Can(userModel.RoleModerator, PermUserModerate) {
+		t.Fatalf("admin and moderator should have their permissions")
+	}
+	if Can(userModel.RoleModerator, PermUserStatus) || Can(userModel.RoleUser, PermUserRead) || Can("", PermUserRead) {
+		t.Fatalf("unexpected permission")
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	role := userModel.RoleUser
+	resolved := 0
+	recorder := &audit.MemoryRecorder{}
+	interceptor := UnaryServerInterceptor(map[string]Permission{"/test/Admin": PermUserStatus},
+		func(ctx context.Context) (string, string, error) {
+			resolved++
+			return "alice", role, nil
+		}, recorder)
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("request_id", "test"))
+
+	// 不需要权限的方法不解析角色
+	if rsp, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test/Public"}, handler); err != nil || rsp != "ok" || resolved != 0 {
+		t.Fatalf("public method: %v %v resolved=%d", rsp, err, resolved)
+	}
+	if _, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test/Admin"}, handler); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("user role: expected PermissionDenied, got %v", err)
+	}
+	if events := recorder.Events(); len(events) != 1 || events[0].Outcome != audit.OutcomeDenied || events[0].Actor != "alice" {
+		t.Fatalf("denied request should be audited: %+v", events)
+	}
+	role = userModel.RoleAdmin
+	if rsp, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test/Admin"}, handler); err != nil || rsp != "ok" {
+		t.Fatalf("admin role: %v %v", rsp, err)
+	}
+}
+
+// 只提供上下文的服务端流
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s contextStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	recorder := &audit.MemoryRecorder{}
+	interceptor := StreamServerInterceptor(map[string]Permission{"/test/Stream": PermAvatarGC},
+		func(ctx context.Context) (string, string, error) {
+			if _, ok := metadata.FromIncomingContext(ctx); !ok {
+				return "", "", status.Error(codes.Unauthenticated, "identity required")
+			}
+			return "mod", userModel.RoleModerator, nil
+		}, recorder)
+	called := 0
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		called++
+		return nil
+	}
+	info := &grpc.StreamServerInfo{FullMethod: "/test/Stream", IsServerStream: true}
+
+	// 未认证和权限不足的调用方都不能打开流
+	if err := interceptor(nil, contextStream{ctx: context.Background()}, info, handler); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("unauthenticated: expected Unauthenticated, got %v", err)
+	}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("request_id", "test"))
+	if err := interceptor(nil, contextStream{ctx: ctx}, info, handler); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("moderator: expected PermissionDenied, got %v", err)
+	}
+	if called != 0 || len(recorder.Events()) != 1 {
+		t.Fatalf("unexpected calls %d, events %+v", called, recorder.Events())
+	}
+	if err := interceptor(nil, contextStream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: "/test/Public"}, handler); err != nil || called != 1 {
+		t.Fatalf("public stream: %v", err)
+	}
+}
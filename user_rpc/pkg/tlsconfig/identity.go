@@ -0,0 +1,67 @@
+package tlsconfig
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"user_rpc/pkg/logger"
+)
+
+// PeerIdentities 获取客户端证书中的身份, 非mTLS连接返回空
+func PeerIdentities(ctx context.Context) []string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return Identities(info.State.VerifiedChains[0][0])
+}
+
+// UnaryServerInterceptor 客户端身份校验拦截器
+// methods 中的方法只允许 allowed 返回的身份调用, allowed 返回空时不校验
+func UnaryServerInterceptor(methods map[string]bool, allowed func() []string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := checkClient(ctx, info.FullMethod, methods, allowed); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor 流式接口的客户端身份校验拦截器, 规则与 UnaryServerInterceptor 相同
+func StreamServerInterceptor(methods map[string]bool, allowed func() []string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := checkClient(ss.Context(), info.FullMethod, methods, allowed); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// 校验客户端证书身份是否允许调用方法
+func checkClient(ctx context.Context, method string, methods map[string]bool, allowed func() []string) error {
+	if !methods[method] {
+		return nil
+	}
+	clients := allowed()
+	if len(clients) == 0 {
+		return nil
+	}
+
+	identities := PeerIdentities(ctx)
+	for _, identity := range identities {
+		for _, client := range clients {
+			if identity == client {
+				return nil
+			}
+		}
+	}
+	logger.Warn("tls", "unauthorized client", identities, "method:", method)
+	return status.Error(codes.PermissionDenied, "client is not allowed to call "+method)
+}
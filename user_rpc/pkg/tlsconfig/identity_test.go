@@ -0,0 +1,66 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net/url"
+	"testing"
+	"user_rpc/pkg/logger"
+)
+
+func peerContext(cert *x509.Certificate) context.Context {
+	p := &peer.Peer{}
+	if cert != nil {
+		p.AuthInfo = credentials.TLSInfo{State: tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{{cert}},
+		}}
+	}
+	return peer.NewContext(context.Background(), p)
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	logger.Logger = zap.NewNop()
+	spiffe, _ := url.Parse("spiffe://user-system/user-web")
+	web := &x509.Certificate{Subject: pkix.Name{CommonName: "web-01"}, URIs: []*url.URL{spiffe}}
+	other := &x509.Certificate{Subject: pkix.Name{CommonName: "user-other"}, DNSNames: []string{"other.local"}}
+
+	allowed := []string{"spiffe://user-system/user-web"}
+	interceptor := UnaryServerInterceptor(map[string]bool{"/test/Privileged": true}, func() []string { return allowed })
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+	call := func(ctx context.Context, method string) error {
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		return err
+	}
+
+	if err := call(peerContext(web), "/test/Privileged"); err != nil {
+		t.Fatalf("allowed identity rejected: %v", err)
+	}
+	for name, ctx := range map[string]context.Context{
+		"other identity": peerContext(other),
+		"no certificate": peerContext(nil),
+		"no peer":        context.Background(),
+	} {
+		if err := call(ctx, "/test/Privileged"); status.Code(err) != codes.PermissionDenied {
+			t.Fatalf("%s: expected PermissionDenied, got %v", name, err)
+		}
+	}
+	if err := call(peerContext(other), "/test/Normal"); err != nil {
+		t.Fatalf("normal method rejected: %v", err)
+	}
+
+	// 未配置允许的身份时不校验
+	allowed = nil
+	if err := call(peerContext(nil), "/test/Privileged"); err != nil {
+		t.Fatalf("empty allow list should not reject: %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package tlsconfig
+
+import (
+	"strings"
+	"user_rpc/pkg/config"
+	"user_rpc/pkg/logger"
+)
+
+// RpcTLS rpc服务端证书, 未开启TLS时为nil
+var RpcTLS *Reloader
+
+// SetupTLS 根据配置加载证书, .env 变化时重新加载
+func SetupTLS() {
+	if !config.GetBool("RPC_TLS_ENABLE") {
+		return
+	}
+
+	var err error
+	RpcTLS, err = NewReloader(func() Files {
+		return Files{
+			CertFile: config.GetString("RPC_TLS_CERT_FILE"),
+			KeyFile:  config.GetString("RPC_TLS_KEY_FILE"),
+			CAFile:   config.GetString("RPC_TLS_CA_FILE"),
+		}
+	})
+	if err != nil {
+		logger.Error("tls", "load certificate error", err)
+		panic(err)
+	}
+	config.OnChange(func() {
+		if err := RpcTLS.Reload(); err != nil {
+			logger.Error("tls", "reload certificate error", err)
+			return
+		}
+		logger.Info("tls", "certificate reloaded")
+	})
+}
+
+// AllowedClients 允许调用敏感方法的客户端身份, 逗号分隔
+func AllowedClients() []string {
+	var clients []string
+	for _, client := range strings.Split(config.GetString("RPC_TLS_ALLOWED_CLIENTS"), ",") {
+		if client = strings.TrimSpace(client); len(client) > 0 {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
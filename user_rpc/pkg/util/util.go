@@ -5,10 +5,14 @@ import (
 	"crypto/md5"
 	"encoding/hex"
 	"fmt"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"math/rand"
 	"time"
 	"user_rpc/pkg/config"
+	"userapi/v1"
 )
 
 // Md5Data md5加密
@@ -52,6 +56,24 @@ func GetRequestIDFromContext(ctx context.Context) string {
 	return md["request_id"][0]
 }
 
+// 客户端信息在metadata中的key, 由 user_web 传递
+const (
+	ClientIPMetadataKey        = "x-client-ip"
+	ClientUserAgentMetadataKey = "x-client-user-agent"
+)
+
+// GetClientFromContext 从context中获取发起请求的客户端IP和User-Agent, 未传递时为空
+func GetClientFromContext(ctx context.Context) (ip string, userAgent string) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(ClientIPMetadataKey); len(values) > 0 {
+		ip = values[0]
+	}
+	if values := md.Get(ClientUserAgentMetadataKey); len(values) > 0 {
+		userAgent = values[0]
+	}
+	return ip, userAgent
+}
+
 // GetTableByUsername 根据用户名获取表名称
 func GetTableByUsername(username string) string {
 	var cSum int32
@@ -60,3 +82,25 @@ func GetTableByUsername(username string) string {
 	}
 	return fmt.Sprintf("users_%d", cSum%config.GetInt32("DB_USER_TABLE_COUNT"))
 }
+
+// GetUserTables 获取所有用户分表的表名称
+func GetUserTables() []string {
+	count := config.GetInt32("DB_USER_TABLE_COUNT")
+	tables := make([]string, 0, count)
+	for i := int32(0); i < count; i++ {
+		tables = append(tables, fmt.Sprintf("users_%d", i))
+	}
+	return tables
+}
+
+// ErrorWithReason 生成携带 ErrorInfo 的rpc错误, 调用方根据 reason 区分失败原因
+func ErrorWithReason(code codes.Code, reason string, message string) error {
+	st, err := status.New(code, message).WithDetails(&errdetails.ErrorInfo{
+		Reason: reason,
+		Domain: userapi.ErrorDomain,
+	})
+	if err != nil {
+		return status.Error(code, message)
+	}
+	return st.Err()
+}
@@ -0,0 +1,151 @@
+package user
+
+import (
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+	userDao "user_rpc/dao/user"
+	userModel "user_rpc/model/user"
+	"user_rpc/pkg/logger"
+	"user_rpc/pkg/util"
+	"userapi/v1"
+)
+
+// 账号注销的审计事件类型
+const (
+	ActionDeleteAccount = "account.delete"
+	ActionPurgeAccount  = "account.purge"
+)
+
+// DefaultDeletionRetention 申请注销后账号数据的默认保留时间
+const DefaultDeletionRetention = 30 * 24 * time.Hour
+
+// SetDeletionRetention 设置申请注销后账号数据的保留时间, 保留期内管理员可以恢复账号
+func (s *UserService) SetDeletionRetention(retention time.Duration) {
+	if retention > 0 {
+		s.deletionRetention = retention
+	}
+}
+
+// DeleteAccount 注销账号逻辑, 校验密码后标记为待注销并删除用户的所有token, 返回数据清理时间
+// 已签发的身份凭证在校验账号状态时被拒绝
+func (s *UserService) DeleteAccount(ctx context.Context, req *userapi.DeleteAccountRequest) (purgeTime time.Time, err error) {
+	requestID := util.GetRequestIDFromContext(ctx)
+
+	// 检查用户是否登录
+	username, err := s.authenticate(ctx, "deleteAccount", "")
+	if err != nil {
+		return purgeTime, err
+	}
+	defer func() {
+		s.recordAction(ctx, ActionDeleteAccount, username, "", nil, err)
+	}()
+
+	// 再次校验密码
+	user, err := s.getUserByUsername(ctx, "deleteAccount", username)
+	if err != nil {
+		return purgeTime, err
+	}
+	if ok := util.ComparePwdHash(req.Password, user.Password, user.Salt); !ok {
+		logger.Warn("deleteAccount", "requestID:", requestID, "password incorrect, username:", username)
+		return purgeTime, status.Error(codes.PermissionDenied, "password incorrect")
+	}
+	if err = accountStatusError("deleteAccount", requestID, user); err != nil {
+		return purgeTime, err
+	}
+
+	// 标记为待注销并强制登出
+	deletedAt := time.Now()
+	_, err = s.updateColumns(ctx, "deleteAccount", username, user.Version, map[string]interface{}{
+		"status":     userModel.StatusPendingDeletion,
+		"deleted_at": deletedAt,
+	})
+	if err != nil {
+		return purgeTime, err
+	}
+	if err = s.tokens.DelByUsername(username); err != nil {
+		logger.Error("deleteAccount", "requestID:", requestID, "delete user tokens, err:", err)
+		return purgeTime, status.Error(codes.Internal, err.Error())
+	}
+
+	return deletedAt.Add(s.deletionRetention), nil
+}
+
+// PurgeReport 账号清理结果
+type PurgeReport struct {
+	// Purged 清理的账号数量
+	Purged int
+	// Skipped 查询后被恢复或修改过的账号数量, 下次清理时重新判断
+	Skipped int
+	// PicPaths 被清理账号的头像路径, 由 user_web 的 avatar_gc 回收
+	PicPaths []string
+}
+
+// 匿名化时清空的个人数据, 用户名保留以免被他人重新注册冒用
+var anonymizedColumns = map[string]interface{}{
+	"password":           "",
+	"salt":               "",
+	"nickname":           "",
+	"pic_path":           "",
+	"role":               userModel.RoleUser,
+	"status":             userModel.StatusDeleted,
+	"mfa_secret":         "",
+	"mfa_enabled":        false,
+	"mfa_recovery_codes": "",
+	"mfa_last_step":      int64(0),
+}
+
+// PurgeAccounts 清理保留期已过的待注销账号, hardDelete为true时删除记录, 否则匿名化
+func (s *UserService) PurgeAccounts(ctx context.Context, now time.Time, hardDelete bool) (report PurgeReport, err error) {
+	requestID := util.GetRequestIDFromContext(ctx)
+
+	// 先取出所有待清理的账号, 避免遍历分表时修改数据
+	var users []userModel.User
+	err = s.repo.ListPendingDeletion(now.Add(-s.deletionRetention), func(user userModel.User) error {
+		users = append(users, user)
+		return nil
+	})
+	if err != nil {
+		logger.Error("purgeAccounts", "requestID:", requestID, "list pending deletion db, err:", err)
+		return report, status.Error(codes.Internal, err.Error())
+	}
+
+	mode := "anonymize"
+	if hardDelete {
+		mode = "delete"
+	}
+	for _, user := range users {
+		// 按查询时的版本号清理, 期间被恢复的账号会版本冲突
+		if hardDelete {
+			err = s.repo.DeleteByUsername(user.Username, user.Version)
+		} else {
+			_, err = s.repo.UpdateColumnsByUsername(user.Username, user.Version, anonymizedColumns)
+		}
+		if err == userDao.ErrVersionConflict {
+			logger.Warn("purgeAccounts", "requestID:", requestID, "account changed, skip, username:", user.Username)
+			report.Skipped++
+			continue
+		}
+		if err == nil {
+			err = s.cache.Del(user.Username)
+		}
+		if err == nil {
+			err = s.tokens.DelByUsername(user.Username)
+		}
+		if err == nil && s.logins != nil {
+			err = s.logins.DelByUsername(user.Username)
+		}
+		s.recordAction(ctx, ActionPurgeAccount, user.Username, "", map[string]string{"mode": mode}, err)
+		if err != nil {
+			logger.Error("purgeAccounts", "requestID:", requestID, "purge account, username:", user.Username, "err:", err)
+			return report, status.Error(codes.Internal, err.Error())
+		}
+
+		report.Purged++
+		if len(user.PicPath) > 0 {
+			report.PicPaths = append(report.PicPaths, user.PicPath)
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,112 @@
+package user
+
+import (
+	"google.golang.org/grpc/codes"
+	"testing"
+	"time"
+	userModel "user_rpc/model/user"
+	"userapi/v1"
+)
+
+func TestDeleteAccount(t *testing.T) {
+	env := newAdminTestEnv(t, "alice")
+	_, token, _, err := env.svc.Login(newTestContext(), &userapi.LoginRequest{Username: "alice", Password: "123456"})
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+
+	// 需要身份凭证和正确的密码
+	_, err = env.svc.DeleteAccount(newTestContext(), &userapi.DeleteAccountRequest{Password: "123456"})
+	assertCode(t, err, codes.Unauthenticated)
+	_, err = env.svc.DeleteAccount(env.as("alice"), &userapi.DeleteAccountRequest{Password: "wrong"})
+	assertCode(t, err, codes.PermissionDenied)
+
+	// 注销后token失效, 不能再登录
+	before := time.Now()
+	purgeTime, err := env.svc.DeleteAccount(env.as("alice"), &userapi.DeleteAccountRequest{Password: "123456"})
+	if err != nil || purgeTime.Before(before.Add(DefaultDeletionRetention)) {
+		t.Fatalf("delete account: %v %v", purgeTime, err)
+	}
+	_, _, _, err = env.svc.Authenticate(newTestContext(), &userapi.AuthRequest{Token: token})
+	assertCode(t, err, codes.Unauthenticated)
+	_, err = env.svc.ExportUserData(env.as("alice"))
+	if reason := errorReason(t, err, codes.PermissionDenied); reason != userapi.ReasonAccountPendingDeletion {
+		t.Fatalf("export with identity after delete: %v", err)
+	}
+	_, _, _, err = env.svc.Login(newTestContext(), &userapi.LoginRequest{Username: "alice", Password: "123456"})
+	if reason := errorReason(t, err, codes.PermissionDenied); reason != userapi.ReasonAccountPendingDeletion {
+		t.Fatalf("login pending deletion: %v", err)
+	}
+
+	// 待注销的账号不能被禁用, 管理员可以恢复
+	_, err = env.svc.SetUserStatus(env.as("admin"), &userapi.SetUserStatusRequest{
+		Username: "alice", Status: userapi.UserStatus_USER_STATUS_DISABLED,
+	})
+	assertCode(t, err, codes.FailedPrecondition)
+	user, err := env.svc.SetUserStatus(env.as("admin"), &userapi.SetUserStatusRequest{
+		Username: "alice", Status: userapi.UserStatus_USER_STATUS_ACTIVE, Reason: "restore",
+	})
+	if err != nil || user.Status != userModel.StatusActive || user.DeletedAt != nil {
+		t.Fatalf("restore: %+v %v", user, err)
+	}
+	if _, _, _, err = env.svc.Login(newTestContext(), &userapi.LoginRequest{Username: "alice", Password: "123456"}); err != nil {
+		t.Fatalf("login after restore: %v", err)
+	}
+
+	events := env.events("account.", "admin.")
+	if len(events) != 4 || events[0].Action != ActionDeleteAccount || events[0].Actor != "alice" ||
+		events[1].Action != ActionDeleteAccount || events[1].Target != "alice" {
+		t.Fatalf("unexpected audit events: %+v", events)
+	}
+}
+
+func TestPurgeAccounts(t *testing.T) {
+	env := newAdminTestEnv(t, "alice", "bob", "carol")
+	if _, err := env.repo.UpdateColumnsByUsername("alice", 0, map[string]interface{}{"pic_path": "avatars/alice.png"}); err != nil {
+		t.Fatalf("set pic path: %v", err)
+	}
+	for _, username := range []string{"alice", "bob"} {
+		if _, err := env.svc.DeleteAccount(env.as(username), &userapi.DeleteAccountRequest{Password: "123456"}); err != nil {
+			t.Fatalf("delete account %s: %v", username, err)
+		}
+	}
+
+	// 保留期内不清理
+	report, err := env.svc.PurgeAccounts(newTestContext(), time.Now(), false)
+	if err != nil || report.Purged != 0 {
+		t.Fatalf("purge within retention: %+v %v", report, err)
+	}
+
+	// 保留期过后匿名化, 用户名保留
+	report, err = env.svc.PurgeAccounts(newTestContext(), time.Now().Add(DefaultDeletionRetention+time.Minute), false)
+	if err != nil || report.Purged != 2 || len(report.PicPaths) != 1 || report.PicPaths[0] != "avatars/alice.png" {
+		t.Fatalf("purge: %+v %v", report, err)
+	}
+	alice, _ := env.repo.GetByUsername("alice")
+	if alice.Status != userModel.StatusDeleted || len(alice.Password) > 0 || len(alice.Nickname) > 0 || len(alice.PicPath) > 0 {
+		t.Fatalf("alice should be anonymized: %+v", alice)
+	}
+	_, _, _, err = env.svc.Login(newTestContext(), &userapi.LoginRequest{Username: "alice", Password: "123456"})
+	assertCode(t, err, codes.NotFound)
+	_, err = env.svc.CreateUserProfile(newTestContext(), &userapi.CreateUserRequest{Username: "alice", Password: "123456"})
+	assertCode(t, err, codes.AlreadyExists)
+	_, err = env.svc.SetUserStatus(env.as("admin"), &userapi.SetUserStatusRequest{
+		Username: "alice", Status: userapi.UserStatus_USER_STATUS_ACTIVE,
+	})
+	assertCode(t, err, codes.FailedPrecondition)
+
+	// 删除记录后用户名可以重新注册
+	if _, err = env.svc.DeleteAccount(env.as("carol"), &userapi.DeleteAccountRequest{Password: "123456"}); err != nil {
+		t.Fatalf("delete account carol: %v", err)
+	}
+	report, err = env.svc.PurgeAccounts(newTestContext(), time.Now().Add(DefaultDeletionRetention+time.Minute), true)
+	if err != nil || report.Purged != 1 {
+		t.Fatalf("hard delete: %+v %v", report, err)
+	}
+	if carol, _ := env.repo.GetByUsername("carol"); carol.ID != 0 {
+		t.Fatalf("carol should be deleted: %+v", carol)
+	}
+	if _, err = env.svc.CreateUserProfile(newTestContext(), &userapi.CreateUserRequest{Username: "carol", Password: "123456"}); err != nil {
+		t.Fatalf("register carol again: %v", err)
+	}
+}
@@ -0,0 +1,193 @@
+package user
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	userDao "user_rpc/dao/user"
+	userModel "user_rpc/model/user"
+	"user_rpc/pkg/logger"
+	"user_rpc/pkg/util"
+	"userapi/v1"
+)
+
+// 管理操作的审计事件类型
+const (
+	ActionSearchUsers   = "admin.search_users"
+	ActionGetUser       = "admin.get_user"
+	ActionSetUserStatus = "admin.set_user_status"
+	ActionForceLogout   = "admin.force_logout"
+	ActionResetNickname = "admin.reset_nickname"
+)
+
+// 搜索用户的分页大小
+const (
+	defaultSearchPageSize = 20
+	maxSearchPageSize     = 100
+)
+
+// CallerRole 获取管理接口调用方的用户名和角色, 管理接口只接受身份凭证
+func (s *UserService) CallerRole(ctx context.Context) (username string, role string, err error) {
+	requestID := util.GetRequestIDFromContext(ctx)
+	username, err = s.signer.FromContext(ctx)
+	if err == nil && len(username) == 0 {
+		logger.Warn("callerRole", "requestID:", requestID, "identity required")
+		return "", "", util.ErrorWithReason(codes.Unauthenticated, userapi.ReasonTokenInvalid, "identity required")
+	}
+	if err != nil {
+		logger.Warn("callerRole", "requestID:", requestID, "invalid identity, err:", err)
+		return "", "", util.ErrorWithReason(codes.Unauthenticated, userapi.ReasonTokenInvalid, "unauthorized")
+	}
+
+	user, err := s.activeUser(ctx, "callerRole", username)
+	if err != nil {
+		return "", "", err
+	}
+	role = user.Role
+	if len(role) == 0 {
+		role = userModel.RoleUser
+	}
+	return username, role, nil
+}
+
+// 解析搜索的分页位置
+func decodePageToken(token string) (cursor userDao.SearchCursor, err error) {
+	if len(token) == 0 {
+		return cursor, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err == nil {
+		_, err = fmt.Sscanf(string(data), "%d-%d", &cursor.Table, &cursor.LastID)
+	}
+	if err != nil || cursor.Table < 0 || cursor.LastID < 0 {
+		return cursor, fmt.Errorf("invalid page_token")
+	}
+	return cursor, nil
+}
+
+// 生成下一页的分页位置
+func encodePageToken(cursor *userDao.SearchCursor) string {
+	if cursor == nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d-%d", cursor.Table, cursor.LastID)))
+}
+
+// SearchUsers 搜索用户逻辑
+func (s *UserService) SearchUsers(ctx context.Context, req *userapi.SearchUsersRequest) (
+	users []userModel.User, nextPageToken string, err error) {
+	requestID := util.GetRequestIDFromContext(ctx)
+	defer func() {
+		s.recordAction(ctx, ActionSearchUsers, "", "", map[string]string{"query": req.Query}, err)
+	}()
+
+	cursor, err := decodePageToken(req.PageToken)
+	if err != nil {
+		logger.Warn("searchUsers", "requestID:", requestID, "decode page token, err:", err)
+		return nil, "", status.Error(codes.InvalidArgument, err.Error())
+	}
+	pageSize := int(req.PageSize)
+	if pageSize <= 0 {
+		pageSize = defaultSearchPageSize
+	}
+	if pageSize > maxSearchPageSize {
+		pageSize = maxSearchPageSize
+	}
+
+	users, next, err := s.repo.Search(req.Query, cursor, pageSize)
+	if err != nil {
+		logger.Error("searchUsers", "requestID:", requestID, "search user db, err:", err)
+		return nil, "", status.Error(codes.Internal, err.Error())
+	}
+	return users, encodePageToken(next), nil
+}
+
+// AdminGetUser 查看任意用户信息逻辑
+func (s *UserService) AdminGetUser(ctx context.Context, req *userapi.AdminUserRequest) (user userModel.User, err error) {
+	defer func() {
+		s.recordAction(ctx, ActionGetUser, req.Username, req.Reason, nil, err)
+	}()
+	return s.getUserByUsername(ctx, "adminGetUser", req.Username)
+}
+
+// SetUserStatus 禁用或启用账号逻辑, 禁用时删除用户的所有token, 已签发的身份凭证在校验账号状态时被拒绝
+func (s *UserService) SetUserStatus(ctx context.Context, req *userapi.SetUserStatusRequest) (user userModel.User, err error) {
+	requestID := util.GetRequestIDFromContext(ctx)
+	defer func() {
+		s.recordAction(ctx, ActionSetUserStatus, req.Username, req.Reason,
+			map[string]string{"status": req.Status.String()}, err)
+	}()
+
+	if req.Status != userapi.UserStatus_USER_STATUS_ACTIVE && req.Status != userapi.UserStatus_USER_STATUS_DISABLED {
+		return user, status.Error(codes.InvalidArgument, "invalid status")
+	}
+	if actor, _ := s.signer.FromContext(ctx); actor == req.Username {
+		logger.Warn("setUserStatus", "requestID:", requestID, "cannot change own status, username:", actor)
+		return user, status.Error(codes.InvalidArgument, "cannot change own status")
+	}
+	current, err := s.getUserByUsername(ctx, "setUserStatus", req.Username)
+	if err != nil {
+		return user, err
+	}
+
+	// 已注销的账号不能修改状态, 待注销的账号只能恢复为正常
+	columns := map[string]interface{}{"status": int(req.Status)}
+	switch {
+	case current.Status == userModel.StatusDeleted:
+		logger.Warn("setUserStatus", "requestID:", requestID, "account deleted, username:", req.Username)
+		return user, util.ErrorWithReason(codes.FailedPrecondition, userapi.ReasonAccountDeleted, "account deleted")
+	case current.Status == userModel.StatusPendingDeletion && req.Status != userapi.UserStatus_USER_STATUS_ACTIVE:
+		logger.Warn("setUserStatus", "requestID:", requestID, "account pending deletion, username:", req.Username)
+		return user, util.ErrorWithReason(codes.FailedPrecondition, userapi.ReasonAccountPendingDeletion,
+			"account pending deletion")
+	case current.Status == userModel.StatusPendingDeletion:
+		columns["deleted_at"] = nil
+	}
+
+	user, err = s.updateColumns(ctx, "setUserStatus", req.Username, current.Version, columns)
+	if err != nil {
+		return user, err
+	}
+	if user.Status == userModel.StatusDisabled {
+		if err = s.tokens.DelByUsername(req.Username); err != nil {
+			logger.Error("setUserStatus", "requestID:", requestID, "delete user tokens, err:", err)
+			return user, status.Error(codes.Internal, err.Error())
+		}
+	}
+	return user, nil
+}
+
+// ForceLogout 强制登出逻辑, 删除用户的所有token
+// 账号仍然正常, 已签发的身份凭证在 RPC_IDENTITY_TTL 内仍然可用; 需要立即拒绝访问时禁用账号
+func (s *UserService) ForceLogout(ctx context.Context, req *userapi.AdminUserRequest) (err error) {
+	requestID := util.GetRequestIDFromContext(ctx)
+	defer func() {
+		s.recordAction(ctx, ActionForceLogout, req.Username, req.Reason, nil, err)
+	}()
+
+	if _, err = s.getUserByUsername(ctx, "forceLogout", req.Username); err != nil {
+		return err
+	}
+	if err = s.tokens.DelByUsername(req.Username); err != nil {
+		logger.Error("forceLogout", "requestID:", requestID, "delete user tokens, err:", err)
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
+
+// ResetNickname 重置违规昵称逻辑, 昵称清空后由客户端显示用户名
+func (s *UserService) ResetNickname(ctx context.Context, req *userapi.AdminUserRequest) (user userModel.User, err error) {
+	var before string
+	defer func() {
+		s.recordAction(ctx, ActionResetNickname, req.Username, req.Reason,
+			map[string]string{"nickname": before}, err)
+	}()
+
+	if user, err = s.getUserByUsername(ctx, "resetNickname", req.Username); err != nil {
+		return user, err
+	}
+	before = user.Nickname
+	return s.updateColumns(ctx, "resetNickname", req.Username, 0, map[string]interface{}{"nickname": ""})
+}
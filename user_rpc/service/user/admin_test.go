@@ -0,0 +1,229 @@
+package user
+
+import (
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"strings"
+	"testing"
+	"time"
+	userDao "user_rpc/dao/user"
+	userModel "user_rpc/model/user"
+	"user_rpc/pkg/audit"
+	"user_rpc/pkg/identity"
+	"userapi/v1"
+)
+
+// 管理接口测试环境, admin 为管理员, 其他用户为普通用户
+type adminTestEnv struct {
+	svc      *UserService
+	repo     *userDao.MemoryUserRepository
+	tokens   *userDao.MemoryTokenStore
+	signer   *identity.Signer
+	recorder *audit.MemoryRecorder
+}
+
+func newAdminTestEnv(t *testing.T, usernames ...string) *adminTestEnv {
+	t.Helper()
+	env := &adminTestEnv{
+		repo:     userDao.NewMemoryUserRepository(),
+		tokens:   userDao.NewMemoryTokenStore(),
+		signer:   identity.NewSigner([]byte("secret"), time.Minute),
+		recorder: &audit.MemoryRecorder{},
+	}
+	env.svc = NewUserService(env.repo, env.tokens, userDao.NewMemoryUserCache(), env.signer, env.recorder)
+	env.svc.SetAuditQuerier(env.recorder)
+	for _, username := range append([]string{"admin"}, usernames...) {
+		if _, err := env.repo.CreateOne(username, "123456", "nick_"+username); err != nil {
+			t.Fatalf("create user %s: %v", username, err)
+		}
+	}
+	if _, err := env.repo.UpdateColumnsByUsername("admin", 0, map[string]interface{}{"role": userModel.RoleAdmin}); err != nil {
+		t.Fatalf("set admin role: %v", err)
+	}
+	return env
+}
+
+// 按事件类型前缀筛选审计事件
+func (env *adminTestEnv) events(prefixes ...string) []audit.Event {
+	var events []audit.Event
+	for _, event := range env.recorder.Events() {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(event.Action, prefix) {
+				events = append(events, event)
+				break
+			}
+		}
+	}
+	return events
+}
+
+// 以指定用户的身份调用
+func (env *adminTestEnv) as(username string) context.Context {
+	assertion, _ := env.signer.Sign(username, time.Now())
+	return metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs("request_id", "test", identity.MetadataKey, assertion))
+}
+
+func TestCallerRole(t *testing.T) {
+	env := newAdminTestEnv(t, "alice")
+
+	if _, role, err := env.svc.CallerRole(env.as("admin")); err != nil || role != userModel.RoleAdmin {
+		t.Fatalf("admin role: %q %v", role, err)
+	}
+	if _, role, err := env.svc.CallerRole(env.as("alice")); err != nil || role != userModel.RoleUser {
+		t.Fatalf("user role: %q %v", role, err)
+	}
+	_, _, err := env.svc.CallerRole(newTestContext())
+	assertCode(t, err, codes.Unauthenticated)
+}
+
+func TestSearchUsers(t *testing.T) {
+	env := newAdminTestEnv(t, "alice", "alina", "bob")
+	ctx := env.as("admin")
+
+	// 用户名前缀匹配, 分页返回
+	users, next, err := env.svc.SearchUsers(ctx, &userapi.SearchUsersRequest{Query: "al", PageSize: 1})
+	if err != nil || len(users) != 1 || users[0].Username != "alice" || len(next) == 0 {
+		t.Fatalf("first page: %+v %q %v", users, next, err)
+	}
+	users, next, err = env.svc.SearchUsers(ctx, &userapi.SearchUsersRequest{Query: "al", PageSize: 1, PageToken: next})
+	if err != nil || len(users) != 1 || users[0].Username != "alina" || len(next) != 0 {
+		t.Fatalf("second page: %+v %q %v", users, next, err)
+	}
+
+	// 昵称包含关键字
+	if users, _, err = env.svc.SearchUsers(ctx, &userapi.SearchUsersRequest{Query: "_bob"}); err != nil || len(users) != 1 {
+		t.Fatalf("nickname search: %+v %v", users, err)
+	}
+	_, _, err = env.svc.SearchUsers(ctx, &userapi.SearchUsersRequest{PageToken: "!!"})
+	assertCode(t, err, codes.InvalidArgument)
+}
+
+func TestSetUserStatus(t *testing.T) {
+	env := newAdminTestEnv(t, "alice")
+	ctx := env.as("admin")
+	_, token, _, err := env.svc.Login(newTestContext(), &userapi.LoginRequest{Username: "alice", Password: "123456"})
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+
+	// 禁用后token失效, 不能再登录
+	user, err := env.svc.SetUserStatus(ctx, &userapi.SetUserStatusRequest{
+		Username: "alice", Status: userapi.UserStatus_USER_STATUS_DISABLED, Reason: "spam",
+	})
+	if err != nil || user.Status != userModel.StatusDisabled {
+		t.Fatalf("disable: %+v %v", user, err)
+	}
+	_, _, _, err = env.svc.Authenticate(newTestContext(), &userapi.AuthRequest{Token: token})
+	assertCode(t, err, codes.Unauthenticated)
+	_, _, _, err = env.svc.Login(newTestContext(), &userapi.LoginRequest{Username: "alice", Password: "123456"})
+	assertCode(t, err, codes.PermissionDenied)
+
+	// 启用后可以登录
+	if _, err = env.svc.SetUserStatus(ctx, &userapi.SetUserStatusRequest{
+		Username: "alice", Status: userapi.UserStatus_USER_STATUS_ACTIVE,
+	}); err != nil {
+		t.Fatalf("enable: %v", err)
+	}
+	if _, _, _, err = env.svc.Login(newTestContext(), &userapi.LoginRequest{Username: "alice", Password: "123456"}); err != nil {
+		t.Fatalf("login after enable: %v", err)
+	}
+
+	// 不能修改自己的状态, 不存在的用户
+	_, err = env.svc.SetUserStatus(ctx, &userapi.SetUserStatusRequest{Username: "admin", Status: userapi.UserStatus_USER_STATUS_DISABLED})
+	assertCode(t, err, codes.InvalidArgument)
+	_, err = env.svc.SetUserStatus(ctx, &userapi.SetUserStatusRequest{Username: "nobody", Status: userapi.UserStatus_USER_STATUS_DISABLED})
+	assertCode(t, err, codes.NotFound)
+
+	// 所有操作都有审计记录
+	events := env.events("admin.")
+	if len(events) != 4 {
+		t.Fatalf("expected 4 audit events, got %+v", events)
+	}
+	first := events[0]
+	if first.Action != ActionSetUserStatus || first.Actor != "admin" || first.Target != "alice" ||
+		first.Reason != "spam" || first.Outcome != audit.OutcomeSuccess || first.Detail["status"] != "USER_STATUS_DISABLED" {
+		t.Fatalf("unexpected audit event: %+v", first)
+	}
+	if events[3].Outcome != audit.OutcomeFailure || len(events[3].Error) == 0 {
+		t.Fatalf("failed action should be audited: %+v", events[3])
+	}
+}
+
+func TestDisabledAccountAccess(t *testing.T) {
+	env := newAdminTestEnv(t, "alice")
+	_, token, _, err := env.svc.Login(newTestContext(), &userapi.LoginRequest{Username: "alice", Password: "123456"})
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	aliceCtx := env.as("alice")
+	if _, err = env.svc.GetUserProfile(aliceCtx, &userapi.AuthRequest{}); err != nil {
+		t.Fatalf("get profile: %v", err)
+	}
+
+	// 禁用后未过期的token和身份凭证都被拒绝
+	if _, err = env.svc.SetUserStatus(env.as("admin"), &userapi.SetUserStatusRequest{
+		Username: "alice", Status: userapi.UserStatus_USER_STATUS_DISABLED,
+	}); err != nil {
+		t.Fatalf("disable: %v", err)
+	}
+	_ = env.tokens.Set(token, "alice")
+	_, _, _, err = env.svc.Authenticate(newTestContext(), &userapi.AuthRequest{Token: token})
+	if reason := errorReason(t, err, codes.PermissionDenied); reason != userapi.ReasonAccountDisabled {
+		t.Fatalf("auth disabled: %v", err)
+	}
+	_, err = env.svc.GetUserProfile(newTestContext(), &userapi.AuthRequest{Token: token})
+	assertCode(t, err, codes.PermissionDenied)
+	_, err = env.svc.GetUserProfile(aliceCtx, &userapi.AuthRequest{})
+	if reason := errorReason(t, err, codes.PermissionDenied); reason != userapi.ReasonAccountDisabled {
+		t.Fatalf("get profile with identity: %v", err)
+	}
+	_, err = env.svc.EditUserProfile(aliceCtx, &userapi.EditUserRequest{Nickname: "nick_alice2"})
+	assertCode(t, err, codes.PermissionDenied)
+
+	// 启用后身份凭证恢复可用
+	if _, err = env.svc.SetUserStatus(env.as("admin"), &userapi.SetUserStatusRequest{
+		Username: "alice", Status: userapi.UserStatus_USER_STATUS_ACTIVE,
+	}); err != nil {
+		t.Fatalf("enable: %v", err)
+	}
+	if _, err = env.svc.GetUserProfile(aliceCtx, &userapi.AuthRequest{}); err != nil {
+		t.Fatalf("get profile after enable: %v", err)
+	}
+}
+
+func TestForceLogoutAndResetNickname(t *testing.T) {
+	env := newAdminTestEnv(t, "alice")
+	ctx := env.as("admin")
+	var tokens []string
+	for i := 0; i < 2; i++ {
+		_, token, _, err := env.svc.Login(newTestContext(), &userapi.LoginRequest{Username: "alice", Password: "123456"})
+		if err != nil {
+			t.Fatalf("login: %v", err)
+		}
+		tokens = append(tokens, token)
+	}
+
+	// 强制登出所有会话
+	if err := env.svc.ForceLogout(ctx, &userapi.AdminUserRequest{Username: "alice"}); err != nil {
+		t.Fatalf("force logout: %v", err)
+	}
+	for _, token := range tokens {
+		_, _, _, err := env.svc.Authenticate(newTestContext(), &userapi.AuthRequest{Token: token})
+		assertCode(t, err, codes.Unauthenticated)
+	}
+
+	// 重置昵称, 审计记录原昵称
+	user, err := env.svc.ResetNickname(ctx, &userapi.AdminUserRequest{Username: "alice", Reason: "offensive"})
+	if err != nil || len(user.Nickname) > 0 {
+		t.Fatalf("reset nickname: %+v %v", user, err)
+	}
+	if user, err = env.svc.AdminGetUser(ctx, &userapi.AdminUserRequest{Username: "alice"}); err != nil || len(user.Nickname) > 0 {
+		t.Fatalf("get user after reset: %+v %v", user, err)
+	}
+	events := env.events("admin.")
+	if len(events) != 3 || events[1].Action != ActionResetNickname || events[1].Detail["nickname"] != "nick_alice" {
+		t.Fatalf("unexpected audit events: %+v", events)
+	}
+}
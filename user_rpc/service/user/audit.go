@@ -0,0 +1,128 @@
+package user
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strconv"
+	"time"
+	"user_rpc/pkg/audit"
+	"user_rpc/pkg/logger"
+	"user_rpc/pkg/util"
+	"userapi/v1"
+)
+
+// ActionListAuditEvents 查询审计日志的审计事件类型
+const ActionListAuditEvents = "admin.list_audit_events"
+
+// 查询审计日志的分页大小
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 500
+)
+
+// SetAuditQuerier 设置审计日志查询, 未设置时不能查询审计日志, 导出的个人数据中不包含审计事件
+func (s *UserService) SetAuditQuerier(querier audit.Querier) {
+	s.auditQuerier = querier
+}
+
+// 记录审计事件, 补充时间、请求ID和客户端信息; err不为空时记录为失败
+func (s *UserService) recordEvent(ctx context.Context, event audit.Event, err error) {
+	event.Time = time.Now()
+	event.RequestID = util.GetRequestIDFromContext(ctx)
+	event.IP, event.UserAgent = util.GetClientFromContext(ctx)
+	event.Outcome = audit.OutcomeSuccess
+	if err != nil {
+		event.Outcome = audit.OutcomeFailure
+		event.Error = status.Convert(err).Message()
+	}
+	s.audit.Record(event)
+}
+
+// 记录审计事件, 操作人为身份凭证中的用户, 没有凭证时为空; err不为空时记录为失败
+func (s *UserService) recordAction(ctx context.Context, action string, target string, reason string,
+	detail map[string]string, err error) {
+	actor, _ := s.signer.FromContext(ctx)
+	s.recordEvent(ctx, audit.Event{
+		Action: action,
+		Actor:  actor,
+		Target: target,
+		Reason: reason,
+		Detail: detail,
+	}, err)
+}
+
+// 解析审计日志的分页位置
+func decodeAuditPageToken(token string) (afterID int64, err error) {
+	if len(token) == 0 {
+		return 0, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err == nil {
+		afterID, err = strconv.ParseInt(string(data), 10, 64)
+	}
+	if err != nil || afterID < 0 {
+		return 0, fmt.Errorf("invalid page_token")
+	}
+	return afterID, nil
+}
+
+// ListAuditEvents 查询审计日志逻辑, 查询本身也记录审计事件
+func (s *UserService) ListAuditEvents(ctx context.Context, req *userapi.ListAuditEventsRequest) (
+	events []audit.Event, nextPageToken string, err error) {
+	requestID := util.GetRequestIDFromContext(ctx)
+	defer func() {
+		s.recordAction(ctx, ActionListAuditEvents, req.Username, "", map[string]string{
+			"action":     req.Action,
+			"start_time": strconv.FormatInt(req.StartTime, 10),
+			"end_time":   strconv.FormatInt(req.EndTime, 10),
+		}, err)
+	}()
+
+	if s.auditQuerier == nil {
+		logger.Warn("listAuditEvents", "requestID:", requestID, "audit sink does not support query")
+		return nil, "", status.Error(codes.Unimplemented, "audit log query not supported")
+	}
+	if req.StartTime < 0 || req.EndTime < 0 || (req.StartTime > 0 && req.EndTime > 0 && req.EndTime <= req.StartTime) {
+		return nil, "", status.Error(codes.InvalidArgument, "invalid time range")
+	}
+	afterID, err := decodeAuditPageToken(req.PageToken)
+	if err != nil {
+		logger.Warn("listAuditEvents", "requestID:", requestID, "decode page token, err:", err)
+		return nil, "", status.Error(codes.InvalidArgument, err.Error())
+	}
+	pageSize := int(req.PageSize)
+	if pageSize <= 0 {
+		pageSize = defaultAuditPageSize
+	}
+	if pageSize > maxAuditPageSize {
+		pageSize = maxAuditPageSize
+	}
+
+	// 多查询一条判断是否还有下一页
+	filter := audit.Filter{
+		Username: req.Username,
+		Action:   req.Action,
+		AfterID:  afterID,
+		Limit:    pageSize + 1,
+	}
+	if req.StartTime > 0 {
+		filter.Start = time.Unix(req.StartTime, 0)
+	}
+	if req.EndTime > 0 {
+		filter.End = time.Unix(req.EndTime, 0)
+	}
+	events, err = s.auditQuerier.Query(filter)
+	if err != nil {
+		logger.Error("listAuditEvents", "requestID:", requestID, "query audit events, err:", err)
+		return nil, "", status.Error(codes.Internal, err.Error())
+	}
+	if len(events) > pageSize {
+		events = events[:pageSize]
+		last := strconv.FormatInt(events[pageSize-1].ID, 10)
+		nextPageToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+	}
+	return events, nextPageToken, nil
+}
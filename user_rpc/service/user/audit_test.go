@@ -0,0 +1,122 @@
+package user
+
+import (
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"testing"
+	"time"
+	"user_rpc/pkg/audit"
+	"user_rpc/pkg/util"
+	"userapi/v1"
+)
+
+// 附加 user_web 传递的客户端信息
+func withClient(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	md = md.Copy()
+	md.Set(util.ClientIPMetadataKey, "10.0.0.1")
+	md.Set(util.ClientUserAgentMetadataKey, "test-agent")
+	return metadata.NewIncomingContext(ctx, md)
+}
+
+func TestUserEventsAudited(t *testing.T) {
+	env := newAdminTestEnv(t)
+	ctx := withClient(newTestContext())
+
+	if _, err := env.svc.CreateUserProfile(ctx, &userapi.CreateUserRequest{Username: "alice", Password: "123456"}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	_, _, _, err := env.svc.Login(ctx, &userapi.LoginRequest{Username: "alice", Password: "wrong"})
+	assertCode(t, err, codes.PermissionDenied)
+	_, token, _, err := env.svc.Login(ctx, &userapi.LoginRequest{Username: "alice", Password: "123456"})
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if _, err = env.svc.EditUserProfile(withClient(env.as("alice")), &userapi.EditUserRequest{Nickname: "alice001"}); err != nil {
+		t.Fatalf("edit nickname: %v", err)
+	}
+	if _, err = env.svc.EditUserProfile(withClient(env.as("alice")), &userapi.EditUserRequest{
+		PicPath: "avatars/a.png", UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"pic_path"}},
+	}); err != nil {
+		t.Fatalf("change avatar: %v", err)
+	}
+	if err = env.svc.Logout(ctx, &userapi.AuthRequest{Token: token}); err != nil {
+		t.Fatalf("logout: %v", err)
+	}
+
+	events := env.recorder.Events()
+	expected := []struct {
+		action  string
+		outcome string
+	}{
+		{ActionRegister, audit.OutcomeSuccess},
+		{ActionLogin, audit.OutcomeFailure},
+		{ActionLogin, audit.OutcomeSuccess},
+		{ActionEditProfile, audit.OutcomeSuccess},
+		{ActionChangeAvatar, audit.OutcomeSuccess},
+		{ActionLogout, audit.OutcomeSuccess},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("unexpected audit events: %+v", events)
+	}
+	for i, event := range events {
+		if event.Action != expected[i].action || event.Outcome != expected[i].outcome || event.Actor != "alice" ||
+			event.IP != "10.0.0.1" || event.UserAgent != "test-agent" || event.RequestID != "test" {
+			t.Fatalf("unexpected audit event %d: %+v", i, event)
+		}
+	}
+
+	// 修改前后的值
+	if edit := events[3]; edit.Before["nickname"] != "" || edit.After["nickname"] != "alice001" || len(edit.After) != 1 {
+		t.Fatalf("unexpected edit event: %+v", edit)
+	}
+	if avatar := events[4]; avatar.Before["pic_path"] != "" || avatar.After["pic_path"] != "avatars/a.png" {
+		t.Fatalf("unexpected avatar event: %+v", avatar)
+	}
+}
+
+func TestListAuditEvents(t *testing.T) {
+	env := newAdminTestEnv(t, "alice", "bob")
+	for _, username := range []string{"alice", "bob", "alice"} {
+		if _, _, _, err := env.svc.Login(newTestContext(), &userapi.LoginRequest{Username: username, Password: "123456"}); err != nil {
+			t.Fatalf("login %s: %v", username, err)
+		}
+	}
+	ctx := env.as("admin")
+
+	// 按用户筛选并分页
+	events, next, err := env.svc.ListAuditEvents(ctx, &userapi.ListAuditEventsRequest{Username: "alice", PageSize: 1})
+	if err != nil || len(events) != 1 || events[0].Actor != "alice" || len(next) == 0 {
+		t.Fatalf("first page: %+v %q %v", events, next, err)
+	}
+	events, _, err = env.svc.ListAuditEvents(ctx, &userapi.ListAuditEventsRequest{
+		Username: "alice", PageSize: 1, PageToken: next,
+	})
+	if err != nil || len(events) != 1 || events[0].ID != 3 {
+		t.Fatalf("second page: %+v %v", events, err)
+	}
+
+	// 按事件类型和时间范围筛选, 查询本身也有审计记录
+	events, _, err = env.svc.ListAuditEvents(ctx, &userapi.ListAuditEventsRequest{Action: ActionListAuditEvents})
+	if err != nil || len(events) != 2 || events[0].Actor != "admin" || events[0].Target != "alice" {
+		t.Fatalf("filter by action: %+v %v", events, err)
+	}
+	events, _, err = env.svc.ListAuditEvents(ctx, &userapi.ListAuditEventsRequest{
+		StartTime: time.Now().Add(time.Hour).Unix(),
+	})
+	if err != nil || len(events) != 0 {
+		t.Fatalf("filter by time: %+v %v", events, err)
+	}
+
+	_, _, err = env.svc.ListAuditEvents(ctx, &userapi.ListAuditEventsRequest{StartTime: 200, EndTime: 100})
+	assertCode(t, err, codes.InvalidArgument)
+	_, _, err = env.svc.ListAuditEvents(ctx, &userapi.ListAuditEventsRequest{PageToken: "invalid"})
+	assertCode(t, err, codes.InvalidArgument)
+
+	// 审计日志不支持查询
+	env.svc.SetAuditQuerier(nil)
+	_, _, err = env.svc.ListAuditEvents(ctx, &userapi.ListAuditEventsRequest{})
+	assertCode(t, err, codes.Unimplemented)
+}
@@ -0,0 +1,69 @@
+package user
+
+import (
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+	userModel "user_rpc/model/user"
+	"user_rpc/pkg/audit"
+	"user_rpc/pkg/logger"
+	"user_rpc/pkg/util"
+)
+
+// ActionExportData 导出个人数据的审计事件类型
+const ActionExportData = "account.export"
+
+// 导出的审计事件和登录记录数量上限
+const (
+	maxExportAuditEvents = 10000
+	maxExportLogins      = 1000
+)
+
+// UserExport 用户的个人数据
+type UserExport struct {
+	User           userModel.User
+	ActiveSessions int
+	// AuditEvents 审计日志不支持查询时为空
+	AuditEvents []audit.Event
+	// Logins 保留期内的登录记录, 不记录登录时为空
+	Logins     []userModel.LoginRecord
+	ExportTime time.Time
+}
+
+// ExportUserData 导出个人数据逻辑, 用户信息直接查询user db
+func (s *UserService) ExportUserData(ctx context.Context) (export UserExport, err error) {
+	requestID := util.GetRequestIDFromContext(ctx)
+
+	// 检查用户是否登录
+	username, err := s.authenticate(ctx, "exportUserData", "")
+	if err != nil {
+		return export, err
+	}
+	defer func() {
+		s.recordAction(ctx, ActionExportData, username, "", nil, err)
+	}()
+
+	export.ExportTime = time.Now()
+	if export.User, err = s.getUserByUsername(ctx, "exportUserData", username); err != nil {
+		return export, err
+	}
+	if export.ActiveSessions, err = s.tokens.CountByUsername(username); err != nil {
+		logger.Error("exportUserData", "requestID:", requestID, "count user tokens, err:", err)
+		return export, status.Error(codes.Internal, err.Error())
+	}
+	if s.auditQuerier != nil {
+		filter := audit.Filter{Username: username, Limit: maxExportAuditEvents}
+		if export.AuditEvents, err = s.auditQuerier.Query(filter); err != nil {
+			logger.Error("exportUserData", "requestID:", requestID, "query audit events, err:", err)
+			return export, status.Error(codes.Internal, err.Error())
+		}
+	}
+	if s.logins != nil {
+		if export.Logins, err = s.logins.List(username, maxExportLogins); err != nil {
+			logger.Error("exportUserData", "requestID:", requestID, "list login records, err:", err)
+			return export, status.Error(codes.Internal, err.Error())
+		}
+	}
+	return export, nil
+}
@@ -0,0 +1,35 @@
+package user
+
+import (
+	"google.golang.org/grpc/codes"
+	"testing"
+	"userapi/v1"
+)
+
+func TestExportUserData(t *testing.T) {
+	env := newAdminTestEnv(t, "alice")
+	for i := 0; i < 2; i++ {
+		if _, _, _, err := env.svc.Login(newTestContext(), &userapi.LoginRequest{Username: "alice", Password: "123456"}); err != nil {
+			t.Fatalf("login: %v", err)
+		}
+	}
+	if _, err := env.svc.ResetNickname(env.as("admin"), &userapi.AdminUserRequest{Username: "alice", Reason: "offensive"}); err != nil {
+		t.Fatalf("reset nickname: %v", err)
+	}
+
+	_, err := env.svc.ExportUserData(newTestContext())
+	assertCode(t, err, codes.Unauthenticated)
+
+	// 包含用户信息、会话数和与用户相关的审计事件
+	export, err := env.svc.ExportUserData(env.as("alice"))
+	if err != nil || export.User.Username != "alice" || export.ActiveSessions != 2 {
+		t.Fatalf("export: %+v %v", export, err)
+	}
+	if len(export.AuditEvents) != 3 || export.AuditEvents[0].Action != ActionLogin ||
+		export.AuditEvents[2].Action != ActionResetNickname {
+		t.Fatalf("unexpected audit events: %+v", export.AuditEvents)
+	}
+	if events := env.recorder.Events(); events[len(events)-1].Action != ActionExportData {
+		t.Fatalf("export should be audited: %+v", events)
+	}
+}
@@ -0,0 +1,100 @@
+package user
+
+import (
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+	userDao "user_rpc/dao/user"
+	userModel "user_rpc/model/user"
+	"user_rpc/pkg/logger"
+	"user_rpc/pkg/notify"
+	"user_rpc/pkg/util"
+	"userapi/v1"
+)
+
+// 查询登录记录的数量
+const (
+	defaultLoginsLimit = 20
+	maxLoginsLimit     = 100
+)
+
+// SetLoginHistory 设置登录记录存储和新设备登录提醒的通知器, 未设置时不记录登录
+func (s *UserService) SetLoginHistory(history userDao.LoginHistory, notifier notify.Notifier) {
+	s.logins = history
+	s.notifier = notifier
+}
+
+// 根据User-Agent计算设备指纹
+func deviceFingerprint(userAgent string) string {
+	return util.Md5Data(userAgent)[:16]
+}
+
+// 写入登录记录, 新设备或新IP登录时发送提醒; 失败只记录日志, 不影响登录
+func (s *UserService) recordLogin(ctx context.Context, username string) (newDevice bool) {
+	if s.logins == nil {
+		return false
+	}
+	requestID := util.GetRequestIDFromContext(ctx)
+	ip, userAgent := util.GetClientFromContext(ctx)
+	now := time.Now()
+	record := userModel.LoginRecord{
+		Time:      now.Truncate(time.Minute),
+		IP:        ip,
+		UserAgent: userAgent,
+		Device:    deviceFingerprint(userAgent),
+	}
+	newDevice, err := s.logins.Add(username, record)
+	if err != nil {
+		logger.Error("login", "requestID:", requestID, "add login record error:", err, "username:", username)
+		return false
+	}
+	if newDevice && s.notifier != nil {
+		logger.Info("login", "requestID:", requestID, "login from new device, username:", username)
+		s.notifier.Notify(notify.Notification{
+			Kind:      notify.KindNewDevice,
+			Username:  username,
+			Time:      now,
+			RequestID: requestID,
+			Detail: map[string]string{
+				"ip":         ip,
+				"user_agent": userAgent,
+				"device":     record.Device,
+			},
+		})
+	}
+	return newDevice
+}
+
+// ListLogins 查看登录记录逻辑, 按时间倒序返回当前用户最近的登录记录
+func (s *UserService) ListLogins(ctx context.Context, req *userapi.ListLoginsRequest) ([]userModel.LoginRecord, error) {
+	requestID := util.GetRequestIDFromContext(ctx)
+
+	// 检查用户是否登录
+	username, err := s.authenticate(ctx, "listLogins", "")
+	if err != nil {
+		return nil, err
+	}
+
+	if s.logins == nil {
+		logger.Warn("listLogins", "requestID:", requestID, "login history not enabled")
+		return nil, status.Error(codes.Unimplemented, "login history not enabled")
+	}
+	limit := int(req.Limit)
+	if limit < 0 {
+		return nil, status.Error(codes.InvalidArgument, "invalid limit")
+	}
+	if limit == 0 {
+		limit = defaultLoginsLimit
+	}
+	if limit > maxLoginsLimit {
+		limit = maxLoginsLimit
+	}
+
+	records, err := s.logins.List(username, limit)
+	if err != nil {
+		logger.Error("listLogins", "requestID:", requestID, "list login records error:", err, "username:", username)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return records, nil
+}
@@ -0,0 +1,92 @@
+package user
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"testing"
+	"time"
+	userDao "user_rpc/dao/user"
+	userModel "user_rpc/model/user"
+	"user_rpc/pkg/notify"
+	"user_rpc/pkg/util"
+	"userapi/v1"
+)
+
+// 以指定的客户端登录
+func (env *adminTestEnv) loginFrom(t *testing.T, username string, ip string, userAgent string) {
+	t.Helper()
+	ctx := metadata.NewIncomingContext(newTestContext(), metadata.Pairs("request_id", "test",
+		util.ClientIPMetadataKey, ip, util.ClientUserAgentMetadataKey, userAgent))
+	if _, _, _, err := env.svc.Login(ctx, &userapi.LoginRequest{Username: username, Password: "123456"}); err != nil {
+		t.Fatalf("login %s: %v", username, err)
+	}
+}
+
+func TestLoginHistory(t *testing.T) {
+	env := newAdminTestEnv(t, "alice")
+	history := userDao.NewMemoryLoginHistory(3, time.Hour)
+	notifier := &notify.MemoryNotifier{}
+	env.svc.SetLoginHistory(history, notifier)
+
+	// 首次登录和已知设备不提醒, 新的设备或IP提醒
+	env.loginFrom(t, "alice", "10.0.0.1", "laptop")
+	env.loginFrom(t, "alice", "10.0.0.1", "laptop")
+	env.loginFrom(t, "alice", "10.0.0.1", "phone")
+	env.loginFrom(t, "alice", "10.0.0.2", "phone")
+	notifications := notifier.Notifications()
+	if len(notifications) != 2 || notifications[0].Kind != notify.KindNewDevice ||
+		notifications[0].Username != "alice" || notifications[0].Detail["user_agent"] != "phone" ||
+		notifications[1].Detail["ip"] != "10.0.0.2" {
+		t.Fatalf("unexpected notifications: %+v", notifications)
+	}
+	logins := env.events(ActionLogin)
+	if len(logins) != 4 || logins[0].Detail["new_device"] != "" || logins[2].Detail["new_device"] != "true" {
+		t.Fatalf("unexpected login events: %+v", logins)
+	}
+
+	// 按时间倒序, 只保留最近的记录
+	records, err := env.svc.ListLogins(env.as("alice"), &userapi.ListLoginsRequest{})
+	if err != nil || len(records) != 3 || records[0].IP != "10.0.0.2" || !records[0].NewDevice ||
+		records[2].NewDevice || records[2].Device != deviceFingerprint("laptop") {
+		t.Fatalf("list logins: %+v %v", records, err)
+	}
+	if records, err = env.svc.ListLogins(env.as("alice"), &userapi.ListLoginsRequest{Limit: 1}); err != nil || len(records) != 1 {
+		t.Fatalf("list logins with limit: %+v %v", records, err)
+	}
+	_, err = env.svc.ListLogins(env.as("alice"), &userapi.ListLoginsRequest{Limit: -1})
+	assertCode(t, err, codes.InvalidArgument)
+	_, err = env.svc.ListLogins(newTestContext(), &userapi.ListLoginsRequest{})
+	assertCode(t, err, codes.Unauthenticated)
+
+	// 导出的个人数据包含登录记录
+	export, err := env.svc.ExportUserData(env.as("alice"))
+	if err != nil || len(export.Logins) != 3 {
+		t.Fatalf("export: %+v %v", export.Logins, err)
+	}
+
+	// 未设置时不记录登录
+	env.svc.SetLoginHistory(nil, nil)
+	_, err = env.svc.ListLogins(env.as("alice"), &userapi.ListLoginsRequest{})
+	assertCode(t, err, codes.Unimplemented)
+}
+
+func TestLoginHistoryRetention(t *testing.T) {
+	env := newAdminTestEnv(t, "alice")
+	history := userDao.NewMemoryLoginHistory(10, time.Hour)
+	notifier := &notify.MemoryNotifier{}
+	env.svc.SetLoginHistory(history, notifier)
+
+	// 保留期之外的设备和记录不再使用
+	old := userModel.LoginRecord{Time: time.Now().Add(-2 * time.Hour), IP: "10.0.0.1", Device: deviceFingerprint("laptop")}
+	if _, err := history.Add("alice", old); err != nil {
+		t.Fatalf("add old record: %v", err)
+	}
+	env.loginFrom(t, "alice", "10.0.0.2", "phone")
+	if notifications := notifier.Notifications(); len(notifications) != 0 {
+		t.Fatalf("unexpected notifications: %+v", notifications)
+	}
+	records, err := env.svc.ListLogins(env.as("alice"), &userapi.ListLoginsRequest{})
+	if err != nil || len(records) != 1 || records[0].IP != "10.0.0.2" {
+		t.Fatalf("list logins: %+v %v", records, err)
+	}
+}
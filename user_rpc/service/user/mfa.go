@@ -0,0 +1,294 @@
+package user
+
+import (
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+	userDao "user_rpc/dao/user"
+	userModel "user_rpc/model/user"
+	"user_rpc/pkg/audit"
+	"user_rpc/pkg/logger"
+	"user_rpc/pkg/mfa"
+	"user_rpc/pkg/util"
+	"userapi/v1"
+)
+
+// 两步验证的审计事件类型, 第二步验证的结果记录为 ActionLogin
+const (
+	ActionMFAChallenge = "user.mfa_challenge"
+	ActionEnrollMFA    = "user.mfa_enroll"
+	ActionEnableMFA    = "user.mfa_enable"
+	ActionDisableMFA   = "user.mfa_disable"
+)
+
+// 两步验证的验证方式, 记录在审计事件中
+const (
+	mfaMethodTOTP         = "totp"
+	mfaMethodRecoveryCode = "recovery_code"
+)
+
+// DefaultMFAChallengeTTL 两步验证挑战的默认有效期
+const DefaultMFAChallengeTTL = 5 * time.Minute
+
+// 同一个挑战允许的验证失败次数, 超过后需要重新校验密码
+const maxMFAFailures = 5
+
+// SetMFA 设置两步验证器和挑战存储, 未设置时不能开启两步验证, 已开启的账号不能登录
+func (s *UserService) SetMFA(authenticator *mfa.Authenticator, challenges userDao.MFAChallengeStore, ttl time.Duration) {
+	s.mfa = authenticator
+	s.mfaChallenges = challenges
+	if ttl > 0 {
+		s.mfaChallengeTTL = ttl
+	}
+}
+
+// 签发两步验证挑战
+func (s *UserService) createMFAChallenge(ctx context.Context, username string) (string, error) {
+	requestID := util.GetRequestIDFromContext(ctx)
+	if s.mfa == nil || s.mfaChallenges == nil {
+		logger.Error("login", "requestID:", requestID, "mfa enabled but not configured, username:", username)
+		return "", status.Error(codes.Internal, "two-factor authentication not configured")
+	}
+	challenge, err := mfa.NewChallenge()
+	if err != nil {
+		logger.Error("login", "requestID:", requestID, "generate mfa challenge error:", err, "username:", username)
+		return "", status.Error(codes.Internal, err.Error())
+	}
+	if err := s.mfaChallenges.Set(challenge, username, s.mfaChallengeTTL); err != nil {
+		logger.Error("login", "requestID:", requestID, "set mfa challenge error:", err, "username:", username)
+		return "", status.Error(codes.Internal, err.Error())
+	}
+	return challenge, nil
+}
+
+// 校验验证码或恢复码, 返回需要更新的字段和验证方式
+// 验证码记录时间步防止重复使用, 恢复码使用后删除
+func (s *UserService) checkMFACode(ctx context.Context, moduleName string, user userModel.User, code string) (
+	columns map[string]interface{}, method string, err error) {
+	requestID := util.GetRequestIDFromContext(ctx)
+	if s.mfa == nil {
+		logger.Error(moduleName, "requestID:", requestID, "mfa not configured")
+		return nil, "", status.Error(codes.Internal, "two-factor authentication not configured")
+	}
+
+	if mfa.IsTOTPCode(code) {
+		secret, err := s.mfa.Open(user.MFASecret)
+		if err != nil {
+			logger.Error(moduleName, "requestID:", requestID, "open mfa secret error:", err, "username:", user.Username)
+			return nil, "", status.Error(codes.Internal, err.Error())
+		}
+		if step, ok := mfa.ValidateCode(secret, code, time.Now(), user.MFALastStep); ok {
+			return map[string]interface{}{"mfa_last_step": step}, mfaMethodTOTP, nil
+		}
+	} else if remaining, ok := mfa.UseRecoveryCode(user.MFARecoveryCodes, code); ok {
+		return map[string]interface{}{"mfa_recovery_codes": remaining}, mfaMethodRecoveryCode, nil
+	}
+
+	logger.Warn(moduleName, "requestID:", requestID, "invalid mfa code, username:", user.Username)
+	return nil, "", util.ErrorWithReason(codes.PermissionDenied, userapi.ReasonMFACodeInvalid, "invalid verification code")
+}
+
+// VerifyMFA 两步验证登录逻辑, 校验挑战和验证码后签发token; 失败次数过多时挑战失效
+func (s *UserService) VerifyMFA(ctx context.Context, req *userapi.VerifyMFARequest) (
+	user userModel.User, token string, err error) {
+	requestID := util.GetRequestIDFromContext(ctx)
+	var username string
+	detail := make(map[string]string)
+	defer func() {
+		s.recordEvent(ctx, audit.Event{Action: ActionLogin, Actor: username, Detail: detail}, err)
+	}()
+
+	if s.mfaChallenges == nil {
+		return user, "", status.Error(codes.Unimplemented, "two-factor authentication not enabled")
+	}
+	challengeErr := util.ErrorWithReason(codes.Unauthenticated, userapi.ReasonMFAChallengeInvalid,
+		"invalid or expired mfa challenge")
+	if username, err = s.mfaChallenges.Get(req.Challenge); err != nil {
+		logger.Error("verifyMFA", "requestID:", requestID, "get mfa challenge error:", err)
+		return user, "", status.Error(codes.Internal, err.Error())
+	}
+	if len(username) == 0 {
+		logger.Warn("verifyMFA", "requestID:", requestID, "mfa challenge not exist")
+		return user, "", challengeErr
+	}
+
+	// 签发挑战后账号可能被禁用或关闭了两步验证
+	if user, err = s.getUserByUsername(ctx, "verifyMFA", username); err != nil {
+		return user, "", err
+	}
+	if err = accountStatusError("verifyMFA", requestID, user); err != nil {
+		return user, "", err
+	}
+	if !user.MFAEnabled {
+		logger.Warn("verifyMFA", "requestID:", requestID, "mfa disabled after challenge, username:", username)
+		_ = s.mfaChallenges.Del(req.Challenge)
+		return user, "", challengeErr
+	}
+
+	columns, method, err := s.checkMFACode(ctx, "verifyMFA", user, req.Code)
+	if status.Code(err) == codes.PermissionDenied {
+		failures, failErr := s.mfaChallenges.Fail(req.Challenge)
+		if failErr == nil && failures >= maxMFAFailures {
+			logger.Warn("verifyMFA", "requestID:", requestID, "too many mfa failures, username:", username)
+			failErr = s.mfaChallenges.Del(req.Challenge)
+		}
+		if failErr != nil {
+			logger.Error("verifyMFA", "requestID:", requestID, "record mfa failure error:", failErr)
+		}
+	}
+	if err != nil {
+		return user, "", err
+	}
+	detail["mfa"] = method
+
+	// 按读取时的版本号更新, 同一个验证码并发使用时只有一个请求成功
+	if user, err = s.updateColumns(ctx, "verifyMFA", username, user.Version, columns); err != nil {
+		return user, "", err
+	}
+	if err = s.mfaChallenges.Del(req.Challenge); err != nil {
+		logger.Error("verifyMFA", "requestID:", requestID, "delete mfa challenge error:", err)
+		return user, "", status.Error(codes.Internal, err.Error())
+	}
+	token, newDevice, err := s.issueToken(ctx, "verifyMFA", user)
+	if newDevice {
+		detail["new_device"] = "true"
+	}
+	return user, token, err
+}
+
+// EnrollMFA 开始设置两步验证逻辑, 生成新的TOTP密钥, 确认前重复调用会替换密钥
+func (s *UserService) EnrollMFA(ctx context.Context) (secret string, uri string, err error) {
+	requestID := util.GetRequestIDFromContext(ctx)
+
+	// 检查用户是否登录
+	username, err := s.authenticate(ctx, "enrollMFA", "")
+	if err != nil {
+		return "", "", err
+	}
+	defer func() {
+		s.recordEvent(ctx, audit.Event{Action: ActionEnrollMFA, Actor: username}, err)
+	}()
+
+	if s.mfa == nil {
+		logger.Warn("enrollMFA", "requestID:", requestID, "mfa not configured")
+		return "", "", status.Error(codes.Unimplemented, "two-factor authentication not enabled")
+	}
+	user, err := s.getUserByUsername(ctx, "enrollMFA", username)
+	if err != nil {
+		return "", "", err
+	}
+	if user.MFAEnabled {
+		logger.Warn("enrollMFA", "requestID:", requestID, "mfa already enabled, username:", username)
+		return "", "", util.ErrorWithReason(codes.FailedPrecondition, userapi.ReasonMFAAlreadyEnabled,
+			"two-factor authentication already enabled")
+	}
+
+	secret, sealed, err := s.mfa.NewSecret()
+	if err != nil {
+		logger.Error("enrollMFA", "requestID:", requestID, "generate mfa secret error:", err)
+		return "", "", status.Error(codes.Internal, err.Error())
+	}
+	_, err = s.updateColumns(ctx, "enrollMFA", username, user.Version, map[string]interface{}{
+		"mfa_secret":    sealed,
+		"mfa_last_step": int64(0),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return secret, s.mfa.ProvisioningURI(username, secret), nil
+}
+
+// ConfirmMFA 确认开启两步验证逻辑, 校验验证码后开启并返回一次性恢复码
+func (s *UserService) ConfirmMFA(ctx context.Context, req *userapi.ConfirmMFARequest) (recoveryCodes []string, err error) {
+	requestID := util.GetRequestIDFromContext(ctx)
+
+	// 检查用户是否登录
+	username, err := s.authenticate(ctx, "confirmMFA", "")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		s.recordEvent(ctx, audit.Event{Action: ActionEnableMFA, Actor: username}, err)
+	}()
+
+	if s.mfa == nil {
+		logger.Warn("confirmMFA", "requestID:", requestID, "mfa not configured")
+		return nil, status.Error(codes.Unimplemented, "two-factor authentication not enabled")
+	}
+	user, err := s.getUserByUsername(ctx, "confirmMFA", username)
+	if err != nil {
+		return nil, err
+	}
+	if user.MFAEnabled {
+		logger.Warn("confirmMFA", "requestID:", requestID, "mfa already enabled, username:", username)
+		return nil, util.ErrorWithReason(codes.FailedPrecondition, userapi.ReasonMFAAlreadyEnabled,
+			"two-factor authentication already enabled")
+	}
+	if len(user.MFASecret) == 0 {
+		logger.Warn("confirmMFA", "requestID:", requestID, "mfa not enrolled, username:", username)
+		return nil, util.ErrorWithReason(codes.FailedPrecondition, userapi.ReasonMFANotEnabled,
+			"two-factor authentication not enrolled")
+	}
+
+	// 确认时只接受验证码, 不接受恢复码
+	if !mfa.IsTOTPCode(req.Code) {
+		logger.Warn("confirmMFA", "requestID:", requestID, "invalid mfa code, username:", username)
+		return nil, util.ErrorWithReason(codes.PermissionDenied, userapi.ReasonMFACodeInvalid, "invalid verification code")
+	}
+	columns, _, err := s.checkMFACode(ctx, "confirmMFA", user, req.Code)
+	if err != nil {
+		return nil, err
+	}
+	recoveryCodes, hashes, err := mfa.NewRecoveryCodes()
+	if err != nil {
+		logger.Error("confirmMFA", "requestID:", requestID, "generate recovery codes error:", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	columns["mfa_enabled"] = true
+	columns["mfa_recovery_codes"] = hashes
+	if _, err = s.updateColumns(ctx, "confirmMFA", username, user.Version, columns); err != nil {
+		return nil, err
+	}
+	return recoveryCodes, nil
+}
+
+// DisableMFA 关闭两步验证逻辑, 需要再次校验密码和验证码或恢复码
+func (s *UserService) DisableMFA(ctx context.Context, req *userapi.DisableMFARequest) (err error) {
+	requestID := util.GetRequestIDFromContext(ctx)
+
+	// 检查用户是否登录
+	username, err := s.authenticate(ctx, "disableMFA", "")
+	if err != nil {
+		return err
+	}
+	detail := make(map[string]string)
+	defer func() {
+		s.recordEvent(ctx, audit.Event{Action: ActionDisableMFA, Actor: username, Detail: detail}, err)
+	}()
+
+	user, err := s.getUserByUsername(ctx, "disableMFA", username)
+	if err != nil {
+		return err
+	}
+	if !user.MFAEnabled {
+		logger.Warn("disableMFA", "requestID:", requestID, "mfa not enabled, username:", username)
+		return util.ErrorWithReason(codes.FailedPrecondition, userapi.ReasonMFANotEnabled,
+			"two-factor authentication not enabled")
+	}
+	if ok := util.ComparePwdHash(req.Password, user.Password, user.Salt); !ok {
+		logger.Warn("disableMFA", "requestID:", requestID, "password incorrect, username:", username)
+		return status.Error(codes.PermissionDenied, "password incorrect")
+	}
+	if _, detail["mfa"], err = s.checkMFACode(ctx, "disableMFA", user, req.Code); err != nil {
+		return err
+	}
+
+	_, err = s.updateColumns(ctx, "disableMFA", username, user.Version, map[string]interface{}{
+		"mfa_secret":         "",
+		"mfa_enabled":        false,
+		"mfa_recovery_codes": "",
+		"mfa_last_step":      int64(0),
+	})
+	return err
+}
@@ -0,0 +1,179 @@
+package user
+
+import (
+	"google.golang.org/grpc/codes"
+	"testing"
+	"time"
+	userDao "user_rpc/dao/user"
+	"user_rpc/pkg/audit"
+	"user_rpc/pkg/mfa"
+	"userapi/v1"
+)
+
+// 开启两步验证, 返回TOTP密钥和恢复码
+func (env *adminTestEnv) enableMFA(t *testing.T, username string) (string, []string) {
+	t.Helper()
+	secret, uri, err := env.svc.EnrollMFA(env.as(username))
+	if err != nil || len(uri) == 0 {
+		t.Fatalf("enroll mfa: %q %v", uri, err)
+	}
+	code, _ := mfa.Code(secret, time.Now())
+	recoveryCodes, err := env.svc.ConfirmMFA(env.as(username), &userapi.ConfirmMFARequest{Code: code})
+	if err != nil || len(recoveryCodes) != mfa.RecoveryCodeCount {
+		t.Fatalf("confirm mfa: %v %v", recoveryCodes, err)
+	}
+	return secret, recoveryCodes
+}
+
+// 校验密码后返回两步验证挑战
+func (env *adminTestEnv) mfaChallenge(t *testing.T, username string) string {
+	t.Helper()
+	_, token, challenge, err := env.svc.Login(newTestContext(), &userapi.LoginRequest{Username: username, Password: "123456"})
+	if err != nil || len(token) != 0 || len(challenge) == 0 {
+		t.Fatalf("login with mfa: %q %q %v", token, challenge, err)
+	}
+	return challenge
+}
+
+func newMFATestEnv(t *testing.T, usernames ...string) *adminTestEnv {
+	t.Helper()
+	env := newAdminTestEnv(t, usernames...)
+	authenticator, err := mfa.NewAuthenticator("user_system", []byte("mfa key"))
+	if err != nil {
+		t.Fatalf("new authenticator: %v", err)
+	}
+	env.svc.SetMFA(authenticator, userDao.NewMemoryMFAChallengeStore(), time.Minute)
+	return env
+}
+
+func TestMFANotConfigured(t *testing.T) {
+	env := newAdminTestEnv(t, "alice")
+	_, _, err := env.svc.EnrollMFA(env.as("alice"))
+	assertCode(t, err, codes.Unimplemented)
+
+	// 已开启两步验证但未配置时拒绝登录
+	if _, err = env.repo.UpdateColumnsByUsername("alice", 0, map[string]interface{}{"mfa_enabled": true}); err != nil {
+		t.Fatalf("enable mfa: %v", err)
+	}
+	_, _, _, err = env.svc.Login(newTestContext(), &userapi.LoginRequest{Username: "alice", Password: "123456"})
+	assertCode(t, err, codes.Internal)
+}
+
+func TestEnableMFA(t *testing.T) {
+	env := newMFATestEnv(t, "alice")
+	secret, _, err := env.svc.EnrollMFA(env.as("alice"))
+	if err != nil {
+		t.Fatalf("enroll mfa: %v", err)
+	}
+
+	// 确认前登录不需要两步验证, 确认只接受正确的验证码
+	if _, token, _, err := env.svc.Login(newTestContext(), &userapi.LoginRequest{Username: "alice", Password: "123456"}); err != nil || len(token) == 0 {
+		t.Fatalf("login before confirm: %q %v", token, err)
+	}
+	_, err = env.svc.ConfirmMFA(env.as("alice"), &userapi.ConfirmMFARequest{Code: "ABCDE-FGHIJ"})
+	if reason := errorReason(t, err, codes.PermissionDenied); reason != userapi.ReasonMFACodeInvalid {
+		t.Fatalf("unexpected reason: %s", reason)
+	}
+	code, _ := mfa.Code(secret, time.Now())
+	if _, err = env.svc.ConfirmMFA(env.as("alice"), &userapi.ConfirmMFARequest{Code: code}); err != nil {
+		t.Fatalf("confirm mfa: %v", err)
+	}
+	user, _ := env.repo.GetByUsername("alice")
+	if !user.MFAEnabled || len(user.MFASecret) == 0 || user.MFASecret == secret || len(user.MFARecoveryCodes) == 0 {
+		t.Fatalf("unexpected user: %+v", user)
+	}
+
+	_, _, err = env.svc.EnrollMFA(env.as("alice"))
+	if reason := errorReason(t, err, codes.FailedPrecondition); reason != userapi.ReasonMFAAlreadyEnabled {
+		t.Fatalf("unexpected reason: %s", reason)
+	}
+	if events := env.events("user.mfa_"); len(events) != 4 || events[2].Action != ActionEnableMFA || events[2].Outcome != audit.OutcomeSuccess {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+}
+
+func TestVerifyMFA(t *testing.T) {
+	env := newMFATestEnv(t, "alice")
+	secret, recoveryCodes := env.enableMFA(t, "alice")
+
+	// 挑战无效, 确认时使用过的验证码不能再次使用
+	_, _, err := env.svc.VerifyMFA(newTestContext(), &userapi.VerifyMFARequest{Challenge: "invalid", Code: "123456"})
+	if reason := errorReason(t, err, codes.Unauthenticated); reason != userapi.ReasonMFAChallengeInvalid {
+		t.Fatalf("unexpected reason: %s", reason)
+	}
+	challenge := env.mfaChallenge(t, "alice")
+	enabled, _ := env.repo.GetByUsername("alice")
+	code, _ := mfa.Code(secret, time.Unix(enabled.MFALastStep*int64(mfa.Period/time.Second), 0))
+	_, _, err = env.svc.VerifyMFA(newTestContext(), &userapi.VerifyMFARequest{Challenge: challenge, Code: code})
+	if reason := errorReason(t, err, codes.PermissionDenied); reason != userapi.ReasonMFACodeInvalid {
+		t.Fatalf("unexpected reason: %s", reason)
+	}
+
+	// 下一个时间步的验证码通过, 挑战只能使用一次
+	code, _ = mfa.Code(secret, time.Now().Add(mfa.Period))
+	user, token, err := env.svc.VerifyMFA(newTestContext(), &userapi.VerifyMFARequest{Challenge: challenge, Code: code})
+	if err != nil || len(token) == 0 || user.Username != "alice" {
+		t.Fatalf("verify mfa: %q %v", token, err)
+	}
+	if _, _, _, err = env.svc.Authenticate(newTestContext(), &userapi.AuthRequest{Token: token}); err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	_, _, err = env.svc.VerifyMFA(newTestContext(), &userapi.VerifyMFARequest{Challenge: challenge, Code: code})
+	assertCode(t, err, codes.Unauthenticated)
+
+	// 恢复码只能使用一次
+	request := &userapi.VerifyMFARequest{Challenge: env.mfaChallenge(t, "alice"), Code: recoveryCodes[0]}
+	if _, _, err = env.svc.VerifyMFA(newTestContext(), request); err != nil {
+		t.Fatalf("verify with recovery code: %v", err)
+	}
+	request.Challenge = env.mfaChallenge(t, "alice")
+	_, _, err = env.svc.VerifyMFA(newTestContext(), request)
+	assertCode(t, err, codes.PermissionDenied)
+
+	events := env.events("user.login", "user.mfa_challenge")
+	last := events[len(events)-3]
+	if last.Action != ActionLogin || last.Actor != "alice" || last.Detail["mfa"] != "recovery_code" {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+}
+
+func TestVerifyMFAFailureLimit(t *testing.T) {
+	env := newMFATestEnv(t, "alice")
+	secret, _ := env.enableMFA(t, "alice")
+	challenge := env.mfaChallenge(t, "alice")
+	for i := 0; i < maxMFAFailures; i++ {
+		_, _, err := env.svc.VerifyMFA(newTestContext(), &userapi.VerifyMFARequest{Challenge: challenge, Code: "000000"})
+		assertCode(t, err, codes.PermissionDenied)
+	}
+
+	// 失败次数过多后挑战失效, 正确的验证码也不能通过
+	code, _ := mfa.Code(secret, time.Now().Add(mfa.Period))
+	_, _, err := env.svc.VerifyMFA(newTestContext(), &userapi.VerifyMFARequest{Challenge: challenge, Code: code})
+	assertCode(t, err, codes.Unauthenticated)
+}
+
+func TestDisableMFA(t *testing.T) {
+	env := newMFATestEnv(t, "alice")
+	_, recoveryCodes := env.enableMFA(t, "alice")
+
+	// 需要密码和验证码
+	err := env.svc.DisableMFA(env.as("alice"), &userapi.DisableMFARequest{Password: "wrong", Code: recoveryCodes[0]})
+	assertCode(t, err, codes.PermissionDenied)
+	err = env.svc.DisableMFA(env.as("alice"), &userapi.DisableMFARequest{Password: "123456", Code: "000000"})
+	assertCode(t, err, codes.PermissionDenied)
+	if err = env.svc.DisableMFA(env.as("alice"), &userapi.DisableMFARequest{Password: "123456", Code: recoveryCodes[0]}); err != nil {
+		t.Fatalf("disable mfa: %v", err)
+	}
+
+	user, _ := env.repo.GetByUsername("alice")
+	if user.MFAEnabled || len(user.MFASecret) != 0 || len(user.MFARecoveryCodes) != 0 {
+		t.Fatalf("unexpected user: %+v", user)
+	}
+	if _, token, _, err := env.svc.Login(newTestContext(), &userapi.LoginRequest{Username: "alice", Password: "123456"}); err != nil || len(token) == 0 {
+		t.Fatalf("login after disable: %q %v", token, err)
+	}
+	err = env.svc.DisableMFA(env.as("alice"), &userapi.DisableMFARequest{Password: "123456", Code: recoveryCodes[1]})
+	if reason := errorReason(t, err, codes.FailedPrecondition); reason != userapi.ReasonMFANotEnabled {
+		t.Fatalf("unexpected reason: %s", reason)
+	}
+}
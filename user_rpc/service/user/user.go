@@ -2,34 +2,171 @@ package user
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"time"
 	userDao "user_rpc/dao/user"
 	userModel "user_rpc/model/user"
+	"user_rpc/pkg/audit"
+	"user_rpc/pkg/identity"
 	"user_rpc/pkg/logger"
+	"user_rpc/pkg/mfa"
+	"user_rpc/pkg/notify"
 	"user_rpc/pkg/util"
-	"user_rpc/proto"
+	"userapi/v1"
 )
 
-// 检查用户是否登录
-func checkAuth(moduleName string, token string, requestID string) (string, error) {
-	username, err := userDao.GetTokenCache(token)
+// 用户操作的审计事件类型
+const (
+	ActionRegister     = "user.register"
+	ActionLogin        = "user.login"
+	ActionLogout       = "user.logout"
+	ActionEditProfile  = "user.edit_profile"
+	ActionChangeAvatar = "user.change_avatar"
+)
+
+// UserService 用户服务逻辑
+type UserService struct {
+	repo   userDao.UserRepository
+	tokens userDao.TokenStore
+	cache  userDao.UserCache
+	signer *identity.Signer
+	audit  audit.Recorder
+
+	auditQuerier      audit.Querier
+	logins            userDao.LoginHistory
+	notifier          notify.Notifier
+	deletionRetention time.Duration
+	mfa               *mfa.Authenticator
+	mfaChallenges     userDao.MFAChallengeStore
+	mfaChallengeTTL   time.Duration
+}
+
+// NewUserService 创建用户服务逻辑, 注入用户存储、token存储、用户缓存、身份凭证签发器和审计记录器
+func NewUserService(repo userDao.UserRepository, tokens userDao.TokenStore, cache userDao.UserCache,
+	signer *identity.Signer, recorder audit.Recorder) *UserService {
+	return &UserService{
+		repo:   repo,
+		tokens: tokens,
+		cache:  cache,
+		signer: signer,
+		audit:  recorder,
+
+		deletionRetention: DefaultDeletionRetention,
+		mfaChallengeTTL:   DefaultMFAChallengeTTL,
+	}
+}
+
+// 检查账号状态, 只有正常的账号可以登录和访问; 已注销的账号视为不存在
+func accountStatusError(moduleName string, requestID string, user userModel.User) error {
+	switch user.Status {
+	case userModel.StatusActive:
+		return nil
+	case userModel.StatusDisabled:
+		logger.Warn(moduleName, "requestID:", requestID, "account disabled, username:", user.Username)
+		return util.ErrorWithReason(codes.PermissionDenied, userapi.ReasonAccountDisabled, "account disabled")
+	case userModel.StatusPendingDeletion:
+		logger.Warn(moduleName, "requestID:", requestID, "account pending deletion, username:", user.Username)
+		return util.ErrorWithReason(codes.PermissionDenied, userapi.ReasonAccountPendingDeletion, "account pending deletion")
+	default:
+		logger.Warn(moduleName, "requestID:", requestID, "account deleted, username:", user.Username)
+		return status.Error(codes.NotFound, "user not found")
+	}
+}
+
+// 检查用户是否登录, 区分过期和无效的token; 账号被禁用或已申请注销时拒绝, 已不存在或已注销时token视为无效
+func (s *UserService) checkAuth(ctx context.Context, moduleName string, token string) (user userModel.User, err error) {
+	requestID := util.GetRequestIDFromContext(ctx)
+	username, err := s.tokens.Get(token)
 	if err == nil && len(username) == 0 {
+		expired, err := s.tokens.Expired(token)
+		if err != nil {
+			logger.Error(moduleName, "requestID:", requestID, "get token issued record, err:", err)
+			return user, status.Error(codes.Internal, err.Error())
+		}
+		if expired {
+			logger.Warn(moduleName, "requestID:", requestID, "token expired, token:", token)
+			return user, util.ErrorWithReason(codes.Unauthenticated, userapi.ReasonTokenExpired, "token expired")
+		}
 		logger.Warn(moduleName, "requestID:", requestID, "not token cache, token:", token)
-		return "", status.Error(codes.Unauthenticated, "unauthorized")
+		return user, util.ErrorWithReason(codes.Unauthenticated, userapi.ReasonTokenInvalid, "unauthorized")
 	}
 	if err != nil {
 		logger.Error(moduleName, "requestID:", requestID, "get token cache, err:", err)
-		return "", status.Error(codes.Internal, err.Error())
+		return user, status.Error(codes.Internal, err.Error())
+	}
+	return s.activeUser(ctx, moduleName, username)
+}
+
+// 获取登录用户的信息并检查账号状态, 用户已不存在或已注销时视为未登录
+func (s *UserService) activeUser(ctx context.Context, moduleName string, username string) (user userModel.User, err error) {
+	user, err = s.getProfile(ctx, moduleName, username)
+	if err == nil {
+		err = accountStatusError(moduleName, util.GetRequestIDFromContext(ctx), user)
+	}
+	if status.Code(err) == codes.NotFound {
+		return user, util.ErrorWithReason(codes.Unauthenticated, userapi.ReasonTokenInvalid, "unauthorized")
+	}
+	return user, err
+}
+
+// 获取请求的用户名, 优先使用 user_web 转发的身份凭证, 没有凭证时校验token; 两种方式都检查账号状态
+func (s *UserService) authenticate(ctx context.Context, moduleName string, token string) (string, error) {
+	requestID := util.GetRequestIDFromContext(ctx)
+	username, err := s.signer.FromContext(ctx)
+	if err != nil {
+		logger.Warn(moduleName, "requestID:", requestID, "invalid identity, err:", err)
+		return "", util.ErrorWithReason(codes.Unauthenticated, userapi.ReasonTokenInvalid, "unauthorized")
+	}
+	var user userModel.User
+	if len(username) > 0 {
+		user, err = s.activeUser(ctx, moduleName, username)
+	} else {
+		user, err = s.checkAuth(ctx, moduleName, token)
+	}
+	if err != nil {
+		return "", err
+	}
+	return user.Username, nil
+}
+
+// 获取用户信息, 优先查询user cache
+func (s *UserService) getProfile(ctx context.Context, moduleName string, username string) (user userModel.User, err error) {
+	requestID := util.GetRequestIDFromContext(ctx)
+
+	// 查询user cache
+	user, err = s.cache.Get(username)
+	if err != nil {
+		logger.Error(moduleName, "requestID:", requestID, "get user cache, err:", err)
+		return user, status.Error(codes.Internal, err.Error())
 	}
-	return username, nil
+
+	// user cache不存在
+	if err == nil && user.ID == 0 {
+		logger.Debug(moduleName, "requestID:", requestID, "not user cache, username:", username)
+
+		// 查询user db
+		user, err = s.getUserByUsername(ctx, moduleName, username)
+		if err != nil {
+			return user, err
+		}
+
+		// 写入user cache
+		if err = s.cache.Set(username, user); err != nil {
+			logger.Error(moduleName, "requestID:", requestID, "set user cache, err:", err)
+			return user, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	return user, nil
 }
 
 // 根据用户名查询用户
-func getUserByUsername(ctx context.Context, moduleName string, username string) (userModel.User, error) {
+func (s *UserService) getUserByUsername(ctx context.Context, moduleName string, username string) (userModel.User, error) {
 	requestID := util.GetRequestIDFromContext(ctx)
-	user, err := userDao.GetByUsername(username)
+	user, err := s.repo.GetByUsername(username)
 	if err == nil && user.ID == 0 {
 		logger.Warn(moduleName, "requestID:", requestID,
 			"get user db not exist, username:", username)
@@ -42,58 +179,98 @@ func getUserByUsername(ctx context.Context, moduleName string, username string)
 	return user, nil
 }
 
-// Login 登录逻辑
-func Login(ctx context.Context, req *proto.LoginRequest) (user userModel.User, token string, err error) {
+// Login 登录逻辑, 成功和失败都记录审计事件, 成功时写入登录记录
+// 开启两步验证的账号密码校验通过后不签发token, 返回两步验证挑战
+func (s *UserService) Login(ctx context.Context, req *userapi.LoginRequest) (
+	user userModel.User, token string, challenge string, err error) {
 	requestID := util.GetRequestIDFromContext(ctx)
+	action := ActionLogin
+	var detail map[string]string
+	defer func() {
+		s.recordEvent(ctx, audit.Event{Action: action, Actor: req.Username, Detail: detail}, err)
+	}()
 
 	// 根据用户名查询用户
-	user, err = userDao.GetByUsername(req.Username)
+	user, err = s.repo.GetByUsername(req.Username)
 	if err == nil && user.ID == 0 {
 		logger.Warn("login", "requestID:", requestID, "username not exist, username:", req.Username)
-		return user, "", status.Error(codes.NotFound, "username not exist")
+		return user, "", "", status.Error(codes.NotFound, "username not exist")
 	}
 	if err != nil {
 		logger.Error("login", "requestID:", requestID, fmt.Sprintf("get user error: %s, username: %s", err.Error(), req.Username))
-		return user, "", status.Error(codes.Internal, err.Error())
+		return user, "", "", status.Error(codes.Internal, err.Error())
+	}
+	if user.Status == userModel.StatusDeleted {
+		logger.Warn("login", "requestID:", requestID, "account deleted, username:", req.Username)
+		return user, "", "", status.Error(codes.NotFound, "username not exist")
 	}
 
 	// 校验密码
 	if ok := util.ComparePwdHash(req.Password, user.Password, user.Salt); !ok {
 		logger.Warn("login", "requestID:", requestID, fmt.Sprintf("password incorrect, password: %s, salt:%s",
 			req.Password, user.Salt))
-		return user, "", status.Error(codes.PermissionDenied, "password incorrect")
+		return user, "", "", status.Error(codes.PermissionDenied, "password incorrect")
 	}
 
+	// 禁用和待注销的账号不能登录
+	if err = accountStatusError("login", requestID, user); err != nil {
+		return user, "", "", err
+	}
+
+	// 开启两步验证时签发挑战, 通过 VerifyMFA 完成登录
+	if user.MFAEnabled {
+		action = ActionMFAChallenge
+		challenge, err = s.createMFAChallenge(ctx, user.Username)
+		return user, "", challenge, err
+	}
+
+	token, newDevice, err := s.issueToken(ctx, "login", user)
+	if newDevice {
+		detail = map[string]string{"new_device": "true"}
+	}
+	return user, token, "", err
+}
+
+// 签发token并写入user cache和登录记录
+func (s *UserService) issueToken(ctx context.Context, moduleName string, user userModel.User) (
+	token string, newDevice bool, err error) {
+	requestID := util.GetRequestIDFromContext(ctx)
+
 	// 生成并写入token
 	token = util.GenerateToken(user.Username)
-	if err := userDao.SetTokenCache(token, user.Username); err != nil {
-		logger.Error("login", "requestID:", requestID, fmt.Sprintf(
-			"set token cache error: %s, username: %s", err.Error(), req.Username))
-		return user, "", status.Error(codes.Internal, err.Error())
+	if err = s.tokens.Set(token, user.Username); err != nil {
+		logger.Error(moduleName, "requestID:", requestID, fmt.Sprintf(
+			"set token cache error: %s, username: %s", err.Error(), user.Username))
+		return "", false, status.Error(codes.Internal, err.Error())
 	}
 
 	// 写入user cache
-	if err = userDao.SetUserCache(user.Username, user); err != nil {
-		logger.Error("login", "requestID:", requestID, fmt.Sprintf(
-			"set user cache error: %s, username: %s", err.Error(), req.Username))
-		return user, "", status.Error(codes.Internal, err.Error())
+	if err = s.cache.Set(user.Username, user); err != nil {
+		logger.Error(moduleName, "requestID:", requestID, fmt.Sprintf(
+			"set user cache error: %s, username: %s", err.Error(), user.Username))
+		return "", false, status.Error(codes.Internal, err.Error())
 	}
 
-	return user, token, nil
+	return token, s.recordLogin(ctx, user.Username), nil
 }
 
 // Logout 登出逻辑
-func Logout(ctx context.Context, req *proto.AuthRequest) (err error) {
+func (s *UserService) Logout(ctx context.Context, req *userapi.AuthRequest) (err error) {
 	requestID := util.GetRequestIDFromContext(ctx)
 
 	// 检查用户是否登录
 	token := req.Token
-	if _, err = checkAuth("logout", token, requestID); err != nil {
+	user, err := s.checkAuth(ctx, "logout", token)
+	if err != nil {
 		return err
 	}
+	username := user.Username
+	defer func() {
+		s.recordEvent(ctx, audit.Event{Action: ActionLogout, Actor: username}, err)
+	}()
 
 	// 删除用户token
-	if err := userDao.DelTokenCache(token); err != nil {
+	if err := s.tokens.Del(token); err != nil {
 		logger.Error("logout", "requestID:", requestID, "delete token cache, err:", err)
 		return status.Error(codes.Internal, err.Error())
 	}
@@ -102,80 +279,143 @@ func Logout(ctx context.Context, req *proto.AuthRequest) (err error) {
 }
 
 // GetUserProfile 获取用户信息逻辑
-func GetUserProfile(ctx context.Context, req *proto.AuthRequest) (user userModel.User, err error) {
-	requestID := util.GetRequestIDFromContext(ctx)
-
+func (s *UserService) GetUserProfile(ctx context.Context, req *userapi.AuthRequest) (user userModel.User, err error) {
 	// 检查用户是否登录
-	token := req.Token
-	username, err := checkAuth("getUserProfile", token, requestID)
+	username, err := s.authenticate(ctx, "getUserProfile", req.Token)
 	if err != nil {
 		return user, err
 	}
 
-	// 查询user cache
-	user, err = userDao.GetUserCache(username)
-	if err != nil {
-		logger.Error("getUserProfile", "requestID:", requestID, "get user cache, err:", err)
-		return user, status.Error(codes.Internal, err.Error())
-	}
+	return s.getProfile(ctx, "getUserProfile", username)
+}
 
-	// user cache不存在
-	if err == nil && user.ID == 0 {
-		logger.Debug("getUserProfile", "requestID:", requestID, "not user cache, username:", username)
+// 未指定版本号时, 版本冲突的最大重试次数
+const editMaxRetries = 3
 
-		// 查询user db
-		user, err = getUserByUsername(ctx, "getUserProfile", username)
-		if err != nil {
-			return user, err
-		}
+// 可编辑的用户字段, field mask路径 -> 请求中的字段值
+var editableColumns = map[string]func(req *userapi.EditUserRequest) string{
+	"nickname": func(req *userapi.EditUserRequest) string { return req.Nickname },
+	"pic_path": func(req *userapi.EditUserRequest) string { return req.PicPath },
+}
 
-		// 写入user cache
-		if err = userDao.SetUserCache(username, user); err != nil {
-			logger.Error("getUserProfile", "requestID:", requestID, "set user cache, err:", err)
-			return user, status.Error(codes.Internal, err.Error())
+// 根据field mask获取需要更新的字段, 未指定mask时只更新非空字段
+func editColumnsFromRequest(req *userapi.EditUserRequest) (map[string]interface{}, error) {
+	columns := make(map[string]interface{})
+	if req.UpdateMask == nil {
+		for column, value := range editableColumns {
+			if v := value(req); len(v) > 0 {
+				columns[column] = v
+			}
+		}
+	} else {
+		for _, path := range req.UpdateMask.Paths {
+			value, ok := editableColumns[path]
+			if !ok {
+				return nil, fmt.Errorf("invalid update_mask path: %s", path)
+			}
+			columns[path] = value(req)
 		}
 	}
-
-	return user, nil
+	if len(columns) == 0 {
+		return nil, errors.New("no fields to update")
+	}
+	return columns, nil
 }
 
 // EditUserProfile 编辑用户信息逻辑
-func EditUserProfile(ctx context.Context, req *proto.EditUserRequest) (username string, err error) {
+func (s *UserService) EditUserProfile(ctx context.Context, req *userapi.EditUserRequest) (user userModel.User, err error) {
 	requestID := util.GetRequestIDFromContext(ctx)
 
 	// 检查用户是否登录
-	token := req.Token
-	username, err = checkAuth("editUserProfile", token, requestID)
+	username, err := s.authenticate(ctx, "editUserProfile", req.Token)
 	if err != nil {
-		return "", err
+		return user, err
 	}
 
-	// 更新db user
-	if len(req.Nickname) > 0 {
-		err = userDao.UpdateNickNameByUsername(username, req.Nickname)
-	} else {
-		err = userDao.UpdatePicPathByUsername(username, req.PicPath)
+	// 获取需要更新的字段
+	columns, err := editColumnsFromRequest(req)
+	if err != nil {
+		logger.Warn("editUserProfile", "requestID:", requestID, "invalid edit request, err:", err)
+		return user, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// 记录修改前后的值, 只修改头像时记录为更换头像
+	event := audit.Event{Action: ActionEditProfile, Actor: username, After: make(map[string]string)}
+	if _, ok := columns["pic_path"]; ok && len(columns) == 1 {
+		event.Action = ActionChangeAvatar
 	}
+	for column, value := range columns {
+		event.After[column] = value.(string)
+	}
+	defer func() {
+		s.recordEvent(ctx, event, err)
+	}()
+	current, err := s.getUserByUsername(ctx, "editUserProfile", username)
 	if err != nil {
-		logger.Error("editUserProfile", "requestID:", requestID, "update db user, err:", err)
-		return "", status.Error(codes.Internal, err.Error())
+		return user, err
+	}
+	event.Before = map[string]string{}
+	for column := range columns {
+		event.Before[column] = editableColumnValue(current, column)
+	}
+
+	return s.updateColumns(ctx, "editUserProfile", username, int(req.ExpectedVersion), columns)
+}
+
+// 获取用户可编辑字段的当前值
+func editableColumnValue(user userModel.User, column string) string {
+	switch column {
+	case "nickname":
+		return user.Nickname
+	case "pic_path":
+		return user.PicPath
+	}
+	return ""
+}
+
+// 更新用户字段并删除user cache, version为0时版本冲突自动重试
+func (s *UserService) updateColumns(ctx context.Context, moduleName string, username string, version int,
+	columns map[string]interface{}) (user userModel.User, err error) {
+	requestID := util.GetRequestIDFromContext(ctx)
+
+	// 更新db user, 客户端指定了版本号时不重试
+	for i := 0; ; i++ {
+		user, err = s.repo.UpdateColumnsByUsername(username, version, columns)
+		if err != userDao.ErrVersionConflict || version != 0 || i >= editMaxRetries {
+			break
+		}
+		logger.Debug(moduleName, "requestID:", requestID, "version conflict, retry:", i+1)
+	}
+	if err == userDao.ErrVersionConflict {
+		logger.Warn(moduleName, "requestID:", requestID, "version conflict, expected version:", version)
+		if version != 0 {
+			return user, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		return user, status.Error(codes.Aborted, err.Error())
+	}
+	if err != nil {
+		logger.Error(moduleName, "requestID:", requestID, "update db user, err:", err)
+		return user, status.Error(codes.Internal, err.Error())
 	}
 
 	// 删除db cache
-	if err = userDao.DelUserCache(username); err != nil {
-		logger.Error("editUserProfile", "requestID:", requestID, "delete user cache, err:", err)
-		return "", status.Error(codes.Internal, err.Error())
+	if err = s.cache.Del(username); err != nil {
+		logger.Error(moduleName, "requestID:", requestID, "delete user cache, err:", err)
+		return user, status.Error(codes.Internal, err.Error())
 	}
 
-	return username, nil
+	return user, nil
 }
 
 // CreateUserProfile 创建用户信息
-func CreateUserProfile(ctx context.Context, req *proto.CreateUserRequest) (user userModel.User, err error) {
+func (s *UserService) CreateUserProfile(ctx context.Context, req *userapi.CreateUserRequest) (user userModel.User, err error) {
 	requestID := util.GetRequestIDFromContext(ctx)
+	defer func() {
+		s.recordEvent(ctx, audit.Event{Action: ActionRegister, Actor: req.Username}, err)
+	}()
 
 	// 检查用户是否已存在
-	user, err = userDao.GetByUsername(req.Username)
+	user, err = s.repo.GetByUsername(req.Username)
 	if err != nil {
 		logger.Error("createUserProfile", "requestID:", requestID, fmt.Sprintf(
 			"get user error: %s, username: %s", err.Error(), req.Username))
@@ -188,7 +428,7 @@ func CreateUserProfile(ctx context.Context, req *proto.CreateUserRequest) (user
 	}
 
 	// 写入user db
-	user, err = userDao.CreateOne(req.Username, req.Password, req.Nickname)
+	user, err = s.repo.CreateOne(req.Username, req.Password, req.Nickname)
 	if err != nil {
 		logger.Error("createUserProfile", "requestID:", requestID, fmt.Sprintf(
 			"create user error: %s, username: %s", err.Error(), req.Username))
@@ -198,14 +438,35 @@ func CreateUserProfile(ctx context.Context, req *proto.CreateUserRequest) (user
 	return user, nil
 }
 
-// Auth 认证逻辑
-func Auth(ctx context.Context, req *proto.AuthRequest) (err error) {
+// Authenticate 认证逻辑, 校验token并签发身份凭证
+func (s *UserService) Authenticate(ctx context.Context, req *userapi.AuthRequest) (
+	user userModel.User, assertion string, expire time.Time, err error) {
+	// 检查用户是否登录和账号状态
+	user, err = s.checkAuth(ctx, "authenticate", req.Token)
+	if err != nil {
+		return user, "", expire, err
+	}
+
+	assertion, expire = s.signer.Sign(user.Username, time.Now())
+	return user, assertion, expire, nil
+}
+
+// ListPicPaths 遍历所有用户正在使用的头像路径
+func (s *UserService) ListPicPaths(ctx context.Context, fn func(picPath string) error) error {
 	requestID := util.GetRequestIDFromContext(ctx)
 
-	token := req.Token
-	if _, err = checkAuth("auth", token, requestID); err != nil {
-		return err
+	// 区分回调错误和数据库错误, 回调错误原样返回
+	var fnErr error
+	err := s.repo.ListPicPaths(func(picPath string) error {
+		fnErr = fn(picPath)
+		return fnErr
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	if err != nil {
+		logger.Error("listPicPaths", "requestID:", requestID, "list pic paths db, err:", err)
+		return status.Error(codes.Internal, err.Error())
 	}
-
 	return nil
 }
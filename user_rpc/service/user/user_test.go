@@ -0,0 +1,360 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"os"
+	"sort"
+	"testing"
+	"time"
+	userDao "user_rpc/dao/user"
+	"user_rpc/pkg/audit"
+	"user_rpc/pkg/identity"
+	"user_rpc/pkg/logger"
+	"userapi/v1"
+)
+
+func TestMain(m *testing.M) {
+	logger.Logger = zap.NewNop()
+	os.Exit(m.Run())
+}
+
+func newTestService() *UserService {
+	return newTestServiceWithTokens(userDao.NewMemoryTokenStore())
+}
+
+func newTestServiceWithTokens(tokens userDao.TokenStore) *UserService {
+	return NewUserService(
+		userDao.NewMemoryUserRepository(),
+		tokens,
+		userDao.NewMemoryUserCache(),
+		identity.NewSigner([]byte("secret"), time.Minute),
+		&audit.MemoryRecorder{},
+	)
+}
+
+func newTestContext() context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("request_id", "test"))
+}
+
+func assertCode(t *testing.T, err error, code codes.Code) {
+	t.Helper()
+	if status.Code(err) != code {
+		t.Fatalf("expected code %s, got %v", code, err)
+	}
+}
+
+func TestUserFlow(t *testing.T) {
+	svc := newTestService()
+	ctx := newTestContext()
+
+	// 创建用户
+	created, err := svc.CreateUserProfile(ctx, &userapi.CreateUserRequest{
+		Username: "alice", Password: "123456", Nickname: "alice001",
+	})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	if created.ID == 0 || created.Nickname != "alice001" {
+		t.Fatalf("unexpected created user: %+v", created)
+	}
+
+	// 重复创建
+	_, err = svc.CreateUserProfile(ctx, &userapi.CreateUserRequest{
+		Username: "alice", Password: "123456",
+	})
+	assertCode(t, err, codes.AlreadyExists)
+
+	// 密码错误、用户不存在
+	_, _, _, err = svc.Login(ctx, &userapi.LoginRequest{Username: "alice", Password: "wrong"})
+	assertCode(t, err, codes.PermissionDenied)
+	_, _, _, err = svc.Login(ctx, &userapi.LoginRequest{Username: "bob", Password: "123456"})
+	assertCode(t, err, codes.NotFound)
+
+	// 登录
+	_, token, _, err := svc.Login(ctx, &userapi.LoginRequest{Username: "alice", Password: "123456"})
+	if err != nil || len(token) == 0 {
+		t.Fatalf("login: token=%q err=%v", token, err)
+	}
+	if _, _, _, err = svc.Authenticate(ctx, &userapi.AuthRequest{Token: token}); err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	// 用户信息缓存不包含凭证
+	if cached, _ := svc.cache.Get("alice"); cached.ID == 0 || len(cached.Password) > 0 || len(cached.Salt) > 0 {
+		t.Fatalf("unexpected cached user: %+v", cached)
+	}
+
+	// 编辑用户信息后, 获取到的应该是新数据
+	if _, err = svc.EditUserProfile(ctx, &userapi.EditUserRequest{Token: token, Nickname: "alice002"}); err != nil {
+		t.Fatalf("edit nickname: %v", err)
+	}
+	if _, err = svc.EditUserProfile(ctx, &userapi.EditUserRequest{Token: token, PicPath: "/a.png"}); err != nil {
+		t.Fatalf("edit pic path: %v", err)
+	}
+	user, err := svc.GetUserProfile(ctx, &userapi.AuthRequest{Token: token})
+	if err != nil {
+		t.Fatalf("get user: %v", err)
+	}
+	if user.Nickname != "alice002" || user.PicPath != "/a.png" {
+		t.Fatalf("unexpected user after edit: %+v", user)
+	}
+
+	// 登出后token失效
+	if err = svc.Logout(ctx, &userapi.AuthRequest{Token: token}); err != nil {
+		t.Fatalf("logout: %v", err)
+	}
+	_, err = svc.GetUserProfile(ctx, &userapi.AuthRequest{Token: token})
+	assertCode(t, err, codes.Unauthenticated)
+	err = svc.Logout(ctx, &userapi.AuthRequest{Token: token})
+	assertCode(t, err, codes.Unauthenticated)
+}
+
+func TestEditUserProfileMask(t *testing.T) {
+	svc := newTestService()
+	ctx := newTestContext()
+
+	created, err := svc.CreateUserProfile(ctx, &userapi.CreateUserRequest{
+		Username: "bob", Password: "123456", Nickname: "bob001",
+	})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	_, token, _, err := svc.Login(ctx, &userapi.LoginRequest{Username: "bob", Password: "123456"})
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+
+	// 同时更新两个字段, 返回完整的用户信息
+	user, err := svc.EditUserProfile(ctx, &userapi.EditUserRequest{
+		Token:      token,
+		Nickname:   "bob002",
+		PicPath:    "/b.png",
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"nickname", "pic_path"}},
+	})
+	if err != nil {
+		t.Fatalf("edit both: %v", err)
+	}
+	if user.ID != created.ID || user.Nickname != "bob002" || user.PicPath != "/b.png" || user.CreateTime.IsZero() {
+		t.Fatalf("unexpected user after edit: %+v", user)
+	}
+
+	// mask中的字段为空时清空该字段, 其余字段不变
+	user, err = svc.EditUserProfile(ctx, &userapi.EditUserRequest{
+		Token:      token,
+		PicPath:    "/ignored.png",
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"nickname"}},
+	})
+	if err != nil {
+		t.Fatalf("clear nickname: %v", err)
+	}
+	if user.Nickname != "" || user.PicPath != "/b.png" {
+		t.Fatalf("unexpected user after clear: %+v", user)
+	}
+
+	// 空请求、非法路径
+	_, err = svc.EditUserProfile(ctx, &userapi.EditUserRequest{Token: token})
+	assertCode(t, err, codes.InvalidArgument)
+	_, err = svc.EditUserProfile(ctx, &userapi.EditUserRequest{
+		Token:      token,
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"password"}},
+	})
+	assertCode(t, err, codes.InvalidArgument)
+
+	user, err = svc.GetUserProfile(ctx, &userapi.AuthRequest{Token: token})
+	if err != nil {
+		t.Fatalf("get user: %v", err)
+	}
+	if user.Nickname != "" || user.PicPath != "/b.png" {
+		t.Fatalf("unexpected user: %+v", user)
+	}
+}
+
+func TestEditUserProfileVersion(t *testing.T) {
+	svc := newTestService()
+	ctx := newTestContext()
+
+	if _, err := svc.CreateUserProfile(ctx, &userapi.CreateUserRequest{
+		Username: "carol", Password: "123456", Nickname: "carol001",
+	}); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	_, token, _, err := svc.Login(ctx, &userapi.LoginRequest{Username: "carol", Password: "123456"})
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	user, err := svc.GetUserProfile(ctx, &userapi.AuthRequest{Token: token})
+	if err != nil {
+		t.Fatalf("get user: %v", err)
+	}
+
+	// 指定当前版本号更新成功, 版本号递增
+	edited, err := svc.EditUserProfile(ctx, &userapi.EditUserRequest{
+		Token: token, Nickname: "carol002", ExpectedVersion: int64(user.Version),
+	})
+	if err != nil {
+		t.Fatalf("edit with current version: %v", err)
+	}
+	if edited.Version != user.Version+1 {
+		t.Fatalf("expected version %d, got %d", user.Version+1, edited.Version)
+	}
+
+	// 使用过期的版本号更新失败
+	_, err = svc.EditUserProfile(ctx, &userapi.EditUserRequest{
+		Token: token, Nickname: "carol003", ExpectedVersion: int64(user.Version),
+	})
+	assertCode(t, err, codes.FailedPrecondition)
+
+	// 未指定版本号时总是更新最新数据
+	edited, err = svc.EditUserProfile(ctx, &userapi.EditUserRequest{Token: token, Nickname: "carol004"})
+	if err != nil {
+		t.Fatalf("edit without version: %v", err)
+	}
+	if edited.Nickname != "carol004" || edited.Version != user.Version+2 {
+		t.Fatalf("unexpected user after edit: %+v", edited)
+	}
+}
+
+func TestListPicPaths(t *testing.T) {
+	svc := newTestService()
+	ctx := newTestContext()
+
+	// 两个用户使用同一个头像, 一个用户没有头像
+	for _, u := range []struct{ username, picPath string }{
+		{"alice", "avatars/aa/a.png"}, {"bob", "avatars/aa/a.png"}, {"carol", "avatars/cc/c.png"}, {"dave", ""},
+	} {
+		if _, err := svc.CreateUserProfile(ctx, &userapi.CreateUserRequest{Username: u.username, Password: "123456"}); err != nil {
+			t.Fatalf("create user %s: %v", u.username, err)
+		}
+		if len(u.picPath) == 0 {
+			continue
+		}
+		if _, err := svc.repo.UpdateColumnsByUsername(u.username, 0, map[string]interface{}{"pic_path": u.picPath}); err != nil {
+			t.Fatalf("set pic path %s: %v", u.username, err)
+		}
+	}
+
+	var picPaths []string
+	err := svc.ListPicPaths(ctx, func(picPath string) error {
+		picPaths = append(picPaths, picPath)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("list pic paths: %v", err)
+	}
+	sort.Strings(picPaths)
+	if len(picPaths) != 3 || picPaths[0] != "avatars/aa/a.png" || picPaths[2] != "avatars/cc/c.png" {
+		t.Fatalf("unexpected pic paths: %v", picPaths)
+	}
+
+	// 回调错误原样返回
+	stop := errors.New("stop")
+	if err = svc.ListPicPaths(ctx, func(string) error { return stop }); err != stop {
+		t.Fatalf("expected callback error, got %v", err)
+	}
+}
+
+func TestAuthenticateIdentity(t *testing.T) {
+	svc := newTestService()
+	ctx := newTestContext()
+
+	if _, err := svc.CreateUserProfile(ctx, &userapi.CreateUserRequest{
+		Username: "alice", Password: "123456", Nickname: "alice001",
+	}); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	_, token, _, err := svc.Login(ctx, &userapi.LoginRequest{Username: "alice", Password: "123456"})
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+
+	// 认证返回用户身份和凭证
+	user, assertion, expire, err := svc.Authenticate(ctx, &userapi.AuthRequest{Token: token})
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	if user.Username != "alice" || len(assertion) == 0 || !expire.After(time.Now()) {
+		t.Fatalf("unexpected identity: %+v %q %v", user, assertion, expire)
+	}
+	_, _, _, err = svc.Authenticate(ctx, &userapi.AuthRequest{Token: "invalid"})
+	assertCode(t, err, codes.Unauthenticated)
+
+	// 携带凭证时不需要token
+	identityCtx := metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs("request_id", "test", identity.MetadataKey, assertion))
+	if user, err = svc.GetUserProfile(identityCtx, &userapi.AuthRequest{}); err != nil || user.Nickname != "alice001" {
+		t.Fatalf("get user with identity: %+v %v", user, err)
+	}
+	if _, err = svc.EditUserProfile(identityCtx, &userapi.EditUserRequest{Nickname: "alice002"}); err != nil {
+		t.Fatalf("edit user with identity: %v", err)
+	}
+
+	// 伪造的凭证不会退回到token校验
+	forgedCtx := metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs("request_id", "test", identity.MetadataKey, assertion+"x"))
+	_, err = svc.GetUserProfile(forgedCtx, &userapi.AuthRequest{Token: token})
+	assertCode(t, err, codes.Unauthenticated)
+}
+
+// 获取认证失败的原因
+func authFailureReason(t *testing.T, err error) string {
+	t.Helper()
+	return errorReason(t, err, codes.Unauthenticated)
+}
+
+// 校验错误码并获取错误携带的原因
+func errorReason(t *testing.T, err error, code codes.Code) string {
+	t.Helper()
+	assertCode(t, err, code)
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok && info.Domain == userapi.ErrorDomain {
+			return info.Reason
+		}
+	}
+	t.Fatalf("missing error info: %v", err)
+	return ""
+}
+
+func TestExpiredToken(t *testing.T) {
+	tokens := userDao.NewMemoryTokenStore()
+	svc := newTestServiceWithTokens(tokens)
+	ctx := newTestContext()
+
+	if _, err := svc.CreateUserProfile(ctx, &userapi.CreateUserRequest{Username: "alice", Password: "123456"}); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	login := func() string {
+		_, token, _, err := svc.Login(ctx, &userapi.LoginRequest{Username: "alice", Password: "123456"})
+		if err != nil {
+			t.Fatalf("login: %v", err)
+		}
+		return token
+	}
+
+	// 过期的token和从未签发的token返回不同的原因
+	expired := login()
+	tokens.Expire(expired)
+	_, _, _, err := svc.Authenticate(ctx, &userapi.AuthRequest{Token: expired})
+	if reason := authFailureReason(t, err); reason != userapi.ReasonTokenExpired {
+		t.Fatalf("expired token: unexpected reason %s", reason)
+	}
+	_, _, _, err = svc.Authenticate(ctx, &userapi.AuthRequest{Token: "never-issued"})
+	if reason := authFailureReason(t, err); reason != userapi.ReasonTokenInvalid {
+		t.Fatalf("unknown token: unexpected reason %s", reason)
+	}
+
+	// 登出的token是无效的, 不是过期
+	loggedOut := login()
+	if err = svc.Logout(ctx, &userapi.AuthRequest{Token: loggedOut}); err != nil {
+		t.Fatalf("logout: %v", err)
+	}
+	_, err = svc.GetUserProfile(ctx, &userapi.AuthRequest{Token: loggedOut})
+	if reason := authFailureReason(t, err); reason != userapi.ReasonTokenInvalid {
+		t.Fatalf("logged out token: unexpected reason %s", reason)
+	}
+}
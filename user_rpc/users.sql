@@ -26,8 +26,16 @@ CREATE TABLE `users_0` (
      `create_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
      `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '更新时间',
      `version` int unsigned NOT NULL DEFAULT '1' COMMENT '记录版本号',
+     `role` varchar(16) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT 'user' COMMENT '角色',
+     `status` tinyint unsigned NOT NULL DEFAULT '0' COMMENT '账号状态: 0正常 1禁用 2待注销 3已注销',
+     `deleted_at` datetime DEFAULT NULL COMMENT '申请注销时间',
+     `mfa_secret` varchar(128) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT '' COMMENT '加密后的TOTP密钥',
+     `mfa_enabled` tinyint(1) NOT NULL DEFAULT '0' COMMENT '是否开启两步验证',
+     `mfa_recovery_codes` varchar(1024) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT '' COMMENT '未使用的恢复码哈希(json)',
+     `mfa_last_step` bigint NOT NULL DEFAULT '0' COMMENT '最近一次验证通过的TOTP时间步',
      PRIMARY KEY (`id`),
-     UNIQUE KEY `uniq_username` (`username`)
+     UNIQUE KEY `uniq_username` (`username`),
+     KEY `idx_status_deleted_at` (`status`, `deleted_at`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
 
 DROP TABLE IF EXISTS `users_1`;
@@ -48,3 +56,25 @@ CREATE TABLE users_6 LIKE users_0;
 CREATE TABLE users_7 LIKE users_0;
 CREATE TABLE users_8 LIKE users_0;
 CREATE TABLE users_9 LIKE users_0;
+
+DROP TABLE IF EXISTS `audit_events`;
+CREATE TABLE `audit_events` (
+     `id` bigint unsigned NOT NULL AUTO_INCREMENT COMMENT '主键ID, 审计事件序号',
+     `create_time` datetime(3) NOT NULL COMMENT '事件时间',
+     `action` varchar(64) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL COMMENT '事件类型',
+     `actor` varchar(64) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '操作人用户名',
+     `target` varchar(64) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '被操作的用户名',
+     `ip` varchar(64) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT '' COMMENT '客户端IP',
+     `user_agent` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '客户端User-Agent',
+     `request_id` varchar(64) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT '' COMMENT '请求ID',
+     `reason` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '操作原因',
+     `detail` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci COMMENT '事件详情(json)',
+     `before_values` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci COMMENT '修改前的字段值(json)',
+     `after_values` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci COMMENT '修改后的字段值(json)',
+     `outcome` varchar(16) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL COMMENT '结果: success failure denied',
+     `error` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '失败原因',
+     PRIMARY KEY (`id`),
+     KEY `idx_actor` (`actor`, `id`),
+     KEY `idx_target` (`target`, `id`),
+     KEY `idx_create_time` (`create_time`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci COMMENT='审计日志, 只追加不修改';
@@ -0,0 +1,127 @@
+/*
+ 已有的 users_0 ~ users_9 升级到 users.sql 的表结构
+
+ MySQL 不支持 ADD COLUMN IF NOT EXISTS, 请按顺序只执行表中还没有的部分:
+ 1. 角色和账号状态
+ 2. 注销时间和按状态清理的索引(需要先执行1)
+ 3. 两步验证
+ 新建的库直接使用 users.sql, 不需要执行本文件
+*/
+
+SET NAMES utf8mb4;
+
+-- 1. 角色和账号状态
+ALTER TABLE `users_0`
+    ADD COLUMN `role` varchar(16) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT 'user' COMMENT '角色',
+    ADD COLUMN `status` tinyint unsigned NOT NULL DEFAULT '0' COMMENT '账号状态: 0正常 1禁用 2待注销 3已注销';
+ALTER TABLE `users_1`
+    ADD COLUMN `role` varchar(16) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT 'user' COMMENT '角色',
+    ADD COLUMN `status` tinyint unsigned NOT NULL DEFAULT '0' COMMENT '账号状态: 0正常 1禁用 2待注销 3已注销';
+ALTER TABLE `users_2`
+    ADD COLUMN `role` varchar(16) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT 'user' COMMENT '角色',
+    ADD COLUMN `status` tinyint unsigned NOT NULL DEFAULT '0' COMMENT '账号状态: 0正常 1禁用 2待注销 3已注销';
+ALTER TABLE `users_3`
+    ADD COLUMN `role` varchar(16) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT 'user' COMMENT '角色',
+    ADD COLUMN `status` tinyint unsigned NOT NULL DEFAULT '0' COMMENT '账号状态: 0正常 1禁用 2待注销 3已注销';
+ALTER TABLE `users_4`
+    ADD COLUMN `role` varchar(16) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT 'user' COMMENT '角色',
+    ADD COLUMN `status` tinyint unsigned NOT NULL DEFAULT '0' COMMENT '账号状态: 0正常 1禁用 2待注销 3已注销';
+ALTER TABLE `users_5`
+    ADD COLUMN `role` varchar(16) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT 'user' COMMENT '角色',
+    ADD COLUMN `status` tinyint unsigned NOT NULL DEFAULT '0' COMMENT '账号状态: 0正常 1禁用 2待注销 3已注销';
+ALTER TABLE `users_6`
+    ADD COLUMN `role` varchar(16) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT 'user' COMMENT '角色',
+    ADD COLUMN `status` tinyint unsigned NOT NULL DEFAULT '0' COMMENT '账号状态: 0正常 1禁用 2待注销 3已注销';
+ALTER TABLE `users_7`
+    ADD COLUMN `role` varchar(16) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT 'user' COMMENT '角色',
+    ADD COLUMN `status` tinyint unsigned NOT NULL DEFAULT '0' COMMENT '账号状态: 0正常 1禁用 2待注销 3已注销';
+ALTER TABLE `users_8`
+    ADD COLUMN `role` varchar(16) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT 'user' COMMENT '角色',
+    ADD COLUMN `status` tinyint unsigned NOT NULL DEFAULT '0' COMMENT '账号状态: 0正常 1禁用 2待注销 3已注销';
+ALTER TABLE `users_9`
+    ADD COLUMN `role` varchar(16) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT 'user' COMMENT '角色',
+    ADD COLUMN `status` tinyint unsigned NOT NULL DEFAULT '0' COMMENT '账号状态: 0正常 1禁用 2待注销 3已注销';
+
+-- 2. 注销时间和按状态清理的索引
+ALTER TABLE `users_0`
+    ADD COLUMN `deleted_at` datetime DEFAULT NULL COMMENT '申请注销时间',
+    ADD KEY `idx_status_deleted_at` (`status`, `deleted_at`);
+ALTER TABLE `users_1`
+    ADD COLUMN `deleted_at` datetime DEFAULT NULL COMMENT '申请注销时间',
+    ADD KEY `idx_status_deleted_at` (`status`, `deleted_at`);
+ALTER TABLE `users_2`
+    ADD COLUMN `deleted_at` datetime DEFAULT NULL COMMENT '申请注销时间',
+    ADD KEY `idx_status_deleted_at` (`status`, `deleted_at`);
+ALTER TABLE `users_3`
+    ADD COLUMN `deleted_at` datetime DEFAULT NULL COMMENT '申请注销时间',
+    ADD KEY `idx_status_deleted_at` (`status`, `deleted_at`);
+ALTER TABLE `users_4`
+    ADD COLUMN `deleted_at` datetime DEFAULT NULL COMMENT '申请注销时间',
+    ADD KEY `idx_status_deleted_at` (`status`, `deleted_at`);
+ALTER TABLE `users_5`
+    ADD COLUMN `deleted_at` datetime DEFAULT NULL COMMENT '申请注销时间',
+    ADD KEY `idx_status_deleted_at` (`status`, `deleted_at`);
+ALTER TABLE `users_6`
+    ADD COLUMN `deleted_at` datetime DEFAULT NULL COMMENT '申请注销时间',
+    ADD KEY `idx_status_deleted_at` (`status`, `deleted_at`);
+ALTER TABLE `users_7`
+    ADD COLUMN `deleted_at` datetime DEFAULT NULL COMMENT '申请注销时间',
+    ADD KEY `idx_status_deleted_at` (`status`, `deleted_at`);
+ALTER TABLE `users_8`
+    ADD COLUMN `deleted_at` datetime DEFAULT NULL COMMENT '申请注销时间',
+    ADD KEY `idx_status_deleted_at` (`status`, `deleted_at`);
+ALTER TABLE `users_9`
+    ADD COLUMN `deleted_at` datetime DEFAULT NULL COMMENT '申请注销时间',
+    ADD KEY `idx_status_deleted_at` (`status`, `deleted_at`);
+
+-- 3. 两步验证
+ALTER TABLE `users_0`
+    ADD COLUMN `mfa_secret` varchar(128) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT '' COMMENT '加密后的TOTP密钥',
+    ADD COLUMN `mfa_enabled` tinyint(1) NOT NULL DEFAULT '0' COMMENT '是否开启两步验证',
+    ADD COLUMN `mfa_recovery_codes` varchar(1024) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT '' COMMENT '未使用的恢复码哈希(json)',
+    ADD COLUMN `mfa_last_step` bigint NOT NULL DEFAULT '0' COMMENT '最近一次验证通过的TOTP时间步';
+ALTER TABLE `users_1`
+    ADD COLUMN `mfa_secret` varchar(128) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT '' COMMENT '加密后的TOTP密钥',
+    ADD COLUMN `mfa_enabled` tinyint(1) NOT NULL DEFAULT '0' COMMENT '是否开启两步验证',
+    ADD COLUMN `mfa_recovery_codes` varchar(1024) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT '' COMMENT '未使用的恢复码哈希(json)',
+    ADD COLUMN `mfa_last_step` bigint NOT NULL DEFAULT '0' COMMENT '最近一次验证通过的TOTP时间步';
+ALTER TABLE `users_2`
+    ADD COLUMN `mfa_secret` varchar(128) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT '' COMMENT '加密后的TOTP密钥',
+    ADD COLUMN `mfa_enabled` tinyint(1) NOT NULL DEFAULT '0' COMMENT '是否开启两步验证',
+    ADD COLUMN `mfa_recovery_codes` varchar(1024) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT '' COMMENT '未使用的恢复码哈希(json)',
+    ADD COLUMN `mfa_last_step` bigint NOT NULL DEFAULT '0' COMMENT '最近一次验证通过的TOTP时间步';
+ALTER TABLE `users_3`
+    ADD COLUMN `mfa_secret` varchar(128) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT '' COMMENT '加密后的TOTP密钥',
+    ADD COLUMN `mfa_enabled` tinyint(1) NOT NULL DEFAULT '0' COMMENT '是否开启两步验证',
+    ADD COLUMN `mfa_recovery_codes` varchar(1024) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT '' COMMENT '未使用的恢复码哈希(json)',
+    ADD COLUMN `mfa_last_step` bigint NOT NULL DEFAULT '0' COMMENT '最近一次验证通过的TOTP时间步';
+ALTER TABLE `users_4`
+    ADD COLUMN `mfa_secret` varchar(128) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT '' COMMENT '加密后的TOTP密钥',
+    ADD COLUMN `mfa_enabled` tinyint(1) NOT NULL DEFAULT '0' COMMENT '是否开启两步验证',
+    ADD COLUMN `mfa_recovery_codes` varchar(1024) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT '' COMMENT '未使用的恢复码哈希(json)',
+    ADD COLUMN `mfa_last_step` bigint NOT NULL DEFAULT '0' COMMENT '最近一次验证通过的TOTP时间步';
+ALTER TABLE `users_5`
+    ADD COLUMN `mfa_secret` varchar(128) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT '' COMMENT '加密后的TOTP密钥',
+    ADD COLUMN `mfa_enabled` tinyint(1) NOT NULL DEFAULT '0' COMMENT '是否开启两步验证',
+    ADD COLUMN `mfa_recovery_codes` varchar(1024) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT '' COMMENT '未使用的恢复码哈希(json)',
+    ADD COLUMN `mfa_last_step` bigint NOT NULL DEFAULT '0' COMMENT '最近一次验证通过的TOTP时间步';
+ALTER TABLE `users_6`
+    ADD COLUMN `mfa_secret` varchar(128) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT '' COMMENT '加密后的TOTP密钥',
+    ADD COLUMN `mfa_enabled` tinyint(1) NOT NULL DEFAULT '0' COMMENT '是否开启两步验证',
+    ADD COLUMN `mfa_recovery_codes` varchar(1024) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT '' COMMENT '未使用的恢复码哈希(json)',
+    ADD COLUMN `mfa_last_step` bigint NOT NULL DEFAULT '0' COMMENT '最近一次验证通过的TOTP时间步';
+ALTER TABLE `users_7`
+    ADD COLUMN `mfa_secret` varchar(128) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT '' COMMENT '加密后的TOTP密钥',
+    ADD COLUMN `mfa_enabled` tinyint(1) NOT NULL DEFAULT '0' COMMENT '是否开启两步验证',
+    ADD COLUMN `mfa_recovery_codes` varchar(1024) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT '' COMMENT '未使用的恢复码哈希(json)',
+    ADD COLUMN `mfa_last_step` bigint NOT NULL DEFAULT '0' COMMENT '最近一次验证通过的TOTP时间步';
+ALTER TABLE `users_8`
+    ADD COLUMN `mfa_secret` varchar(128) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT '' COMMENT '加密后的TOTP密钥',
+    ADD COLUMN `mfa_enabled` tinyint(1) NOT NULL DEFAULT '0' COMMENT '是否开启两步验证',
+    ADD COLUMN `mfa_recovery_codes` varchar(1024) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT '' COMMENT '未使用的恢复码哈希(json)',
+    ADD COLUMN `mfa_last_step` bigint NOT NULL DEFAULT '0' COMMENT '最近一次验证通过的TOTP时间步';
+ALTER TABLE `users_9`
+    ADD COLUMN `mfa_secret` varchar(128) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT '' COMMENT '加密后的TOTP密钥',
+    ADD COLUMN `mfa_enabled` tinyint(1) NOT NULL DEFAULT '0' COMMENT '是否开启两步验证',
+    ADD COLUMN `mfa_recovery_codes` varchar(1024) CHARACTER SET ascii COLLATE ascii_general_ci NOT NULL DEFAULT '' COMMENT '未使用的恢复码哈希(json)',
+    ADD COLUMN `mfa_last_step` bigint NOT NULL DEFAULT '0' COMMENT '最近一次验证通过的TOTP时间步';
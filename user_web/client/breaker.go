@@ -0,0 +1,70 @@
+package client
+
+import (
+	"context"
+	"github.com/sony/gobreaker"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sync"
+	"time"
+	"user_web/pkg/config"
+	"user_web/pkg/logger"
+)
+
+// 每个rpc方法一个熔断器
+type breakerSet struct {
+	breakers sync.Map
+}
+
+// 获取方法对应的熔断器, 连续失败 RPC_BREAKER_MAX_FAILURES 次后打开, RPC_BREAKER_OPEN_TIMEOUT 秒后半开
+func (bs *breakerSet) get(method string) *gobreaker.CircuitBreaker {
+	if cb, ok := bs.breakers.Load(method); ok {
+		return cb.(*gobreaker.CircuitBreaker)
+	}
+
+	maxFailures := uint32(config.GetInt("RPC_BREAKER_MAX_FAILURES"))
+	if maxFailures == 0 {
+		maxFailures = 5
+	}
+	cb, _ := bs.breakers.LoadOrStore(method, gobreaker.NewCircuitBreaker(gobreaker.Settings{
+		Name:    method,
+		Timeout: time.Duration(config.GetInt("RPC_BREAKER_OPEN_TIMEOUT")) * time.Second,
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return counts.ConsecutiveFailures >= maxFailures
+		},
+		OnStateChange: func(name string, from gobreaker.State, to gobreaker.State) {
+			logger.Warn("client", "circuit breaker", name, "state changed from", from.String(), "to", to.String())
+		},
+	}))
+	return cb.(*gobreaker.CircuitBreaker)
+}
+
+// 服务端不可用或过载的错误计入熔断, 业务错误和客户端取消不计入
+func isBreakerFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return true
+	}
+	return false
+}
+
+// BreakerInterceptor 熔断拦截器, 熔断打开时直接返回 Unavailable
+func BreakerInterceptor() grpc.UnaryClientInterceptor {
+	bs := &breakerSet{}
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		var callErr error
+		_, err := bs.get(method).Execute(func() (interface{}, error) {
+			callErr = invoker(ctx, method, req, reply, cc, opts...)
+			if isBreakerFailure(callErr) {
+				return nil, callErr
+			}
+			return nil, nil
+		})
+		if err == gobreaker.ErrOpenState || err == gobreaker.ErrTooManyRequests {
+			return status.Error(codes.Unavailable, "circuit breaker is open: "+method)
+		}
+		return callErr
+	}
+}
@@ -1,24 +1,164 @@
 package client
 
 import (
+	"encoding/json"
+	"fmt"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	_ "google.golang.org/grpc/health"
+	"google.golang.org/grpc/keepalive"
+	"strings"
+	"time"
+	_ "user_web/pkg/balancer"
 	"user_web/pkg/config"
+	"user_web/pkg/discovery"
 	"user_web/pkg/logger"
-	"user_web/proto"
+	"user_web/pkg/tlsconfig"
+	"userapi/v1"
 )
 
-var RpcClient proto.UserServiceClient
+var RpcClient userapi.UserServiceClient
 var internalConn *grpc.ClientConn
 
+// 健康检查的服务名, 与 user_rpc 注册的一致
+const healthServiceName = "userapi.v1.UserService"
+
+// 默认的rpc超时时间(毫秒)
+const defaultTimeout = 3000
+
+// 一元rpc方法, 超时时间可以通过 RPC_TIMEOUT_<方法名> 单独配置
+var unaryMethods = []string{
+	"Login", "Logout", "GetUserProfile", "CreateUserProfile", "EditUserProfile", "Authenticate",
+	"DeleteAccount", "ExportUserData", "ListLogins", "VerifyMFA", "EnrollMFA", "ConfirmMFA", "DisableMFA",
+	"SearchUsers", "AdminGetUser", "SetUserStatus", "ForceLogout", "ResetNickname", "ListAuditEvents",
+}
+
+// 幂等的只读方法, 服务不可用时自动重试
+var retryableMethods = map[string]bool{"Authenticate": true, "GetUserProfile": true}
+
+// grpc服务配置, 参考 https://github.com/grpc/grpc/blob/master/doc/service_config.md
+type serviceConfig struct {
+	LoadBalancingConfig []map[string]struct{} `json:"loadBalancingConfig,omitempty"`
+	HealthCheckConfig   *healthCheckConfig    `json:"healthCheckConfig,omitempty"`
+	MethodConfig        []methodConfig        `json:"methodConfig"`
+}
+
+type healthCheckConfig struct {
+	ServiceName string `json:"serviceName"`
+}
+
+type methodConfig struct {
+	Name        []methodName `json:"name"`
+	Timeout     string       `json:"timeout,omitempty"`
+	RetryPolicy *retryPolicy `json:"retryPolicy,omitempty"`
+}
+
+type methodName struct {
+	Service string `json:"service"`
+	Method  string `json:"method"`
+}
+
+type retryPolicy struct {
+	MaxAttempts          int      `json:"maxAttempts"`
+	InitialBackoff       string   `json:"initialBackoff"`
+	MaxBackoff           string   `json:"maxBackoff"`
+	BackoffMultiplier    float64  `json:"backoffMultiplier"`
+	RetryableStatusCodes []string `json:"retryableStatusCodes"`
+}
+
+// 生成各方法的超时时间和重试策略
+func buildServiceConfig() string {
+	cfg := serviceConfig{}
+	// 负载均衡策略: pick_first round_robin least_request
+	if policy := config.GetString("RPC_LB_POLICY"); len(policy) > 0 {
+		cfg.LoadBalancingConfig = []map[string]struct{}{{policy: {}}}
+	}
+	// 通过 grpc.health.v1 检查实例状态, 不健康的实例不参与负载均衡
+	if config.GetBool("RPC_HEALTH_CHECK") {
+		cfg.HealthCheckConfig = &healthCheckConfig{ServiceName: healthServiceName}
+	}
+	for _, method := range unaryMethods {
+		timeout := config.GetInt("RPC_TIMEOUT_" + strings.ToUpper(method))
+		if timeout <= 0 {
+			timeout = config.GetInt("RPC_TIMEOUT_DEFAULT")
+		}
+		if timeout <= 0 {
+			timeout = defaultTimeout
+		}
+
+		mc := methodConfig{
+			Name:    []methodName{{Service: "userapi.v1.UserService", Method: method}},
+			Timeout: fmt.Sprintf("%.3fs", (time.Duration(timeout) * time.Millisecond).Seconds()),
+		}
+		if retryableMethods[method] {
+			mc.RetryPolicy = &retryPolicy{
+				MaxAttempts:          3,
+				InitialBackoff:       "0.05s",
+				MaxBackoff:           "0.5s",
+				BackoffMultiplier:    2,
+				RetryableStatusCodes: []string{"UNAVAILABLE"},
+			}
+		}
+		cfg.MethodConfig = append(cfg.MethodConfig, mc)
+	}
+
+	data, _ := json.Marshal(cfg)
+	return string(data)
+}
+
+// DialOptions rpc连接参数: 各方法的超时和重试、keepalive、熔断和降级
+func DialOptions() []grpc.DialOption {
+	opts := []grpc.DialOption{
+		grpc.WithDefaultServiceConfig(buildServiceConfig()),
+		// 降级在熔断之外, 熔断打开时也可以返回降级数据
+		grpc.WithChainUnaryInterceptor(FallbackInterceptor(), BreakerInterceptor()),
+	}
+	if tlsconfig.RpcTLS != nil {
+		opts = append(opts, grpc.WithTransportCredentials(
+			credentials.NewTLS(tlsconfig.RpcTLS.ClientConfig(config.GetString("RPC_TLS_SERVER_NAME")))))
+	} else {
+		opts = append(opts, grpc.WithInsecure())
+	}
+	// 服务端需要配置对应的 RPC_KEEPALIVE_MIN_TIME, 否则探测过于频繁会被断开
+	if keepaliveTime := config.GetInt("RPC_KEEPALIVE_TIME"); keepaliveTime > 0 {
+		opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                time.Duration(keepaliveTime) * time.Second,
+			Timeout:             time.Duration(config.GetInt("RPC_KEEPALIVE_TIMEOUT")) * time.Second,
+			PermitWithoutStream: true,
+		}))
+	}
+	return opts
+}
+
+// 服务发现的地址来源: static 逗号分隔的 RPC_SERVER_ADDR, dns SRV记录, file 地址列表文件
+func newSource() (discovery.Source, error) {
+	interval := time.Duration(config.GetInt("RPC_DISCOVERY_INTERVAL")) * time.Second
+	switch driver := config.GetString("RPC_DISCOVERY"); driver {
+	case "", "static":
+		return discovery.NewStaticSource(config.GetString("RPC_SERVER_ADDR")), nil
+	case "dns":
+		return discovery.NewDNSSource(config.GetString("RPC_DISCOVERY_SRV_NAME"), interval), nil
+	case "file":
+		return discovery.NewFileSource(config.GetString("RPC_DISCOVERY_FILE"), interval), nil
+	default:
+		return nil, fmt.Errorf("unsupported discovery driver: %s", driver)
+	}
+}
+
 // SetupClient 初始化rpcClient
 func SetupClient() {
-	var err error
-	internalConn, err = grpc.Dial(config.GetString("RPC_SERVER_ADDR"), grpc.WithInsecure())
+	source, err := newSource()
+	if err != nil {
+		logger.Error("client", "discovery error", err)
+		panic(err)
+	}
+	internalConn, err = grpc.Dial(discovery.Scheme+":///user_rpc",
+		append(DialOptions(), grpc.WithResolvers(discovery.NewBuilder(source)))...)
 	if err != nil {
 		logger.Error("client", "conn client error", err)
 		panic(err)
 	}
-	RpcClient = proto.NewUserServiceClient(internalConn)
+	RpcClient = userapi.NewUserServiceClient(internalConn)
 	logger.Debug("client", "conn success")
 }
 
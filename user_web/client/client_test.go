@@ -0,0 +1,253 @@
+package client
+
+import (
+	"context"
+	viperLib "github.com/spf13/viper"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"user_web/pkg/config"
+	"user_web/pkg/discovery"
+	"user_web/pkg/logger"
+	"userapi/v1"
+)
+
+// 按预设结果返回的rpc服务
+type fakeServer struct {
+	userapi.UnimplementedUserServiceServer
+	authCalls   int32
+	authFails   int32
+	loginCalls  int32
+	createCalls int32
+
+	mu          sync.Mutex
+	createErr   error
+	createDelay time.Duration
+}
+
+func (s *fakeServer) setCreate(err error, delay time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.createErr, s.createDelay = err, delay
+}
+
+func (s *fakeServer) Authenticate(context.Context, *userapi.AuthRequest) (*userapi.AuthResponse, error) {
+	if atomic.AddInt32(&s.authCalls, 1) <= s.authFails {
+		return nil, status.Error(codes.Unavailable, "unavailable")
+	}
+	return &userapi.AuthResponse{}, nil
+}
+
+func (s *fakeServer) Login(context.Context, *userapi.LoginRequest) (*userapi.LoginResponse, error) {
+	atomic.AddInt32(&s.loginCalls, 1)
+	return nil, status.Error(codes.Unavailable, "unavailable")
+}
+
+func (s *fakeServer) CreateUserProfile(ctx context.Context, _ *userapi.CreateUserRequest) (*userapi.UserResponse, error) {
+	atomic.AddInt32(&s.createCalls, 1)
+	s.mu.Lock()
+	createErr, createDelay := s.createErr, s.createDelay
+	s.mu.Unlock()
+	if createDelay > 0 {
+		select {
+		case <-time.After(createDelay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if createErr != nil {
+		return nil, createErr
+	}
+	return &userapi.UserResponse{}, nil
+}
+
+func setupTestConfig(settings map[string]interface{}) {
+	logger.Logger = zap.NewNop()
+	config.Config = viperLib.New()
+	for k, v := range settings {
+		config.Config.Set(k, v)
+	}
+}
+
+func newTestClient(t *testing.T, srv *fakeServer, settings map[string]interface{}) userapi.UserServiceClient {
+	t.Helper()
+	setupTestConfig(settings)
+
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	userapi.RegisterUserServiceServer(server, srv)
+	go func() {
+		_ = server.Serve(lis)
+	}()
+
+	opts := append(DialOptions(), grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}))
+	conn, err := grpc.Dial("bufnet", opts...)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+		server.Stop()
+	})
+	return userapi.NewUserServiceClient(conn)
+}
+
+func TestRetryIdempotentMethods(t *testing.T) {
+	srv := &fakeServer{authFails: 2}
+	c := newTestClient(t, srv, nil)
+
+	if _, err := c.Authenticate(context.Background(), &userapi.AuthRequest{Token: "t"}); err != nil {
+		t.Fatalf("Authenticate should succeed after retries: %v", err)
+	}
+	if got := atomic.LoadInt32(&srv.authCalls); got != 3 {
+		t.Errorf("Authenticate calls = %d, want 3", got)
+	}
+
+	if _, err := c.Login(context.Background(), &userapi.LoginRequest{}); status.Code(err) != codes.Unavailable {
+		t.Fatalf("Login err = %v, want Unavailable", err)
+	}
+	if got := atomic.LoadInt32(&srv.loginCalls); got != 1 {
+		t.Errorf("Login must not be retried, calls = %d", got)
+	}
+}
+
+func TestBreakerOpens(t *testing.T) {
+	srv := &fakeServer{createErr: status.Error(codes.InvalidArgument, "bad")}
+	c := newTestClient(t, srv, map[string]interface{}{
+		"RPC_BREAKER_MAX_FAILURES": 2,
+		"RPC_BREAKER_OPEN_TIMEOUT": 60,
+	})
+
+	// 业务错误不计入熔断
+	for i := 0; i < 3; i++ {
+		if _, err := c.CreateUserProfile(context.Background(), &userapi.CreateUserRequest{}); status.Code(err) != codes.InvalidArgument {
+			t.Fatalf("err = %v, want InvalidArgument", err)
+		}
+	}
+
+	srv.setCreate(status.Error(codes.ResourceExhausted, "busy"), 0)
+	for i := 0; i < 2; i++ {
+		if _, err := c.CreateUserProfile(context.Background(), &userapi.CreateUserRequest{}); status.Code(err) != codes.ResourceExhausted {
+			t.Fatalf("err = %v, want ResourceExhausted", err)
+		}
+	}
+	_, err := c.CreateUserProfile(context.Background(), &userapi.CreateUserRequest{})
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("err = %v, want Unavailable from open breaker", err)
+	}
+	if got := atomic.LoadInt32(&srv.createCalls); got != 5 {
+		t.Errorf("server calls = %d, want 5", got)
+	}
+
+	// 其他方法的熔断器不受影响
+	if _, err := c.Authenticate(context.Background(), &userapi.AuthRequest{}); err != nil {
+		t.Errorf("Authenticate err = %v", err)
+	}
+}
+
+func TestMethodTimeout(t *testing.T) {
+	srv := &fakeServer{createDelay: time.Second}
+	c := newTestClient(t, srv, map[string]interface{}{
+		"RPC_TIMEOUT_DEFAULT":           5000,
+		"RPC_TIMEOUT_CREATEUSERPROFILE": 50,
+	})
+
+	start := time.Now()
+	_, err := c.CreateUserProfile(context.Background(), &userapi.CreateUserRequest{})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("err = %v, want DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("call took %v, method timeout not applied", elapsed)
+	}
+
+	// 调用方更短的deadline优先
+	srv.setCreate(nil, 0)
+	if _, err := c.CreateUserProfile(context.Background(), &userapi.CreateUserRequest{}); err != nil {
+		t.Errorf("err = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	time.Sleep(2 * time.Millisecond)
+	if _, err := c.CreateUserProfile(ctx, &userapi.CreateUserRequest{}); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("err = %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestLoadBalancingWithHealthCheck(t *testing.T) {
+	for _, policy := range []string{"round_robin", "least_request"} {
+		t.Run(policy, func(t *testing.T) {
+			setupTestConfig(map[string]interface{}{
+				"RPC_LB_POLICY":    policy,
+				"RPC_HEALTH_CHECK": true,
+			})
+
+			// 两个副本, 各自注册健康检查
+			replicas := map[string]*fakeServer{"rpc-a": {}, "rpc-b": {}}
+			listeners := map[string]*bufconn.Listener{}
+			healthServers := map[string]*health.Server{}
+			for addr, srv := range replicas {
+				lis := bufconn.Listen(1 << 20)
+				server := grpc.NewServer()
+				userapi.RegisterUserServiceServer(server, srv)
+				healthServer := health.NewServer()
+				healthServer.SetServingStatus(healthServiceName, healthpb.HealthCheckResponse_SERVING)
+				healthpb.RegisterHealthServer(server, healthServer)
+				go func() {
+					_ = server.Serve(lis)
+				}()
+				t.Cleanup(server.Stop)
+				listeners[addr], healthServers[addr] = lis, healthServer
+			}
+
+			conn, err := grpc.Dial(discovery.Scheme+":///user_rpc", append(DialOptions(),
+				grpc.WithResolvers(discovery.NewBuilder(discovery.NewStaticSource("rpc-a,rpc-b"))),
+				grpc.WithContextDialer(func(_ context.Context, addr string) (net.Conn, error) {
+					return listeners[addr].Dial()
+				}),
+			)...)
+			if err != nil {
+				t.Fatalf("dial: %v", err)
+			}
+			t.Cleanup(func() {
+				_ = conn.Close()
+			})
+			c := userapi.NewUserServiceClient(conn)
+
+			// 等待两个副本都就绪后, 请求分散到两个副本
+			deadline := time.Now().Add(2 * time.Second)
+			for atomic.LoadInt32(&replicas["rpc-a"].authCalls) == 0 || atomic.LoadInt32(&replicas["rpc-b"].authCalls) == 0 {
+				if time.Now().After(deadline) {
+					t.Fatalf("requests not spread: a=%d b=%d", replicas["rpc-a"].authCalls, replicas["rpc-b"].authCalls)
+				}
+				if _, err := c.Authenticate(context.Background(), &userapi.AuthRequest{}, grpc.WaitForReady(true)); err != nil {
+					t.Fatalf("Authenticate: %v", err)
+				}
+			}
+
+			// 不健康的副本不再接收请求
+			healthServers["rpc-b"].SetServingStatus(healthServiceName, healthpb.HealthCheckResponse_NOT_SERVING)
+			time.Sleep(100 * time.Millisecond)
+			before := atomic.LoadInt32(&replicas["rpc-b"].authCalls)
+			for i := 0; i < 20; i++ {
+				if _, err := c.Authenticate(context.Background(), &userapi.AuthRequest{}); err != nil {
+					t.Fatalf("Authenticate: %v", err)
+				}
+			}
+			if after := atomic.LoadInt32(&replicas["rpc-b"].authCalls); after != before {
+				t.Fatalf("unhealthy replica received %d requests", after-before)
+			}
+		})
+	}
+}
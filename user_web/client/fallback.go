@@ -0,0 +1,87 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"time"
+	"user_web/pkg/config"
+	"user_web/pkg/logger"
+	"user_web/pkg/redis"
+	"user_web/pkg/util"
+)
+
+const (
+	getUserProfileMethod  = "/userapi.v1.UserService/GetUserProfile"
+	editUserProfileMethod = "/userapi.v1.UserService/EditUserProfile"
+	deleteAccountMethod   = "/userapi.v1.UserService/DeleteAccount"
+)
+
+// 用户信息的降级缓存key, 请求通过身份凭证识别用户
+func profileFallbackKey(username string) string {
+	return fmt.Sprintf("%s:profile:%s", config.GetString("RPC_FALLBACK_PREFIX"), util.Md5Data(username))
+}
+
+// 获取请求的降级缓存key, 不需要降级的请求返回空
+// 认证不降级: token可能已登出、账号可能已被禁用或注销, 服务不可用时拒绝请求
+func fallbackKey(ctx context.Context, method string) string {
+	switch method {
+	case getUserProfileMethod, editUserProfileMethod, deleteAccountMethod:
+		if identity, ok := util.IdentityFromContext(ctx); ok {
+			return profileFallbackKey(identity.Username)
+		}
+	}
+	return ""
+}
+
+// FallbackInterceptor 降级拦截器
+// 获取用户信息成功时缓存结果, 服务不可用或熔断打开时返回 RPC_FALLBACK_EXPIRE 秒内缓存的结果;
+// 编辑用户信息(包括上传头像)成功时用返回的新数据覆盖缓存, 失败时不降级; 注销账号时删除用户信息缓存
+func FallbackInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		key := fallbackKey(ctx, method)
+		if len(key) == 0 {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if method == deleteAccountMethod {
+			if err == nil {
+				if delErr := redis.Redis.Del(key); delErr != nil {
+					logger.Error("client", "delete fallback error", delErr)
+				}
+			}
+			return err
+		}
+
+		if err != nil && method == editUserProfileMethod {
+			return err
+		}
+		if err == nil {
+			data, marshalErr := proto.Marshal(reply.(proto.Message))
+			expire := time.Duration(config.GetInt("RPC_FALLBACK_EXPIRE")) * time.Second
+			if marshalErr == nil && expire > 0 {
+				marshalErr = redis.Redis.Set(key, data, expire)
+			}
+			if marshalErr != nil {
+				logger.Error("client", "set fallback error", marshalErr)
+			}
+			return nil
+		}
+		if !isBreakerFailure(err) {
+			return err
+		}
+
+		cached, getErr := redis.Redis.Get(key)
+		if getErr != nil || len(cached) == 0 {
+			return err
+		}
+		if proto.Unmarshal([]byte(cached), reply.(proto.Message)) != nil {
+			return err
+		}
+		logger.Warn("client", "use fallback for", method, "error:", err.Error())
+		return nil
+	}
+}
@@ -0,0 +1,68 @@
+// avatar_gc 回收不再被任何用户引用的头像文件
+//
+// 在 user_web 目录下运行, 读取同一份 .env 配置; 遍历头像路径需要管理员权限, 管理员的token通过 AVATAR_GC_TOKEN 配置:
+//
+//	go run ./cmd/avatar_gc -dry-run
+//	go run ./cmd/avatar_gc -grace 48h
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"google.golang.org/grpc/metadata"
+	"os"
+	"time"
+	"user_web/client"
+	"user_web/pkg/avatargc"
+	"user_web/pkg/config"
+	"user_web/pkg/logger"
+	"user_web/pkg/storage"
+	"user_web/pkg/tlsconfig"
+	"user_web/pkg/util"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "只输出需要回收的文件, 不删除")
+	grace := flag.Duration("grace", 24*time.Hour, "宽限期, 修改时间在宽限期内的文件不回收")
+	prefix := flag.String("prefix", util.AvatarPrefix, "需要回收的存储前缀, 只能是头像前缀或其子目录")
+	flag.Parse()
+
+	// 初始化config、logger、storage、tls、client
+	config.SetupConfig()
+	logger.SetupLogger()
+	storage.SetupStorage()
+	tlsconfig.SetupTLS()
+	client.SetupClient()
+	defer client.Close()
+
+	requestID := fmt.Sprintf("avatar-gc-%d", time.Now().UnixNano())
+	ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("request_id", requestID))
+	ctx, err := avatargc.Authenticate(ctx, client.RpcClient, config.GetString("AVATAR_GC_TOKEN"))
+	if err != nil {
+		logger.Error("avatarGC", "requestID:", requestID, "authenticate error", err.Error())
+		fmt.Fprintln(os.Stderr, "avatar gc authenticate failed:", err)
+		os.Exit(1)
+	}
+	report, err := avatargc.Run(ctx, storage.Store, avatargc.RPCReferences(client.RpcClient), avatargc.Options{
+		Prefix:      *prefix,
+		GracePeriod: *grace,
+		DryRun:      *dryRun,
+	})
+	if err != nil {
+		logger.Error("avatarGC", "requestID:", requestID, "run error", err.Error())
+		fmt.Fprintln(os.Stderr, "avatar gc failed:", err)
+		os.Exit(1)
+	}
+
+	action := "deleted"
+	if *dryRun {
+		action = "would delete"
+	}
+	for _, info := range report.Orphans {
+		fmt.Printf("%s %s (%d bytes, modified %s)\n", action, info.Key, info.Size, info.ModTime.Format(time.RFC3339))
+	}
+	fmt.Printf("scanned: %d, referenced: %d, within grace period: %d, orphaned: %d, deleted: %d, freed: %d bytes\n",
+		report.Scanned, report.Referenced, report.Recent, len(report.Orphans), report.Deleted, report.FreedBytes)
+	logger.Info("avatarGC", "requestID:", requestID, "dryRun:", *dryRun, "report:", report)
+}
@@ -0,0 +1,46 @@
+// export_gc 删除下载地址已过期的个人数据导出文件
+//
+// 在 user_web 目录下运行, 读取同一份 .env 配置, 建议每小时定时执行:
+//
+//	go run ./cmd/export_gc -dry-run
+//	go run ./cmd/export_gc
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+	"user_web/pkg/config"
+	"user_web/pkg/export"
+	"user_web/pkg/logger"
+	"user_web/pkg/storage"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "只输出过期的文件, 不删除")
+	flag.Parse()
+
+	// 初始化config、logger、storage
+	config.SetupConfig()
+	logger.SetupLogger()
+	storage.SetupStorage()
+
+	expired, err := export.Sweep(context.Background(), storage.Store, time.Now(), *dryRun)
+	if err != nil {
+		logger.Error("exportGC", "run error", err.Error())
+		fmt.Fprintln(os.Stderr, "export gc failed:", err)
+		os.Exit(1)
+	}
+
+	action := "deleted"
+	if *dryRun {
+		action = "would delete"
+	}
+	for _, info := range expired {
+		fmt.Printf("%s %s (%d bytes, modified %s)\n", action, info.Key, info.Size, info.ModTime.Format(time.RFC3339))
+	}
+	fmt.Printf("expired: %d\n", len(expired))
+	logger.Info("exportGC", "dryRun:", *dryRun, "expired:", len(expired))
+}
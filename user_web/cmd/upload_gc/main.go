@@ -0,0 +1,49 @@
+// upload_gc 删除断点续传会话过期后留在存储中的分片
+//
+// 在 user_web 目录下运行, 读取同一份 .env 配置, 建议每小时定时执行:
+//
+//	go run ./cmd/upload_gc -dry-run
+//	go run ./cmd/upload_gc
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+	"user_web/pkg/config"
+	"user_web/pkg/logger"
+	"user_web/pkg/redis"
+	"user_web/pkg/storage"
+	"user_web/pkg/upload"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "只输出需要删除的分片, 不删除")
+	flag.Parse()
+
+	// 初始化config、logger、redis、storage
+	config.SetupConfig()
+	logger.SetupLogger()
+	redis.SetupRedis()
+	defer redis.Close()
+	storage.SetupStorage()
+
+	orphans, err := upload.Sweep(context.Background(), storage.Store, *dryRun)
+	if err != nil {
+		logger.Error("uploadGC", "run error", err.Error())
+		fmt.Fprintln(os.Stderr, "upload gc failed:", err)
+		os.Exit(1)
+	}
+
+	action := "deleted"
+	if *dryRun {
+		action = "would delete"
+	}
+	for _, info := range orphans {
+		fmt.Printf("%s %s (%d bytes, modified %s)\n", action, info.Key, info.Size, info.ModTime.Format(time.RFC3339))
+	}
+	fmt.Printf("orphaned: %d\n", len(orphans))
+	logger.Info("uploadGC", "dryRun:", *dryRun, "orphaned:", len(orphans))
+}
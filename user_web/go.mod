@@ -3,17 +3,26 @@ module user_web
 go 1.16
 
 require (
+	github.com/alicebob/miniredis/v2 v2.30.0
+	github.com/fsnotify/fsnotify v1.5.1
 	github.com/gin-gonic/gin v1.7.7
 	github.com/go-redis/redis/v8 v8.11.5
+	github.com/minio/minio-go/v7 v7.0.50
 	github.com/mojocn/base64Captcha v1.3.5
-	github.com/natefinch/lumberjack v2.0.0+incompatible // indirect
-	github.com/sirupsen/logrus v1.8.1
+	github.com/natefinch/lumberjack v2.0.0+incompatible
+	github.com/sirupsen/logrus v1.9.0
+	github.com/sony/gobreaker v0.5.0
 	github.com/spf13/cast v1.4.1
 	github.com/spf13/viper v1.11.0
 	github.com/thedevsaddam/govalidator v1.9.10
 	go.uber.org/atomic v1.9.0 // indirect
 	go.uber.org/multierr v1.8.0 // indirect
-	go.uber.org/zap v1.21.0 // indirect
+	go.uber.org/zap v1.21.0
+	golang.org/x/image v0.0.0-20190802002840-cff245a6509b
+	google.golang.org/genproto v0.0.0-20220407144326-9054f6ed7bac
 	google.golang.org/grpc v1.45.0
 	google.golang.org/protobuf v1.28.0
+	userapi v0.0.0
 )
+
+replace userapi => ../userapi
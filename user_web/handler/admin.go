@@ -0,0 +1,244 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"user_web/client"
+	"user_web/pkg/logger"
+	"user_web/pkg/util"
+	"user_web/request"
+	"user_web/response"
+	"userapi/v1"
+)
+
+// 账号状态在http接口中的名称
+var userStatusNames = map[userapi.UserStatus]string{
+	userapi.UserStatus_USER_STATUS_ACTIVE:           "active",
+	userapi.UserStatus_USER_STATUS_DISABLED:         "disabled",
+	userapi.UserStatus_USER_STATUS_PENDING_DELETION: "pending_deletion",
+	userapi.UserStatus_USER_STATUS_DELETED:          "deleted",
+}
+
+// AdminController 管理控制器, 权限由 user_rpc 根据调用方的角色校验
+type AdminController struct {
+}
+
+// 管理接口返回的用户信息
+func adminUserData(rsp *userapi.UserResponse) gin.H {
+	return gin.H{
+		"id":          rsp.Id,
+		"username":    rsp.Username,
+		"nickname":    rsp.Nickname,
+		"pic_path":    rsp.PicPath,
+		"role":        rsp.Role,
+		"status":      userStatusNames[rsp.Status],
+		"create_time": rsp.CreateTime,
+		"update_time": rsp.UpdateTime,
+		"delete_time": rsp.DeleteTime,
+	}
+}
+
+// 管理接口的rpc错误转换为http响应, 目标用户不存在时返回404
+func adminRpcErrRsp(err error, ctx *gin.Context) {
+	if status.Code(err) == codes.NotFound {
+		response.NotFoundRsp(ctx)
+		return
+	}
+	response.RpcRspToHttpRsp(err, ctx)
+}
+
+// SearchUsersHandler 搜索用户接口
+func (ctrl *AdminController) SearchUsersHandler(ctx *gin.Context) {
+	requestID := ctx.GetString("request_id")
+
+	// 验证请求参数
+	req := request.SearchUsersRequest{}
+	if err := request.Validate(ctx, &req, request.SearchUsersRequestValid); err != nil {
+		logger.Warn("searchUsers", "requestID:", requestID, "request valid error", err.Error())
+		return
+	}
+
+	// rpc调用
+	rpcCtx := util.GenRpcCtxWithRequestID(ctx)
+	rsp, err := client.RpcClient.SearchUsers(rpcCtx, &userapi.SearchUsersRequest{
+		Query:     req.Query,
+		PageSize:  int32(req.PageSize),
+		PageToken: req.PageToken,
+	})
+	if err != nil {
+		logger.Error("searchUsers", "requestID:", requestID, "call searchUsers error", err.Error())
+		adminRpcErrRsp(err, ctx)
+		return
+	}
+
+	// 返回结果
+	users := make([]gin.H, 0, len(rsp.Users))
+	for _, user := range rsp.Users {
+		users = append(users, adminUserData(user))
+	}
+	response.SuccessDataRsp(ctx, gin.H{
+		"users":           users,
+		"next_page_token": rsp.NextPageToken,
+	})
+}
+
+// GetUserHandler 查看用户信息接口
+func (ctrl *AdminController) GetUserHandler(ctx *gin.Context) {
+	requestID := ctx.GetString("request_id")
+
+	// rpc调用
+	rpcCtx := util.GenRpcCtxWithRequestID(ctx)
+	rsp, err := client.RpcClient.AdminGetUser(rpcCtx, &userapi.AdminUserRequest{
+		Username: ctx.Param("username"),
+	})
+	if err != nil {
+		logger.Error("adminGetUser", "requestID:", requestID, "call adminGetUser error", err.Error())
+		adminRpcErrRsp(err, ctx)
+		return
+	}
+
+	response.SuccessDataRsp(ctx, adminUserData(rsp))
+}
+
+// SetUserStatusHandler 禁用或启用账号接口
+func (ctrl *AdminController) SetUserStatusHandler(ctx *gin.Context) {
+	requestID := ctx.GetString("request_id")
+	username := ctx.Param("username")
+
+	// 验证请求参数
+	req := request.SetUserStatusRequest{}
+	if err := request.Validate(ctx, &req, request.SetUserStatusRequestValid); err != nil {
+		logger.Warn("setUserStatus", "requestID:", requestID, "request valid error", err.Error())
+		return
+	}
+	userStatus := userapi.UserStatus_USER_STATUS_ACTIVE
+	if req.Status == userStatusNames[userapi.UserStatus_USER_STATUS_DISABLED] {
+		userStatus = userapi.UserStatus_USER_STATUS_DISABLED
+	}
+
+	// rpc调用
+	rpcCtx := util.GenRpcCtxWithRequestID(ctx)
+	rsp, err := client.RpcClient.SetUserStatus(rpcCtx, &userapi.SetUserStatusRequest{
+		Username: username,
+		Status:   userStatus,
+		Reason:   req.Reason,
+	})
+	if err != nil {
+		logger.Error("setUserStatus", "requestID:", requestID, "call setUserStatus error", err.Error())
+		adminRpcErrRsp(err, ctx)
+		return
+	}
+
+	response.SuccessDataRsp(ctx, adminUserData(rsp))
+}
+
+// ForceLogoutHandler 强制登出接口
+func (ctrl *AdminController) ForceLogoutHandler(ctx *gin.Context) {
+	requestID := ctx.GetString("request_id")
+
+	// 验证请求参数
+	req := request.AdminActionRequest{}
+	if err := request.Validate(ctx, &req, request.AdminActionRequestValid); err != nil {
+		logger.Warn("forceLogout", "requestID:", requestID, "request valid error", err.Error())
+		return
+	}
+
+	// rpc调用
+	rpcCtx := util.GenRpcCtxWithRequestID(ctx)
+	_, err := client.RpcClient.ForceLogout(rpcCtx, &userapi.AdminUserRequest{
+		Username: ctx.Param("username"),
+		Reason:   req.Reason,
+	})
+	if err != nil {
+		logger.Error("forceLogout", "requestID:", requestID, "call forceLogout error", err.Error())
+		adminRpcErrRsp(err, ctx)
+		return
+	}
+
+	response.SuccessRsp(ctx)
+}
+
+// ResetNicknameHandler 重置违规昵称接口
+func (ctrl *AdminController) ResetNicknameHandler(ctx *gin.Context) {
+	requestID := ctx.GetString("request_id")
+
+	// 验证请求参数
+	req := request.AdminActionRequest{}
+	if err := request.Validate(ctx, &req, request.AdminActionRequestValid); err != nil {
+		logger.Warn("resetNickname", "requestID:", requestID, "request valid error", err.Error())
+		return
+	}
+
+	// rpc调用
+	rpcCtx := util.GenRpcCtxWithRequestID(ctx)
+	rsp, err := client.RpcClient.ResetNickname(rpcCtx, &userapi.AdminUserRequest{
+		Username: ctx.Param("username"),
+		Reason:   req.Reason,
+	})
+	if err != nil {
+		logger.Error("resetNickname", "requestID:", requestID, "call resetNickname error", err.Error())
+		adminRpcErrRsp(err, ctx)
+		return
+	}
+
+	response.SuccessDataRsp(ctx, adminUserData(rsp))
+}
+
+// 审计事件在http接口中的格式
+func auditEventData(event *userapi.AuditEvent) gin.H {
+	return gin.H{
+		"id":         event.Id,
+		"time":       event.Time,
+		"action":     event.Action,
+		"actor":      event.Actor,
+		"target":     event.Target,
+		"ip":         event.Ip,
+		"user_agent": event.UserAgent,
+		"request_id": event.RequestId,
+		"reason":     event.Reason,
+		"detail":     event.Detail,
+		"before":     event.Before,
+		"after":      event.After,
+		"outcome":    event.Outcome,
+		"error":      event.Error,
+	}
+}
+
+// ListAuditEventsHandler 查询审计日志接口, 按用户、事件类型和时间范围筛选
+func (ctrl *AdminController) ListAuditEventsHandler(ctx *gin.Context) {
+	requestID := ctx.GetString("request_id")
+
+	// 验证请求参数
+	req := request.ListAuditEventsRequest{}
+	if err := request.Validate(ctx, &req, request.ListAuditEventsRequestValid); err != nil {
+		logger.Warn("listAuditEvents", "requestID:", requestID, "request valid error", err.Error())
+		return
+	}
+
+	// rpc调用
+	rpcCtx := util.GenRpcCtxWithRequestID(ctx)
+	rsp, err := client.RpcClient.ListAuditEvents(rpcCtx, &userapi.ListAuditEventsRequest{
+		Username:  req.Username,
+		Action:    req.Action,
+		StartTime: req.StartTime,
+		EndTime:   req.EndTime,
+		PageSize:  int32(req.PageSize),
+		PageToken: req.PageToken,
+	})
+	if err != nil {
+		logger.Error("listAuditEvents", "requestID:", requestID, "call listAuditEvents error", err.Error())
+		adminRpcErrRsp(err, ctx)
+		return
+	}
+
+	// 返回结果
+	events := make([]gin.H, 0, len(rsp.Events))
+	for _, event := range rsp.Events {
+		events = append(events, auditEventData(event))
+	}
+	response.SuccessDataRsp(ctx, gin.H{
+		"events":          events,
+		"next_page_token": rsp.NextPageToken,
+	})
+}
@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"mime"
+	"net/http"
+	"path"
+	"user_web/pkg/logger"
+	"user_web/pkg/storage"
+	"user_web/pkg/util"
+	"user_web/response"
+)
+
+// 头像按内容生成存储路径, 内容不变路径就不变, 可以长期缓存
+const avatarCacheControl = "public, max-age=31536000, immutable"
+
+// 直接打开头像时禁止执行脚本, 防止伪装成图片的内容被当作页面执行
+const avatarCSP = "default-src 'none'; img-src 'self'; style-src 'unsafe-inline'; sandbox"
+
+// AvatarController 头像控制器
+type AvatarController struct {
+}
+
+// ShowAvatarHandler 获取头像文件接口, 支持ETag/Last-Modified协商缓存和Range请求
+func (ctrl *AvatarController) ShowAvatarHandler(ctx *gin.Context) {
+	requestID := ctx.GetString("request_id")
+
+	// 校验存储路径, 防止路径穿越
+	key, err := storage.CleanKey(util.AvatarPrefix + ctx.Param("filepath"))
+	if err != nil {
+		logger.Warn("showAvatar", "requestID:", requestID, "path:", ctx.Param("filepath"), "invalid key")
+		response.NotFoundRsp(ctx)
+		return
+	}
+
+	obj, err := storage.Store.Open(ctx, key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotExist) {
+			response.NotFoundRsp(ctx)
+			return
+		}
+		logger.Error("showAvatar", "requestID:", requestID, "key:", key, "open avatar error", err.Error())
+		response.ErrorRsp(ctx, err)
+		return
+	}
+	defer obj.Close()
+
+	contentType := obj.Info.ContentType
+	if len(contentType) == 0 {
+		contentType = mime.TypeByExtension(path.Ext(key))
+	}
+	if len(contentType) > 0 {
+		ctx.Header("Content-Type", contentType)
+	}
+	ctx.Header("X-Content-Type-Options", "nosniff")
+	ctx.Header("Content-Security-Policy", avatarCSP)
+	ctx.Header("Cache-Control", avatarCacheControl)
+	if len(obj.Info.ETag) > 0 {
+		ctx.Header("ETag", obj.Info.ETag)
+	}
+
+	// ServeContent 处理 If-None-Match、If-Modified-Since 和 Range
+	http.ServeContent(ctx.Writer, ctx.Request, path.Base(key), obj.Info.ModTime, obj)
+}
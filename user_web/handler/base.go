@@ -5,10 +5,11 @@ import (
 	"user_web/client"
 	"user_web/pkg/captcha"
 	"user_web/pkg/logger"
+	"user_web/pkg/session"
 	"user_web/pkg/util"
-	"user_web/proto"
 	"user_web/request"
 	"user_web/response"
+	"userapi/v1"
 )
 
 // BaseController 登录前控制器
@@ -28,7 +29,7 @@ func (ctrl *BaseController) RegisterHandler(ctx *gin.Context) {
 
 	// rpc调用
 	rpcCtx := util.GenRpcCtxWithRequestID(ctx)
-	rsp, err := client.RpcClient.CreateUserProfile(rpcCtx, &proto.CreateUserRequest{
+	rsp, err := client.RpcClient.CreateUserProfile(rpcCtx, &userapi.CreateUserRequest{
 		Username: req.Username,
 		Password: req.Password,
 		Nickname: req.Nickname,
@@ -64,7 +65,7 @@ func (ctrl *BaseController) LoginHandler(ctx *gin.Context) {
 
 	// rpc调用
 	rpcCtx := util.GenRpcCtxWithRequestID(ctx)
-	rsp, err := client.RpcClient.Login(rpcCtx, &proto.LoginRequest{
+	rsp, err := client.RpcClient.Login(rpcCtx, &userapi.LoginRequest{
 		Username: req.Username,
 		Password: req.Password,
 	})
@@ -74,15 +75,81 @@ func (ctrl *BaseController) LoginHandler(ctx *gin.Context) {
 		return
 	}
 
+	// 开启两步验证时返回挑战, 通过 /login/mfa 提交验证码后完成登录
+	if rsp.MfaRequired {
+		data := gin.H{
+			"mfa_required":  true,
+			"mfa_challenge": rsp.MfaChallenge,
+		}
+		logger.Debug("login", "requestID:", requestID, "mfa required, username:", rsp.Username)
+		response.SuccessDataRsp(ctx, data)
+		return
+	}
+
+	loginSuccessRsp(ctx, "login", rsp)
+}
+
+// VerifyMFAHandler 两步验证登录接口, 使用登录返回的挑战和验证码或恢复码换取token
+func (ctrl *BaseController) VerifyMFAHandler(ctx *gin.Context) {
+	requestID := ctx.GetString("request_id")
+
+	// 验证请求参数
+	req := request.VerifyMFARequest{}
+	if err := request.Validate(ctx, &req, request.VerifyMFARequestValid); err != nil {
+		logger.Warn("verifyMFA", "requestID:", requestID, "request valid error", err.Error())
+		return
+	}
+
+	// rpc调用
+	rpcCtx := util.GenRpcCtxWithRequestID(ctx)
+	rsp, err := client.RpcClient.VerifyMFA(rpcCtx, &userapi.VerifyMFARequest{
+		Challenge: req.MFAChallenge,
+		Code:      req.Code,
+	})
+	if err != nil {
+		logger.Error("verifyMFA", "requestID:", requestID, "call verifyMFA error", err.Error())
+		response.RpcRspToHttpRsp(err, ctx)
+		return
+	}
+
+	loginSuccessRsp(ctx, "verifyMFA", rsp)
+}
+
+// 返回登录成功的用户信息和token, 登录和两步验证登录共用
+func loginSuccessRsp(ctx *gin.Context, module string, rsp *userapi.LoginResponse) {
+	requestID := ctx.GetString("request_id")
+
+	// 获取头像访问地址
+	avatar, avatars, err := util.WrapUploadPic(ctx, rsp.PicPath)
+	if err != nil {
+		logger.Error(module, "requestID:", requestID, "wrap upload pic error", err.Error())
+		response.ErrorRsp(ctx, err)
+		return
+	}
+
 	// 返回结果
 	data := gin.H{
 		"id":       rsp.Id,
 		"username": rsp.Username,
 		"nickname": rsp.Nickname,
 		"pic_path": rsp.PicPath,
+		"avatar":   avatar,
+		"avatars":  avatars,
 		"token":    rsp.Token,
 	}
-	logger.Debug("login", "requestID:", requestID, "login success", data)
+
+	// cookie会话模式下token只通过HttpOnly cookie下发, 前端使用csrf_token提交修改类请求
+	if session.Enabled() {
+		csrfToken, err := session.Start(ctx, rsp.Token)
+		if err != nil {
+			logger.Error(module, "requestID:", requestID, "start session error", err.Error())
+			response.ErrorRsp(ctx, err)
+			return
+		}
+		delete(data, "token")
+		data["csrf_token"] = csrfToken
+	}
+	logger.Debug(module, "requestID:", requestID, "login success", data)
 
 	response.SuccessDataRsp(ctx, data)
 }
@@ -90,11 +157,11 @@ func (ctrl *BaseController) LoginHandler(ctx *gin.Context) {
 // LogoutHandler 登出接口
 func (ctrl *BaseController) LogoutHandler(ctx *gin.Context) {
 	requestID := ctx.GetString("request_id")
-	token := ctx.GetHeader("Authorization")
+	token := ctx.GetString("token")
 
 	// rpc调用
 	rpcCtx := util.GenRpcCtxWithRequestID(ctx)
-	_, err := client.RpcClient.Logout(rpcCtx, &proto.AuthRequest{
+	_, err := client.RpcClient.Logout(rpcCtx, &userapi.AuthRequest{
 		Token: token,
 	})
 	if err != nil {
@@ -105,6 +172,9 @@ func (ctrl *BaseController) LogoutHandler(ctx *gin.Context) {
 	}
 
 	// 返回结果
+	if session.Enabled() {
+		session.End(ctx)
+	}
 	logger.Debug("logout", "requestID:", requestID, "token:", token, "logout success")
 
 	response.SuccessRsp(ctx)
@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+	"user_web/client"
+	"user_web/pkg/export"
+	"user_web/pkg/logger"
+	"user_web/pkg/storage"
+	"user_web/pkg/util"
+	"user_web/response"
+	"userapi/v1"
+)
+
+// 导出文件只允许下载到本地, 不允许缓存
+const exportCacheControl = "private, no-store"
+
+// ExportController 个人数据导出控制器
+type ExportController struct {
+}
+
+// CreateExportHandler 发起数据导出接口, 后台生成压缩包, 通过 GET /user/export 查询进度
+// 已有进行中的任务时直接返回该任务
+func (ctrl *ExportController) CreateExportHandler(ctx *gin.Context) {
+	requestID := ctx.GetString("request_id")
+	identity, _ := util.GetIdentity(ctx)
+
+	job, started, err := export.Start(identity.Username, time.Now())
+	if err != nil {
+		logger.Error("createExport", "requestID:", requestID, "start export job error", err.Error())
+		response.ErrorRsp(ctx, err)
+		return
+	}
+	if !started {
+		exportJobRsp(ctx, job)
+		return
+	}
+
+	// 身份凭证有效期较短, rpc在请求内完成, 只有压缩包在后台生成
+	rpcCtx := util.GenRpcCtxWithRequestID(ctx)
+	rsp, err := client.RpcClient.ExportUserData(rpcCtx, &userapi.ExportUserDataRequest{})
+	if err != nil {
+		logger.Error("createExport", "requestID:", requestID, "call exportUserData error", err.Error())
+		if failErr := export.Fail(identity.Username, job); failErr != nil {
+			logger.Error("createExport", "requestID:", requestID, "mark export job failed error", failErr.Error())
+		}
+		response.RpcRspToHttpRsp(err, ctx)
+		return
+	}
+
+	go func() {
+		buildCtx, cancel := context.WithTimeout(context.Background(), export.JobTimeout())
+		defer cancel()
+		if err := export.Run(buildCtx, storage.Store, identity.Username, job, rsp); err != nil {
+			logger.Error("createExport", "requestID:", requestID, "build export error", err.Error())
+			return
+		}
+		logger.Info("createExport", "requestID:", requestID, "export ready, id:", job.ID)
+	}()
+
+	ctx.Header("Location", "/user/export")
+	exportJobRsp(ctx, job)
+}
+
+// ShowExportHandler 查询数据导出进度接口, 完成后返回有时效的下载地址
+func (ctrl *ExportController) ShowExportHandler(ctx *gin.Context) {
+	requestID := ctx.GetString("request_id")
+	identity, _ := util.GetIdentity(ctx)
+
+	job, err := export.Get(identity.Username)
+	if err == export.ErrNotFound {
+		response.NotFoundRsp(ctx)
+		return
+	}
+	if err != nil {
+		logger.Error("showExport", "requestID:", requestID, "get export job error", err.Error())
+		response.ErrorRsp(ctx, err)
+		return
+	}
+	exportJobRsp(ctx, job)
+}
+
+// 返回导出任务, 进行中返回202
+func exportJobRsp(ctx *gin.Context, job *export.Job) {
+	data := gin.H{
+		"status":      job.Status,
+		"create_time": job.CreatedAt.Unix(),
+	}
+	switch job.Status {
+	case export.StatusPending:
+		ctx.JSON(http.StatusAccepted, gin.H{
+			"success": true,
+			"data":    data,
+		})
+		return
+	case export.StatusReady:
+		downloadURL, expire := export.DownloadURL(job)
+		data["ready_time"] = job.ReadyAt.Unix()
+		data["download_url"] = downloadURL
+		data["expire_time"] = expire.Unix()
+	}
+	response.SuccessDataRsp(ctx, data)
+}
+
+// DownloadExportHandler 下载导出文件接口, 通过地址中的签名校验, 不需要登录凭证
+func (ctrl *ExportController) DownloadExportHandler(ctx *gin.Context) {
+	requestID := ctx.GetString("request_id")
+	id := ctx.Param("id")
+
+	err := export.VerifyLink(id, ctx.Query("expires"), ctx.Query("signature"), time.Now())
+	if err == export.ErrLinkExpired {
+		response.LinkExpiredRsp(ctx)
+		return
+	}
+	if err != nil {
+		logger.Warn("downloadExport", "requestID:", requestID, "id:", id, "invalid link")
+		response.NotFoundRsp(ctx)
+		return
+	}
+
+	obj, err := storage.Store.Open(ctx, export.Key(id))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotExist) {
+			response.NotFoundRsp(ctx)
+			return
+		}
+		logger.Error("downloadExport", "requestID:", requestID, "id:", id, "open export error", err.Error())
+		response.ErrorRsp(ctx, err)
+		return
+	}
+	defer obj.Close()
+
+	ctx.Header("Content-Type", "application/zip")
+	ctx.Header("Content-Disposition", `attachment; filename="user-data-`+id+`.zip"`)
+	ctx.Header("X-Content-Type-Options", "nosniff")
+	ctx.Header("Cache-Control", exportCacheControl)
+	http.ServeContent(ctx.Writer, ctx.Request, "", obj.Info.ModTime, obj)
+}
@@ -0,0 +1,183 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"user_web/pkg/logger"
+	"user_web/pkg/storage"
+	"user_web/pkg/upload"
+	"user_web/pkg/util"
+	"user_web/response"
+)
+
+const (
+	// tus协议版本
+	tusResumable = "1.0.0"
+	// 追加数据请求的Content-Type
+	offsetOctetStream = "application/offset+octet-stream"
+)
+
+// UploadController 断点续传控制器
+// POST 创建上传会话, HEAD 查询已上传的偏移量, PATCH 从偏移量处继续上传, 上传完成后保存为头像
+type UploadController struct {
+}
+
+// 获取当前用户ID, 由认证中间件保存在上下文中
+func (ctrl *UploadController) userID(ctx *gin.Context) int64 {
+	identity, _ := util.GetIdentity(ctx)
+	return identity.UserID
+}
+
+// 获取当前用户的上传会话, 其他用户的会话视为不存在
+func (ctrl *UploadController) session(ctx *gin.Context, module string, userID int64) (*upload.Session, bool) {
+	requestID := ctx.GetString("request_id")
+
+	session, err := upload.Get(ctx.Param("id"))
+	if err == nil && session.UserID != userID {
+		err = upload.ErrNotFound
+	}
+	if err == upload.ErrNotFound {
+		logger.Warn(module, "requestID:", requestID, "upload session not found", ctx.Param("id"))
+		response.NotFoundRsp(ctx)
+		return nil, false
+	}
+	if err != nil {
+		logger.Error(module, "requestID:", requestID, "get upload session error", err.Error())
+		response.ErrorRsp(ctx, err)
+		return nil, false
+	}
+	return session, true
+}
+
+// 设置上传进度响应头
+func setUploadHeader(ctx *gin.Context, session *upload.Session) {
+	ctx.Header("Tus-Resumable", tusResumable)
+	ctx.Header("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	ctx.Header("Upload-Length", strconv.FormatInt(session.Length, 10))
+	ctx.Header("Cache-Control", "no-store")
+}
+
+// CreateUploadHandler 创建上传会话接口, 请求头 Upload-Length 为文件总长度
+func (ctrl *UploadController) CreateUploadHandler(ctx *gin.Context) {
+	requestID := ctx.GetString("request_id")
+
+	userID := ctrl.userID(ctx)
+
+	length, err := strconv.ParseInt(ctx.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		logger.Warn("createUpload", "requestID:", requestID, "invalid upload length", ctx.GetHeader("Upload-Length"))
+		response.BadRequestRsp(ctx, errors.New("invalid Upload-Length"))
+		return
+	}
+	if length > util.AvatarMaxSize() {
+		logger.Warn("createUpload", "requestID:", requestID, "upload length too large", length)
+		response.RequestEntityTooLargeRsp(ctx)
+		return
+	}
+
+	session, err := upload.Create(ctx.Request.Context(), storage.Store, userID, length)
+	if err != nil {
+		logger.Error("createUpload", "requestID:", requestID, "create upload session error", err.Error())
+		response.ErrorRsp(ctx, err)
+		return
+	}
+	logger.Debug("createUpload", "requestID:", requestID, "session:", session)
+
+	setUploadHeader(ctx, session)
+	ctx.Header("Location", "/user/avatar/uploads/"+session.ID)
+	response.CreatedDataRsp(ctx, gin.H{
+		"id":     session.ID,
+		"length": session.Length,
+	})
+}
+
+// HeadUploadHandler 查询上传进度接口
+func (ctrl *UploadController) HeadUploadHandler(ctx *gin.Context) {
+	userID := ctrl.userID(ctx)
+	session, ok := ctrl.session(ctx, "headUpload", userID)
+	if !ok {
+		return
+	}
+
+	setUploadHeader(ctx, session)
+	ctx.Status(http.StatusOK)
+}
+
+// PatchUploadHandler 继续上传接口, 请求头 Upload-Offset 必须等于已上传的长度
+// 上传完成后保存头像并返回与上传图片接口相同的结果, 未完成时返回204
+func (ctrl *UploadController) PatchUploadHandler(ctx *gin.Context) {
+	requestID := ctx.GetString("request_id")
+
+	userID := ctrl.userID(ctx)
+	if ctx.ContentType() != offsetOctetStream {
+		logger.Warn("patchUpload", "requestID:", requestID, "invalid content type", ctx.ContentType())
+		response.UnsupportedMediaTypeRsp(ctx)
+		return
+	}
+	offset, err := strconv.ParseInt(ctx.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		logger.Warn("patchUpload", "requestID:", requestID, "invalid upload offset", ctx.GetHeader("Upload-Offset"))
+		response.BadRequestRsp(ctx, errors.New("invalid Upload-Offset"))
+		return
+	}
+	session, ok := ctrl.session(ctx, "patchUpload", userID)
+	if !ok {
+		return
+	}
+	if offset != session.Offset {
+		logger.Warn("patchUpload", "requestID:", requestID, "offset mismatch", offset, session.Offset)
+		setUploadHeader(ctx, session)
+		response.UploadOffsetConflictRsp(ctx)
+		return
+	}
+
+	// 请求体不能超过剩余长度
+	body := http.MaxBytesReader(ctx.Writer, ctx.Request.Body, session.Length-session.Offset)
+	chunk, err := ioutil.ReadAll(body)
+	if err != nil {
+		logger.Warn("patchUpload", "requestID:", requestID, "read body error", err.Error())
+		if util.IsRequestBodyTooLarge(err) {
+			response.RequestEntityTooLargeRsp(ctx)
+		} else {
+			response.BadRequestRsp(ctx, err)
+		}
+		return
+	}
+
+	session.Offset, err = upload.Append(ctx.Request.Context(), storage.Store, session, offset, chunk)
+	if err == upload.ErrOffsetMismatch {
+		logger.Warn("patchUpload", "requestID:", requestID, "concurrent upload offset mismatch", offset)
+		response.UploadOffsetConflictRsp(ctx)
+		return
+	}
+	if err == upload.ErrNotFound {
+		response.NotFoundRsp(ctx)
+		return
+	}
+	if err != nil {
+		logger.Error("patchUpload", "requestID:", requestID, "append upload error", err.Error())
+		response.ErrorRsp(ctx, err)
+		return
+	}
+	setUploadHeader(ctx, session)
+	if !session.Done() {
+		ctx.Status(http.StatusNoContent)
+		return
+	}
+
+	// 上传完成, 保存头像后删除会话
+	data, err := upload.Data(ctx.Request.Context(), storage.Store, session.ID)
+	if err != nil {
+		logger.Error("patchUpload", "requestID:", requestID, "get upload data error", err.Error())
+		response.ErrorRsp(ctx, err)
+		return
+	}
+	saveAvatar(ctx, "patchUpload", bytes.NewReader(data))
+	if err = upload.Delete(ctx.Request.Context(), storage.Store, session); err != nil {
+		logger.Error("patchUpload", "requestID:", requestID, "delete upload session error", err.Error())
+	}
+}
@@ -1,52 +1,76 @@
 package handler
 
 import (
+	"context"
+	"errors"
 	"github.com/gin-gonic/gin"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"io"
 	"user_web/client"
+	"user_web/pkg/export"
+	"user_web/pkg/imaging"
 	"user_web/pkg/logger"
+	"user_web/pkg/session"
+	"user_web/pkg/storage"
 	"user_web/pkg/util"
-	"user_web/proto"
 	"user_web/request"
 	"user_web/response"
+	"userapi/v1"
 )
 
 // UserController 用户控制器
 type UserController struct {
 }
 
+// 用户信息的返回格式, 获取和编辑用户信息接口共用
+func profileData(ctx *gin.Context, rsp *userapi.UserResponse) (gin.H, error) {
+	avatar, avatars, err := util.WrapUploadPic(ctx, rsp.PicPath)
+	if err != nil {
+		return nil, err
+	}
+	return gin.H{
+		"id":          rsp.Id,
+		"username":    rsp.Username,
+		"nickname":    rsp.Nickname,
+		"pic_path":    rsp.PicPath,
+		"avatar":      avatar,
+		"avatars":     avatars,
+		"role":        rsp.Role,
+		"mfa_enabled": rsp.MfaEnabled,
+		"create_time": rsp.CreateTime,
+		"update_time": rsp.UpdateTime,
+	}, nil
+}
+
 // GetUserProfileHandler 获取用户信息接口
 func (ctrl *UserController) GetUserProfileHandler(ctx *gin.Context) {
 	requestID := ctx.GetString("request_id")
-	token := ctx.GetHeader("Authorization")
 
-	// rpc调用
+	// rpc调用, 通过身份凭证识别用户
 	rpcCtx := util.GenRpcCtxWithRequestID(ctx)
-	rsp, err := client.RpcClient.GetUserProfile(rpcCtx, &proto.AuthRequest{
-		Token: token,
-	})
+	rsp, err := client.RpcClient.GetUserProfile(rpcCtx, &userapi.AuthRequest{})
 	if err != nil {
-		logger.Error("getUserProfile", "requestID:", requestID,
-			"token:", token, "call getUserProfile error", err.Error())
+		logger.Error("getUserProfile", "requestID:", requestID, "call getUserProfile error", err.Error())
 		response.RpcRspToHttpRsp(err, ctx)
 		return
 	}
 
-	// 返回结果
-	data := gin.H{
-		"id":       rsp.Id,
-		"username": rsp.Username,
-		"nickname": rsp.Nickname,
-		"pic_path": rsp.PicPath,
+	// 获取头像访问地址并返回结果
+	data, err := profileData(ctx, rsp)
+	if err != nil {
+		logger.Error("getUserProfile", "requestID:", requestID, "wrap upload pic error", err.Error())
+		response.ErrorRsp(ctx, err)
+		return
 	}
 	logger.Debug("getUserProfile", "requestID:", requestID, "data:", data)
 
+	ctx.Header("ETag", util.VersionToETag(rsp.Version))
 	response.SuccessDataRsp(ctx, data)
 }
 
 // EditUserProfileHandler 编辑用户信息接口
 func (ctrl *UserController) EditUserProfileHandler(ctx *gin.Context) {
 	requestID := ctx.GetString("request_id")
-	token := ctx.GetHeader("Authorization")
 
 	// 验证请求参数
 	req := request.EditUserProfileRequest{}
@@ -55,47 +79,237 @@ func (ctrl *UserController) EditUserProfileHandler(ctx *gin.Context) {
 		return
 	}
 
+	// 解析If-Match中的版本号
+	rpcCtx := util.GenRpcCtxWithRequestID(ctx)
+	expectedVersion, ok := ifMatchVersion(ctx, rpcCtx, "editUserProfile")
+	if !ok {
+		return
+	}
+
+	// rpc调用
+	editReq := &userapi.EditUserRequest{
+		UpdateMask:      &fieldmaskpb.FieldMask{Paths: req.Paths},
+		ExpectedVersion: expectedVersion,
+	}
+	if req.Nickname != nil {
+		editReq.Nickname = *req.Nickname
+	}
+	rsp, err := client.RpcClient.EditUserProfile(rpcCtx, editReq)
+	if err != nil {
+		logger.Error("editUserProfile", "requestID:", requestID, "call editUserProfile error", err.Error())
+		response.RpcRspToHttpRsp(err, ctx)
+		return
+	}
+
+	// 获取头像访问地址并返回结果, 与获取用户信息接口相同
+	data, err := profileData(ctx, rsp)
+	if err != nil {
+		logger.Error("editUserProfile", "requestID:", requestID, "wrap upload pic error", err.Error())
+		response.ErrorRsp(ctx, err)
+		return
+	}
+	logger.Debug("editUserProfile", "requestID:", requestID, "data:", data)
+
+	ctx.Header("ETag", util.VersionToETag(rsp.Version))
+	response.SuccessDataRsp(ctx, data)
+}
+
+// 根据If-Match获取编辑时期望的版本号, 为0时不校验; 不满足条件时返回响应并返回false
+// If-Match包含多个ETag时先查询当前版本, 当前版本在列表中时按当前版本更新
+func ifMatchVersion(ctx *gin.Context, rpcCtx context.Context, module string) (int64, bool) {
+	requestID := ctx.GetString("request_id")
+	ifMatch := ctx.GetHeader("If-Match")
+	if len(ifMatch) == 0 {
+		return 0, true
+	}
+	versions, wildcard, err := util.IfMatchVersions(ifMatch)
+	if err != nil {
+		logger.Warn(module, "requestID:", requestID, "parse if-match error", err.Error())
+		response.BadRequestRsp(ctx, err)
+		return 0, false
+	}
+	switch {
+	case wildcard:
+		return 0, true
+	case len(versions) == 0:
+		logger.Warn(module, "requestID:", requestID, "no strong etag matches, if-match:", ifMatch)
+		response.PreconditionFailedRsp(ctx)
+		return 0, false
+	case len(versions) == 1:
+		return versions[0], true
+	}
+
+	rsp, err := client.RpcClient.GetUserProfile(rpcCtx, &userapi.AuthRequest{})
+	if err != nil {
+		logger.Error(module, "requestID:", requestID, "call getUserProfile error", err.Error())
+		response.RpcRspToHttpRsp(err, ctx)
+		return 0, false
+	}
+	for _, version := range versions {
+		if version == rsp.Version {
+			return version, true
+		}
+	}
+	logger.Warn(module, "requestID:", requestID, "version not in if-match, version:", rsp.Version)
+	response.PreconditionFailedRsp(ctx)
+	return 0, false
+}
+
+// DeleteAccountHandler 注销账号接口, 保留期内可以联系管理员恢复
+func (ctrl *UserController) DeleteAccountHandler(ctx *gin.Context) {
+	requestID := ctx.GetString("request_id")
+
+	// 验证请求参数
+	req := request.DeleteAccountRequest{}
+	if err := request.Validate(ctx, &req, request.DeleteAccountRequestValid); err != nil {
+		logger.Warn("deleteAccount", "requestID:", requestID, "request valid error", err.Error())
+		return
+	}
+
 	// rpc调用
 	rpcCtx := util.GenRpcCtxWithRequestID(ctx)
-	rsp, err := client.RpcClient.EditUserProfile(rpcCtx, &proto.EditUserRequest{
-		Token:    token,
-		Nickname: req.Nickname,
+	rsp, err := client.RpcClient.DeleteAccount(rpcCtx, &userapi.DeleteAccountRequest{
+		Password: req.Password,
 	})
 	if err != nil {
-		logger.Error("editUserProfile", "requestID:", requestID,
-			"token:", token, "call editUserProfile error", err.Error())
+		logger.Error("deleteAccount", "requestID:", requestID, "call deleteAccount error", err.Error())
+		response.RpcRspToHttpRsp(err, ctx)
+		return
+	}
+
+	// 所有token已失效, 同时结束cookie会话
+	if session.Enabled() {
+		session.End(ctx)
+	}
+	// 删除已导出的个人数据, 失败时由 export_gc 在下载地址过期后删除
+	if identity, ok := util.GetIdentity(ctx); ok {
+		if err = export.Remove(ctx.Request.Context(), storage.Store, identity.Username); err != nil {
+			logger.Error("deleteAccount", "requestID:", requestID, "remove export error", err.Error())
+		}
+	}
+	logger.Debug("deleteAccount", "requestID:", requestID, "delete account success")
+
+	response.SuccessDataRsp(ctx, gin.H{
+		"purge_time": rsp.PurgeTime,
+	})
+}
+
+// ListLoginsHandler 查看登录记录接口, 按时间倒序返回最近的登录
+func (ctrl *UserController) ListLoginsHandler(ctx *gin.Context) {
+	requestID := ctx.GetString("request_id")
+
+	// 验证请求参数
+	req := request.ListLoginsRequest{}
+	if err := request.Validate(ctx, &req, request.ListLoginsRequestValid); err != nil {
+		logger.Warn("listLogins", "requestID:", requestID, "request valid error", err.Error())
+		return
+	}
+
+	// rpc调用, 通过身份凭证识别用户
+	rpcCtx := util.GenRpcCtxWithRequestID(ctx)
+	rsp, err := client.RpcClient.ListLogins(rpcCtx, &userapi.ListLoginsRequest{Limit: int32(req.Limit)})
+	if err != nil {
+		logger.Error("listLogins", "requestID:", requestID, "call listLogins error", err.Error())
 		response.RpcRspToHttpRsp(err, ctx)
 		return
 	}
 
 	// 返回结果
-	data := gin.H{
-		"username": rsp.Username,
-		"nickname": rsp.Nickname,
-		"pic_path": rsp.PicPath,
+	logins := make([]gin.H, 0, len(rsp.Logins))
+	for _, login := range rsp.Logins {
+		logins = append(logins, gin.H{
+			"time":       login.Time,
+			"ip":         login.Ip,
+			"user_agent": login.UserAgent,
+			"device":     login.Device,
+			"new_device": login.NewDevice,
+		})
 	}
-	logger.Debug("editUserProfile", "requestID:", requestID, "data:", data)
+	response.SuccessDataRsp(ctx, gin.H{
+		"logins": logins,
+	})
+}
 
-	response.SuccessDataRsp(ctx, data)
+// EnrollMFAHandler 开始设置两步验证接口, 返回密钥和 otpauth:// 地址, 由前端生成二维码
+func (ctrl *UserController) EnrollMFAHandler(ctx *gin.Context) {
+	requestID := ctx.GetString("request_id")
+
+	// rpc调用, 通过身份凭证识别用户
+	rpcCtx := util.GenRpcCtxWithRequestID(ctx)
+	rsp, err := client.RpcClient.EnrollMFA(rpcCtx, &userapi.EnrollMFARequest{})
+	if err != nil {
+		logger.Error("enrollMFA", "requestID:", requestID, "call enrollMFA error", err.Error())
+		response.RpcRspToHttpRsp(err, ctx)
+		return
+	}
+
+	// 密钥只在设置时返回一次
+	ctx.Header("Cache-Control", "no-store")
+	response.SuccessDataRsp(ctx, gin.H{
+		"secret":           rsp.Secret,
+		"provisioning_uri": rsp.ProvisioningUri,
+	})
 }
 
-// UploadPicHandler 上传图片接口
-func (ctrl *UserController) UploadPicHandler(ctx *gin.Context) {
+// ConfirmMFAHandler 确认开启两步验证接口, 返回一次性恢复码
+func (ctrl *UserController) ConfirmMFAHandler(ctx *gin.Context) {
 	requestID := ctx.GetString("request_id")
-	token := ctx.GetHeader("Authorization")
 
-	// rcp调用：认证
+	// 验证请求参数
+	req := request.ConfirmMFARequest{}
+	if err := request.Validate(ctx, &req, request.ConfirmMFARequestValid); err != nil {
+		logger.Warn("confirmMFA", "requestID:", requestID, "request valid error", err.Error())
+		return
+	}
+
+	// rpc调用, 通过身份凭证识别用户
 	rpcCtx := util.GenRpcCtxWithRequestID(ctx)
-	_, err := client.RpcClient.Auth(rpcCtx, &proto.AuthRequest{
-		Token: token,
+	rsp, err := client.RpcClient.ConfirmMFA(rpcCtx, &userapi.ConfirmMFARequest{Code: req.Code})
+	if err != nil {
+		logger.Error("confirmMFA", "requestID:", requestID, "call confirmMFA error", err.Error())
+		response.RpcRspToHttpRsp(err, ctx)
+		return
+	}
+
+	// 恢复码只在开启时返回一次
+	logger.Debug("confirmMFA", "requestID:", requestID, "confirm mfa success")
+	ctx.Header("Cache-Control", "no-store")
+	response.SuccessDataRsp(ctx, gin.H{
+		"recovery_codes": rsp.RecoveryCodes,
+	})
+}
+
+// DisableMFAHandler 关闭两步验证接口, 需要密码和验证码或恢复码
+func (ctrl *UserController) DisableMFAHandler(ctx *gin.Context) {
+	requestID := ctx.GetString("request_id")
+
+	// 验证请求参数
+	req := request.DisableMFARequest{}
+	if err := request.Validate(ctx, &req, request.DisableMFARequestValid); err != nil {
+		logger.Warn("disableMFA", "requestID:", requestID, "request valid error", err.Error())
+		return
+	}
+
+	// rpc调用, 通过身份凭证识别用户
+	rpcCtx := util.GenRpcCtxWithRequestID(ctx)
+	_, err := client.RpcClient.DisableMFA(rpcCtx, &userapi.DisableMFARequest{
+		Password: req.Password,
+		Code:     req.Code,
 	})
 	if err != nil {
-		logger.Error("uploadPic", "requestID:", requestID,
-			"token:", token, "call auth error", err.Error())
+		logger.Error("disableMFA", "requestID:", requestID, "call disableMFA error", err.Error())
 		response.RpcRspToHttpRsp(err, ctx)
 		return
 	}
 
+	logger.Debug("disableMFA", "requestID:", requestID, "disable mfa success")
+	response.SuccessRsp(ctx)
+}
+
+// UploadPicHandler 上传图片接口
+func (ctrl *UserController) UploadPicHandler(ctx *gin.Context) {
+	requestID := ctx.GetString("request_id")
+
 	// 验证图片格式
 	req := request.UploadPicRequest{}
 	if err := request.Validate(ctx, &req, request.UploadPicValid); err != nil {
@@ -103,32 +317,67 @@ func (ctrl *UserController) UploadPicHandler(ctx *gin.Context) {
 		return
 	}
 
+	// 保存图片并更新用户信息
+	f, err := req.Avatar.Open()
+	if err != nil {
+		logger.Error("uploadPic", "requestID:", requestID, "open upload file error", err.Error())
+		response.ErrorRsp(ctx, err)
+		return
+	}
+	defer f.Close()
+	saveAvatar(ctx, "uploadPic", f)
+}
+
+// 保存头像并更新用户信息, 普通上传和断点续传共用
+func saveAvatar(ctx *gin.Context, module string, reader io.Reader) {
+	requestID := ctx.GetString("request_id")
+
 	// 保存图片
-	avatar, err := util.SaveUploadPic(ctx, req.Avatar)
+	avatar, err := util.SaveUploadPic(ctx, reader)
+	if err == util.ErrAvatarTooLarge {
+		logger.Warn(module, "requestID:", requestID, "avatar too large")
+		response.RequestEntityTooLargeRsp(ctx)
+		return
+	}
+	if errors.Is(err, imaging.ErrInvalidImage) {
+		logger.Warn(module, "requestID:", requestID, "invalid image", err.Error())
+		response.ValidErrorRsp(ctx, err)
+		return
+	}
 	if err != nil {
-		logger.Error("uploadPic", "requestID:", requestID,
+		logger.Error(module, "requestID:", requestID,
 			"save upload pic error", err.Error())
-		response.RpcRspToHttpRsp(err, ctx)
+		response.ErrorRsp(ctx, err)
 		return
 	}
 
 	// rpc调用：更新用户信息
-	_, err = client.RpcClient.EditUserProfile(rpcCtx, &proto.EditUserRequest{
-		Token:   token,
-		PicPath: avatar,
+	rpcCtx := util.GenRpcCtxWithRequestID(ctx)
+	_, err = client.RpcClient.EditUserProfile(rpcCtx, &userapi.EditUserRequest{
+		PicPath:    avatar,
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"pic_path"}},
 	})
 	if err != nil {
-		logger.Error("uploadPic", "requestID:", requestID,
-			"call editUserProfile error", err.Error())
+		// 已保存的头像没有被引用, 超过宽限期后由 cmd/avatar_gc 回收
+		logger.Error(module, "requestID:", requestID,
+			"call editUserProfile error", err.Error(), "avatar:", avatar)
 		response.RpcRspToHttpRsp(err, ctx)
 		return
 	}
 
 	// 返回结果
+	avatarURL, avatars, err := util.WrapUploadPic(ctx, avatar)
+	if err != nil {
+		logger.Error(module, "requestID:", requestID, "wrap upload pic error", err.Error())
+		response.ErrorRsp(ctx, err)
+		return
+	}
 	data := gin.H{
-		"avatar": util.WrapUploadPic(avatar),
+		"pic_path": avatar,
+		"avatar":   avatarURL,
+		"avatars":  avatars,
 	}
-	logger.Debug("uploadPic", "requestID:", requestID, "upload success, data", data)
+	logger.Debug(module, "requestID:", requestID, "upload success, data", data)
 
 	response.SuccessDataRsp(ctx, data)
 }
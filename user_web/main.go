@@ -3,23 +3,36 @@ package main
 import (
 	"context"
 	"github.com/gin-gonic/gin"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"time"
 	"user_web/client"
 	"user_web/pkg/config"
+	"user_web/pkg/export"
 	"user_web/pkg/logger"
 	"user_web/pkg/redis"
+	"user_web/pkg/storage"
+	"user_web/pkg/tlsconfig"
 	"user_web/route"
 )
 
 func init() {
-	// 初始化logger、config、redis、client
+	// 初始化logger、config、redis、storage、导出签名密钥、tls、client、https证书
 	config.SetupConfig()
 	logger.SetupLogger()
 	redis.SetupRedis()
+	storage.SetupStorage()
+	export.SetupExport()
+	tlsconfig.SetupTLS()
 	client.SetupClient()
+	tlsconfig.SetupWebTLS()
+}
+
+// 读取以秒为单位的超时配置
+func timeout(key string) time.Duration {
+	return time.Duration(config.GetInt(key)) * time.Second
 }
 
 func destroy() {
@@ -34,17 +47,47 @@ func main() {
 	route.RegisterHandler(r)
 	gin.SetMode(gin.ReleaseMode)
 	srv := &http.Server{
-		Addr:    config.GetString("WEB_SERVER_ADDR"),
-		Handler: r,
+		Addr:              config.GetString("WEB_SERVER_ADDR"),
+		Handler:           r,
+		TLSConfig:         tlsconfig.WebTLS,
+		ReadHeaderTimeout: timeout("WEB_READ_HEADER_TIMEOUT"),
+		ReadTimeout:       timeout("WEB_READ_TIMEOUT"),
+		WriteTimeout:      timeout("WEB_WRITE_TIMEOUT"),
+		IdleTimeout:       timeout("WEB_IDLE_TIMEOUT"),
 	}
 
-	// 启动http服务
+	// 启动http服务, 配置了证书时使用https
 	go func() {
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if srv.TLSConfig != nil {
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			logger.Error("main", "listenAndServe err", err)
 		}
 	}()
 
+	// 开启https时, WEB_HTTP_REDIRECT_ADDR 上的http请求跳转到https
+	var redirectSrv *http.Server
+	if addr := config.GetString("WEB_HTTP_REDIRECT_ADDR"); srv.TLSConfig != nil && len(addr) > 0 {
+		_, port, _ := net.SplitHostPort(srv.Addr)
+		redirectSrv = &http.Server{
+			Addr:              addr,
+			Handler:           route.RedirectHandler(port, config.GetString("WEB_TLS_ACME_WEBROOT")),
+			ReadHeaderTimeout: timeout("WEB_READ_HEADER_TIMEOUT"),
+			ReadTimeout:       timeout("WEB_READ_TIMEOUT"),
+			WriteTimeout:      timeout("WEB_WRITE_TIMEOUT"),
+			IdleTimeout:       timeout("WEB_IDLE_TIMEOUT"),
+		}
+		go func() {
+			if err := redirectSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Error("main", "redirect listenAndServe err", err)
+			}
+		}()
+	}
+
 	// 监听退出信号
 	quit := make(chan os.Signal)
 	signal.Notify(quit, os.Interrupt)
@@ -57,6 +100,11 @@ func main() {
 	if err := srv.Shutdown(ctx); err != nil {
 		logger.Error("main", "shutdown server err", err)
 	}
+	if redirectSrv != nil {
+		if err := redirectSrv.Shutdown(ctx); err != nil {
+			logger.Error("main", "shutdown redirect server err", err)
+		}
+	}
 	destroy()
 	logger.Info("main", "server exit")
 }
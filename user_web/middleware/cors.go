@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+	"user_web/pkg/config"
+	"user_web/pkg/logger"
+	"user_web/pkg/session"
+)
+
+// 跨域请求允许携带的请求头, 包括断点续传和条件更新使用的请求头
+var corsAllowHeaders = strings.Join([]string{
+	"Authorization", "Content-Type", session.CSRFHeader, "If-Match", "If-None-Match",
+	"Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Metadata",
+}, ", ")
+
+// 跨域请求允许前端读取的响应头
+var corsExposeHeaders = strings.Join([]string{
+	"ETag", "Location", "Retry-After", "Tus-Resumable", "Upload-Offset", "Upload-Length",
+	"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy",
+}, ", ")
+
+const corsAllowMethods = "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS"
+
+// CORS 跨域中间件, WEB_CORS_ALLOWED_ORIGINS 为允许的来源, 逗号分隔, * 表示任意来源, 为空时不允许跨域
+// WEB_CORS_ALLOW_CREDENTIALS 开启时明确配置的来源可以携带cookie, 通过 * 匹配的来源不能携带
+func CORS() gin.HandlerFunc {
+	origins := make(map[string]bool)
+	for _, origin := range strings.Split(config.GetString("WEB_CORS_ALLOWED_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); len(origin) > 0 {
+			origins[strings.TrimSuffix(origin, "/")] = true
+		}
+	}
+	credentials := config.GetBool("WEB_CORS_ALLOW_CREDENTIALS")
+	maxAge := strconv.Itoa(config.GetInt("WEB_CORS_MAX_AGE"))
+
+	return func(ctx *gin.Context) {
+		origin := ctx.GetHeader("Origin")
+		if len(origin) == 0 {
+			ctx.Next()
+			return
+		}
+		ctx.Writer.Header().Add("Vary", "Origin")
+		preflight := ctx.Request.Method == http.MethodOptions && len(ctx.GetHeader("Access-Control-Request-Method")) > 0
+
+		exact := origins[origin]
+		if !exact && !origins["*"] {
+			if preflight {
+				logger.Warn("middleware", "requestID:", ctx.GetString("request_id"), "cors origin not allowed", origin)
+				ctx.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			ctx.Next()
+			return
+		}
+
+		header := ctx.Writer.Header()
+		if exact {
+			header.Set("Access-Control-Allow-Origin", origin)
+			if credentials {
+				header.Set("Access-Control-Allow-Credentials", "true")
+			}
+		} else {
+			header.Set("Access-Control-Allow-Origin", "*")
+		}
+		if !preflight {
+			header.Set("Access-Control-Expose-Headers", corsExposeHeaders)
+			ctx.Next()
+			return
+		}
+
+		header.Set("Access-Control-Allow-Methods", corsAllowMethods)
+		header.Set("Access-Control-Allow-Headers", corsAllowHeaders)
+		if maxAge != "0" {
+			header.Set("Access-Control-Max-Age", maxAge)
+		}
+		ctx.AbortWithStatus(http.StatusNoContent)
+	}
+}
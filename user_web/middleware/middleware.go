@@ -3,10 +3,27 @@ package middleware
 import (
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
+	"user_web/client"
+	"user_web/pkg/bearer"
+	"user_web/pkg/config"
 	"user_web/pkg/logger"
+	"user_web/pkg/ratelimit"
+	"user_web/pkg/redis"
+	"user_web/pkg/session"
 	"user_web/pkg/util"
+	"user_web/response"
+	"userapi/v1"
+)
+
+// token 的来源
+const (
+	TokenSourceHeader = "header"
+	TokenSourceCookie = "cookie"
 )
 
 // GenerateGlobalRequestID 根据时间戳生成请求ID
@@ -19,15 +36,167 @@ func GenerateGlobalRequestID() gin.HandlerFunc {
 	}
 }
 
-// CheckTokenExist 检查Authorization参数
+// SecurityHeaders 添加安全相关的响应头
+// HSTS 只在https请求中返回, WEB_HSTS_MAX_AGE 为0时不返回
+func SecurityHeaders() gin.HandlerFunc {
+	hsts := ""
+	if maxAge := config.GetInt("WEB_HSTS_MAX_AGE"); maxAge > 0 {
+		hsts = fmt.Sprintf("max-age=%d; includeSubDomains", maxAge)
+	}
+
+	return func(ctx *gin.Context) {
+		header := ctx.Writer.Header()
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("X-Frame-Options", "DENY")
+		header.Set("Referrer-Policy", "no-referrer")
+		// 接口只返回json, 不需要加载任何资源
+		header.Set("Content-Security-Policy", "default-src 'none'; frame-ancestors 'none'")
+		if ctx.Request.TLS != nil && len(hsts) > 0 {
+			header.Set("Strict-Transport-Security", hsts)
+		}
+		ctx.Next()
+	}
+}
+
+// CheckTokenExist 检查登录凭证, 支持 Authorization 请求头(RFC 6750 Bearer)和会话cookie, 请求头优先
+// token 保存在上下文的 token 中, 来源保存在 token_source 中
 func CheckTokenExist() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		token := ctx.GetHeader("Authorization")
+		requestID := ctx.GetString("request_id")
+		var token, source string
+		if header := ctx.GetHeader("Authorization"); len(header) > 0 {
+			var err error
+			token, err = bearer.Parse(header)
+			if err == bearer.ErrMalformed {
+				logger.Warn("middleware", "requestID:", requestID, "malformed authorization header")
+				response.MalformedTokenRsp(ctx)
+				return
+			}
+			source = TokenSourceHeader
+		} else if session.Enabled() {
+			token, source = session.Token(ctx), TokenSourceCookie
+		}
 		if len(token) == 0 {
-			logger.Warn("middleware", "token not exist", token)
-			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"message": "token 必传",
-			})
+			logger.Warn("middleware", "requestID:", requestID, "token not exist")
+			response.MissingTokenRsp(ctx)
+			return
+		}
+		ctx.Set("token", token)
+		ctx.Set("token_source", source)
+		ctx.Next()
+	}
+}
+
+// Authenticate 调用一次rpc校验token, 用户身份保存在上下文的 identity 中, 需要在 CheckTokenExist 之后使用
+// 后续rpc请求携带身份凭证代替token
+func Authenticate() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		requestID := ctx.GetString("request_id")
+		rpcCtx := util.GenRpcCtxWithRequestID(ctx)
+		rsp, err := client.RpcClient.Authenticate(rpcCtx, &userapi.AuthRequest{
+			Token: ctx.GetString("token"),
+		})
+		if err != nil {
+			logger.Warn("middleware", "requestID:", requestID, "call authenticate error", err.Error())
+			response.RpcRspToHttpRsp(err, ctx)
+			return
+		}
+		ctx.Set("identity", util.Identity{
+			UserID:    rsp.Id,
+			Username:  rsp.Username,
+			Assertion: rsp.Identity,
+		})
+		ctx.Next()
+	}
+}
+
+// CSRFProtect 校验cookie会话的修改类请求的CSRF token, 通过请求头认证的请求不受CSRF影响
+func CSRFProtect() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		switch ctx.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			ctx.Next()
+			return
+		}
+		if ctx.GetString("token_source") == TokenSourceCookie && !session.CheckCSRF(ctx) {
+			logger.Warn("middleware", "requestID:", ctx.GetString("request_id"), "csrf token mismatch", ctx.FullPath())
+			response.CSRFFailedRsp(ctx)
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// LimitRequestBody 限制请求体大小, 在解析请求体之前生效
+// Content-Length 已超过限制时直接拒绝, 否则读取超过限制时报错
+func LimitRequestBody(limit int64) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if ctx.Request.ContentLength > limit {
+			logger.Warn("middleware", "request body too large", ctx.Request.ContentLength)
+			response.RequestEntityTooLargeRsp(ctx)
+			return
+		}
+		ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, limit)
+		ctx.Next()
+	}
+}
+
+// RateLimit 按配置 RATE_LIMIT_<NAME> 中的策略限流, 未配置时不限流
+// 所有策略在同一个lua脚本中检查并计数, 超过任一策略时都不计数; 响应头 RateLimit-* 返回剩余最少的策略,
+// 超过限制时返回429; redis不可用时放行. 按 user 限流的策略需要在 Authenticate 之后使用
+func RateLimit(name string) gin.HandlerFunc {
+	policies, err := ratelimit.ParsePolicies(config.GetString("RATE_LIMIT_" + strings.ToUpper(name)))
+	if err != nil {
+		logger.Error("middleware", "parse rate limit policies error", err)
+		panic(err)
+	}
+	limiter := ratelimit.NewLimiter(redis.Redis, config.GetString("RATE_LIMIT_PREFIX"))
+	policyHeader := make([]string, 0, len(policies))
+	for _, policy := range policies {
+		policyHeader = append(policyHeader, fmt.Sprintf("%d;w=%d", policy.Limit, int64(policy.Window/time.Second)))
+	}
+
+	return func(ctx *gin.Context) {
+		requestID := ctx.GetString("request_id")
+		if len(policies) == 0 {
+			ctx.Next()
+			return
+		}
+
+		subjects := make([]string, 0, len(policies))
+		for _, policy := range policies {
+			subject := ctx.ClientIP()
+			if token := ctx.GetString("token"); policy.Key == ratelimit.KeyToken && len(token) > 0 {
+				subject = util.Md5Data(token)
+			}
+			if identity, ok := util.GetIdentity(ctx); policy.Key == ratelimit.KeyUser && ok {
+				subject = util.Md5Data(identity.Username)
+			}
+			subjects = append(subjects, subject)
+		}
+		results, err := limiter.Allow(name, policies, subjects, time.Now())
+		if err != nil {
+			logger.Error("middleware", "requestID:", requestID, "rate limit error", err.Error())
+			ctx.Next()
+			return
+		}
+		// 返回被拒绝的策略, 都允许时返回剩余最少的策略
+		var result *ratelimit.Result
+		for i := range results {
+			res := &results[i]
+			if result == nil || (result.Allowed && (!res.Allowed || res.Remaining < result.Remaining)) {
+				result = res
+			}
+		}
+		reset := strconv.FormatInt(int64(math.Ceil(result.Reset.Seconds())), 10)
+		ctx.Header("RateLimit-Limit", strconv.Itoa(result.Policy.Limit))
+		ctx.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		ctx.Header("RateLimit-Reset", reset)
+		ctx.Header("RateLimit-Policy", strings.Join(policyHeader, ", "))
+		if !result.Allowed {
+			logger.Warn("middleware", "requestID:", requestID, "rate limited", name, result.Policy.String(), ctx.ClientIP())
+			ctx.Header("Retry-After", reset)
+			response.TooManyRequestsRsp(ctx)
 			return
 		}
 		ctx.Next()
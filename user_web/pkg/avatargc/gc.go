@@ -0,0 +1,147 @@
+// Package avatargc 回收不再被任何用户引用的头像文件
+//
+// 头像按内容寻址保存, 多个用户可能共用同一个文件, 因此上传失败或更换头像时不能直接删除旧文件.
+// 引用关系以各个 users_N 分表中的 pic_path 为准: 存储中的文件(包括各尺寸缩略图)只要对应的
+// pic_path 仍被引用就保留, 否则在超过宽限期后删除. 宽限期用于保护刚写入存储、还未写入用户信息的头像.
+package avatargc
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc/metadata"
+	"io"
+	"strings"
+	"time"
+	"user_web/pkg/storage"
+	"user_web/pkg/util"
+	"userapi/v1"
+)
+
+// ErrInvalidPrefix 回收前缀不在头像前缀下, 拒绝回收其他数据(例如导出文件)
+var ErrInvalidPrefix = errors.New("avatargc: prefix must be " + util.AvatarPrefix + " or a directory under it")
+
+// Authenticate 校验管理员的token, 返回携带身份凭证的ctx; 遍历头像路径需要 avatar:gc 权限, ctx 需要带上请求ID
+func Authenticate(ctx context.Context, rpcClient userapi.UserServiceClient, token string) (context.Context, error) {
+	rsp, err := rpcClient.Authenticate(ctx, &userapi.AuthRequest{Token: token})
+	if err != nil {
+		return nil, err
+	}
+	return metadata.AppendToOutgoingContext(ctx, util.IdentityMetadataKey, rsp.Identity), nil
+}
+
+// 校验回收前缀, 只能是头像前缀或其子目录
+func checkPrefix(prefix string) error {
+	key, err := storage.CleanKey(strings.TrimSuffix(prefix, "/"))
+	if err != nil || (key != util.AvatarPrefix && !strings.HasPrefix(key, util.AvatarPrefix+"/")) {
+		return ErrInvalidPrefix
+	}
+	return nil
+}
+
+// References 加载所有正在使用的头像路径
+type References func(ctx context.Context) (map[string]bool, error)
+
+// RPCReferences 通过 user_rpc 加载所有用户分表中正在使用的头像路径, ctx 需要带上请求ID
+func RPCReferences(rpcClient userapi.UserServiceClient) References {
+	return func(ctx context.Context) (map[string]bool, error) {
+		stream, err := rpcClient.ListPicPaths(ctx, &userapi.ListPicPathsRequest{})
+		if err != nil {
+			return nil, err
+		}
+		refs := make(map[string]bool)
+		for {
+			rsp, err := stream.Recv()
+			if err == io.EOF {
+				return refs, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			refs[rsp.PicPath] = true
+		}
+	}
+}
+
+// Options 回收配置
+type Options struct {
+	// Prefix 需要回收的存储前缀, 只能是 util.AvatarPrefix 或其子目录
+	Prefix string
+	// GracePeriod 宽限期, 修改时间在宽限期内的文件不回收
+	GracePeriod time.Duration
+	// DryRun 只生成报告, 不删除文件
+	DryRun bool
+}
+
+// Report 回收报告
+type Report struct {
+	// Scanned 扫描的文件数
+	Scanned int
+	// Referenced 仍被引用的文件数
+	Referenced int
+	// Recent 未被引用但仍在宽限期内的文件数
+	Recent int
+	// Orphans 需要回收的文件, DryRun 时不会删除
+	Orphans []storage.ObjectInfo
+	// Deleted 实际删除的文件数
+	Deleted int
+	// FreedBytes 实际释放的空间
+	FreedBytes int64
+}
+
+// Run 执行一次回收
+// 先扫描存储再加载引用, 扫描之后才写入的引用也能被看到; 删除前再次检查修改时间,
+// 避免删除扫描之后被重新上传的文件
+func Run(ctx context.Context, store storage.Storage, references References, opts Options) (*Report, error) {
+	if err := checkPrefix(opts.Prefix); err != nil {
+		return nil, err
+	}
+	report := &Report{}
+	cutoff := time.Now().Add(-opts.GracePeriod)
+
+	var candidates []storage.ObjectInfo
+	err := store.List(ctx, opts.Prefix, func(info storage.ObjectInfo) error {
+		report.Scanned++
+		candidates = append(candidates, info)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	refs, err := references(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, info := range candidates {
+		if refs[info.Key] || refs[util.AvatarBaseKey(info.Key)] {
+			report.Referenced++
+			continue
+		}
+		if info.ModTime.After(cutoff) {
+			report.Recent++
+			continue
+		}
+		report.Orphans = append(report.Orphans, info)
+		if opts.DryRun {
+			continue
+		}
+
+		current, err := store.Stat(ctx, info.Key)
+		if err == storage.ErrNotExist {
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+		if current.ModTime.After(cutoff) {
+			continue
+		}
+		if err = store.Delete(ctx, info.Key); err != nil {
+			return report, err
+		}
+		report.Deleted++
+		report.FreedBytes += current.Size
+	}
+	return report, nil
+}
@@ -0,0 +1,140 @@
+package avatargc
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+	"user_web/pkg/storage"
+)
+
+// 写入文件并设置修改时间
+func putFile(t *testing.T, store *storage.LocalStorage, key string, modTime time.Time) {
+	t.Helper()
+	if err := store.Put(context.Background(), key, bytes.NewReader([]byte(key)), int64(len(key)), ""); err != nil {
+		t.Fatalf("put %s: %v", key, err)
+	}
+	if err := os.Chtimes(filepath.Join(store.Dir, filepath.FromSlash(key)), modTime, modTime); err != nil {
+		t.Fatalf("chtimes %s: %v", key, err)
+	}
+}
+
+func newTestStore(t *testing.T) *storage.LocalStorage {
+	store := storage.NewLocalStorage(t.TempDir(), "")
+	old := time.Now().Add(-48 * time.Hour)
+	// a 被引用, b 未被引用, c 未被引用但刚上传, legacy 是没有缩略图的旧头像
+	for _, size := range []string{"64", "128", "512"} {
+		putFile(t, store, "avatars/aa/a_"+size+".png", old)
+		putFile(t, store, "avatars/bb/b_"+size+".png", old)
+		putFile(t, store, "avatars/cc/c_"+size+".png", time.Now())
+	}
+	putFile(t, store, "avatars/legacy.png", old)
+	return store
+}
+
+func staticReferences(refs ...string) References {
+	return func(ctx context.Context) (map[string]bool, error) {
+		m := make(map[string]bool)
+		for _, ref := range refs {
+			m[ref] = true
+		}
+		return m, nil
+	}
+}
+
+func orphanKeys(report *Report) []string {
+	var keys []string
+	for _, info := range report.Orphans {
+		keys = append(keys, info.Key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	refs := staticReferences("avatars/aa/a.png", "avatars/legacy.png")
+	opts := Options{Prefix: "avatars", GracePeriod: 24 * time.Hour, DryRun: true}
+
+	// dry-run 只生成报告
+	store := newTestStore(t)
+	report, err := Run(ctx, store, refs, opts)
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	expected := []string{"avatars/bb/b_128.png", "avatars/bb/b_512.png", "avatars/bb/b_64.png"}
+	if keys := orphanKeys(report); len(keys) != 3 || keys[0] != expected[0] || keys[2] != expected[2] {
+		t.Fatalf("unexpected orphans: %v", keys)
+	}
+	if report.Scanned != 10 || report.Referenced != 4 || report.Recent != 3 || report.Deleted != 0 {
+		t.Fatalf("unexpected dry run report: %+v", report)
+	}
+	if ok, _ := store.Exists(ctx, expected[0]); !ok {
+		t.Fatalf("dry run should not delete files")
+	}
+
+	// 实际删除
+	opts.DryRun = false
+	report, err = Run(ctx, store, refs, opts)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if report.Deleted != 3 || report.FreedBytes == 0 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	for _, key := range expected {
+		if ok, _ := store.Exists(ctx, key); ok {
+			t.Fatalf("orphan %s not deleted", key)
+		}
+	}
+	for _, key := range []string{"avatars/aa/a_64.png", "avatars/cc/c_512.png", "avatars/legacy.png"} {
+		if ok, _ := store.Exists(ctx, key); !ok {
+			t.Fatalf("%s should be kept", key)
+		}
+	}
+}
+
+func TestRunSkipsReuploaded(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+
+	// 扫描之后、删除之前重新上传的文件不会被删除
+	refs := func(ctx context.Context) (map[string]bool, error) {
+		putFile(t, store, "avatars/bb/b_64.png", time.Now())
+		return map[string]bool{"avatars/aa/a.png": true}, nil
+	}
+	report, err := Run(ctx, store, refs, Options{Prefix: "avatars", GracePeriod: time.Hour})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if ok, _ := store.Exists(ctx, "avatars/bb/b_64.png"); !ok {
+		t.Fatalf("re-uploaded file should be kept")
+	}
+	if report.Deleted != 3 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+}
+
+func TestRunRejectsPrefix(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	putFile(t, store, "exports/1.zip", time.Now().Add(-48*time.Hour))
+
+	// 只能回收头像前缀下的文件
+	for _, prefix := range []string{"", "/", "exports", "avatars2", "avatars/../exports", "../avatars"} {
+		if _, err := Run(ctx, store, staticReferences(), Options{Prefix: prefix}); err != ErrInvalidPrefix {
+			t.Fatalf("prefix %q: expected ErrInvalidPrefix, got %v", prefix, err)
+		}
+	}
+	if ok, _ := store.Exists(ctx, "exports/1.zip"); !ok {
+		t.Fatalf("export archive should be kept")
+	}
+	for _, prefix := range []string{"avatars", "avatars/", "avatars/bb"} {
+		if _, err := Run(ctx, store, staticReferences(), Options{Prefix: prefix, DryRun: true}); err != nil {
+			t.Fatalf("prefix %q: %v", prefix, err)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package balancer
+
+import (
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+// LeastRequest 负载均衡策略名称, 在服务配置的 loadBalancingConfig 中使用
+const LeastRequest = "least_request"
+
+func init() {
+	balancer.Register(base.NewBalancerBuilder(LeastRequest, &leastRequestPickerBuilder{
+		inflight: make(map[balancer.SubConn]*int64),
+	}, base.Config{HealthCheck: true}))
+}
+
+// 每个连接的进行中请求数, 连接重建picker时保留计数
+type leastRequestPickerBuilder struct {
+	mu       sync.Mutex
+	inflight map[balancer.SubConn]*int64
+}
+
+func (b *leastRequestPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sc := range b.inflight {
+		if _, ok := info.ReadySCs[sc]; !ok {
+			delete(b.inflight, sc)
+		}
+	}
+
+	p := &leastRequestPicker{}
+	for sc := range info.ReadySCs {
+		counter, ok := b.inflight[sc]
+		if !ok {
+			counter = new(int64)
+			b.inflight[sc] = counter
+		}
+		p.subConns = append(p.subConns, subConn{sc: sc, inflight: counter})
+	}
+	return p
+}
+
+type subConn struct {
+	sc       balancer.SubConn
+	inflight *int64
+}
+
+// 随机选取两个连接, 使用进行中请求较少的一个(power of two choices)
+type leastRequestPicker struct {
+	subConns []subConn
+}
+
+func (p *leastRequestPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	picked := p.subConns[rand.Intn(len(p.subConns))]
+	if len(p.subConns) > 1 {
+		other := p.subConns[rand.Intn(len(p.subConns))]
+		if atomic.LoadInt64(other.inflight) < atomic.LoadInt64(picked.inflight) {
+			picked = other
+		}
+	}
+
+	atomic.AddInt64(picked.inflight, 1)
+	return balancer.PickResult{
+		SubConn: picked.sc,
+		Done: func(balancer.DoneInfo) {
+			atomic.AddInt64(picked.inflight, -1)
+		},
+	}, nil
+}
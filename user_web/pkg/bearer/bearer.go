@@ -0,0 +1,52 @@
+// Package bearer 解析 RFC 6750 的 Bearer 认证信息
+package bearer
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	// ErrUnsupportedScheme Authorization 使用了 Bearer 以外的认证方式
+	ErrUnsupportedScheme = errors.New("bearer: unsupported authorization scheme")
+	// ErrMalformed Bearer 认证信息格式错误
+	ErrMalformed = errors.New("bearer: malformed credentials")
+)
+
+// Parse 解析 Authorization 请求头, 返回其中的token
+// 格式为 "Bearer" 1*SP token68, 认证方式不区分大小写; 兼容旧客户端直接传递token
+func Parse(header string) (string, error) {
+	i := strings.IndexByte(header, ' ')
+	if i < 0 {
+		if strings.EqualFold(header, "Bearer") || !isToken68(header) {
+			return "", ErrMalformed
+		}
+		return header, nil
+	}
+	if !strings.EqualFold(header[:i], "Bearer") {
+		return "", ErrUnsupportedScheme
+	}
+	token := strings.TrimLeft(header[i:], " ")
+	if !isToken68(token) {
+		return "", ErrMalformed
+	}
+	return token, nil
+}
+
+// token68 = 1*( ALPHA / DIGIT / "-" / "." / "_" / "~" / "+" / "/" ) *"="
+func isToken68(s string) bool {
+	s = strings.TrimRight(s, "=")
+	if len(s) == 0 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-', c == '.', c == '_', c == '~', c == '+', c == '/':
+		default:
+			return false
+		}
+	}
+	return true
+}
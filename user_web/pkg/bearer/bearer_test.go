@@ -0,0 +1,32 @@
+package bearer
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	for header, want := range map[string]string{
+		"Bearer abc123":      "abc123",
+		"bearer abc123":      "abc123",
+		"BEARER   a-b.c_d~e": "a-b.c_d~e",
+		"Bearer YWJj+/==":    "YWJj+/==",
+		"abc123":             "abc123",
+	} {
+		if token, err := Parse(header); err != nil || token != want {
+			t.Errorf("Parse(%q) = %q, %v, want %q", header, token, err, want)
+		}
+	}
+
+	for header, want := range map[string]error{
+		"Basic dXNlcjpwd2Q=": ErrUnsupportedScheme,
+		"Bearer":             ErrMalformed,
+		"Bearer ":            ErrMalformed,
+		"Bearer a b":         ErrMalformed,
+		"Bearer ==":          ErrMalformed,
+		"Bearer a=b":         ErrMalformed,
+		"Bearer\tabc":        ErrMalformed,
+		"abc,def":            ErrMalformed,
+	} {
+		if _, err := Parse(header); err != want {
+			t.Errorf("Parse(%q): expected %v, got %v", header, want, err)
+		}
+	}
+}
@@ -1,12 +1,19 @@
 package config
 
 import (
+	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/cast"
 	viperLib "github.com/spf13/viper"
+	"sync"
 )
 
 var Config *viperLib.Viper
 
+var (
+	changeMu       sync.Mutex
+	changeHandlers []func()
+)
+
 // SetupConfig 初始化配置
 func SetupConfig() {
 	Config = viperLib.New()
@@ -17,9 +24,24 @@ func SetupConfig() {
 	if err := Config.ReadInConfig(); err != nil {
 		panic(err)
 	}
+	Config.OnConfigChange(func(fsnotify.Event) {
+		changeMu.Lock()
+		handlers := append([]func(){}, changeHandlers...)
+		changeMu.Unlock()
+		for _, handler := range handlers {
+			handler()
+		}
+	})
 	Config.WatchConfig()
 }
 
+// OnChange 注册配置文件变化时的回调
+func OnChange(handler func()) {
+	changeMu.Lock()
+	defer changeMu.Unlock()
+	changeHandlers = append(changeHandlers, handler)
+}
+
 func internalGet(path string) interface{} {
 	if !Config.IsSet(path) {
 		return nil
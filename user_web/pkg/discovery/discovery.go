@@ -0,0 +1,121 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc/resolver"
+	"sort"
+	"strings"
+)
+
+// Scheme 服务发现使用的地址前缀, 例如 discovery:///user_rpc
+const Scheme = "discovery"
+
+// ErrNoAddress 没有可用的服务地址
+var ErrNoAddress = errors.New("discovery: no available address")
+
+// Source 服务地址来源
+// Watch 阻塞运行直到ctx取消, 地址变化时调用update推送完整的地址列表; refresh有信号时立即重新获取
+type Source interface {
+	Watch(ctx context.Context, refresh <-chan struct{}, update func(addrs []string, err error))
+}
+
+// NewBuilder 创建基于Source的grpc resolver, 通过 grpc.WithResolvers 使用
+func NewBuilder(source Source) resolver.Builder {
+	return &builder{source: source}
+}
+
+type builder struct {
+	source Source
+}
+
+func (b *builder) Scheme() string {
+	return Scheme
+}
+
+func (b *builder) Build(_ resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &sourceResolver{
+		cc:      cc,
+		cancel:  cancel,
+		refresh: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	go func() {
+		defer close(r.done)
+		b.source.Watch(ctx, r.refresh, r.update)
+	}()
+	return r, nil
+}
+
+type sourceResolver struct {
+	cc      resolver.ClientConn
+	cancel  context.CancelFunc
+	refresh chan struct{}
+	done    chan struct{}
+	last    []string
+}
+
+// 推送新的地址列表, 地址未变化时忽略
+func (r *sourceResolver) update(addrs []string, err error) {
+	if err == nil && len(addrs) == 0 {
+		err = ErrNoAddress
+	}
+	if err != nil {
+		r.cc.ReportError(err)
+		return
+	}
+	if equal(r.last, addrs) {
+		return
+	}
+	r.last = addrs
+
+	state := resolver.State{}
+	for _, addr := range addrs {
+		state.Addresses = append(state.Addresses, resolver.Address{Addr: addr})
+	}
+	_ = r.cc.UpdateState(state)
+}
+
+func (r *sourceResolver) ResolveNow(resolver.ResolveNowOptions) {
+	select {
+	case r.refresh <- struct{}{}:
+	default:
+	}
+}
+
+func (r *sourceResolver) Close() {
+	r.cancel()
+	<-r.done
+}
+
+// Normalize 去除空白、空行、#注释和重复的地址并排序
+func Normalize(addrs []string) []string {
+	seen := make(map[string]bool, len(addrs))
+	result := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		if i := strings.IndexByte(addr, '#'); i >= 0 {
+			addr = addr[:i]
+		}
+		addr = strings.TrimSpace(addr)
+		if len(addr) == 0 || seen[addr] {
+			continue
+		}
+		seen[addr] = true
+		result = append(result, addr)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return a != nil
+}
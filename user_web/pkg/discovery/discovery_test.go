@@ -0,0 +1,125 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 收集Source推送的地址列表
+type updates struct {
+	ch chan []string
+}
+
+func watch(t *testing.T, source Source, refresh chan struct{}) *updates {
+	t.Helper()
+	u := &updates{ch: make(chan []string, 16)}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		source.Watch(ctx, refresh, func(addrs []string, err error) {
+			if err != nil {
+				addrs = []string{"error"}
+			}
+			u.ch <- addrs
+		})
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return u
+}
+
+func (u *updates) expect(t *testing.T, want ...string) {
+	t.Helper()
+	select {
+	case got := <-u.ch:
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("addrs = %v, want %v", got, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timeout waiting for %v", want)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	got := Normalize([]string{" b:1 ", "", "a:1 # primary", "b:1", "# comment"})
+	if !reflect.DeepEqual(got, []string{"a:1", "b:1"}) {
+		t.Fatalf("unexpected addrs: %v", got)
+	}
+}
+
+func TestStaticSource(t *testing.T) {
+	u := watch(t, NewStaticSource("10.0.0.2:5001, 10.0.0.1:5001,"), nil)
+	u.expect(t, "10.0.0.1:5001", "10.0.0.2:5001")
+}
+
+type fakeSRV struct {
+	mu      sync.Mutex
+	records []*net.SRV
+	err     error
+}
+
+func (f *fakeSRV) LookupSRV(_ context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return name, f.records, f.err
+}
+
+func (f *fakeSRV) set(records []*net.SRV, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.records, f.err = records, err
+}
+
+func TestDNSSource(t *testing.T) {
+	resolver := &fakeSRV{records: []*net.SRV{{Target: "rpc-1.local.", Port: 5001}}}
+	refresh := make(chan struct{})
+	u := watch(t, &DNSSource{Name: "_grpc._tcp.user-rpc", Interval: time.Hour, Resolver: resolver}, refresh)
+	u.expect(t, "rpc-1.local:5001")
+
+	resolver.set([]*net.SRV{{Target: "rpc-2.local.", Port: 5001}, {Target: "rpc-1.local.", Port: 5001}}, nil)
+	refresh <- struct{}{}
+	u.expect(t, "rpc-1.local:5001", "rpc-2.local:5001")
+
+	resolver.set(nil, errors.New("lookup failed"))
+	refresh <- struct{}{}
+	u.expect(t, "error")
+}
+
+func TestFileSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rpc_servers")
+	if err := ioutil.WriteFile(path, []byte("127.0.0.1:5001\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	u := watch(t, NewFileSource(path, time.Hour), nil)
+	u.expect(t, "127.0.0.1:5001")
+
+	// 原子替换文件
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte("127.0.0.1:5001\n127.0.0.1:5002 # new replica\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		select {
+		case got := <-u.ch:
+			if reflect.DeepEqual(got, []string{"127.0.0.1:5001", "127.0.0.1:5002"}) {
+				return
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timeout waiting for file change")
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SRVResolver DNS SRV查询, net.Resolver 实现了该接口
+type SRVResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// DNSSource 通过DNS SRV记录发现服务, 按Interval定期刷新
+type DNSSource struct {
+	Name     string
+	Interval time.Duration
+	Resolver SRVResolver
+}
+
+// NewDNSSource 创建DNS SRV服务发现, name为完整的记录名, 例如 _grpc._tcp.user-rpc.service.consul
+func NewDNSSource(name string, interval time.Duration) *DNSSource {
+	return &DNSSource{Name: name, Interval: interval, Resolver: net.DefaultResolver}
+}
+
+func (s *DNSSource) lookup(ctx context.Context) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	_, records, err := s.Resolver.LookupSRV(ctx, "", "", s.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]string, 0, len(records))
+	for _, record := range records {
+		host := strings.TrimSuffix(record.Target, ".")
+		addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(int(record.Port))))
+	}
+	return Normalize(addrs), nil
+}
+
+func (s *DNSSource) Watch(ctx context.Context, refresh <-chan struct{}, update func([]string, error)) {
+	interval := s.Interval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		update(s.lookup(ctx))
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-refresh:
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package discovery
+
+import (
+	"context"
+	"github.com/fsnotify/fsnotify"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileSource 从文件读取地址列表, 每行一个地址, 支持#注释; 文件变化时自动重新读取
+type FileSource struct {
+	Path string
+	// 监听失败时的轮询间隔
+	Interval time.Duration
+}
+
+// NewFileSource 创建基于文件的服务发现
+func NewFileSource(path string, interval time.Duration) *FileSource {
+	return &FileSource{Path: path, Interval: interval}
+}
+
+func (s *FileSource) read() ([]string, error) {
+	data, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	return Normalize(strings.Split(string(data), "\n")), nil
+}
+
+func (s *FileSource) Watch(ctx context.Context, refresh <-chan struct{}, update func([]string, error)) {
+	interval := s.Interval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// 监听所在目录, 以便感知编辑器和配置管理工具的重命名替换
+	var events chan fsnotify.Event
+	var errs chan error
+	if watcher, err := fsnotify.NewWatcher(); err == nil {
+		defer watcher.Close()
+		if err = watcher.Add(filepath.Dir(s.Path)); err == nil {
+			events, errs = watcher.Events, watcher.Errors
+		}
+	}
+
+	target := filepath.Clean(s.Path)
+	for {
+		update(s.read())
+		for changed := false; !changed; {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				changed = true
+			case <-refresh:
+				changed = true
+			case event := <-events:
+				changed = filepath.Clean(event.Name) == target
+			case <-errs:
+			}
+		}
+	}
+}
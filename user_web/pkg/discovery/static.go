@@ -0,0 +1,19 @@
+package discovery
+
+import (
+	"context"
+	"strings"
+)
+
+// StaticSource 固定的地址列表
+type StaticSource []string
+
+// NewStaticSource 解析逗号分隔的地址列表
+func NewStaticSource(addrs string) StaticSource {
+	return StaticSource(Normalize(strings.Split(addrs, ",")))
+}
+
+func (s StaticSource) Watch(ctx context.Context, _ <-chan struct{}, update func([]string, error)) {
+	update(s, nil)
+	<-ctx.Done()
+}
@@ -0,0 +1,453 @@
+// Package export 个人数据导出
+//
+// 导出任务在后台生成zip压缩包, 保存到文件存储的 exports/ 下; 任务状态保存在redis中, 多个 user_web 实例之间共享,
+// 同一个用户同时只有一个进行中的任务. 下载地址带有过期时间和HMAC签名, 不需要登录凭证即可在浏览器中下载
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	redisLib "github.com/go-redis/redis/v8"
+	"io"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"user_web/pkg/config"
+	"user_web/pkg/logger"
+	"user_web/pkg/redis"
+	"user_web/pkg/storage"
+	"user_web/pkg/util"
+	"userapi/v1"
+)
+
+// 导出任务状态
+const (
+	StatusPending = "pending"
+	StatusReady   = "ready"
+	StatusFailed  = "failed"
+)
+
+var (
+	// ErrNotFound 导出任务不存在或已过期
+	ErrNotFound = errors.New("export: job not found")
+	// ErrInvalidLink 下载地址签名错误
+	ErrInvalidLink = errors.New("export: invalid link")
+	// ErrLinkExpired 下载地址已过期
+	ErrLinkExpired = errors.New("export: link expired")
+)
+
+// 默认的任务超时时间和下载地址有效期
+const (
+	defaultJobTimeout = 10 * time.Minute
+	defaultLinkExpire = 24 * time.Hour
+)
+
+// Job 导出任务
+type Job struct {
+	ID        string
+	Status    string
+	CreatedAt time.Time
+	// ReadyAt 压缩包生成的时间, 下载地址在 ReadyAt + EXPORT_LINK_EXPIRE 之前有效
+	ReadyAt time.Time
+
+	// 上一次导出的任务ID, 新的压缩包生成后删除旧文件
+	previousID string
+}
+
+// 下载地址的签名密钥
+var secret []byte
+
+// ErrSecretRequired 未配置 EXPORT_URL_SECRET 且不是单实例部署
+var ErrSecretRequired = errors.New("export: EXPORT_URL_SECRET is required unless WEB_SINGLE_INSTANCE=true")
+
+// SetupExport 根据配置初始化下载地址的签名密钥
+// 多个实例需要配置相同的 EXPORT_URL_SECRET; 未配置时启动失败,
+// 只有显式配置 WEB_SINGLE_INSTANCE=true (单实例或开发环境) 时使用随机密钥
+func SetupExport() {
+	secret = []byte(config.GetString("EXPORT_URL_SECRET"))
+	if len(secret) == 0 {
+		if !config.GetBool("WEB_SINGLE_INSTANCE") {
+			logger.Error("export", "setup export error", ErrSecretRequired)
+			panic(ErrSecretRequired)
+		}
+		logger.Warn("export", "EXPORT_URL_SECRET is empty, use a random secret for single instance")
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			logger.Error("export", "generate secret error", err)
+			panic(err)
+		}
+	}
+}
+
+// JobTimeout 导出任务的超时时间, 超时后可以重新发起导出
+func JobTimeout() time.Duration {
+	if timeout := config.GetInt("EXPORT_JOB_TIMEOUT"); timeout > 0 {
+		return time.Duration(timeout) * time.Second
+	}
+	return defaultJobTimeout
+}
+
+// 下载地址的有效期, 也是任务状态的保留时间
+func linkExpire() time.Duration {
+	if expire := config.GetInt("EXPORT_LINK_EXPIRE"); expire > 0 {
+		return time.Duration(expire) * time.Second
+	}
+	return defaultLinkExpire
+}
+
+// 任务状态的缓存key
+func jobKey(username string) string {
+	return fmt.Sprintf("%s:job:%s", config.GetString("EXPORT_PREFIX"), util.Md5Data(username))
+}
+
+// Key 导出文件的存储路径
+func Key(id string) string {
+	return "exports/" + id + ".zip"
+}
+
+// 没有进行中的任务时创建新任务, 返回 {是否创建, 上一次完成的任务ID}
+var startScript = redisLib.NewScript(`
+local status = redis.call('HGET', KEYS[1], 'status')
+if status == 'pending' then
+	return {0, ''}
+end
+local previous = ''
+if status == 'ready' then
+	previous = redis.call('HGET', KEYS[1], 'id')
+end
+redis.call('DEL', KEYS[1])
+redis.call('HSET', KEYS[1], 'id', ARGV[1], 'status', 'pending', 'created_at', ARGV[2])
+redis.call('EXPIRE', KEYS[1], ARGV[3])
+return {1, previous}
+`)
+
+// 任务仍是当前任务时更新状态, 返回是否更新
+var finishScript = redisLib.NewScript(`
+if redis.call('HGET', KEYS[1], 'id') ~= ARGV[1] then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'status', ARGV[2], 'ready_at', ARGV[3])
+redis.call('EXPIRE', KEYS[1], ARGV[4])
+return 1
+`)
+
+// Start 创建导出任务, 已有进行中的任务时返回该任务, started 为false
+func Start(username string, now time.Time) (job *Job, started bool, err error) {
+	b := make([]byte, 16)
+	if _, err = rand.Read(b); err != nil {
+		return nil, false, err
+	}
+	job = &Job{ID: hex.EncodeToString(b), Status: StatusPending, CreatedAt: now}
+
+	values, err := startScript.Run(redis.Redis.Ctx, redis.Redis.Client, []string{jobKey(username)},
+		job.ID, now.Unix(), int64(JobTimeout()/time.Second)).Slice()
+	if err != nil {
+		return nil, false, err
+	}
+	if values[0].(int64) == 0 {
+		job, err = Get(username)
+		return job, false, err
+	}
+	job.previousID, _ = values[1].(string)
+	return job, true, nil
+}
+
+// Get 获取用户最近的导出任务
+func Get(username string) (*Job, error) {
+	values, err := redis.Redis.Client.HGetAll(redis.Redis.Ctx, jobKey(username)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, ErrNotFound
+	}
+	job := &Job{ID: values["id"], Status: values["status"]}
+	if createdAt, err := strconv.ParseInt(values["created_at"], 10, 64); err == nil {
+		job.CreatedAt = time.Unix(createdAt, 0)
+	}
+	if readyAt, err := strconv.ParseInt(values["ready_at"], 10, 64); err == nil && readyAt > 0 {
+		job.ReadyAt = time.Unix(readyAt, 0)
+	}
+	return job, nil
+}
+
+// 更新任务状态, 任务已被新任务替代时返回false
+func finish(username string, job *Job, status string, readyAt time.Time, expire time.Duration) (bool, error) {
+	var readyAtUnix int64
+	if !readyAt.IsZero() {
+		readyAtUnix = readyAt.Unix()
+	}
+	n, err := finishScript.Run(redis.Redis.Ctx, redis.Redis.Client, []string{jobKey(username)},
+		job.ID, status, readyAtUnix, int64(expire/time.Second)).Int64()
+	return n == 1, err
+}
+
+// Fail 标记任务失败, 失败状态保留 EXPORT_JOB_TIMEOUT 秒, 之后可以重新发起导出
+func Fail(username string, job *Job) error {
+	_, err := finish(username, job, StatusFailed, time.Time{}, JobTimeout())
+	return err
+}
+
+// Run 生成压缩包并保存, 完成后删除上一次导出的文件; 失败时标记任务失败
+func Run(ctx context.Context, store storage.Storage, username string, job *Job, data *userapi.ExportUserDataResponse) error {
+	err := run(ctx, store, username, job, data)
+	if err != nil {
+		if failErr := Fail(username, job); failErr != nil {
+			logger.Error("export", "mark job failed error", failErr)
+		}
+	}
+	return err
+}
+
+func run(ctx context.Context, store storage.Storage, username string, job *Job, data *userapi.ExportUserDataResponse) error {
+	archive, err := Build(ctx, store, data)
+	if err != nil {
+		return err
+	}
+	if err = store.Put(ctx, Key(job.ID), bytes.NewReader(archive), int64(len(archive)), "application/zip"); err != nil {
+		return err
+	}
+
+	// 任务超时后已有新任务时, 删除本次生成的文件
+	ok, err := finish(username, job, StatusReady, time.Now(), linkExpire())
+	if err != nil || !ok {
+		if delErr := store.Delete(ctx, Key(job.ID)); delErr != nil {
+			logger.Error("export", "delete superseded export error", delErr)
+		}
+		if err == nil {
+			err = fmt.Errorf("export job %s superseded", job.ID)
+		}
+		return err
+	}
+	if len(job.previousID) > 0 {
+		if err = store.Delete(ctx, Key(job.previousID)); err != nil {
+			logger.Error("export", "delete previous export error", err)
+		}
+	}
+	return nil
+}
+
+// Remove 删除用户的导出任务和已生成的文件, 注销账号时调用; 进行中的任务完成时发现任务已被删除, 会删除自己生成的文件
+func Remove(ctx context.Context, store storage.Storage, username string) error {
+	job, err := Get(username)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err = redis.Redis.Client.Del(redis.Redis.Ctx, jobKey(username)).Err(); err != nil {
+		return err
+	}
+	return store.Delete(ctx, Key(job.ID))
+}
+
+// Sweep 删除下载地址已过期的导出文件, 返回过期的文件; dryRun 时只返回不删除
+// 文件在任务完成前写入, 修改时间早于 ReadyAt, 超过 EXPORT_LINK_EXPIRE 后下载地址一定已失效
+func Sweep(ctx context.Context, store storage.Storage, now time.Time, dryRun bool) ([]storage.ObjectInfo, error) {
+	cutoff := now.Add(-linkExpire())
+	var expired []storage.ObjectInfo
+	err := store.List(ctx, "exports", func(info storage.ObjectInfo) error {
+		if info.ModTime.Before(cutoff) {
+			expired = append(expired, info)
+		}
+		return nil
+	})
+	if err != nil || dryRun {
+		return expired, err
+	}
+	for _, info := range expired {
+		if err = store.Delete(ctx, info.Key); err != nil {
+			return expired, err
+		}
+	}
+	return expired, nil
+}
+
+// 压缩包中的数据文件
+type archiveData struct {
+	ExportTime     int64          `json:"export_time"`
+	Profile        archiveProfile `json:"profile"`
+	ActiveSessions int32          `json:"active_sessions"`
+	AuditEvents    []archiveEvent `json:"audit_events"`
+	Logins         []archiveLogin `json:"logins"`
+}
+
+type archiveProfile struct {
+	ID         int64  `json:"id"`
+	Username   string `json:"username"`
+	Nickname   string `json:"nickname"`
+	PicPath    string `json:"pic_path"`
+	Role       string `json:"role"`
+	Status     string `json:"status"`
+	CreateTime int64  `json:"create_time"`
+	UpdateTime int64  `json:"update_time"`
+	DeleteTime int64  `json:"delete_time,omitempty"`
+}
+
+type archiveEvent struct {
+	Time      int64             `json:"time"`
+	Action    string            `json:"action"`
+	Actor     string            `json:"actor"`
+	Target    string            `json:"target,omitempty"`
+	RequestID string            `json:"request_id"`
+	Reason    string            `json:"reason,omitempty"`
+	Detail    map[string]string `json:"detail,omitempty"`
+	Outcome   string            `json:"outcome"`
+	Error     string            `json:"error,omitempty"`
+}
+
+type archiveLogin struct {
+	Time      int64  `json:"time"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent"`
+	Device    string `json:"device"`
+	NewDevice bool   `json:"new_device"`
+}
+
+// 转换导出的数据, 账号状态使用与http接口一致的名称
+func newArchiveData(data *userapi.ExportUserDataResponse) archiveData {
+	profile := data.GetProfile()
+	a := archiveData{
+		ExportTime: data.ExportTime,
+		Profile: archiveProfile{
+			ID:         profile.GetId(),
+			Username:   profile.GetUsername(),
+			Nickname:   profile.GetNickname(),
+			PicPath:    profile.GetPicPath(),
+			Role:       profile.GetRole(),
+			Status:     strings.ToLower(strings.TrimPrefix(profile.GetStatus().String(), "USER_STATUS_")),
+			CreateTime: profile.GetCreateTime(),
+			UpdateTime: profile.GetUpdateTime(),
+			DeleteTime: profile.GetDeleteTime(),
+		},
+		ActiveSessions: data.ActiveSessions,
+		AuditEvents:    make([]archiveEvent, 0, len(data.AuditEvents)),
+		Logins:         make([]archiveLogin, 0, len(data.Logins)),
+	}
+	for _, event := range data.AuditEvents {
+		a.AuditEvents = append(a.AuditEvents, archiveEvent{
+			Time:      event.Time,
+			Action:    event.Action,
+			Actor:     event.Actor,
+			Target:    event.Target,
+			RequestID: event.RequestId,
+			Reason:    event.Reason,
+			Detail:    event.Detail,
+			Outcome:   event.Outcome,
+			Error:     event.Error,
+		})
+	}
+	for _, login := range data.Logins {
+		a.Logins = append(a.Logins, archiveLogin{
+			Time:      login.Time,
+			IP:        login.Ip,
+			UserAgent: login.UserAgent,
+			Device:    login.Device,
+			NewDevice: login.NewDevice,
+		})
+	}
+	return a
+}
+
+// Build 生成压缩包: data.json 为用户信息、会话数和审计事件, avatars/ 下为头像的各个尺寸
+func Build(ctx context.Context, store storage.Storage, data *userapi.ExportUserDataResponse) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	w, err := zw.Create("data.json")
+	if err != nil {
+		return nil, err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(newArchiveData(data)); err != nil {
+		return nil, err
+	}
+
+	// 旧头像没有缩略图, 原图和缩略图都尝试读取
+	if avatar := data.GetProfile().GetPicPath(); len(avatar) > 0 {
+		keys := []string{avatar}
+		for _, size := range util.AvatarSizes {
+			keys = append(keys, util.AvatarVariantKey(avatar, size))
+		}
+		for _, key := range keys {
+			if err = addObject(ctx, zw, store, key, "avatars/"+path.Base(key)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err = zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// 把存储中的文件写入压缩包, 文件不存在时跳过
+func addObject(ctx context.Context, zw *zip.Writer, store storage.Storage, key string, name string) error {
+	obj, err := store.Open(ctx, key)
+	if errors.Is(err, storage.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, obj)
+	return err
+}
+
+// 下载地址的签名
+func sign(id string, expires int64) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(id + "." + strconv.FormatInt(expires, 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// DownloadURL 生成任务的下载地址, 有效期从压缩包生成时开始计算
+func DownloadURL(job *Job) (string, time.Time) {
+	expire := job.ReadyAt.Add(linkExpire())
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expire.Unix(), 10))
+	query.Set("signature", sign(job.ID, expire.Unix()))
+	return "/exports/" + job.ID + "?" + query.Encode(), expire
+}
+
+// 导出任务ID的格式
+var jobIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// VerifyLink 校验下载地址的签名和有效期
+func VerifyLink(id string, expires string, signature string, now time.Time) error {
+	if !jobIDPattern.MatchString(id) {
+		return ErrInvalidLink
+	}
+	expireUnix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrInvalidLink
+	}
+	if !hmac.Equal([]byte(signature), []byte(sign(id, expireUnix))) {
+		return ErrInvalidLink
+	}
+	if now.Unix() >= expireUnix {
+		return ErrLinkExpired
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	viperLib "github.com/spf13/viper"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+	"user_web/pkg/config"
+	"user_web/pkg/logger"
+	"user_web/pkg/storage"
+	"user_web/pkg/util"
+	"userapi/v1"
+)
+
+func setupTestConfig() {
+	logger.Logger = zap.NewNop()
+	config.Config = viperLib.New()
+	config.Config.Set("EXPORT_LINK_EXPIRE", 3600)
+	config.Config.Set("EXPORT_URL_SECRET", "secret")
+	SetupExport()
+}
+
+func TestSetupExport(t *testing.T) {
+	setupTestConfig()
+
+	// 未配置密钥时启动失败, 单实例使用随机密钥
+	config.Config.Set("EXPORT_URL_SECRET", "")
+	func() {
+		defer func() {
+			if r := recover(); r != ErrSecretRequired {
+				t.Fatalf("expected ErrSecretRequired panic, got %v", r)
+			}
+		}()
+		SetupExport()
+	}()
+	config.Config.Set("WEB_SINGLE_INSTANCE", true)
+	SetupExport()
+	if len(secret) != 32 {
+		t.Fatalf("unexpected secret length: %d", len(secret))
+	}
+}
+
+func TestDownloadURL(t *testing.T) {
+	setupTestConfig()
+	job := &Job{ID: strings.Repeat("ab", 16), Status: StatusReady, ReadyAt: time.Unix(1700000000, 0)}
+
+	link, expire := DownloadURL(job)
+	if expire.Unix() != 1700003600 || !strings.HasPrefix(link, "/exports/"+job.ID+"?") {
+		t.Fatalf("unexpected link: %s %v", link, expire)
+	}
+	u, _ := url.Parse(link)
+	query := u.Query()
+	if err := VerifyLink(job.ID, query.Get("expires"), query.Get("signature"), job.ReadyAt); err != nil {
+		t.Fatalf("verify link: %v", err)
+	}
+
+	// 修改任务ID、过期时间或签名都会校验失败
+	otherID := strings.Repeat("cd", 16)
+	if err := VerifyLink(otherID, query.Get("expires"), query.Get("signature"), job.ReadyAt); err != ErrInvalidLink {
+		t.Fatalf("other id: %v", err)
+	}
+	if err := VerifyLink(job.ID, "1800000000", query.Get("signature"), job.ReadyAt); err != ErrInvalidLink {
+		t.Fatalf("extended expires: %v", err)
+	}
+	if err := VerifyLink(job.ID, query.Get("expires"), "", job.ReadyAt); err != ErrInvalidLink {
+		t.Fatalf("missing signature: %v", err)
+	}
+	if err := VerifyLink("../secret", query.Get("expires"), query.Get("signature"), job.ReadyAt); err != ErrInvalidLink {
+		t.Fatalf("invalid id: %v", err)
+	}
+	if err := VerifyLink(job.ID, query.Get("expires"), query.Get("signature"), expire); err != ErrLinkExpired {
+		t.Fatalf("expired link: %v", err)
+	}
+}
+
+func TestBuild(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewLocalStorage(t.TempDir(), "")
+	avatar := "avatars/ab/abc.png"
+	for _, key := range []string{avatar, util.AvatarVariantKey(avatar, util.AvatarSizes[0])} {
+		if err := store.Put(ctx, key, bytes.NewReader([]byte(key)), int64(len(key)), "image/png"); err != nil {
+			t.Fatalf("put %s: %v", key, err)
+		}
+	}
+
+	archive, err := Build(ctx, store, &userapi.ExportUserDataResponse{
+		Profile: &userapi.UserResponse{
+			Username: "alice", PicPath: avatar, Status: userapi.UserStatus_USER_STATUS_PENDING_DELETION,
+		},
+		ActiveSessions: 2,
+		AuditEvents:    []*userapi.AuditEvent{{Action: "account.delete", Actor: "alice", Outcome: "success"}},
+		Logins:         []*userapi.LoginRecord{{Time: 1700000000, Ip: "10.0.0.1", UserAgent: "laptop", NewDevice: true}},
+		ExportTime:     1700000000,
+	})
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatalf("open archive: %v", err)
+	}
+	files := map[string][]byte{}
+	var names []string
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatalf("open %s: %v", f.Name, err)
+		}
+		files[f.Name], _ = ioutil.ReadAll(r)
+		r.Close()
+		names = append(names, f.Name)
+	}
+
+	// 缺少的缩略图跳过
+	sort.Strings(names)
+	expected := []string{"avatars/abc.png", "avatars/" + path.Base(util.AvatarVariantKey(avatar, util.AvatarSizes[0])), "data.json"}
+	sort.Strings(expected)
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Fatalf("unexpected files: %v", names)
+	}
+
+	var data archiveData
+	if err = json.Unmarshal(files["data.json"], &data); err != nil {
+		t.Fatalf("decode data.json: %v", err)
+	}
+	if data.Profile.Username != "alice" || data.Profile.Status != "pending_deletion" || data.ActiveSessions != 2 ||
+		len(data.AuditEvents) != 1 || data.AuditEvents[0].Action != "account.delete" ||
+		len(data.Logins) != 1 || data.Logins[0].IP != "10.0.0.1" || !data.Logins[0].NewDevice {
+		t.Fatalf("unexpected data: %+v", data)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	setupTestConfig()
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := storage.NewLocalStorage(dir, "")
+	now := time.Now()
+	for key, age := range map[string]time.Duration{
+		Key(strings.Repeat("a", 32)): 2 * time.Hour,
+		Key(strings.Repeat("b", 32)): 30 * time.Minute,
+		"avatars/ab/abc.png":         2 * time.Hour,
+	} {
+		if err := store.Put(ctx, key, bytes.NewReader([]byte(key)), int64(len(key)), "application/zip"); err != nil {
+			t.Fatalf("put %s: %v", key, err)
+		}
+		modTime := now.Add(-age)
+		if err := os.Chtimes(filepath.Join(dir, filepath.FromSlash(key)), modTime, modTime); err != nil {
+			t.Fatalf("chtimes %s: %v", key, err)
+		}
+	}
+
+	// 只删除下载地址已过期的导出文件
+	expired, err := Sweep(ctx, store, now, true)
+	if err != nil || len(expired) != 1 || expired[0].Key != Key(strings.Repeat("a", 32)) {
+		t.Fatalf("dry run: %+v %v", expired, err)
+	}
+	if ok, _ := store.Exists(ctx, expired[0].Key); !ok {
+		t.Fatalf("dry run should not delete")
+	}
+	if _, err = Sweep(ctx, store, now, false); err != nil {
+		t.Fatalf("sweep: %v", err)
+	}
+	for key, kept := range map[string]bool{
+		Key(strings.Repeat("a", 32)): false,
+		Key(strings.Repeat("b", 32)): true,
+		"avatars/ab/abc.png":         true,
+	} {
+		if ok, _ := store.Exists(ctx, key); ok != kept {
+			t.Fatalf("%s: expected kept=%v", key, kept)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// Package imaging 头像图片的校验、解码和缩略图生成
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"golang.org/x/image/draw"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+const (
+	// MaxDimension 图片宽高上限
+	MaxDimension = 8192
+	// MaxPixels 图片像素总数上限, 防止解压炸弹占用大量内存
+	MaxPixels = 4096 * 4096
+	// jpeg重新编码的质量
+	jpegQuality = 90
+)
+
+var (
+	// ErrInvalidImage 非法图片, 以下错误均可通过 errors.Is 判断为该错误
+	ErrInvalidImage = errors.New("imaging: invalid image")
+	// ErrUnsupportedFormat 不支持的图片格式
+	ErrUnsupportedFormat = fmt.Errorf("%w: unsupported format", ErrInvalidImage)
+	// ErrTooLarge 图片尺寸过大
+	ErrTooLarge = fmt.Errorf("%w: dimensions too large", ErrInvalidImage)
+)
+
+// 支持的图片格式: 真实的Content-Type => 文件扩展名
+var formats = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+}
+
+// Image 解码后的图片
+type Image struct {
+	image.Image
+	// ContentType 根据文件内容识别的真实类型
+	ContentType string
+	// Ext 与真实类型对应的文件扩展名
+	Ext string
+}
+
+// Decode 识别文件内容的真实类型并解码图片
+// 先只读取图片头部检查宽高, 再完整解码, 超出限制的图片不会被解码
+func Decode(data []byte) (*Image, error) {
+	contentType := http.DetectContentType(data)
+	ext, ok := formats[contentType]
+	if !ok {
+		return nil, ErrUnsupportedFormat
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if "image/"+format != contentType {
+		return nil, ErrUnsupportedFormat
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width > MaxDimension || cfg.Height > MaxDimension ||
+		cfg.Width*cfg.Height > MaxPixels {
+		return nil, ErrTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	return &Image{Image: img, ContentType: contentType, Ext: ext}, nil
+}
+
+// Thumbnail 从图片中心裁剪出正方形并缩放到 size x size
+func (img *Image) Thumbnail(size int) image.Image {
+	b := img.Bounds()
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+	x0 := b.Min.X + (b.Dx()-side)/2
+	y0 := b.Min.Y + (b.Dy()-side)/2
+	crop := image.Rect(x0, y0, x0+side, y0+side)
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img.Image, crop, draw.Src, nil)
+	return dst
+}
+
+// Encode 按原图格式重新编码, 重新编码后不再包含EXIF等元数据
+func (img *Image) Encode(thumb image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch img.ContentType {
+	case "image/png":
+		err = png.Encode(&buf, thumb)
+	case "image/jpeg":
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: jpegQuality})
+	default:
+		err = ErrUnsupportedFormat
+	}
+	return buf.Bytes(), err
+}
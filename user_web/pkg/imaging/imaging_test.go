@@ -0,0 +1,110 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func encodePNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	img.Set(w/2, h/2, color.RGBA{R: 255, A: 255})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// 在jpeg的SOI之后插入一个EXIF段
+func encodeJPEGWithExif(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 20, 10)), nil); err != nil {
+		t.Fatalf("encode jpeg: %v", err)
+	}
+	payload := append([]byte("Exif\x00\x00"), []byte("GPS 39.9042N 116.4074E")...)
+	segment := []byte{0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	data := buf.Bytes()
+	return append(append(append([]byte{}, data[:2]...), segment...), data[2:]...)
+}
+
+// 修改png的宽高声明并重新计算IHDR的校验和
+func forgePNGSize(data []byte, w, h uint32) []byte {
+	data = append([]byte{}, data...)
+	// 8字节签名 + 4字节长度 + 4字节"IHDR"
+	binary.BigEndian.PutUint32(data[16:], w)
+	binary.BigEndian.PutUint32(data[20:], h)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+func TestDecodeAndThumbnail(t *testing.T) {
+	img, err := Decode(encodePNG(t, 300, 200))
+	if err != nil {
+		t.Fatalf("decode png: %v", err)
+	}
+	if img.ContentType != "image/png" || img.Ext != ".png" {
+		t.Fatalf("unexpected format: %s %s", img.ContentType, img.Ext)
+	}
+
+	for _, size := range []int{64, 128, 512} {
+		data, err := img.Encode(img.Thumbnail(size))
+		if err != nil {
+			t.Fatalf("encode thumbnail %d: %v", size, err)
+		}
+		cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil || format != "png" || cfg.Width != size || cfg.Height != size {
+			t.Fatalf("thumbnail %d: %s %dx%d %v", size, format, cfg.Width, cfg.Height, err)
+		}
+	}
+}
+
+func TestEncodeStripsExif(t *testing.T) {
+	data := encodeJPEGWithExif(t)
+	img, err := Decode(data)
+	if err != nil {
+		t.Fatalf("decode jpeg: %v", err)
+	}
+	if img.ContentType != "image/jpeg" || img.Ext != ".jpg" {
+		t.Fatalf("unexpected format: %s %s", img.ContentType, img.Ext)
+	}
+	out, err := img.Encode(img.Thumbnail(64))
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if bytes.Contains(out, []byte("Exif")) || bytes.Contains(out, []byte("GPS")) {
+		t.Fatalf("exif metadata not stripped")
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	valid := encodePNG(t, 8, 8)
+	cases := map[string]struct {
+		data []byte
+		err  error
+	}{
+		"executable":    {[]byte("MZ\x90\x00\x03\x00\x00\x00\x04\x00\x00\x00\xff\xff"), ErrUnsupportedFormat},
+		"script":        {[]byte("#!/bin/sh\nrm -rf /\n"), ErrUnsupportedFormat},
+		"gif":           {[]byte("GIF89a\x01\x00\x01\x00\x00\x00\x00;"), ErrUnsupportedFormat},
+		"truncated":     {valid[:40], ErrInvalidImage},
+		"bomb":          {forgePNGSize(valid, 60000, 60000), ErrTooLarge},
+		"too many rows": {forgePNGSize(valid, 1, MaxDimension+1), ErrTooLarge},
+	}
+	for name, c := range cases {
+		_, err := Decode(c.data)
+		if !errors.Is(err, c.err) || !errors.Is(err, ErrInvalidImage) {
+			t.Errorf("%s: expected %v, got %v", name, c.err, err)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// Package ratelimit 基于redis的滑动窗口限流
+//
+// 使用滑动窗口计数: 当前窗口的计数加上上一个窗口按剩余比例折算的计数, 近似最近一个窗口内的请求数;
+// 每个限流对象只需要两个计数器, 计算和计数在同一个lua脚本中完成, 多个 user_web 实例共享限流状态
+package ratelimit
+
+import (
+	"fmt"
+	redisLib "github.com/go-redis/redis/v8"
+	"strconv"
+	"strings"
+	"time"
+	"user_web/pkg/redis"
+)
+
+// 限流对象
+const (
+	// KeyIP 按客户端IP限流
+	KeyIP = "ip"
+	// KeyToken 按用户token限流, 没有token时按IP限流
+	KeyToken = "token"
+	// KeyUser 按已认证的用户限流, 重新登录不会重置计数; 需要在认证之后使用, 未认证时按IP限流
+	KeyUser = "user"
+)
+
+// Policy 限流策略, 每个 Window 内最多允许 Limit 次请求
+type Policy struct {
+	Key    string
+	Limit  int
+	Window time.Duration
+}
+
+// String 策略的配置格式, 例如 ip:10/1m
+func (p Policy) String() string {
+	return fmt.Sprintf("%s:%d/%s", p.Key, p.Limit, p.Window)
+}
+
+// ParsePolicies 解析限流策略配置, 多个策略用逗号分隔, 例如 ip:10/1m,ip:100/1h
+func ParsePolicies(s string) ([]Policy, error) {
+	var policies []Policy
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 || (parts[0] != KeyIP && parts[0] != KeyToken && parts[0] != KeyUser) {
+			return nil, fmt.Errorf("invalid rate limit policy: %s", item)
+		}
+		rate := strings.SplitN(parts[1], "/", 2)
+		if len(rate) != 2 {
+			return nil, fmt.Errorf("invalid rate limit policy: %s", item)
+		}
+		limit, err := strconv.Atoi(rate[0])
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("invalid rate limit policy: %s", item)
+		}
+		window, err := time.ParseDuration(rate[1])
+		if err != nil || window < time.Second {
+			return nil, fmt.Errorf("invalid rate limit policy: %s", item)
+		}
+		policies = append(policies, Policy{Key: parts[0], Limit: limit, Window: window})
+	}
+	return policies, nil
+}
+
+// Result 限流结果
+type Result struct {
+	Allowed bool
+	Policy  Policy
+	// Remaining 当前窗口内剩余的请求次数
+	Remaining int
+	// Reset 当前窗口结束的剩余时间
+	Reset time.Duration
+}
+
+// 依次检查每个策略的窗口, 全部允许时才给所有策略计数加一, 任一策略超过限制时不计数
+// KEYS 每个策略两个: 当前窗口、上一个窗口; ARGV 每个策略三个: 次数上限、窗口(毫秒)、当前窗口已过去的时间(毫秒)
+// 返回每个策略的 {是否允许, 剩余次数}
+var slidingWindowScript = redisLib.NewScript(`
+local n = #KEYS / 2
+local counts = {}
+local allowed = 1
+for i = 1, n do
+	local limit = tonumber(ARGV[i * 3 - 2])
+	local window = tonumber(ARGV[i * 3 - 1])
+	local elapsed = tonumber(ARGV[i * 3])
+	local current = tonumber(redis.call('GET', KEYS[i * 2 - 1]) or '0')
+	local previous = tonumber(redis.call('GET', KEYS[i * 2]) or '0')
+	counts[i] = previous * (window - elapsed) / window + current
+	if counts[i] + 1 > limit then
+		allowed = 0
+	end
+end
+local result = {}
+for i = 1, n do
+	local limit = tonumber(ARGV[i * 3 - 2])
+	local window = tonumber(ARGV[i * 3 - 1])
+	local remaining = limit - counts[i]
+	if allowed == 1 then
+		redis.call('INCR', KEYS[i * 2 - 1])
+		redis.call('PEXPIRE', KEYS[i * 2 - 1], window * 2)
+		remaining = remaining - 1
+	end
+	if counts[i] + 1 > limit then
+		result[i * 2 - 1] = 0
+		result[i * 2] = 0
+	else
+		result[i * 2 - 1] = 1
+		result[i * 2] = math.max(math.floor(remaining), 0)
+	end
+end
+return result
+`)
+
+// Limiter 限流器
+type Limiter struct {
+	Client *redis.RedisClient
+	// Prefix 缓存key前缀
+	Prefix string
+}
+
+// NewLimiter 创建限流器
+func NewLimiter(client *redis.RedisClient, prefix string) *Limiter {
+	return &Limiter{Client: client, Prefix: prefix}
+}
+
+// Allow 判断 subjects[i] 在 name 下按 policies[i] 是否还允许请求, 在同一个lua脚本中完成
+// 所有策略都允许时每个策略计数加一, 任一策略超过限制时都不计数, 被拒绝的请求不会消耗其他策略的次数
+func (l *Limiter) Allow(name string, policies []Policy, subjects []string, now time.Time) ([]Result, error) {
+	keys := make([]string, 0, len(policies)*2)
+	args := make([]interface{}, 0, len(policies)*3)
+	resets := make([]time.Duration, 0, len(policies))
+	for i, policy := range policies {
+		window := policy.Window.Milliseconds()
+		index := now.UnixNano() / int64(time.Millisecond) / window
+		elapsed := now.UnixNano()/int64(time.Millisecond) - index*window
+		key := fmt.Sprintf("%s:%s:%s:%d:%s", l.Prefix, name, policy.Key, window, subjects[i])
+		keys = append(keys, fmt.Sprintf("%s:%d", key, index), fmt.Sprintf("%s:%d", key, index-1))
+		args = append(args, policy.Limit, window, elapsed)
+		resets = append(resets, time.Duration(window-elapsed)*time.Millisecond)
+	}
+
+	values, err := slidingWindowScript.Run(l.Client.Ctx, l.Client.Client, keys, args...).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+	results := make([]Result, 0, len(policies))
+	for i, policy := range policies {
+		results = append(results, Result{
+			Allowed:   values[i*2] == 1,
+			Policy:    policy,
+			Remaining: int(values[i*2+1]),
+			Reset:     resets[i],
+		})
+	}
+	return results, nil
+}
@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	redisLib "github.com/go-redis/redis/v8"
+	"testing"
+	"time"
+	"user_web/pkg/redis"
+)
+
+func TestParsePolicies(t *testing.T) {
+	policies, err := ParsePolicies(" ip:10/1m, token:100/1h ,user:3/24h")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(policies) != 3 ||
+		policies[0] != (Policy{Key: KeyIP, Limit: 10, Window: time.Minute}) ||
+		policies[1] != (Policy{Key: KeyToken, Limit: 100, Window: time.Hour}) ||
+		policies[2] != (Policy{Key: KeyUser, Limit: 3, Window: 24 * time.Hour}) {
+		t.Fatalf("unexpected policies: %v", policies)
+	}
+	if policies, err = ParsePolicies(""); err != nil || len(policies) != 0 {
+		t.Fatalf("empty config: %v %v", policies, err)
+	}
+
+	for _, s := range []string{"ip", "uid:10/1m", "ip:0/1m", "ip:x/1m", "ip:10", "ip:10/1ms", "ip:10/abc"} {
+		if _, err = ParsePolicies(s); err == nil {
+			t.Errorf("ParsePolicies(%q): expected error", s)
+		}
+	}
+}
+
+func newTestLimiter(t *testing.T) *Limiter {
+	server := miniredis.RunT(t)
+	client := redisLib.NewClient(&redisLib.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewLimiter(&redis.RedisClient{Client: client, Ctx: context.Background()}, "test")
+}
+
+func TestAllowMultiplePolicies(t *testing.T) {
+	limiter := newTestLimiter(t)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	policies := []Policy{{Key: KeyToken, Limit: 5, Window: time.Minute}, {Key: KeyIP, Limit: 2, Window: time.Minute}}
+	subjects := []string{"token", "127.0.0.1"}
+
+	for i := 0; i < 2; i++ {
+		results, err := limiter.Allow("user", policies, subjects, now)
+		if err != nil {
+			t.Fatalf("allow: %v", err)
+		}
+		if !results[0].Allowed || !results[1].Allowed || results[0].Remaining != 4-i || results[1].Remaining != 1-i {
+			t.Fatalf("request %d: unexpected results %+v", i, results)
+		}
+	}
+
+	// ip策略超过限制, 两个策略都不计数
+	for i := 0; i < 3; i++ {
+		results, err := limiter.Allow("user", policies, subjects, now)
+		if err != nil {
+			t.Fatalf("allow: %v", err)
+		}
+		if !results[0].Allowed || results[0].Remaining != 3 || results[1].Allowed || results[1].Remaining != 0 {
+			t.Fatalf("denied request %d: unexpected results %+v", i, results)
+		}
+	}
+
+	// 另一个IP使用同一个token, token策略的次数没有被拒绝的请求消耗
+	results, err := limiter.Allow("user", policies, []string{"token", "127.0.0.2"}, now)
+	if err != nil {
+		t.Fatalf("allow: %v", err)
+	}
+	if !results[0].Allowed || !results[1].Allowed || results[0].Remaining != 2 {
+		t.Fatalf("other ip: unexpected results %+v", results)
+	}
+}
@@ -0,0 +1,91 @@
+package session
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+	"user_web/pkg/config"
+)
+
+// CSRFHeader 提交CSRF token的请求头, 值需要与CSRF cookie一致(double-submit)
+const CSRFHeader = "X-CSRF-Token"
+
+// Enabled 是否开启cookie会话, 开启后登录接口通过HttpOnly cookie下发token
+func Enabled() bool {
+	return config.GetBool("WEB_SESSION_COOKIE")
+}
+
+func cookieName() string {
+	if name := config.GetString("WEB_SESSION_COOKIE_NAME"); len(name) > 0 {
+		return name
+	}
+	return "session"
+}
+
+func csrfCookieName() string {
+	if name := config.GetString("WEB_CSRF_COOKIE_NAME"); len(name) > 0 {
+		return name
+	}
+	return "csrf_token"
+}
+
+func sameSite() http.SameSite {
+	switch strings.ToLower(config.GetString("WEB_SESSION_COOKIE_SAMESITE")) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
+
+// 会话cookie和CSRF cookie使用相同的属性, CSRF cookie需要被前端读取, 不能设置HttpOnly
+func setCookie(ctx *gin.Context, name, value string, maxAge int, httpOnly bool) {
+	http.SetCookie(ctx.Writer, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   config.GetString("WEB_SESSION_COOKIE_DOMAIN"),
+		MaxAge:   maxAge,
+		Secure:   config.GetBool("WEB_SESSION_COOKIE_SECURE"),
+		HttpOnly: httpOnly,
+		SameSite: sameSite(),
+	})
+}
+
+// Start 登录成功后写入会话cookie和CSRF cookie, 返回CSRF token
+func Start(ctx *gin.Context, token string) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	csrfToken := hex.EncodeToString(buf)
+
+	maxAge := config.GetInt("WEB_SESSION_MAX_AGE")
+	setCookie(ctx, cookieName(), token, maxAge, true)
+	setCookie(ctx, csrfCookieName(), csrfToken, maxAge, false)
+	return csrfToken, nil
+}
+
+// End 清除会话cookie和CSRF cookie
+func End(ctx *gin.Context) {
+	setCookie(ctx, cookieName(), "", -1, true)
+	setCookie(ctx, csrfCookieName(), "", -1, false)
+}
+
+// Token 从会话cookie中获取token
+func Token(ctx *gin.Context) string {
+	token, _ := ctx.Cookie(cookieName())
+	return token
+}
+
+// CheckCSRF 校验请求头中的CSRF token与cookie一致
+func CheckCSRF(ctx *gin.Context) bool {
+	cookie, _ := ctx.Cookie(csrfCookieName())
+	header := ctx.GetHeader(CSRFHeader)
+	return len(cookie) > 0 && subtle.ConstantTimeCompare([]byte(cookie), []byte(header)) == 1
+}
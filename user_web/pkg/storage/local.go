@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStorage 本地磁盘存储
+type LocalStorage struct {
+	// Dir 文件存放目录
+	Dir string
+	// BaseURL 公开访问地址前缀, 为空时返回以"/"开头的相对地址, 由user_web自身提供访问
+	BaseURL string
+}
+
+// NewLocalStorage 创建本地磁盘存储
+func NewLocalStorage(dir string, baseURL string) *LocalStorage {
+	return &LocalStorage{Dir: dir, BaseURL: baseURL}
+}
+
+// 存储路径转换为文件路径
+func (s *LocalStorage) path(key string) (string, error) {
+	key, err := CleanKey(strings.TrimLeft(key, "/"))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(s.Dir, filepath.FromSlash(key)), nil
+}
+
+// Put 保存文件, 先写入临时文件再重命名, 避免读取到不完整的文件
+func (s *LocalStorage) Put(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error {
+	dst, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = io.Copy(tmp, reader); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+// Exists 文件是否存在
+func (s *LocalStorage) Exists(ctx context.Context, key string) (bool, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Delete 删除文件
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// URL 获取文件的访问地址
+func (s *LocalStorage) URL(ctx context.Context, key string) (string, error) {
+	return joinURL(s.BaseURL, key), nil
+}
+
+// Open 打开文件用于读取
+func (s *LocalStorage) Open(ctx context.Context, key string) (*Object, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if stat.IsDir() {
+		f.Close()
+		return nil, ErrNotExist
+	}
+	return &Object{ReadSeekCloser: f, Info: localObjectInfo(key, stat)}, nil
+}
+
+// Stat 获取文件信息
+func (s *LocalStorage) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	stat, err := os.Stat(path)
+	if os.IsNotExist(err) || (err == nil && stat.IsDir()) {
+		return ObjectInfo{}, ErrNotExist
+	}
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return localObjectInfo(key, stat), nil
+}
+
+// List 遍历指定前缀下的所有文件, 跳过写入中的临时文件
+func (s *LocalStorage) List(ctx context.Context, prefix string, fn func(info ObjectInfo) error) error {
+	root := filepath.Join(s.Dir, filepath.FromSlash(strings.Trim(prefix, "/")))
+	err := filepath.Walk(root, func(path string, stat os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if stat.IsDir() || strings.HasPrefix(stat.Name(), ".") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.Dir, path)
+		if err != nil {
+			return err
+		}
+		return fn(localObjectInfo(filepath.ToSlash(rel), stat))
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// 本地文件信息, 以修改时间和大小作为ETag
+func localObjectInfo(key string, stat os.FileInfo) ObjectInfo {
+	return ObjectInfo{
+		Key:     key,
+		Size:    stat.Size(),
+		ModTime: stat.ModTime(),
+		ETag:    fmt.Sprintf("\"%x-%x\"", stat.ModTime().UnixNano(), stat.Size()),
+	}
+}
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// S3Options S3兼容存储配置
+type S3Options struct {
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	Bucket    string
+	Region    string
+	UseSSL    bool
+	// BaseURL 公开访问地址前缀(例如CDN), 为空时生成带签名的临时地址
+	BaseURL string
+	// PresignExpire 临时地址有效期
+	PresignExpire time.Duration
+}
+
+// S3Storage S3兼容存储(AWS S3、MinIO等)
+type S3Storage struct {
+	Client  *minio.Client
+	Options S3Options
+}
+
+// NewS3Storage 创建S3兼容存储
+func NewS3Storage(opts S3Options) (*S3Storage, error) {
+	client, err := minio.New(opts.Endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(opts.AccessKey, opts.SecretKey, ""),
+		Secure:       opts.UseSSL,
+		Region:       opts.Region,
+		BucketLookup: minio.BucketLookupPath,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if opts.PresignExpire <= 0 {
+		opts.PresignExpire = time.Hour
+	}
+	return &S3Storage{Client: client, Options: opts}, nil
+}
+
+// Put 保存文件
+func (s *S3Storage) Put(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error {
+	_, err := s.Client.PutObject(ctx, s.Options.Bucket, key, reader, size, minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	return err
+}
+
+// Exists 文件是否存在
+func (s *S3Storage) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := s.Client.StatObject(ctx, s.Options.Bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Delete 删除文件
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	return s.Client.RemoveObject(ctx, s.Options.Bucket, key, minio.RemoveObjectOptions{})
+}
+
+// URL 获取文件的访问地址
+func (s *S3Storage) URL(ctx context.Context, key string) (string, error) {
+	if len(s.Options.BaseURL) > 0 {
+		return joinURL(s.Options.BaseURL, key), nil
+	}
+	u, err := s.Client.PresignedGetObject(ctx, s.Options.Bucket, key, s.Options.PresignExpire, url.Values{})
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// Open 打开文件用于读取
+func (s *S3Storage) Open(ctx context.Context, key string) (*Object, error) {
+	obj, err := s.Client.GetObject(ctx, s.Options.Bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	stat, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, ErrNotExist
+		}
+		return nil, err
+	}
+	return &Object{ReadSeekCloser: obj, Info: s3ObjectInfo(stat)}, nil
+}
+
+// Stat 获取文件信息
+func (s *S3Storage) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	stat, err := s.Client.StatObject(ctx, s.Options.Bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return ObjectInfo{}, ErrNotExist
+		}
+		return ObjectInfo{}, err
+	}
+	return s3ObjectInfo(stat), nil
+}
+
+// List 遍历指定前缀下的所有文件
+func (s *S3Storage) List(ctx context.Context, prefix string, fn func(info ObjectInfo) error) error {
+	// 提前结束遍历时取消ListObjects, 避免后台协程泄露
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if prefix = strings.Trim(prefix, "/"); len(prefix) > 0 {
+		prefix += "/"
+	}
+	objects := s.Client.ListObjects(ctx, s.Options.Bucket, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	})
+	for obj := range objects {
+		if obj.Err != nil {
+			return obj.Err
+		}
+		if err := fn(s3ObjectInfo(obj)); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// S3文件信息
+func s3ObjectInfo(stat minio.ObjectInfo) ObjectInfo {
+	return ObjectInfo{
+		Key:         stat.Key,
+		Size:        stat.Size,
+		ModTime:     stat.LastModified,
+		ETag:        fmt.Sprintf("\"%s\"", stat.ETag),
+		ContentType: stat.ContentType,
+	}
+}
@@ -0,0 +1,238 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 MinIO风格的S3测试服务, 只支持path-style的单对象操作和ListObjectsV2
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	types   map[string]string
+	times   map[string]time.Time
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *httptest.Server) {
+	f := &fakeS3{objects: make(map[string][]byte), types: make(map[string]string), times: make(map[string]time.Time)}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256") &&
+		len(r.URL.Query().Get("X-Amz-Signature")) == 0 {
+		http.Error(w, "unsigned request", http.StatusForbidden)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	if r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2" {
+		f.list(w, strings.TrimSuffix(key, "/"), r.URL.Query().Get("prefix"))
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+			if body, err = decodeAwsChunked(body); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		f.objects[key] = body
+		f.types[key] = r.Header.Get("Content-Type")
+		f.times[key] = time.Now().UTC()
+		w.Header().Set("ETag", `"etag"`)
+	case http.MethodHead, http.MethodGet:
+		data, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				fmt.Fprint(w, `<Error><Code>NoSuchKey</Code></Error>`)
+			}
+			return
+		}
+		w.Header().Set("ETag", `"etag"`)
+		w.Header().Set("Content-Type", f.types[key])
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("Last-Modified", f.times[key].Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+	case http.MethodDelete:
+		delete(f.objects, key)
+		delete(f.types, key)
+		delete(f.times, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// 列出bucket中指定前缀的对象, 不分页
+func (f *fakeS3) list(w http.ResponseWriter, bucket string, prefix string) {
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, bucket+"/"+prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprintf(w, `<ListBucketResult><Name>%s</Name><Prefix>%s</Prefix><KeyCount>%d</KeyCount>`+
+		`<MaxKeys>1000</MaxKeys><IsTruncated>false</IsTruncated>`, bucket, prefix, len(keys))
+	for _, key := range keys {
+		fmt.Fprintf(w, `<Contents><Key>%s</Key><LastModified>%s</LastModified><ETag>"etag"</ETag>`+
+			`<Size>%d</Size><StorageClass>STANDARD</StorageClass></Contents>`,
+			strings.TrimPrefix(key, bucket+"/"), f.times[key].Format("2006-01-02T15:04:05.000Z"), len(f.objects[key]))
+	}
+	fmt.Fprint(w, `</ListBucketResult>`)
+}
+
+// 解码aws-chunked格式的请求体: <hex-size>;chunk-signature=<sig>\r\n<data>\r\n
+func decodeAwsChunked(body []byte) ([]byte, error) {
+	var out bytes.Buffer
+	reader := bufio.NewReader(bytes.NewReader(body))
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.ParseInt(strings.SplitN(strings.TrimSpace(line), ";", 2)[0], 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return out.Bytes(), nil
+		}
+		if _, err = io.CopyN(&out, reader, size); err != nil {
+			return nil, err
+		}
+		if _, err = reader.Discard(2); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func newTestS3Storage(t *testing.T, baseURL string) (*fakeS3, *S3Storage) {
+	fake, srv := newFakeS3(t)
+	u, _ := url.Parse(srv.URL)
+	s, err := NewS3Storage(S3Options{
+		Endpoint:  u.Host,
+		AccessKey: "access",
+		SecretKey: "secret",
+		Bucket:    "avatars",
+		Region:    "us-east-1",
+		BaseURL:   baseURL,
+	})
+	if err != nil {
+		t.Fatalf("new s3 storage: %v", err)
+	}
+	return fake, s
+}
+
+func TestS3Storage(t *testing.T) {
+	ctx := context.Background()
+	fake, s := newTestS3Storage(t, "")
+	key := "avatars/ab/abc.png"
+
+	if ok, err := s.Exists(ctx, key); err != nil || ok {
+		t.Fatalf("exists before put: %v %v", ok, err)
+	}
+	if err := s.Put(ctx, key, bytes.NewReader([]byte("data")), 4, "image/png"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if string(fake.objects["avatars/"+key]) != "data" || fake.types["avatars/"+key] != "image/png" {
+		t.Fatalf("unexpected stored object: %q %q", fake.objects["avatars/"+key], fake.types["avatars/"+key])
+	}
+	if ok, err := s.Exists(ctx, key); err != nil || !ok {
+		t.Fatalf("exists after put: %v %v", ok, err)
+	}
+
+	// 未配置公开地址时生成带签名的临时地址, 可以直接下载
+	u, err := s.URL(ctx, key)
+	if err != nil {
+		t.Fatalf("presign url: %v", err)
+	}
+	if !strings.Contains(u, "X-Amz-Signature=") || !strings.Contains(u, "X-Amz-Expires=3600") {
+		t.Fatalf("unexpected presigned url: %s", u)
+	}
+	rsp, err := http.Get(u)
+	if err != nil {
+		t.Fatalf("get presigned url: %v", err)
+	}
+	data, _ := ioutil.ReadAll(rsp.Body)
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK || string(data) != "data" {
+		t.Fatalf("unexpected presigned response: %d %q", rsp.StatusCode, data)
+	}
+
+	obj, err := s.Open(ctx, key)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	data, _ = ioutil.ReadAll(obj)
+	obj.Close()
+	if string(data) != "data" || obj.Info.ContentType != "image/png" || obj.Info.ETag != `"etag"` {
+		t.Fatalf("unexpected object: %q %+v", data, obj.Info)
+	}
+
+	info, err := s.Stat(ctx, key)
+	if err != nil || info.Size != 4 || info.ModTime.IsZero() {
+		t.Fatalf("stat: %+v %v", info, err)
+	}
+	if err = s.Put(ctx, "thumbs/x.png", bytes.NewReader([]byte("x")), 1, "image/png"); err != nil {
+		t.Fatalf("put other prefix: %v", err)
+	}
+	var listed []string
+	err = s.List(ctx, "avatars", func(info ObjectInfo) error {
+		listed = append(listed, info.Key)
+		return nil
+	})
+	if err != nil || len(listed) != 1 || listed[0] != key {
+		t.Fatalf("list: %v %v", listed, err)
+	}
+
+	if err = s.Delete(ctx, key); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if ok, _ := s.Exists(ctx, key); ok {
+		t.Fatalf("exists after delete")
+	}
+	if _, err = s.Open(ctx, key); err != ErrNotExist {
+		t.Fatalf("open missing object: %v", err)
+	}
+	if _, err = s.Stat(ctx, key); err != ErrNotExist {
+		t.Fatalf("stat missing object: %v", err)
+	}
+}
+
+func TestS3StoragePublicURL(t *testing.T) {
+	_, s := newTestS3Storage(t, "https://cdn.example.com")
+	u, err := s.URL(context.Background(), "avatars/ab/abc.png")
+	if err != nil || u != "https://cdn.example.com/avatars/ab/abc.png" {
+		t.Fatalf("unexpected public url: %s %v", u, err)
+	}
+}
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"user_web/pkg/config"
+	"user_web/pkg/logger"
+)
+
+// Storage 文件存储接口
+type Storage interface {
+	// Put 保存文件
+	Put(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error
+	// Exists 文件是否存在
+	Exists(ctx context.Context, key string) (bool, error)
+	// Delete 删除文件
+	Delete(ctx context.Context, key string) error
+	// URL 获取文件的访问地址, 未配置公开地址的私有存储返回带签名的临时地址
+	URL(ctx context.Context, key string) (string, error)
+	// Open 打开文件用于读取, 文件不存在时返回 ErrNotExist
+	Open(ctx context.Context, key string) (*Object, error)
+	// Stat 获取文件信息, 文件不存在时返回 ErrNotExist
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	// List 遍历指定前缀下的所有文件, fn返回错误时停止遍历
+	List(ctx context.Context, prefix string, fn func(info ObjectInfo) error) error
+}
+
+// ObjectInfo 文件信息
+type ObjectInfo struct {
+	Key         string
+	Size        int64
+	ModTime     time.Time
+	ETag        string
+	ContentType string
+}
+
+// Object 已打开的文件, 支持随机读取以便处理Range请求
+type Object struct {
+	io.ReadSeekCloser
+	Info ObjectInfo
+}
+
+var (
+	// ErrNotExist 文件不存在
+	ErrNotExist = errors.New("storage: object does not exist")
+	// ErrInvalidKey 非法的存储路径
+	ErrInvalidKey = errors.New("storage: invalid key")
+)
+
+// Store 全局文件存储
+var Store Storage
+
+// SetupStorage 根据配置初始化文件存储
+func SetupStorage() {
+	var err error
+	switch driver := config.GetString("STORAGE_DRIVER"); driver {
+	case "", "local":
+		Store = NewLocalStorage(config.GetString("APP_UPLOAD_DIR"), config.GetString("STORAGE_BASE_URL"))
+	case "s3":
+		Store, err = NewS3Storage(S3Options{
+			Endpoint:      config.GetString("STORAGE_S3_ENDPOINT"),
+			AccessKey:     config.GetString("STORAGE_S3_ACCESS_KEY"),
+			SecretKey:     config.GetString("STORAGE_S3_SECRET_KEY"),
+			Bucket:        config.GetString("STORAGE_S3_BUCKET"),
+			Region:        config.GetString("STORAGE_S3_REGION"),
+			UseSSL:        config.GetBool("STORAGE_S3_USE_SSL"),
+			BaseURL:       config.GetString("STORAGE_BASE_URL"),
+			PresignExpire: time.Duration(config.GetInt("STORAGE_S3_PRESIGN_EXPIRE")) * time.Second,
+		})
+	default:
+		err = fmt.Errorf("unknown storage driver: %s", driver)
+	}
+	if err != nil {
+		logger.Error("storage", "setup storage error", err)
+		panic(err)
+	}
+	logger.Debug("storage", "setup success")
+}
+
+// ContentKey 根据文件内容生成存储路径, 相同内容的文件得到相同的路径
+// 例如: avatars/3a/3a7bd3e2360a3d...png
+func ContentKey(prefix string, data []byte, ext string) string {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	return fmt.Sprintf("%s/%s/%s%s", strings.Trim(prefix, "/"), hash[:2], hash, strings.ToLower(ext))
+}
+
+// CleanKey 校验存储路径, 拒绝绝对路径、反斜杠以及"."、".."等路径片段, 防止路径穿越
+func CleanKey(key string) (string, error) {
+	if len(key) == 0 || strings.HasPrefix(key, "/") || strings.ContainsAny(key, "\\\x00") {
+		return "", ErrInvalidKey
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return "", ErrInvalidKey
+		}
+	}
+	return key, nil
+}
+
+// 拼接公开访问地址
+func joinURL(baseURL string, key string) string {
+	return fmt.Sprintf("%s/%s", strings.TrimRight(baseURL, "/"), strings.TrimLeft(key, "/"))
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestContentKey(t *testing.T) {
+	a := ContentKey("/avatars/", []byte("hello"), ".PNG")
+	b := ContentKey("avatars", []byte("hello"), ".png")
+	if a != b {
+		t.Fatalf("same content should have same key: %s != %s", a, b)
+	}
+	if !strings.HasPrefix(a, "avatars/2c/2cf24dba") || !strings.HasSuffix(a, ".png") {
+		t.Fatalf("unexpected key: %s", a)
+	}
+	if ContentKey("avatars", []byte("world"), ".png") == a {
+		t.Fatalf("different content should have different key")
+	}
+}
+
+func TestLocalStorage(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := NewLocalStorage(dir, "")
+	key := "avatars/ab/abc.png"
+
+	if ok, err := s.Exists(ctx, key); err != nil || ok {
+		t.Fatalf("exists before put: %v %v", ok, err)
+	}
+	if err := s.Put(ctx, key, bytes.NewReader([]byte("data")), 4, "image/png"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if ok, err := s.Exists(ctx, key); err != nil || !ok {
+		t.Fatalf("exists after put: %v %v", ok, err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "avatars", "ab", "abc.png"))
+	if err != nil || string(data) != "data" {
+		t.Fatalf("read stored file: %q %v", data, err)
+	}
+
+	obj, err := s.Open(ctx, key)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	data, _ = ioutil.ReadAll(obj)
+	obj.Close()
+	if string(data) != "data" || obj.Info.Size != 4 || len(obj.Info.ETag) == 0 {
+		t.Fatalf("unexpected object: %q %+v", data, obj.Info)
+	}
+
+	info, err := s.Stat(ctx, key)
+	if err != nil || info.Key != key || info.Size != 4 {
+		t.Fatalf("stat: %+v %v", info, err)
+	}
+	// 写入中的临时文件和其他前缀的文件不会被遍历
+	_ = ioutil.WriteFile(filepath.Join(dir, "avatars", "ab", ".upload-1"), []byte("tmp"), 0644)
+	_ = s.Put(ctx, "thumbs/x.png", bytes.NewReader([]byte("x")), 1, "image/png")
+	var listed []string
+	err = s.List(ctx, "avatars", func(info ObjectInfo) error {
+		listed = append(listed, info.Key)
+		return nil
+	})
+	if err != nil || len(listed) != 1 || listed[0] != key {
+		t.Fatalf("list: %v %v", listed, err)
+	}
+	if err = s.List(ctx, "missing", func(ObjectInfo) error { return nil }); err != nil {
+		t.Fatalf("list missing prefix: %v", err)
+	}
+
+	u, _ := s.URL(ctx, key)
+	if u != "/avatars/ab/abc.png" {
+		t.Fatalf("unexpected url without base url: %s", u)
+	}
+	s.BaseURL = "https://cdn.example.com/"
+	if u, _ = s.URL(ctx, key); u != "https://cdn.example.com/avatars/ab/abc.png" {
+		t.Fatalf("unexpected url with base url: %s", u)
+	}
+
+	if err = s.Delete(ctx, key); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err = s.Delete(ctx, key); err != nil {
+		t.Fatalf("delete missing file: %v", err)
+	}
+	if ok, _ := s.Exists(ctx, key); ok {
+		t.Fatalf("exists after delete")
+	}
+	if _, err = s.Open(ctx, key); err != ErrNotExist {
+		t.Fatalf("open missing file: %v", err)
+	}
+	if _, err = s.Stat(ctx, key); err != ErrNotExist {
+		t.Fatalf("stat missing file: %v", err)
+	}
+	if _, err = s.Open(ctx, "avatars/../../etc/passwd"); err != ErrInvalidKey {
+		t.Fatalf("open traversal key: %v", err)
+	}
+}
+
+func TestCleanKey(t *testing.T) {
+	for _, key := range []string{"avatars/ab/abc.png", "a", "a/b.c/d"} {
+		if _, err := CleanKey(key); err != nil {
+			t.Errorf("CleanKey(%q): unexpected error %v", key, err)
+		}
+	}
+	for _, key := range []string{"", "/etc/passwd", "../a", "a/../b", "a/./b", "a//b", "a/", "a\\..\\b", "a\x00b"} {
+		if _, err := CleanKey(key); err != ErrInvalidKey {
+			t.Errorf("CleanKey(%q): expected ErrInvalidKey, got %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DirStore 从磁盘加载ACME客户端(certbot、lego、acme.sh等)签发的证书
+// 目录结构为 <Dir>/<域名>/<CertName> 和 <Dir>/<域名>/<KeyName>, 按SNI选择证书; 续期后文件变化会自动重新加载
+type DirStore struct {
+	Dir      string
+	CertName string
+	KeyName  string
+	// 客户端未发送SNI时使用的域名
+	DefaultName string
+	// 检查文件是否更新的最小间隔
+	Interval time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*dirEntry
+}
+
+type dirEntry struct {
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+// NewDirStore 创建证书目录, 文件名与certbot的 live 目录一致
+func NewDirStore(dir, defaultName string) *DirStore {
+	return &DirStore{
+		Dir:         dir,
+		CertName:    "fullchain.pem",
+		KeyName:     "privkey.pem",
+		DefaultName: defaultName,
+		Interval:    time.Minute,
+	}
+}
+
+// 域名只允许小写字母、数字、-和., 防止SNI中的路径穿越
+func validServerName(name string) bool {
+	if len(name) == 0 || len(name) > 253 || strings.HasPrefix(name, ".") || strings.Contains(name, "..") {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// GetCertificate 按SNI返回证书, 用于 tls.Config.GetCertificate
+func (s *DirStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if len(name) == 0 {
+		name = s.DefaultName
+	}
+	if !validServerName(name) {
+		return nil, errors.New("tls: invalid server name")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry := s.entries[name]
+	now := time.Now()
+	if entry != nil && now.Sub(entry.checked) < s.Interval {
+		return entry.cert, nil
+	}
+
+	cert, modTime, err := s.load(name, entry)
+	if err != nil {
+		// 续期过程中文件可能暂时不完整, 继续使用已加载的证书
+		if entry != nil {
+			entry.checked = now
+			return entry.cert, nil
+		}
+		return nil, err
+	}
+	if s.entries == nil {
+		s.entries = make(map[string]*dirEntry)
+	}
+	s.entries[name] = &dirEntry{cert: cert, modTime: modTime, checked: now}
+	return cert, nil
+}
+
+// 文件未变化时返回已加载的证书
+func (s *DirStore) load(name string, entry *dirEntry) (*tls.Certificate, time.Time, error) {
+	certFile := filepath.Join(s.Dir, name, s.CertName)
+	keyFile := filepath.Join(s.Dir, name, s.KeyName)
+
+	var modTime time.Time
+	for _, file := range []string{certFile, keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, modTime, fmt.Errorf("tls: no certificate for %s: %w", name, err)
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	if entry != nil && !modTime.After(entry.modTime) {
+		return entry.cert, entry.modTime, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, modTime, fmt.Errorf("tls: load certificate for %s: %w", name, err)
+	}
+	return &cert, modTime, nil
+}
@@ -0,0 +1,114 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 写入自签名证书, serial 用于区分不同版本的证书
+func writeSelfSigned(t *testing.T, dir, name string, serial int64) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.MkdirAll(filepath.Join(dir, name), 0700); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"fullchain.pem": pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		"privkey.pem":   pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+	for file, data := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, name, file), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func serialOf(t *testing.T, store *DirStore, serverName string) int64 {
+	t.Helper()
+	cert, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+	if err != nil {
+		t.Fatalf("get certificate %q: %v", serverName, err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.SerialNumber.Int64()
+}
+
+func TestDirStore(t *testing.T) {
+	dir := t.TempDir()
+	writeSelfSigned(t, dir, "api.example.com", 1)
+	writeSelfSigned(t, dir, "www.example.com", 2)
+	store := NewDirStore(dir, "api.example.com")
+	store.Interval = 0
+
+	if serial := serialOf(t, store, "www.example.com"); serial != 2 {
+		t.Fatalf("sni certificate serial = %d", serial)
+	}
+	if serial := serialOf(t, store, "API.example.com."); serial != 1 {
+		t.Fatalf("case-insensitive certificate serial = %d", serial)
+	}
+	if serial := serialOf(t, store, ""); serial != 1 {
+		t.Fatalf("default certificate serial = %d", serial)
+	}
+	for _, name := range []string{"unknown.example.com", "../api.example.com", "api.example.com/x", "..", ".hidden"} {
+		if _, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: name}); err == nil {
+			t.Fatalf("%q should not resolve to a certificate", name)
+		}
+	}
+
+	// 续期后重新加载, 修改时间需要晚于上次加载
+	writeSelfSigned(t, dir, "www.example.com", 3)
+	later := time.Now().Add(time.Minute)
+	for _, file := range []string{"fullchain.pem", "privkey.pem"} {
+		if err := os.Chtimes(filepath.Join(dir, "www.example.com", file), later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if serial := serialOf(t, store, "www.example.com"); serial != 3 {
+		t.Fatalf("renewed certificate serial = %d", serial)
+	}
+
+	// 文件损坏时继续使用已加载的证书
+	broken := later.Add(time.Minute)
+	path := filepath.Join(dir, "www.example.com", "fullchain.pem")
+	if err := ioutil.WriteFile(path, []byte("partial"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, broken, broken); err != nil {
+		t.Fatal(err)
+	}
+	if serial := serialOf(t, store, "www.example.com"); serial != 3 {
+		t.Fatalf("broken renewal should keep serial 3, got %d", serial)
+	}
+}
@@ -0,0 +1,59 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"user_web/pkg/config"
+	"user_web/pkg/logger"
+)
+
+// RpcTLS 连接user_rpc使用的证书, 未开启TLS时为nil
+var RpcTLS *Reloader
+
+// WebTLS https服务的TLS配置, 未开启时为nil
+var WebTLS *tls.Config
+
+// SetupTLS 根据配置加载连接user_rpc的证书, .env 变化时重新加载
+func SetupTLS() {
+	if !config.GetBool("RPC_TLS_ENABLE") {
+		return
+	}
+	RpcTLS = newReloader("RPC_TLS")
+}
+
+// SetupWebTLS 根据配置加载https证书
+// 配置了 WEB_TLS_ACME_DIR 时从ACME客户端的证书目录按域名加载, 否则使用 WEB_TLS_CERT_FILE/WEB_TLS_KEY_FILE
+func SetupWebTLS() {
+	if !config.GetBool("WEB_TLS_ENABLE") {
+		return
+	}
+
+	WebTLS = &tls.Config{MinVersion: tls.VersionTLS12}
+	if dir := config.GetString("WEB_TLS_ACME_DIR"); len(dir) > 0 {
+		WebTLS.GetCertificate = NewDirStore(dir, config.GetString("WEB_TLS_ACME_DEFAULT_NAME")).GetCertificate
+		return
+	}
+	WebTLS.GetCertificate = newReloader("WEB_TLS").GetCertificate
+}
+
+// 加载 <prefix>_CERT_FILE、<prefix>_KEY_FILE、<prefix>_CA_FILE 配置的证书, .env 变化时重新加载
+func newReloader(prefix string) *Reloader {
+	reloader, err := NewReloader(func() Files {
+		return Files{
+			CertFile: config.GetString(prefix + "_CERT_FILE"),
+			KeyFile:  config.GetString(prefix + "_KEY_FILE"),
+			CAFile:   config.GetString(prefix + "_CA_FILE"),
+		}
+	})
+	if err != nil {
+		logger.Error("tls", "load certificate error", prefix, err)
+		panic(err)
+	}
+	config.OnChange(func() {
+		if err := reloader.Reload(); err != nil {
+			logger.Error("tls", "reload certificate error", prefix, err)
+			return
+		}
+		logger.Info("tls", "certificate reloaded", prefix)
+	})
+	return reloader
+}
@@ -0,0 +1,121 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+)
+
+// Files 证书文件路径
+// CertFile/KeyFile 为本端证书和私钥, 客户端为空时不提供客户端证书; CAFile 为校验服务端证书的CA, 为空时使用系统根证书
+type Files struct {
+	CertFile string
+	KeyFile  string
+	CAFile   string
+}
+
+// Reloader 持有当前的证书和CA, Reload 后新的握手使用新证书, 已建立的连接不受影响
+type Reloader struct {
+	files func() Files
+	mu    sync.RWMutex
+	cert  *tls.Certificate
+	pool  *x509.CertPool
+}
+
+// NewReloader 创建并加载证书, files 在每次 Reload 时调用以获取最新的路径
+func NewReloader(files func() Files) (*Reloader, error) {
+	r := &Reloader{files: files}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload 重新读取证书文件, 失败时保留原来的证书
+func (r *Reloader) Reload() error {
+	files := r.files()
+	var cert *tls.Certificate
+	if len(files.CertFile) > 0 {
+		pair, err := tls.LoadX509KeyPair(files.CertFile, files.KeyFile)
+		if err != nil {
+			return fmt.Errorf("load key pair: %w", err)
+		}
+		cert = &pair
+	}
+
+	var pool *x509.CertPool
+	if len(files.CAFile) > 0 {
+		data, err := ioutil.ReadFile(files.CAFile)
+		if err != nil {
+			return fmt.Errorf("load ca: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("load ca: no certificate found in %s", files.CAFile)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert, r.pool = cert, pool
+	return nil
+}
+
+func (r *Reloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, r.pool
+}
+
+// GetCertificate 返回当前的证书, 用于 tls.Config.GetCertificate
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, _ := r.current()
+	if cert == nil {
+		return nil, errors.New("tls: no server certificate")
+	}
+	return cert, nil
+}
+
+// ClientConfig 客户端TLS配置, 每次握手使用最新的客户端证书和CA
+// serverName 为校验服务端证书使用的名称, 为空时使用连接地址中的主机名
+func (r *Reloader) ClientConfig(serverName string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+		// 标准校验只能使用固定的RootCAs, 改为在 VerifyConnection 中使用最新的CA校验
+		InsecureSkipVerify: true,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			if cert, _ := r.current(); cert != nil {
+				return cert, nil
+			}
+			return &tls.Certificate{}, nil
+		},
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			name := serverName
+			if len(name) == 0 {
+				name = cs.ServerName
+			}
+			if len(name) == 0 {
+				return errors.New("tls: server name is required to verify the server certificate")
+			}
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("tls: server did not provide a certificate")
+			}
+
+			_, pool := r.current()
+			opts := x509.VerifyOptions{
+				Roots:         pool,
+				DNSName:       name,
+				Intermediates: x509.NewCertPool(),
+			}
+			for _, cert := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			_, err := cs.PeerCertificates[0].Verify(opts)
+			return err
+		},
+	}
+}
@@ -0,0 +1,309 @@
+// Package upload 断点续传上传会话, 参考 tus 协议的 create/patch/head
+//
+// 会话信息保存在redis中, 已上传的数据按分片保存在存储的 uploads/<会话ID>/ 下, 多个 user_web 实例之间共享;
+// 分片先写入存储再登记到会话, 偏移量校验和登记在同一个lua脚本中完成, 并发的重复请求只有一个会成功,
+// 失败的请求删除自己写入的分片; 每个用户最多保留 UPLOAD_SESSION_MAX 个未完成的会话, 创建新会话时删除最早的会话,
+// 会话过期后留下的分片通过 Sweep 删除
+package upload
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	redisLib "github.com/go-redis/redis/v8"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"user_web/pkg/config"
+	"user_web/pkg/redis"
+	"user_web/pkg/storage"
+)
+
+// 分片在存储中的前缀
+const chunkPrefix = "uploads"
+
+var (
+	// ErrNotFound 上传会话不存在或已过期
+	ErrNotFound = errors.New("upload: session not found")
+	// ErrOffsetMismatch 偏移量与已上传的数据长度不一致
+	ErrOffsetMismatch = errors.New("upload: offset mismatch")
+	// ErrExceedLength 上传的数据超过了创建会话时声明的长度
+	ErrExceedLength = errors.New("upload: exceeds upload length")
+)
+
+// Session 上传会话
+type Session struct {
+	ID string
+	// UserID 创建会话的用户, 只有该用户可以继续上传
+	UserID int64
+	// Length 文件总长度
+	Length int64
+	// Offset 已上传的长度
+	Offset int64
+}
+
+// Done 是否已上传完成
+func (s *Session) Done() bool {
+	return s.Offset >= s.Length
+}
+
+// 校验偏移量并登记分片, 同时延长会话和用户会话集合的有效期, 返回登记后的偏移量; -1 会话不存在, -2 偏移量不一致, -3 超过总长度
+// KEYS: 会话信息, 分片列表, 用户的会话集合; ARGV: 偏移量, 分片长度, 有效期, 分片的存储路径(空数据时为空)
+var appendScript = redisLib.NewScript(`
+local meta = redis.call('HMGET', KEYS[1], 'length', 'offset')
+if not meta[1] then
+	return -1
+end
+local offset = tonumber(meta[2] or '0')
+if offset ~= tonumber(ARGV[1]) then
+	return -2
+end
+if offset + tonumber(ARGV[2]) > tonumber(meta[1]) then
+	return -3
+end
+local n = redis.call('HINCRBY', KEYS[1], 'offset', ARGV[2])
+if ARGV[4] ~= '' then
+	redis.call('RPUSH', KEYS[2], ARGV[4])
+end
+redis.call('EXPIRE', KEYS[1], ARGV[3])
+redis.call('EXPIRE', KEYS[2], ARGV[3])
+redis.call('EXPIRE', KEYS[3], ARGV[3])
+return n
+`)
+
+// 创建会话, 清理用户已过期的会话, 超过上限时删除最早的会话, 返回被清理的会话ID
+// KEYS[1] 用户的会话集合; ARGV: 前缀, 会话ID, 创建时间, 会话上限, 有效期, 用户ID, 文件总长度
+var createScript = redisLib.NewScript(`
+local prefix = ARGV[1]
+local removed = {}
+for _, id in ipairs(redis.call('ZRANGE', KEYS[1], 0, -1)) do
+	if redis.call('EXISTS', prefix .. ':' .. id .. ':meta') == 0 then
+		redis.call('DEL', prefix .. ':' .. id .. ':chunks')
+		redis.call('ZREM', KEYS[1], id)
+		table.insert(removed, id)
+	end
+end
+while redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[4]) do
+	local oldest = redis.call('ZRANGE', KEYS[1], 0, 0)[1]
+	redis.call('DEL', prefix .. ':' .. oldest .. ':meta', prefix .. ':' .. oldest .. ':chunks')
+	redis.call('ZREM', KEYS[1], oldest)
+	table.insert(removed, oldest)
+end
+local meta = prefix .. ':' .. ARGV[2] .. ':meta'
+redis.call('HSET', meta, 'user_id', ARGV[6], 'length', ARGV[7], 'offset', 0)
+redis.call('EXPIRE', meta, ARGV[5])
+redis.call('ZADD', KEYS[1], ARGV[3], ARGV[2])
+redis.call('EXPIRE', KEYS[1], ARGV[5])
+return removed
+`)
+
+// 会话信息的缓存key
+func metaKey(id string) string {
+	return fmt.Sprintf("%s:%s:meta", config.GetString("UPLOAD_SESSION_PREFIX"), id)
+}
+
+// 已登记的分片列表的缓存key, 按偏移量顺序保存分片的存储路径
+func chunksKey(id string) string {
+	return fmt.Sprintf("%s:%s:chunks", config.GetString("UPLOAD_SESSION_PREFIX"), id)
+}
+
+// 会话的分片在存储中的目录
+func chunkDir(id string) string {
+	return chunkPrefix + "/" + id
+}
+
+// 生成随机ID
+func randomID(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// 用户未完成的会话集合的缓存key
+func userKey(userID int64) string {
+	return fmt.Sprintf("%s:user:%d", config.GetString("UPLOAD_SESSION_PREFIX"), userID)
+}
+
+// 每个用户未完成的会话上限, 未配置时为1
+func maxSessions() int {
+	if n := config.GetInt("UPLOAD_SESSION_MAX"); n > 0 {
+		return n
+	}
+	return 1
+}
+
+// 会话有效期, 每次追加数据后延长
+func expire() time.Duration {
+	return time.Duration(config.GetInt("UPLOAD_SESSION_EXPIRE")) * time.Second
+}
+
+// Create 创建上传会话, 用户未完成的会话超过上限时删除最早的会话及其分片
+func Create(ctx context.Context, store storage.Storage, userID int64, length int64) (*Session, error) {
+	id, err := randomID(16)
+	if err != nil {
+		return nil, err
+	}
+	session := &Session{ID: id, UserID: userID, Length: length}
+
+	removed, err := createScript.Run(redis.Redis.Ctx, redis.Redis.Client, []string{userKey(userID)},
+		config.GetString("UPLOAD_SESSION_PREFIX"), session.ID, time.Now().UnixNano(), maxSessions(),
+		int64(expire()/time.Second), userID, length).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range removed {
+		if err = deleteChunks(ctx, store, id); err != nil {
+			return nil, err
+		}
+	}
+	return session, nil
+}
+
+// Get 获取上传会话
+func Get(id string) (*Session, error) {
+	meta, err := redis.Redis.Client.HGetAll(redis.Redis.Ctx, metaKey(id)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(meta) == 0 {
+		return nil, ErrNotFound
+	}
+
+	session := &Session{ID: id}
+	if session.UserID, err = strconv.ParseInt(meta["user_id"], 10, 64); err != nil {
+		return nil, err
+	}
+	if session.Length, err = strconv.ParseInt(meta["length"], 10, 64); err != nil {
+		return nil, err
+	}
+	if session.Offset, err = strconv.ParseInt(meta["offset"], 10, 64); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// Append 从offset处追加数据, 返回追加后的偏移量
+// 分片使用随机路径写入存储, 登记失败时删除, 并发的重复请求不会覆盖已登记的分片
+func Append(ctx context.Context, store storage.Storage, session *Session, offset int64, data []byte) (int64, error) {
+	var key string
+	if len(data) > 0 {
+		nonce, err := randomID(8)
+		if err != nil {
+			return 0, err
+		}
+		key = fmt.Sprintf("%s/%d-%s", chunkDir(session.ID), offset, nonce)
+		if err = store.Put(ctx, key, bytes.NewReader(data), int64(len(data)), "application/octet-stream"); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := appendScript.Run(redis.Redis.Ctx, redis.Redis.Client,
+		[]string{metaKey(session.ID), chunksKey(session.ID), userKey(session.UserID)},
+		offset, len(data), int64(expire()/time.Second), key).Int64()
+	if err == nil && n < 0 {
+		switch n {
+		case -1:
+			err = ErrNotFound
+		case -2:
+			err = ErrOffsetMismatch
+		default:
+			err = ErrExceedLength
+		}
+	}
+	if err != nil {
+		if len(key) > 0 {
+			_ = store.Delete(ctx, key)
+		}
+		return 0, err
+	}
+	return n, nil
+}
+
+// Data 按顺序读取已登记的分片, 返回已上传的数据
+func Data(ctx context.Context, store storage.Storage, id string) ([]byte, error) {
+	keys, err := redis.Redis.Client.LRange(redis.Redis.Ctx, chunksKey(id), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	for _, key := range keys {
+		obj, err := store.Open(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.Copy(&buf, obj)
+		obj.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// Delete 删除上传会话及其分片
+func Delete(ctx context.Context, store storage.Storage, session *Session) error {
+	_, err := redis.Redis.Client.TxPipelined(redis.Redis.Ctx, func(pipe redisLib.Pipeliner) error {
+		pipe.Del(redis.Redis.Ctx, metaKey(session.ID), chunksKey(session.ID))
+		pipe.ZRem(redis.Redis.Ctx, userKey(session.UserID), session.ID)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return deleteChunks(ctx, store, session.ID)
+}
+
+// 删除会话在存储中的所有分片
+func deleteChunks(ctx context.Context, store storage.Storage, id string) error {
+	var keys []string
+	err := store.List(ctx, chunkDir(id), func(info storage.ObjectInfo) error {
+		keys = append(keys, info.Key)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err = store.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sweep 删除会话已不存在(过期或被删除)的分片, 返回这些分片; dryRun 时只返回不删除
+func Sweep(ctx context.Context, store storage.Storage, dryRun bool) ([]storage.ObjectInfo, error) {
+	exists := make(map[string]bool)
+	var orphans []storage.ObjectInfo
+	err := store.List(ctx, chunkPrefix, func(info storage.ObjectInfo) error {
+		id := strings.SplitN(strings.TrimPrefix(info.Key, chunkPrefix+"/"), "/", 2)[0]
+		ok, checked := exists[id]
+		if !checked {
+			n, err := redis.Redis.Client.Exists(redis.Redis.Ctx, metaKey(id)).Result()
+			if err != nil {
+				return err
+			}
+			ok = n > 0
+			exists[id] = ok
+		}
+		if !ok {
+			orphans = append(orphans, info)
+		}
+		return nil
+	})
+	if err != nil || dryRun {
+		return orphans, err
+	}
+	for _, info := range orphans {
+		if err = store.Delete(ctx, info.Key); err != nil {
+			return orphans, err
+		}
+	}
+	return orphans, nil
+}
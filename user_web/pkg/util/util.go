@@ -1,18 +1,24 @@
 package util
 
 import (
+	"bytes"
 	"context"
 	"crypto/md5"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"google.golang.org/grpc/metadata"
+	"io"
+	"io/ioutil"
 	"math/rand"
-	"mime/multipart"
-	"os"
-	"path/filepath"
+	"path"
+	"strconv"
+	"strings"
 	"time"
 	"user_web/pkg/config"
+	"user_web/pkg/imaging"
+	"user_web/pkg/storage"
 )
 
 // Md5Data md5加密
@@ -39,35 +45,195 @@ func TimeNowInTimeZone() time.Time {
 	return time.Now().In(timeZone)
 }
 
-// 生成随机文件名
-func randomFileName(file *multipart.FileHeader) string {
-	return fmt.Sprintf("%s%s", GenerateRandomStr(), filepath.Ext(file.Filename))
+// 默认的头像文件大小上限
+const defaultAvatarMaxSize = 5 << 20
+
+// ErrAvatarTooLarge 头像文件超过大小上限
+var ErrAvatarTooLarge = errors.New("avatar file too large")
+
+// AvatarMaxSize 头像文件大小上限, 未配置 AVATAR_MAX_SIZE 时为5MB
+func AvatarMaxSize() int64 {
+	if size := config.GetInt("AVATAR_MAX_SIZE"); size > 0 {
+		return int64(size)
+	}
+	return defaultAvatarMaxSize
 }
 
-// SaveUploadPic 保存上传的图片
-func SaveUploadPic(ctx *gin.Context, file *multipart.FileHeader) (string, error) {
-	publicPath := config.GetString("APP_UPLOAD_DIR")
-	avatarDir := fmt.Sprintf("/uploads/avatars/%s", TimeNowInTimeZone().Format("2006/01/02"))
-	uploadDir := fmt.Sprintf("%s%s", publicPath, avatarDir)
-	if err := os.MkdirAll(uploadDir, 0755); err != nil {
-		return "", err
+// AvatarSizes 头像缩略图尺寸, 最后一个为默认尺寸
+var AvatarSizes = []int{64, 128, 512}
+
+// AvatarVariantKey 获取头像指定尺寸缩略图的存储路径
+// 例如: avatars/3a/3a7b...png => avatars/3a/3a7b..._64.png
+func AvatarVariantKey(avatar string, size int) string {
+	ext := path.Ext(avatar)
+	return fmt.Sprintf("%s_%d%s", strings.TrimSuffix(avatar, ext), size, ext)
+}
+
+// AvatarBaseKey 获取缩略图对应的头像存储路径, 与 AvatarVariantKey 互逆; 不是缩略图时原样返回
+func AvatarBaseKey(key string) string {
+	ext := path.Ext(key)
+	name := strings.TrimSuffix(key, ext)
+	for _, size := range AvatarSizes {
+		if suffix := fmt.Sprintf("_%d", size); strings.HasSuffix(name, suffix) {
+			return strings.TrimSuffix(name, suffix) + ext
+		}
 	}
+	return key
+}
 
-	avatar := fmt.Sprintf("%s/%s", avatarDir, randomFileName(file))
-	dstPath := fmt.Sprintf("%s%s", publicPath, avatar)
-	if err := ctx.SaveUploadedFile(file, dstPath); err != nil {
+// AvatarPrefix 头像在存储中的前缀, 头像回收只处理该前缀下的文件
+const AvatarPrefix = "avatars"
+
+// SaveUploadPic 校验并保存上传的图片, 返回按内容生成的存储路径
+// 图片按真实类型解码后裁剪为各个尺寸的正方形缩略图重新编码保存, 原图不保存;
+// 最多读取 AvatarMaxSize 字节, 超过时返回 ErrAvatarTooLarge
+func SaveUploadPic(ctx context.Context, reader io.Reader) (string, error) {
+	maxSize := AvatarMaxSize()
+	data, err := ioutil.ReadAll(io.LimitReader(reader, maxSize+1))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(data)) > maxSize {
+		return "", ErrAvatarTooLarge
+	}
+	img, err := imaging.Decode(data)
+	if err != nil {
 		return "", err
 	}
+
+	// 相同内容的图片得到相同的路径, 重复上传时也重新写入以刷新修改时间, 避免被头像回收误删
+	avatar := storage.ContentKey(AvatarPrefix, data, img.Ext)
+	for _, size := range AvatarSizes {
+		thumb, err := img.Encode(img.Thumbnail(size))
+		if err != nil {
+			return "", err
+		}
+		err = storage.Store.Put(ctx, AvatarVariantKey(avatar, size), bytes.NewReader(thumb), int64(len(thumb)), img.ContentType)
+		if err != nil {
+			return "", err
+		}
+	}
 	return avatar, nil
 }
 
-// WrapUploadPic 拼接图片路径
-func WrapUploadPic(avatar string) string {
-	return fmt.Sprintf("%s%s", config.GetString("APP_UPLOAD_DIR"), avatar)
+// WrapUploadPic 获取头像默认尺寸和各个尺寸的访问地址
+func WrapUploadPic(ctx context.Context, avatar string) (string, map[string]string, error) {
+	urls := make(map[string]string, len(AvatarSizes))
+	if len(avatar) == 0 {
+		return "", urls, nil
+	}
+
+	var u string
+	var err error
+	for _, size := range AvatarSizes {
+		if u, err = storage.Store.URL(ctx, AvatarVariantKey(avatar, size)); err != nil {
+			return "", nil, err
+		}
+		urls[strconv.Itoa(size)] = u
+	}
+	return u, urls, nil
+}
+
+// IsRequestBodyTooLarge 是否为请求体超过 http.MaxBytesReader 限制的错误
+func IsRequestBodyTooLarge(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "request body too large")
+}
+
+// VersionToETag 记录版本号转换为ETag
+func VersionToETag(version int64) string {
+	return fmt.Sprintf("\"%d\"", version)
+}
+
+// ErrMalformedIfMatch If-Match请求头格式错误
+var ErrMalformedIfMatch = errors.New("malformed If-Match header")
+
+// IfMatchVersions 解析If-Match中的ETag列表, 返回可以匹配的记录版本号; "*"时wildcard为true
+// If-Match按强比较匹配(RFC 7232 3.1), 弱ETag和不是版本号的ETag不会匹配, 不返回
+func IfMatchVersions(header string) (versions []int64, wildcard bool, err error) {
+	header = strings.TrimSpace(header)
+	if header == "*" {
+		return nil, true, nil
+	}
+	for len(header) > 0 {
+		header = strings.TrimLeft(header, " \t,")
+		if len(header) == 0 {
+			break
+		}
+		weak := strings.HasPrefix(header, "W/")
+		if weak {
+			header = header[2:]
+		}
+		if !strings.HasPrefix(header, "\"") {
+			return nil, false, ErrMalformedIfMatch
+		}
+		end := strings.IndexByte(header[1:], '"')
+		if end < 0 {
+			return nil, false, ErrMalformedIfMatch
+		}
+		tag := header[1 : end+1]
+		header = strings.TrimLeft(header[end+2:], " \t")
+		if len(header) > 0 && header[0] != ',' {
+			return nil, false, ErrMalformedIfMatch
+		}
+		if version, err := strconv.ParseInt(tag, 10, 64); err == nil && version > 0 && !weak {
+			versions = append(versions, version)
+		}
+	}
+	return versions, false, nil
+}
+
+// IdentityMetadataKey 身份凭证在rpc metadata中的key
+const IdentityMetadataKey = "x-identity"
+
+// 客户端信息在rpc metadata中的key, user_rpc 记录在审计日志中
+const (
+	ClientIPMetadataKey        = "x-client-ip"
+	ClientUserAgentMetadataKey = "x-client-user-agent"
+)
+
+// Identity 认证中间件获取的用户身份, Assertion 为 user_rpc 签发的身份凭证
+type Identity struct {
+	UserID    int64
+	Username  string
+	Assertion string
+}
+
+type identityContextKey struct{}
+
+// GetIdentity 获取认证中间件保存在上下文中的用户身份
+func GetIdentity(ctx *gin.Context) (Identity, bool) {
+	identity, ok := ctx.Value("identity").(Identity)
+	return identity, ok
+}
+
+// IdentityFromContext 获取rpc context中的用户身份
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityContextKey{}).(Identity)
+	return identity, ok
+}
+
+// metadata的值只能包含可打印的ASCII字符, 其他字符替换为?
+func printableASCII(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e {
+			return '?'
+		}
+		return r
+	}, s)
 }
 
-// GenRpcCtxWithRequestID 生成带请求ID的context
+// GenRpcCtxWithRequestID 生成带请求ID和客户端信息的context, 继承http请求的取消和超时
+// 已认证的请求同时携带身份凭证, user_rpc 据此识别用户, 不再需要token
 func GenRpcCtxWithRequestID(ctx *gin.Context) context.Context {
 	requestID := ctx.GetString("request_id")
-	return metadata.NewOutgoingContext(context.Background(), metadata.Pairs("request_id", requestID))
+	md := metadata.Pairs("request_id", requestID, ClientIPMetadataKey, ctx.ClientIP())
+	if userAgent := ctx.Request.UserAgent(); len(userAgent) > 0 {
+		md.Set(ClientUserAgentMetadataKey, printableASCII(userAgent))
+	}
+	rpcCtx := ctx.Request.Context()
+	if identity, ok := GetIdentity(ctx); ok {
+		md.Set(IdentityMetadataKey, identity.Assertion)
+		rpcCtx = context.WithValue(rpcCtx, identityContextKey{}, identity)
+	}
+	return metadata.NewOutgoingContext(rpcCtx, md)
 }
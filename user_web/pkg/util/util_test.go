@@ -0,0 +1,31 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIfMatchVersions(t *testing.T) {
+	cases := []struct {
+		header   string
+		versions []int64
+		wildcard bool
+		err      bool
+	}{
+		{header: "*", wildcard: true},
+		{header: `"3"`, versions: []int64{3}},
+		{header: `"3", "4"`, versions: []int64{3, 4}},
+		{header: `W/"3"`},
+		{header: `W/"3", "4"`, versions: []int64{4}},
+		{header: `"abc"`},
+		{header: `3`, err: true},
+		{header: `"3`, err: true},
+		{header: `"3" "4"`, err: true},
+	}
+	for _, c := range cases {
+		versions, wildcard, err := IfMatchVersions(c.header)
+		if (err != nil) != c.err || wildcard != c.wildcard || !reflect.DeepEqual(versions, c.versions) {
+			t.Errorf("%s: got %v %v %v", c.header, versions, wildcard, err)
+		}
+	}
+}
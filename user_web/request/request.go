@@ -11,6 +11,7 @@ import (
 	"strings"
 	"unicode/utf8"
 	"user_web/pkg/captcha"
+	"user_web/pkg/util"
 	"user_web/response"
 )
 
@@ -164,15 +165,90 @@ func LoginRequestValid(data interface{}, ctx *gin.Context) map[string][]string {
 	return errs
 }
 
+// VerifyMFARequest 两步验证登录参数对象
+type VerifyMFARequest struct {
+	MFAChallenge string `json:"mfa_challenge" valid:"mfa_challenge"`
+	Code         string `json:"code" valid:"code"`
+}
+
+// VerifyMFARequestValid 两步验证登录参数校验逻辑
+func VerifyMFARequestValid(data interface{}, ctx *gin.Context) map[string][]string {
+	rules := govalidator.MapData{
+		"mfa_challenge": []string{"required", "max:64", "alpha_dash"},
+		"code":          []string{"required", "min:6", "max:16", "alpha_dash"},
+	}
+
+	messages := govalidator.MapData{
+		"mfa_challenge": []string{
+			"required:mfa_challenge 必填",
+			"max:mfa_challenge 长度最大为64",
+			"alpha_dash:mfa_challenge 只能包含字母、数字和连字符",
+		},
+		"code": []string{
+			"required:验证码 必填",
+			"min:验证码 长度最小为6",
+			"max:验证码 长度最大为16",
+			"alpha_dash:验证码 只能包含字母、数字和连字符",
+		},
+	}
+
+	return validateStruct(data, rules, messages)
+}
+
 // EditUserProfileRequest 编辑用户信息参数对象
+// update_mask 为逗号分隔的字段列表, 为空时更新请求中出现的字段; mask中的字段值为空时清空该字段
 type EditUserProfileRequest struct {
-	Nickname string `json:"nickname" valid:"nickname"`
+	Nickname   *string `json:"nickname"`
+	UpdateMask string  `json:"update_mask"`
+
+	// Paths 校验后需要更新的字段
+	Paths []string `json:"-"`
 }
 
-// EditUserProfileRequestValid 编辑用户信息参数校验逻辑
+// 可以通过http编辑的用户字段, 头像通过上传接口修改
+var editableProfileFields = map[string]bool{"nickname": true}
+
+// 编辑用户信息时需要校验的字段值
+type editProfileFields struct {
+	Nickname string `valid:"nickname"`
+}
+
+// EditUserProfileRequestValid 编辑用户信息参数校验逻辑, 只校验需要更新的字段
 func EditUserProfileRequestValid(data interface{}, ctx *gin.Context) map[string][]string {
-	rules := govalidator.MapData{
-		"nickname": []string{"min_cn:3", "max_cn:20", "legal_char"},
+	_data := data.(*EditUserProfileRequest)
+	errs := make(map[string][]string)
+
+	// 获取需要更新的字段
+	_data.Paths = nil
+	if len(_data.UpdateMask) > 0 {
+		for _, path := range strings.Split(_data.UpdateMask, ",") {
+			path = strings.TrimSpace(path)
+			if !editableProfileFields[path] {
+				errs["update_mask"] = append(errs["update_mask"], "不支持的字段 "+path)
+				continue
+			}
+			_data.Paths = append(_data.Paths, path)
+		}
+	} else if _data.Nickname != nil {
+		_data.Paths = append(_data.Paths, "nickname")
+	}
+	if len(_data.Paths) == 0 && len(errs) == 0 {
+		errs["update_mask"] = append(errs["update_mask"], "没有需要更新的字段")
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	rules := govalidator.MapData{}
+	fields := editProfileFields{}
+	for _, path := range _data.Paths {
+		switch path {
+		case "nickname":
+			if _data.Nickname != nil {
+				fields.Nickname = *_data.Nickname
+			}
+			rules["nickname"] = []string{"min_cn:3", "max_cn:20", "legal_char"}
+		}
 	}
 
 	messages := govalidator.MapData{
@@ -183,6 +259,222 @@ func EditUserProfileRequestValid(data interface{}, ctx *gin.Context) map[string]
 		},
 	}
 
+	return validateStruct(&fields, rules, messages)
+}
+
+// SearchUsersRequest 搜索用户参数对象
+type SearchUsersRequest struct {
+	Query     string `form:"q" valid:"q"`
+	PageSize  int    `form:"page_size" valid:"page_size"`
+	PageToken string `form:"page_token" valid:"page_token"`
+}
+
+// SearchUsersRequestValid 搜索用户参数校验逻辑
+func SearchUsersRequestValid(data interface{}, ctx *gin.Context) map[string][]string {
+	rules := govalidator.MapData{
+		"q":          []string{"max_cn:64"},
+		"page_size":  []string{"numeric_between:0,100"},
+		"page_token": []string{"max:128"},
+	}
+
+	messages := govalidator.MapData{
+		"q": []string{
+			"max_cn:q 长度最大为64",
+		},
+		"page_size": []string{
+			"numeric_between:page_size 必须在0到100之间",
+		},
+		"page_token": []string{
+			"max:page_token 长度最大为128",
+		},
+	}
+
+	return validateStruct(data, rules, messages)
+}
+
+// ListAuditEventsRequest 查询审计日志参数对象, 时间为unix秒
+type ListAuditEventsRequest struct {
+	Username  string `form:"username" valid:"username"`
+	Action    string `form:"action" valid:"action"`
+	StartTime int64  `form:"start_time" valid:"start_time"`
+	EndTime   int64  `form:"end_time" valid:"end_time"`
+	PageSize  int    `form:"page_size" valid:"page_size"`
+	PageToken string `form:"page_token" valid:"page_token"`
+}
+
+// ListAuditEventsRequestValid 查询审计日志参数校验逻辑
+func ListAuditEventsRequestValid(data interface{}, ctx *gin.Context) map[string][]string {
+	rules := govalidator.MapData{
+		"username":   []string{"max_cn:64"},
+		"action":     []string{"max:64"},
+		"start_time": []string{"numeric"},
+		"end_time":   []string{"numeric"},
+		"page_size":  []string{"numeric_between:0,500"},
+		"page_token": []string{"max:128"},
+	}
+
+	messages := govalidator.MapData{
+		"username": []string{
+			"max_cn:username 长度最大为64",
+		},
+		"action": []string{
+			"max:action 长度最大为64",
+		},
+		"start_time": []string{
+			"numeric:start_time 必须为unix时间戳",
+		},
+		"end_time": []string{
+			"numeric:end_time 必须为unix时间戳",
+		},
+		"page_size": []string{
+			"numeric_between:page_size 必须在0到500之间",
+		},
+		"page_token": []string{
+			"max:page_token 长度最大为128",
+		},
+	}
+
+	return validateStruct(data, rules, messages)
+}
+
+// AdminActionRequest 管理操作参数对象, 原因记录在审计日志中
+type AdminActionRequest struct {
+	Reason string `json:"reason" valid:"reason"`
+}
+
+// AdminActionRequestValid 管理操作参数校验逻辑
+func AdminActionRequestValid(data interface{}, ctx *gin.Context) map[string][]string {
+	rules := govalidator.MapData{
+		"reason": []string{"required", "max_cn:200"},
+	}
+
+	messages := govalidator.MapData{
+		"reason": []string{
+			"required:操作原因 必填",
+			"max_cn:操作原因 长度最大为200",
+		},
+	}
+
+	return validateStruct(data, rules, messages)
+}
+
+// SetUserStatusRequest 修改账号状态参数对象
+type SetUserStatusRequest struct {
+	Status string `json:"status" valid:"status"`
+	Reason string `json:"reason" valid:"reason"`
+}
+
+// SetUserStatusRequestValid 修改账号状态参数校验逻辑
+func SetUserStatusRequestValid(data interface{}, ctx *gin.Context) map[string][]string {
+	rules := govalidator.MapData{
+		"status": []string{"required", "in:active,disabled"},
+		"reason": []string{"required", "max_cn:200"},
+	}
+
+	messages := govalidator.MapData{
+		"status": []string{
+			"required:status 必填",
+			"in:status 只能是 active 或 disabled",
+		},
+		"reason": []string{
+			"required:操作原因 必填",
+			"max_cn:操作原因 长度最大为200",
+		},
+	}
+
+	return validateStruct(data, rules, messages)
+}
+
+// DeleteAccountRequest 注销账号参数对象
+type DeleteAccountRequest struct {
+	Password string `json:"password" valid:"password"`
+}
+
+// DeleteAccountRequestValid 注销账号参数校验逻辑
+func DeleteAccountRequestValid(data interface{}, ctx *gin.Context) map[string][]string {
+	rules := govalidator.MapData{
+		"password": []string{"required", "min:6", "max:20"},
+	}
+
+	messages := govalidator.MapData{
+		"password": []string{
+			"required:密码 必填",
+			"min:密码 长度最小为6",
+			"max:密码 长度最大为20",
+		},
+	}
+
+	return validateStruct(data, rules, messages)
+}
+
+// ListLoginsRequest 查看登录记录参数对象
+type ListLoginsRequest struct {
+	Limit int `form:"limit" valid:"limit"`
+}
+
+// ListLoginsRequestValid 查看登录记录参数校验逻辑
+func ListLoginsRequestValid(data interface{}, ctx *gin.Context) map[string][]string {
+	rules := govalidator.MapData{
+		"limit": []string{"numeric_between:0,100"},
+	}
+
+	messages := govalidator.MapData{
+		"limit": []string{
+			"numeric_between:limit 必须在0到100之间",
+		},
+	}
+
+	return validateStruct(data, rules, messages)
+}
+
+// ConfirmMFARequest 确认开启两步验证参数对象
+type ConfirmMFARequest struct {
+	Code string `json:"code" valid:"code"`
+}
+
+// ConfirmMFARequestValid 确认开启两步验证参数校验逻辑, 只接受验证器应用生成的验证码
+func ConfirmMFARequestValid(data interface{}, ctx *gin.Context) map[string][]string {
+	rules := govalidator.MapData{
+		"code": []string{"required", "digits:6"},
+	}
+
+	messages := govalidator.MapData{
+		"code": []string{
+			"required:验证码 必填",
+			"digits:验证码 必须是6位数字",
+		},
+	}
+
+	return validateStruct(data, rules, messages)
+}
+
+// DisableMFARequest 关闭两步验证参数对象
+type DisableMFARequest struct {
+	Password string `json:"password" valid:"password"`
+	Code     string `json:"code" valid:"code"`
+}
+
+// DisableMFARequestValid 关闭两步验证参数校验逻辑, 验证码也可以是恢复码
+func DisableMFARequestValid(data interface{}, ctx *gin.Context) map[string][]string {
+	rules := govalidator.MapData{
+		"password": []string{"required", "min:6", "max:20"},
+		"code":     []string{"required", "min:6", "max:16", "alpha_dash"},
+	}
+
+	messages := govalidator.MapData{
+		"password": []string{
+			"required:密码 必填",
+			"min:密码 长度最小为6",
+			"max:密码 长度最大为20",
+		},
+		"code": []string{
+			"required:验证码 必填",
+			"min:验证码 长度最小为6",
+			"max:验证码 长度最大为16",
+			"alpha_dash:验证码 只能包含字母、数字和连字符",
+		},
+	}
+
 	return validateStruct(data, rules, messages)
 }
 
@@ -194,13 +486,15 @@ type UploadPicRequest struct {
 // UploadPicValid 上传图片参数校验逻辑
 func UploadPicValid(data interface{}, ctx *gin.Context) map[string][]string {
 	rules := govalidator.MapData{
-		"file:avatar": []string{"required", "ext:png,jpg,jpeg", "size:20971520"},
+		"file:avatar": []string{"required", "ext:png,jpg,jpeg", "mime:image/png,image/jpeg",
+			fmt.Sprintf("size:%d", util.AvatarMaxSize())},
 	}
 
 	messages := govalidator.MapData{
 		"file:avatar": []string{
 			"ext:ext头像只能上传 png, jpg, jpeg 任意一种的图片",
-			"size:头像文件最大不能超过 20MB",
+			"mime:头像只能上传 png, jpg, jpeg 任意一种的图片",
+			fmt.Sprintf("size:头像文件最大不能超过 %d 字节", util.AvatarMaxSize()),
 			"required:必须上传图片",
 		},
 	}
@@ -233,6 +527,11 @@ func validateFile(ctx *gin.Context, rules govalidator.MapData, messages govalida
 // Validate 参数校验入口
 func Validate(ctx *gin.Context, data interface{}, handler validRequestFunc) error {
 	if err := ctx.ShouldBind(data); err != nil {
+		// 请求体超过 http.MaxBytesReader 的限制
+		if util.IsRequestBodyTooLarge(err) {
+			response.RequestEntityTooLargeRsp(ctx)
+			return err
+		}
 		response.BadRequestRsp(ctx, err)
 		return err
 	}
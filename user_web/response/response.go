@@ -1,10 +1,24 @@
 package response
 
 import (
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"net/http"
+	"userapi/v1"
+)
+
+// WWW-Authenticate 中的 realm
+const authRealm = "user_web"
+
+// 认证失败的错误码, invalid_request 和 invalid_token 同时用于 WWW-Authenticate, 参考 RFC 6750 3.1
+const (
+	AuthErrorMissingToken   = "missing_token"
+	AuthErrorInvalidRequest = "invalid_request"
+	AuthErrorInvalidToken   = "invalid_token"
+	AuthErrorExpired        = "expired"
 )
 
 // RpcRspToHttpRsp rpc响应转换为http响应
@@ -27,20 +41,59 @@ func RpcRspToHttpRsp(err error, ctx *gin.Context) {
 				"message": message,
 			})
 		case codes.PermissionDenied:
-			message = "账号或密码错误"
-			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{
-				"message": message,
-			})
+			switch authFailureReason(e) {
+			case userapi.ReasonInsufficientPermission:
+				ForbiddenRsp(ctx)
+			case userapi.ReasonAccountDisabled:
+				AccountDisabledRsp(ctx)
+			case userapi.ReasonAccountPendingDeletion:
+				AccountPendingDeletionRsp(ctx)
+			case userapi.ReasonMFACodeInvalid:
+				MFACodeInvalidRsp(ctx)
+			default:
+				message = "账号或密码错误"
+				ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+					"message": message,
+				})
+			}
 		case codes.Unauthenticated:
-			message = "未授权"
-			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			switch authFailureReason(e) {
+			case userapi.ReasonTokenExpired:
+				ExpiredTokenRsp(ctx)
+			case userapi.ReasonMFAChallengeInvalid:
+				MFAChallengeInvalidRsp(ctx)
+			default:
+				InvalidTokenRsp(ctx)
+			}
+		case codes.InvalidArgument:
+			message = "参数错误"
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
 				"message": message,
 			})
+		case codes.Aborted:
+			ConflictRsp(ctx)
+		case codes.FailedPrecondition:
+			switch authFailureReason(e) {
+			case userapi.ReasonAccountPendingDeletion:
+				AccountStateConflictRsp(ctx, "account_pending_deletion")
+			case userapi.ReasonAccountDeleted:
+				AccountStateConflictRsp(ctx, "account_deleted")
+			case userapi.ReasonMFAAlreadyEnabled:
+				AccountStateConflictRsp(ctx, "mfa_already_enabled")
+			case userapi.ReasonMFANotEnabled:
+				AccountStateConflictRsp(ctx, "mfa_not_enabled")
+			default:
+				PreconditionFailedRsp(ctx)
+			}
 		case codes.AlreadyExists:
 			message = "用户已存在"
 			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
 				"message": message,
 			})
+		case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+			ServiceUnavailableRsp(ctx)
+		case codes.Unimplemented:
+			NotImplementedRsp(ctx)
 		default:
 			message = "服务器其他错误"
 			ctx.AbortWithStatusJSON(http.StatusBadGateway, gin.H{
@@ -55,6 +108,182 @@ func RpcRspToHttpRsp(err error, ctx *gin.Context) {
 	}
 }
 
+// 获取rpc错误携带的原因, 用于区分认证、授权失败和账号状态
+func authFailureReason(st *status.Status) string {
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok && info.Domain == userapi.ErrorDomain {
+			return info.Reason
+		}
+	}
+	return ""
+}
+
+// 返回 Bearer 认证质询, errCode 为空时只返回 realm
+func bearerChallengeRsp(ctx *gin.Context, code int, errCode, headerErr, description, message string) {
+	challenge := fmt.Sprintf("Bearer realm=%q", authRealm)
+	if len(headerErr) > 0 {
+		challenge += fmt.Sprintf(", error=%q, error_description=%q", headerErr, description)
+	}
+	ctx.Header("WWW-Authenticate", challenge)
+	ctx.AbortWithStatusJSON(code, gin.H{
+		"message":           message,
+		"error":             errCode,
+		"error_description": description,
+	})
+}
+
+// MissingTokenRsp 未提供登录凭证响应
+func MissingTokenRsp(ctx *gin.Context) {
+	bearerChallengeRsp(ctx, http.StatusUnauthorized, AuthErrorMissingToken, "",
+		"authentication required", "token 必传")
+}
+
+// MalformedTokenRsp 登录凭证格式错误响应
+func MalformedTokenRsp(ctx *gin.Context) {
+	bearerChallengeRsp(ctx, http.StatusBadRequest, AuthErrorInvalidRequest, AuthErrorInvalidRequest,
+		"malformed bearer credentials", "登录凭证格式错误")
+}
+
+// InvalidTokenRsp 登录凭证无效响应
+func InvalidTokenRsp(ctx *gin.Context) {
+	bearerChallengeRsp(ctx, http.StatusUnauthorized, AuthErrorInvalidToken, AuthErrorInvalidToken,
+		"the access token is invalid", "未授权")
+}
+
+// ExpiredTokenRsp 登录凭证过期响应, WWW-Authenticate 中的错误码按 RFC 6750 仍为 invalid_token
+func ExpiredTokenRsp(ctx *gin.Context) {
+	bearerChallengeRsp(ctx, http.StatusUnauthorized, AuthErrorExpired, AuthErrorInvalidToken,
+		"the access token expired", "登录已过期，请重新登录")
+}
+
+// ForbiddenRsp 权限不足响应
+func ForbiddenRsp(ctx *gin.Context) {
+	ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+		"message": "权限不足",
+		"error":   "insufficient_permission",
+	})
+}
+
+// AccountDisabledRsp 账号已被禁用响应
+func AccountDisabledRsp(ctx *gin.Context) {
+	ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+		"message": "账号已被禁用",
+		"error":   "account_disabled",
+	})
+}
+
+// AccountPendingDeletionRsp 账号已申请注销响应
+func AccountPendingDeletionRsp(ctx *gin.Context) {
+	ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+		"message": "账号已申请注销",
+		"error":   "account_pending_deletion",
+	})
+}
+
+// AccountStateConflictRsp 账号当前状态不允许该操作响应, errCode为账号状态
+func AccountStateConflictRsp(ctx *gin.Context, errCode string) {
+	ctx.AbortWithStatusJSON(http.StatusConflict, gin.H{
+		"message": "账号当前状态不允许该操作",
+		"error":   errCode,
+	})
+}
+
+// MFACodeInvalidRsp 两步验证的验证码或恢复码错误响应
+func MFACodeInvalidRsp(ctx *gin.Context) {
+	ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+		"message": "验证码错误",
+		"error":   "mfa_code_invalid",
+	})
+}
+
+// MFAChallengeInvalidRsp 两步验证挑战无效或已过期响应, 需要重新输入密码登录
+func MFAChallengeInvalidRsp(ctx *gin.Context) {
+	ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+		"message": "验证已过期，请重新登录",
+		"error":   "mfa_challenge_invalid",
+	})
+}
+
+// PreconditionFailedRsp 数据版本不一致响应
+func PreconditionFailedRsp(ctx *gin.Context) {
+	ctx.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{
+		"message": "数据已被修改，请刷新后重试",
+	})
+}
+
+// ConflictRsp 并发修改冲突响应, 未指定If-Match时重试后仍然冲突
+func ConflictRsp(ctx *gin.Context) {
+	ctx.AbortWithStatusJSON(http.StatusConflict, gin.H{
+		"message": "数据正在被修改，请稍后重试",
+		"error":   "conflict",
+	})
+}
+
+// NotFoundRsp 资源不存在响应
+func NotFoundRsp(ctx *gin.Context) {
+	ctx.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+		"message": "资源不存在",
+	})
+}
+
+// LinkExpiredRsp 下载地址已过期响应
+func LinkExpiredRsp(ctx *gin.Context) {
+	ctx.AbortWithStatusJSON(http.StatusGone, gin.H{
+		"message": "下载地址已过期，请重新导出",
+		"error":   "link_expired",
+	})
+}
+
+// RequestEntityTooLargeRsp 请求体过大响应
+func RequestEntityTooLargeRsp(ctx *gin.Context) {
+	ctx.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{
+		"message": "上传文件过大",
+	})
+}
+
+// UploadOffsetConflictRsp 断点续传偏移量不一致响应
+func UploadOffsetConflictRsp(ctx *gin.Context) {
+	ctx.AbortWithStatusJSON(http.StatusConflict, gin.H{
+		"message": "上传偏移量不一致，请重新获取上传进度",
+	})
+}
+
+// UnsupportedMediaTypeRsp 请求体类型错误响应
+func UnsupportedMediaTypeRsp(ctx *gin.Context) {
+	ctx.AbortWithStatusJSON(http.StatusUnsupportedMediaType, gin.H{
+		"message": "不支持的请求体类型",
+	})
+}
+
+// CSRFFailedRsp CSRF校验失败响应
+func CSRFFailedRsp(ctx *gin.Context) {
+	ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+		"message": "CSRF校验失败，请刷新后重试",
+	})
+}
+
+// TooManyRequestsRsp 请求过于频繁响应
+func TooManyRequestsRsp(ctx *gin.Context) {
+	ctx.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+		"message": "请求过于频繁，请稍后再试",
+	})
+}
+
+// ServiceUnavailableRsp 服务暂时不可用响应
+func ServiceUnavailableRsp(ctx *gin.Context) {
+	ctx.Header("Retry-After", "5")
+	ctx.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+		"message": "服务暂时不可用，请稍后重试",
+	})
+}
+
+// NotImplementedRsp 服务端未开启该功能响应
+func NotImplementedRsp(ctx *gin.Context) {
+	ctx.AbortWithStatusJSON(http.StatusNotImplemented, gin.H{
+		"message": "该功能未开启",
+	})
+}
+
 // BadRequestRsp 非法请求响应
 func BadRequestRsp(ctx *gin.Context, err error) {
 	ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
@@ -87,6 +316,14 @@ func SuccessDataRsp(ctx *gin.Context, data interface{}) {
 	})
 }
 
+// CreatedDataRsp 创建成功响应(带上数据)
+func CreatedDataRsp(ctx *gin.Context, data interface{}) {
+	ctx.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    data,
+	})
+}
+
 // SuccessRsp 成功响应
 func SuccessRsp(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, gin.H{
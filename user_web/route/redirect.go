@@ -0,0 +1,30 @@
+package route
+
+import (
+	"net"
+	"net/http"
+)
+
+// RedirectHandler http请求跳转到https
+// httpsPort 为https服务的端口, 443时省略; webroot 不为空时提供ACME http-01 验证文件(certbot --webroot)
+func RedirectHandler(httpsPort, webroot string) http.Handler {
+	mux := http.NewServeMux()
+	if len(webroot) > 0 {
+		mux.Handle("/.well-known/acme-challenge/", http.FileServer(http.Dir(webroot)))
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if len(host) == 0 {
+			http.Error(w, "missing host", http.StatusBadRequest)
+			return
+		}
+		if len(httpsPort) > 0 && httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+	return mux
+}
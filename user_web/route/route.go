@@ -4,25 +4,71 @@ import (
 	"github.com/gin-gonic/gin"
 	"user_web/handler"
 	"user_web/middleware"
+	"user_web/pkg/util"
 )
 
+// multipart表单中除文件内容外的开销上限
+const multipartOverhead = 64 << 10
+
 // RegisterHandler 注册路由接口
 func RegisterHandler(r *gin.Engine) {
-	// 注册recovery、全局请求ID中间件
-	r.Use(gin.Recovery(), middleware.GenerateGlobalRequestID())
+	// 注册recovery、全局请求ID、安全响应头、跨域中间件
+	r.Use(gin.Recovery(), middleware.GenerateGlobalRequestID(), middleware.SecurityHeaders(), middleware.CORS())
 
 	baseCtrl := new(handler.BaseController)
-	r.GET("/captcha", baseCtrl.ShowCaptcha)
-	r.POST("/register", baseCtrl.RegisterHandler)
-	r.POST("/login", baseCtrl.LoginHandler)
-	r.POST("/logout", middleware.CheckTokenExist(), baseCtrl.LogoutHandler)
+	r.GET("/captcha", middleware.RateLimit("captcha"), baseCtrl.ShowCaptcha)
+	r.POST("/register", middleware.RateLimit("register"), baseCtrl.RegisterHandler)
+	r.POST("/login", middleware.RateLimit("login"), baseCtrl.LoginHandler)
+	r.POST("/login/mfa", middleware.RateLimit("login"), baseCtrl.VerifyMFAHandler)
+	r.POST("/logout", middleware.CheckTokenExist(), middleware.CSRFProtect(), baseCtrl.LogoutHandler)
+
+	// 头像文件, 地址由 STORAGE_BASE_URL 拼接存储路径得到
+	avatarCtrl := new(handler.AvatarController)
+	r.GET("/avatars/*filepath", avatarCtrl.ShowAvatarHandler)
+	r.HEAD("/avatars/*filepath", avatarCtrl.ShowAvatarHandler)
 
-	// 设置user分组，并注册"检查Authorization参数"中间件
-	userGroup := r.Group("user", middleware.CheckTokenExist())
+	// 个人数据导出文件, 通过下载地址中的签名校验
+	exportCtrl := new(handler.ExportController)
+	r.GET("/exports/:id", exportCtrl.DownloadExportHandler)
+
+	// 设置user分组，并注册检查登录凭证、CSRF校验、认证、按用户限流中间件
+	// 限流在认证之后, 按 user 限流的策略才能拿到用户身份
+	userGroup := r.Group("user", middleware.CheckTokenExist(), middleware.CSRFProtect(), middleware.Authenticate(),
+		middleware.RateLimit("user"))
 	{
 		userCtrl := new(handler.UserController)
 		userGroup.GET("/", userCtrl.GetUserProfileHandler)
 		userGroup.PUT("/", userCtrl.EditUserProfileHandler)
-		userGroup.POST("/avatar", userCtrl.UploadPicHandler)
+		userGroup.DELETE("/", userCtrl.DeleteAccountHandler)
+		userGroup.GET("/logins", userCtrl.ListLoginsHandler)
+		userGroup.POST("/mfa", userCtrl.EnrollMFAHandler)
+		userGroup.POST("/mfa/confirm", userCtrl.ConfirmMFAHandler)
+		userGroup.DELETE("/mfa", userCtrl.DisableMFAHandler)
+		// 解析multipart之前限制请求体大小, 预留表单边界等开销
+		userGroup.POST("/avatar", middleware.LimitRequestBody(util.AvatarMaxSize()+multipartOverhead),
+			userCtrl.UploadPicHandler)
+
+		// 断点续传上传头像
+		uploadCtrl := new(handler.UploadController)
+		userGroup.POST("/avatar/uploads", uploadCtrl.CreateUploadHandler)
+		userGroup.HEAD("/avatar/uploads/:id", uploadCtrl.HeadUploadHandler)
+		userGroup.PATCH("/avatar/uploads/:id", uploadCtrl.PatchUploadHandler)
+
+		// 个人数据导出, 发起导出单独限流
+		userGroup.POST("/export", middleware.RateLimit("export"), exportCtrl.CreateExportHandler)
+		userGroup.GET("/export", exportCtrl.ShowExportHandler)
+	}
+
+	// 设置admin分组, 中间件与user分组一致, 角色权限由 user_rpc 校验
+	adminGroup := r.Group("admin", middleware.CheckTokenExist(), middleware.CSRFProtect(), middleware.Authenticate(),
+		middleware.RateLimit("admin"))
+	{
+		adminCtrl := new(handler.AdminController)
+		adminGroup.GET("/users", adminCtrl.SearchUsersHandler)
+		adminGroup.GET("/users/:username", adminCtrl.GetUserHandler)
+		adminGroup.PUT("/users/:username/status", adminCtrl.SetUserStatusHandler)
+		adminGroup.POST("/users/:username/logout", adminCtrl.ForceLogoutHandler)
+		adminGroup.POST("/users/:username/nickname/reset", adminCtrl.ResetNicknameHandler)
+		adminGroup.GET("/audit-events", adminCtrl.ListAuditEventsHandler)
 	}
 }
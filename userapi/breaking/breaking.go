@@ -0,0 +1,202 @@
+// Package breaking 检查 proto 定义的破坏性变更, 规则参考 buf breaking 的 FILE 类别
+package breaking
+
+import (
+	"fmt"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Violation 破坏性变更
+type Violation struct {
+	Rule    string
+	Message string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: %s", v.Rule, v.Message)
+}
+
+// 检查上下文, 收集破坏性变更
+type checker struct {
+	violations []Violation
+}
+
+func (c *checker) add(rule string, format string, args ...interface{}) {
+	c.violations = append(c.violations, Violation{
+		Rule:    rule,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// Check 对比新旧 proto 文件描述, 返回所有破坏性变更
+func Check(previous, current *descriptorpb.FileDescriptorProto) []Violation {
+	c := &checker{}
+
+	if previous.GetPackage() != current.GetPackage() {
+		c.add("FILE_SAME_PACKAGE", "package changed from %q to %q",
+			previous.GetPackage(), current.GetPackage())
+	}
+	if previous.GetOptions().GetGoPackage() != current.GetOptions().GetGoPackage() {
+		c.add("FILE_SAME_GO_PACKAGE", "go_package changed from %q to %q",
+			previous.GetOptions().GetGoPackage(), current.GetOptions().GetGoPackage())
+	}
+
+	prefix := previous.GetPackage()
+	c.checkMessages(prefix, previous.GetMessageType(), current.GetMessageType())
+	c.checkEnums(prefix, previous.GetEnumType(), current.GetEnumType())
+	c.checkServices(prefix, previous.GetService(), current.GetService())
+	return c.violations
+}
+
+// 检查消息定义
+func (c *checker) checkMessages(prefix string, previous, current []*descriptorpb.DescriptorProto) {
+	currentByName := make(map[string]*descriptorpb.DescriptorProto, len(current))
+	for _, m := range current {
+		currentByName[m.GetName()] = m
+	}
+
+	for _, prev := range previous {
+		name := prefix + "." + prev.GetName()
+		cur, ok := currentByName[prev.GetName()]
+		if !ok {
+			c.add("MESSAGE_NO_DELETE", "message %q was deleted", name)
+			continue
+		}
+		c.checkFields(name, prev, cur)
+		c.checkMessages(name, prev.GetNestedType(), cur.GetNestedType())
+		c.checkEnums(name, prev.GetEnumType(), cur.GetEnumType())
+	}
+}
+
+// 检查字段定义, 字段以编号为准进行比较
+func (c *checker) checkFields(message string, previous, current *descriptorpb.DescriptorProto) {
+	currentByNumber := make(map[int32]*descriptorpb.FieldDescriptorProto, len(current.GetField()))
+	for _, f := range current.GetField() {
+		currentByNumber[f.GetNumber()] = f
+	}
+
+	for _, prev := range previous.GetField() {
+		cur, ok := currentByNumber[prev.GetNumber()]
+		if !ok {
+			if !isReservedNumber(current, prev.GetNumber()) {
+				c.add("FIELD_NO_DELETE", "field %d %q on message %q was deleted without reserving its number",
+					prev.GetNumber(), prev.GetName(), message)
+			}
+			continue
+		}
+		if prev.GetName() != cur.GetName() {
+			c.add("FIELD_SAME_NAME", "field %d on message %q changed name from %q to %q",
+				prev.GetNumber(), message, prev.GetName(), cur.GetName())
+		}
+		if prev.GetType() != cur.GetType() || prev.GetTypeName() != cur.GetTypeName() {
+			c.add("FIELD_SAME_TYPE", "field %d %q on message %q changed type from %s to %s",
+				prev.GetNumber(), prev.GetName(), message, fieldType(prev), fieldType(cur))
+		}
+		if prev.GetLabel() != cur.GetLabel() {
+			c.add("FIELD_SAME_LABEL", "field %d %q on message %q changed label from %s to %s",
+				prev.GetNumber(), prev.GetName(), message, prev.GetLabel(), cur.GetLabel())
+		}
+		if (prev.OneofIndex != nil) != (cur.OneofIndex != nil) {
+			c.add("FIELD_SAME_ONEOF", "field %d %q on message %q moved into or out of a oneof",
+				prev.GetNumber(), prev.GetName(), message)
+		}
+	}
+}
+
+// 检查枚举定义, 枚举值以编号为准进行比较
+func (c *checker) checkEnums(prefix string, previous, current []*descriptorpb.EnumDescriptorProto) {
+	currentByName := make(map[string]*descriptorpb.EnumDescriptorProto, len(current))
+	for _, e := range current {
+		currentByName[e.GetName()] = e
+	}
+
+	for _, prev := range previous {
+		name := prefix + "." + prev.GetName()
+		cur, ok := currentByName[prev.GetName()]
+		if !ok {
+			c.add("ENUM_NO_DELETE", "enum %q was deleted", name)
+			continue
+		}
+
+		values := make(map[int32]string, len(cur.GetValue()))
+		for _, v := range cur.GetValue() {
+			values[v.GetNumber()] = v.GetName()
+		}
+		for _, v := range prev.GetValue() {
+			curName, ok := values[v.GetNumber()]
+			if !ok {
+				c.add("ENUM_VALUE_NO_DELETE", "enum value %d %q on enum %q was deleted",
+					v.GetNumber(), v.GetName(), name)
+				continue
+			}
+			if curName != v.GetName() {
+				c.add("ENUM_VALUE_SAME_NAME", "enum value %d on enum %q changed name from %q to %q",
+					v.GetNumber(), name, v.GetName(), curName)
+			}
+		}
+	}
+}
+
+// 检查服务定义
+func (c *checker) checkServices(prefix string, previous, current []*descriptorpb.ServiceDescriptorProto) {
+	currentByName := make(map[string]*descriptorpb.ServiceDescriptorProto, len(current))
+	for _, s := range current {
+		currentByName[s.GetName()] = s
+	}
+
+	for _, prev := range previous {
+		name := prefix + "." + prev.GetName()
+		cur, ok := currentByName[prev.GetName()]
+		if !ok {
+			c.add("SERVICE_NO_DELETE", "service %q was deleted", name)
+			continue
+		}
+
+		methods := make(map[string]*descriptorpb.MethodDescriptorProto, len(cur.GetMethod()))
+		for _, m := range cur.GetMethod() {
+			methods[m.GetName()] = m
+		}
+		for _, pm := range prev.GetMethod() {
+			cm, ok := methods[pm.GetName()]
+			if !ok {
+				c.add("RPC_NO_DELETE", "rpc %q on service %q was deleted", pm.GetName(), name)
+				continue
+			}
+			if pm.GetInputType() != cm.GetInputType() {
+				c.add("RPC_SAME_REQUEST_TYPE", "rpc %q on service %q changed request type from %q to %q",
+					pm.GetName(), name, pm.GetInputType(), cm.GetInputType())
+			}
+			if pm.GetOutputType() != cm.GetOutputType() {
+				c.add("RPC_SAME_RESPONSE_TYPE", "rpc %q on service %q changed response type from %q to %q",
+					pm.GetName(), name, pm.GetOutputType(), cm.GetOutputType())
+			}
+			if pm.GetClientStreaming() != cm.GetClientStreaming() {
+				c.add("RPC_SAME_CLIENT_STREAMING", "rpc %q on service %q changed client streaming",
+					pm.GetName(), name)
+			}
+			if pm.GetServerStreaming() != cm.GetServerStreaming() {
+				c.add("RPC_SAME_SERVER_STREAMING", "rpc %q on service %q changed server streaming",
+					pm.GetName(), name)
+			}
+		}
+	}
+}
+
+// 字段编号是否已声明为reserved
+func isReservedNumber(message *descriptorpb.DescriptorProto, number int32) bool {
+	for _, r := range message.GetReservedRange() {
+		// reserved range 的 end 不包含在内
+		if number >= r.GetStart() && number < r.GetEnd() {
+			return true
+		}
+	}
+	return false
+}
+
+// 字段类型描述
+func fieldType(f *descriptorpb.FieldDescriptorProto) string {
+	if len(f.GetTypeName()) > 0 {
+		return f.GetTypeName()
+	}
+	return f.GetType().String()
+}
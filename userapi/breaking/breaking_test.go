@@ -0,0 +1,119 @@
+package breaking
+
+import (
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"testing"
+)
+
+func baseFile() *descriptorpb.FileDescriptorProto {
+	return &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("userapi/v1/user.proto"),
+		Package: proto.String("userapi.v1"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("UserResponse"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				{Name: proto.String("id"), Number: proto.Int32(1), Type: descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum()},
+				{Name: proto.String("nickname"), Number: proto.Int32(2), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()},
+			},
+		}},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("UserService"),
+			Method: []*descriptorpb.MethodDescriptorProto{{
+				Name:       proto.String("GetUserProfile"),
+				InputType:  proto.String(".userapi.v1.AuthRequest"),
+				OutputType: proto.String(".userapi.v1.UserResponse"),
+			}},
+		}},
+	}
+}
+
+func rules(violations []Violation) map[string]bool {
+	res := make(map[string]bool)
+	for _, v := range violations {
+		res[v.Rule] = true
+	}
+	return res
+}
+
+func TestCheck(t *testing.T) {
+	cases := []struct {
+		name   string
+		modify func(f *descriptorpb.FileDescriptorProto)
+		rules  []string
+	}{
+		{
+			name:   "unchanged",
+			modify: func(f *descriptorpb.FileDescriptorProto) {},
+		},
+		{
+			name: "add field",
+			modify: func(f *descriptorpb.FileDescriptorProto) {
+				f.MessageType[0].Field = append(f.MessageType[0].Field, &descriptorpb.FieldDescriptorProto{
+					Name: proto.String("pic_path"), Number: proto.Int32(3), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+				})
+			},
+		},
+		{
+			name: "renumber field",
+			modify: func(f *descriptorpb.FileDescriptorProto) {
+				f.MessageType[0].Field[1].Number = proto.Int32(3)
+			},
+			rules: []string{"FIELD_NO_DELETE"},
+		},
+		{
+			name: "swap field numbers",
+			modify: func(f *descriptorpb.FileDescriptorProto) {
+				f.MessageType[0].Field[0].Number = proto.Int32(2)
+				f.MessageType[0].Field[1].Number = proto.Int32(1)
+			},
+			rules: []string{"FIELD_SAME_NAME", "FIELD_SAME_TYPE"},
+		},
+		{
+			name: "delete reserved field",
+			modify: func(f *descriptorpb.FileDescriptorProto) {
+				f.MessageType[0].Field = f.MessageType[0].Field[:1]
+				f.MessageType[0].ReservedRange = []*descriptorpb.DescriptorProto_ReservedRange{
+					{Start: proto.Int32(2), End: proto.Int32(3)},
+				}
+			},
+		},
+		{
+			name: "change package",
+			modify: func(f *descriptorpb.FileDescriptorProto) {
+				f.Package = proto.String("userapi.v2")
+			},
+			rules: []string{"FILE_SAME_PACKAGE"},
+		},
+		{
+			name: "delete rpc",
+			modify: func(f *descriptorpb.FileDescriptorProto) {
+				f.Service[0].Method = nil
+			},
+			rules: []string{"RPC_NO_DELETE"},
+		},
+		{
+			name: "change rpc response",
+			modify: func(f *descriptorpb.FileDescriptorProto) {
+				f.Service[0].Method[0].OutputType = proto.String(".google.protobuf.Empty")
+			},
+			rules: []string{"RPC_SAME_RESPONSE_TYPE"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			current := baseFile()
+			c.modify(current)
+			got := rules(Check(baseFile(), current))
+			if len(got) != len(c.rules) {
+				t.Fatalf("expected rules %v, got %v", c.rules, got)
+			}
+			for _, rule := range c.rules {
+				if !got[rule] {
+					t.Fatalf("expected rules %v, got %v", c.rules, got)
+				}
+			}
+		})
+	}
+}
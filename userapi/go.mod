@@ -0,0 +1,8 @@
+module userapi
+
+go 1.16
+
+require (
+	google.golang.org/grpc v1.45.0
+	google.golang.org/protobuf v1.28.0
+)
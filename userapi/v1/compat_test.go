@@ -0,0 +1,53 @@
+package userapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"io/ioutil"
+	"testing"
+	"userapi/breaking"
+)
+
+// 已发布的接口描述快照, 新增字段或接口后使用 -update 更新
+const snapshotFile = "testdata/user.lock.json"
+
+var update = flag.Bool("update", false, "update the published descriptor snapshot")
+
+// 当前接口定义不能破坏已发布的快照
+func TestNoBreakingChanges(t *testing.T) {
+	current := protodesc.ToFileDescriptorProto(File_userapi_v1_user_proto)
+
+	data, err := ioutil.ReadFile(snapshotFile)
+	if err != nil {
+		t.Fatalf("read snapshot: %v", err)
+	}
+	previous := &descriptorpb.FileDescriptorProto{}
+	if err := protojson.Unmarshal(data, previous); err != nil {
+		t.Fatalf("decode snapshot: %v", err)
+	}
+
+	violations := breaking.Check(previous, current)
+	for _, v := range violations {
+		t.Error(v)
+	}
+
+	if *update && len(violations) == 0 {
+		data, err := protojson.Marshal(current)
+		if err != nil {
+			t.Fatalf("encode snapshot: %v", err)
+		}
+		// protojson 的输出格式不稳定, 重新缩进以便对比快照变更
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, data, "", "  "); err != nil {
+			t.Fatalf("indent snapshot: %v", err)
+		}
+		buf.WriteByte('\n')
+		if err := ioutil.WriteFile(snapshotFile, buf.Bytes(), 0644); err != nil {
+			t.Fatalf("write snapshot: %v", err)
+		}
+	}
+}
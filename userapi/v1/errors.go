@@ -0,0 +1,27 @@
+package userapi
+
+// 认证和授权失败时错误携带的 google.rpc.ErrorInfo 详情, 调用方据此区分失败原因
+const (
+	// ErrorDomain ErrorInfo 的 domain
+	ErrorDomain = "userapi.v1"
+	// ReasonTokenInvalid token不存在或已登出, 错误码为 Unauthenticated
+	ReasonTokenInvalid = "TOKEN_INVALID"
+	// ReasonTokenExpired token曾经签发, 但已过期, 错误码为 Unauthenticated
+	ReasonTokenExpired = "TOKEN_EXPIRED"
+	// ReasonAccountDisabled 账号已被禁用, 错误码为 PermissionDenied
+	ReasonAccountDisabled = "ACCOUNT_DISABLED"
+	// ReasonAccountPendingDeletion 账号已申请注销, 登录时错误码为 PermissionDenied, 管理操作时为 FailedPrecondition
+	ReasonAccountPendingDeletion = "ACCOUNT_PENDING_DELETION"
+	// ReasonAccountDeleted 账号已注销, 管理操作时错误码为 FailedPrecondition
+	ReasonAccountDeleted = "ACCOUNT_DELETED"
+	// ReasonInsufficientPermission 调用方的角色没有接口要求的权限, 错误码为 PermissionDenied
+	ReasonInsufficientPermission = "INSUFFICIENT_PERMISSION"
+	// ReasonMFACodeInvalid 两步验证的验证码或恢复码错误, 错误码为 PermissionDenied
+	ReasonMFACodeInvalid = "MFA_CODE_INVALID"
+	// ReasonMFAChallengeInvalid 两步验证的挑战不存在、已过期或尝试次数过多, 需要重新登录, 错误码为 Unauthenticated
+	ReasonMFAChallengeInvalid = "MFA_CHALLENGE_INVALID"
+	// ReasonMFAAlreadyEnabled 已开启两步验证, 错误码为 FailedPrecondition
+	ReasonMFAAlreadyEnabled = "MFA_ALREADY_ENABLED"
+	// ReasonMFANotEnabled 未开启或未开始设置两步验证, 错误码为 FailedPrecondition
+	ReasonMFANotEnabled = "MFA_NOT_ENABLED"
+)
@@ -0,0 +1,4 @@
+// Package userapi 用户服务 v1 版本的接口定义, 由 user_rpc 和 user_web 共同引用
+package userapi
+
+//go:generate protoc -I ../proto --go_out=plugins=grpc:../.. ../proto/userapi/v1/user.proto